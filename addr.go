@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import "net"
+
+// wrappedAddr is implemented by the layered addresses of go.cryptoscope.co/netwrap
+type wrappedAddr interface {
+	net.Addr
+	Head() net.Addr
+	Inner() net.Addr
+}
+
+// AddrLayers flattens the remote address of an endpoint or request into its layers, outermost first.
+// It unwraps netwrap'ed addresses as well as TLSAddr and SecretStreamAddr. A TunnelAddr is a layer of its own, followed by the layers it runs over.
+// For a secretstream connection over TCP the result is the secretstream.Addr and the *net.TCPAddr.
+func AddrLayers(addr net.Addr) []net.Addr {
+	switch a := addr.(type) {
+	case nil:
+		return nil
+	case wrappedAddr:
+		return append(AddrLayers(a.Head()), AddrLayers(a.Inner())...)
+	case TLSAddr:
+		return AddrLayers(a.Addr)
+	case SecretStreamAddr:
+		return AddrLayers(a.Addr)
+	case TunnelAddr:
+		return append([]net.Addr{a}, AddrLayers(a.Via)...)
+	default:
+		return []net.Addr{addr}
+	}
+}
+
+// FindAddr returns the layer of addr with the passed network name (like "tcp" or "shs-bs") or nil if there is none.
+func FindAddr(addr net.Addr, network string) net.Addr {
+	for _, layer := range AddrLayers(addr) {
+		if layer.Network() == network {
+			return layer
+		}
+	}
+	return nil
+}
+
+// TCPAddr returns the TCP layer of addr.
+func TCPAddr(addr net.Addr) (*net.TCPAddr, bool) {
+	for _, layer := range AddrLayers(addr) {
+		if ta, ok := layer.(*net.TCPAddr); ok {
+			return ta, true
+		}
+	}
+	return nil, false
+}
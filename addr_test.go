@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddrLayers(t *testing.T) {
+	r := require.New(t)
+
+	tcp := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8008}
+	shs := shsAddr{make([]byte, 32)}
+	wrapped := netwrapAddr{shs, tcp}
+
+	r.Equal([]net.Addr{shs, tcp}, AddrLayers(wrapped))
+	r.Equal([]net.Addr{tcp}, AddrLayers(tcp))
+	r.Nil(AddrLayers(nil))
+
+	// the muxrpc address types unwrap to what they were created from
+	r.Equal([]net.Addr{shs, tcp}, AddrLayers(SecretStreamAddr{Addr: wrapped, PubKey: shs.PubKey}))
+	r.Equal([]net.Addr{tcp}, AddrLayers(TLSAddr{Addr: tcp}))
+
+	r.Equal(shs, FindAddr(wrapped, "shs-bs"))
+	r.Equal(tcp, FindAddr(wrapped, "tcp"))
+	r.Nil(FindAddr(tcp, "shs-bs"))
+
+	got, ok := TCPAddr(wrapped)
+	r.True(ok)
+	r.Equal(tcp, got)
+
+	_, ok = TCPAddr(shs)
+	r.False(ok)
+}
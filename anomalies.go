@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+	"go.mindeco.de/log/level"
+)
+
+// ErrProtocolViolation ends sessions with WithStrictPackets once the remote sent a packet that doesn't fit the state of its request, see PacketAnomaly.
+var ErrProtocolViolation = errors.New("muxrpc: protocol violation")
+
+// PacketAnomaly is a kind of packet that doesn't fit the state of its request.
+// They are dropped and counted (see Anomalies), or end the session with WithStrictPackets.
+type PacketAnomaly uint8
+
+const (
+	// DataAfterClose is data on a request that both sides ended already.
+	// Data for a stream that was only closed on our side is still in flight and not counted.
+	DataAfterClose PacketAnomaly = iota + 1
+
+	// ReplyBeforeRequest is a reply for a request id we never used.
+	ReplyBeforeRequest
+
+	// DuplicateReply is a second reply to one of our async calls.
+	DuplicateReply
+)
+
+func (a PacketAnomaly) String() string {
+	switch a {
+	case DataAfterClose:
+		return "data after close"
+	case ReplyBeforeRequest:
+		return "reply before request"
+	case DuplicateReply:
+		return "duplicate reply"
+	}
+	return fmt.Sprintf("PacketAnomaly(%d)", uint8(a))
+}
+
+// PacketAnomalies counts the packets of the remote that were dropped, by kind.
+type PacketAnomalies struct {
+	DataAfterClose     uint64
+	ReplyBeforeRequest uint64
+	DuplicateReply     uint64
+}
+
+// WithStrictPackets ends the session with ErrProtocolViolation on the first packet that doesn't fit the state of its request,
+// instead of dropping it. Useful to find bugs in implementations of the other side, less so for sessions with arbitrary peers.
+func WithStrictPackets() HandleOption {
+	return func(r *rpc) {
+		r.strictPackets = true
+	}
+}
+
+// anomalyCounter is implemented by the endpoints returned from Handle
+type anomalyCounter interface {
+	packetAnomalies() PacketAnomalies
+}
+
+// Anomalies returns how many packets of each kind the remote sent that didn't fit the state of their request.
+// ok is false if edp doesn't keep track, which is only the case for endpoints not created by Handle.
+func Anomalies(edp Endpoint) (PacketAnomalies, bool) {
+	ac, ok := edp.(anomalyCounter)
+	if !ok {
+		return PacketAnomalies{}, false
+	}
+	return ac.packetAnomalies(), true
+}
+
+func (r *rpc) packetAnomalies() PacketAnomalies {
+	return PacketAnomalies{
+		DataAfterClose:     atomic.LoadUint64(&r.anomalies[DataAfterClose-1]),
+		ReplyBeforeRequest: atomic.LoadUint64(&r.anomalies[ReplyBeforeRequest-1]),
+		DuplicateReply:     atomic.LoadUint64(&r.anomalies[DuplicateReply-1]),
+	}
+}
+
+// closedState is what is left of a request after it was closed, as far as packets of the remote are concerned.
+type closedState uint8
+
+const (
+	// closedLocally: we ended the request, the remote might still send data until it sees that
+	closedLocally closedState = iota
+	// closedBoth: the remote ended the request as well, nothing may follow
+	closedBoth
+	// closedReplied: our async call got its reply
+	closedReplied
+)
+
+// anomaly returns what a data packet is for a request in this state, zero if it can be dropped quietly.
+func (s closedState) anomaly() PacketAnomaly {
+	switch s {
+	case closedBoth:
+		return DataAfterClose
+	case closedReplied:
+		return DuplicateReply
+	}
+	return 0
+}
+
+// dropAnomaly discards the body of a packet that doesn't fit the state of its request and counts it.
+// Only the first anomaly of each kind is logged as a warning. The error ends the session.
+func (r *rpc) dropAnomaly(hdr codec.Header, a PacketAnomaly) error {
+	if _, err := io.Copy(ioutil.Discard, r.pkr.r.NextBodyReader(hdr.Len)); err != nil {
+		return fmt.Errorf("muxrpc: failed to discard body of req: %d (len:%d): %w", hdr.Req, hdr.Len, err)
+	}
+
+	lvl := level.Debug(r.logger)
+	if atomic.AddUint64(&r.anomalies[a-1], 1) == 1 {
+		lvl = level.Warn(r.logger)
+	}
+	lvl.Log("event", "dropped packet", "anomaly", a.String(), "req", hdr.Req, "len", hdr.Len, "flags", hdr.Flag)
+
+	if r.strictPackets {
+		return fmt.Errorf("muxrpc: %s on req %d: %w", a, hdr.Req, ErrProtocolViolation)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+)
+
+// anomalyHandler answers hello and streams a single frame for things
+func anomalyHandler() *FakeHandler {
+	var fh FakeHandler
+	fh.HandledCalls(func(m Method) bool { return m.String() == "hello" || m.String() == "things" })
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		if req.Method.String() == "hello" {
+			req.Return(ctx, "world")
+			return
+		}
+		snk, err := req.ResponseSink()
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		snk.Write([]byte("thing"))
+		req.Close()
+	})
+	return &fh
+}
+
+func TestPacketAnomalies(t *testing.T) {
+	r := require.New(t)
+
+	edp, rd, w := rawPeer(t, anomalyHandler())
+	ctx := context.Background()
+
+	// the serve loop handled everything before the reply to this
+	hello := func(id int32) {
+		r.NoError(w.WritePacket(codec.Packet{
+			Req:  id,
+			Flag: codec.FlagJSON,
+			Body: []byte(`{"name":["hello"],"args":[],"type":"async"}`),
+		}))
+		for {
+			pkt, err := rd.ReadPacket()
+			r.NoError(err)
+			if pkt.Req == -id {
+				r.Equal("world", string(pkt.Body))
+				return
+			}
+		}
+	}
+
+	// a reply to a call we didn't make
+	r.NoError(w.WritePacket(codec.Packet{
+		Req:  -77,
+		Flag: codec.FlagJSON,
+		Body: []byte(`"surprise"`),
+	}))
+	hello(1)
+
+	// two replies to one call
+	replied := make(chan error, 1)
+	go func() {
+		var resp string
+		replied <- edp.Async(ctx, &resp, TypeString, Method{"hello"})
+	}()
+	call, err := rd.ReadPacket()
+	r.NoError(err)
+	for i := 0; i < 2; i++ {
+		r.NoError(w.WritePacket(codec.Packet{
+			Req:  -call.Req,
+			Flag: codec.FlagString,
+			Body: []byte("world"),
+		}))
+	}
+	r.NoError(<-replied)
+	hello(2)
+
+	// data after both sides ended a stream
+	r.NoError(w.WritePacket(codec.Packet{
+		Req:  3,
+		Flag: codec.FlagJSON | codec.FlagStream,
+		Body: []byte(`{"name":["things"],"args":[],"type":"source"}`),
+	}))
+	for {
+		pkt, err := rd.ReadPacket()
+		r.NoError(err)
+		r.Equal(int32(-3), pkt.Req)
+		if pkt.Flag.Get(codec.FlagEndErr) {
+			break
+		}
+	}
+	r.NoError(w.WritePacket(codec.Packet{
+		Req:  3,
+		Flag: codec.FlagJSON | codec.FlagStream | codec.FlagEndErr,
+		Body: []byte("true"),
+	}))
+	r.NoError(w.WritePacket(codec.Packet{
+		Req:  3,
+		Flag: codec.FlagStream,
+		Body: []byte("late"),
+	}))
+	hello(4)
+
+	got, ok := Anomalies(edp)
+	r.True(ok)
+	r.Equal(PacketAnomalies{DataAfterClose: 1, ReplyBeforeRequest: 1, DuplicateReply: 1}, got)
+	_, ended := SessionEnd(edp)
+	r.False(ended)
+
+	_, ok = Anomalies(&FakeEndpoint{})
+	r.False(ok)
+}
+
+func TestStrictPackets(t *testing.T) {
+	r := require.New(t)
+
+	edp, rd, w := rawPeer(t, anomalyHandler(), WithStrictPackets())
+
+	replied := make(chan error, 1)
+	go func() {
+		var resp string
+		replied <- edp.Async(context.Background(), &resp, TypeString, Method{"hello"})
+	}()
+	call, err := rd.ReadPacket()
+	r.NoError(err)
+	for i := 0; i < 2; i++ {
+		r.NoError(w.WritePacket(codec.Packet{
+			Req:  -call.Req,
+			Flag: codec.FlagString,
+			Body: []byte("world"),
+		}))
+	}
+	r.NoError(<-replied)
+
+	r.Eventually(func() bool {
+		_, ended := SessionEnd(edp)
+		return ended
+	}, 5*time.Second, 10*time.Millisecond, "the session should end on the second reply")
+	end, _ := SessionEnd(edp)
+	r.True(errors.Is(end, ErrProtocolViolation), "unexpected end: %v", end)
+
+	got, _ := Anomalies(edp)
+	r.EqualValues(1, got.DuplicateReply)
+}
@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ParseArgs decodes the arguments of the call into dst, one pointer per position.
+// A nil pointer skips its argument. The call needs to have exactly as many arguments as dst has entries.
+func (req *Request) ParseArgs(dst ...interface{}) error {
+	args, err := req.rawArgList()
+	if err != nil {
+		return err
+	}
+	if len(args) != len(dst) {
+		return fmt.Errorf("muxrpc: %s takes %d arguments, got %d", req.Method, len(dst), len(args))
+	}
+
+	for i, arg := range args {
+		if dst[i] == nil {
+			continue
+		}
+		if err := json.Unmarshal(arg, dst[i]); err != nil {
+			return fmt.Errorf("muxrpc: argument %d of %s: %w", i+1, req.Method, err)
+		}
+	}
+	return nil
+}
+
+// Args decodes the first argument of the call as a T. Further arguments are ignored.
+func Args[T any](req *Request) (T, error) {
+	var v T
+	args, err := req.rawArgList()
+	if err != nil {
+		return v, err
+	}
+	if len(args) == 0 {
+		return v, fmt.Errorf("muxrpc: %s needs an argument", req.Method)
+	}
+	if err := json.Unmarshal(args[0], &v); err != nil {
+		return v, fmt.Errorf("muxrpc: argument 1 of %s: %w", req.Method, err)
+	}
+	return v, nil
+}
+
+// rawArgList splits RawArgs into the single arguments
+func (req *Request) rawArgList() ([]json.RawMessage, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		return nil, fmt.Errorf("muxrpc: arguments of %s are not a list: %w", req.Method, err)
+	}
+	return args, nil
+}
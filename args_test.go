@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArgs(t *testing.T) {
+	r := require.New(t)
+
+	req := &Request{
+		Method:  Method{"blobs", "get"},
+		RawArgs: json.RawMessage(`["&abc.sha256", {"max": 23}, true]`),
+	}
+
+	var (
+		ref  string
+		opts struct {
+			Max int `json:"max"`
+		}
+		flag bool
+	)
+	r.NoError(req.ParseArgs(&ref, &opts, &flag))
+	r.Equal("&abc.sha256", ref)
+	r.Equal(23, opts.Max)
+	r.True(flag)
+
+	// skipping one
+	ref = ""
+	r.NoError(req.ParseArgs(&ref, nil, nil))
+	r.Equal("&abc.sha256", ref)
+
+	err := req.ParseArgs(&ref)
+	r.EqualError(err, "muxrpc: blobs.get takes 1 arguments, got 3")
+
+	err = req.ParseArgs(&flag, nil, nil)
+	r.Error(err)
+	r.Contains(err.Error(), "argument 1 of blobs.get")
+
+	first, err := Args[string](req)
+	r.NoError(err)
+	r.Equal("&abc.sha256", first)
+
+	_, err = Args[int](req)
+	r.Error(err)
+
+	_, err = Args[string](&Request{Method: Method{"whoami"}, RawArgs: json.RawMessage(`[]`)})
+	r.EqualError(err, "muxrpc: whoami needs an argument")
+
+	err = (&Request{Method: Method{"whoami"}, RawArgs: json.RawMessage(`{}`)}).ParseArgs()
+	r.Error(err)
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"go.cryptoscope.co/luigi"
+)
+
+// AuditRecord describes an incoming call once it finished.
+type AuditRecord struct {
+	// Remote is the address of the caller, see RemoteKey and PeerCertificate for its identity
+	Remote net.Addr
+
+	Method Method
+	Type   CallType
+
+	// ArgsSize is the length of the encoded arguments
+	ArgsSize int
+
+	// Err is nil if the call ended regularly.
+	// Calls to methods we don't handle have ErrNoSuchMethod and
+	// calls that were still running when the session ended have ErrSessionTerminated.
+	Err error
+
+	Started  time.Time
+	Duration time.Duration
+}
+
+// AuditSink receives a record for every incoming call, see WithAuditSink.
+// It is called from the goroutines of the session and should not block.
+type AuditSink func(AuditRecord)
+
+// WithAuditSink passes a record of every incoming call to sink, for instance to feed security monitoring.
+func WithAuditSink(sink AuditSink) HandleOption {
+	return func(r *rpc) {
+		r.audit = sink
+	}
+}
+
+// auditCall reports the end of an incoming call to the audit sink. Only the first report of a call counts.
+// It also runs the OnCallEnd hooks for it.
+func (r *rpc) auditCall(req *Request, err error) {
+	if req.id >= 0 {
+		return
+	}
+	if !atomic.CompareAndSwapUint32(&req.state.audited, 0, 1) {
+		return
+	}
+	r.callEnded(req, err)
+	if r.audit == nil {
+		return
+	}
+	if errors.Is(err, io.EOF) || luigi.IsEOS(err) {
+		err = nil
+	}
+	r.audit(AuditRecord{
+		Remote:   r.remote,
+		Method:   req.Method,
+		Type:     req.Type,
+		ArgsSize: len(req.RawArgs),
+		Err:      err,
+		Started:  req.started,
+		Duration: r.clock.Now().Sub(req.started),
+	})
+}
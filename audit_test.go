@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditSink(t *testing.T) {
+	r := require.New(t)
+
+	records := make(chan AuditRecord, 10)
+	sink := func(rec AuditRecord) {
+		if rec.Method.String() != "manifest" { // asked for by both sides during the setup
+			records <- rec
+		}
+	}
+
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(func(m Method) bool {
+		return m.String() == "whoami" || m.String() == "fail"
+	})
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		if req.Method.String() == "fail" {
+			req.CloseWithError(errors.New("nope"))
+			return
+		}
+		req.Return(ctx, "you")
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2, WithAuditSink(sink))
+
+	next := func() AuditRecord {
+		select {
+		case rec := <-records:
+			return rec
+		case <-time.After(2 * time.Second):
+			t.Fatal("no audit record")
+			return AuditRecord{}
+		}
+	}
+
+	ctx := context.Background()
+	var resp string
+	r.NoError(rpc1.Async(ctx, &resp, TypeString, Method{"whoami"}, "some", "args"))
+	rec := next()
+	r.Equal("whoami", rec.Method.String())
+	r.Equal(CallType("async"), rec.Type)
+	r.Equal(len(`["some","args"]`), rec.ArgsSize)
+	r.NoError(rec.Err)
+	r.NotNil(rec.Remote)
+	r.False(rec.Started.IsZero())
+
+	src, err := rpc1.Source(ctx, TypeString, Method{"fail"})
+	r.NoError(err)
+	r.False(src.Next(ctx))
+	rec = next()
+	r.Equal("fail", rec.Method.String())
+	r.Equal(CallType("source"), rec.Type)
+	r.EqualError(rec.Err, "nope")
+
+	// the manifest lets every call through, but the remote doesn't handle this one
+	err = rpc1.Async(ctx, &resp, TypeString, Method{"unknown"})
+	r.Error(err)
+	rec = next()
+	r.Equal("unknown", rec.Method.String())
+	var nsm ErrNoSuchMethod
+	r.True(errors.As(rec.Err, &nsm))
+
+	select {
+	case rec := <-records:
+		t.Fatalf("unexpected record: %+v", rec)
+	default:
+	}
+}
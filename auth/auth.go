@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: MIT
+
+// Package auth restricts which methods a peer may call, based on the identity it authenticated with.
+//
+// The identity comes from the remote address of the connection:
+// the public key of a secretstream connection (see muxrpc.NewSecretStreamPacker)
+// or the certificate of a TLS connection (see muxrpc.NewTLSPacker).
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	"go.cryptoscope.co/muxrpc/v2"
+)
+
+// ErrNotAuthorized is returned to callers of methods they are not allowed to call.
+// Its message matches the one of the JS permissions module, so JS clients recognize it.
+type ErrNotAuthorized struct {
+	Method muxrpc.Method
+}
+
+func (e ErrNotAuthorized) Error() string {
+	return fmt.Sprintf("method:%s is not in list of allowed methods", strings.Join(e.Method, ","))
+}
+
+// KeyID returns the identity of a peer that authenticated with the secretstream key pub.
+// It is formatted as a feed reference (@base64.ed25519).
+func KeyID(pub ed25519.PublicKey) string {
+	return "@" + base64.StdEncoding.EncodeToString(pub) + ".ed25519"
+}
+
+// CertID returns the identity of a peer that presented cert over TLS.
+// It is the hex encoded SHA-256 fingerprint of the certificate, prefixed by "sha256:".
+func CertID(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// PeerID returns the identity of the peer behind addr, in the format of KeyID or CertID.
+// It returns false if the connection didn't authenticate the peer.
+func PeerID(addr net.Addr) (string, bool) {
+	if key, ok := muxrpc.RemoteKey(addr); ok {
+		return KeyID(key), true
+	}
+	if cert, ok := muxrpc.PeerCertificate(addr); ok {
+		return CertID(cert), true
+	}
+	return "", false
+}
+
+// Permissions are lists of methods a peer may or may not call.
+// Every entry also matches the methods below it, so Method{"blobs"} covers blobs.get and blobs.has.
+// The zero value permits nothing.
+type Permissions struct {
+	// AllowAll permits every method that is not denied, regardless of Allow.
+	AllowAll bool
+
+	// Allow lists the permitted methods.
+	Allow []muxrpc.Method
+
+	// Deny lists forbidden methods. It takes precedence over Allow.
+	Deny []muxrpc.Method
+}
+
+// Allowed returns true if m may be called
+func (p Permissions) Allowed(m muxrpc.Method) bool {
+	if matchesAny(p.Deny, m) {
+		return false
+	}
+	return p.AllowAll || matchesAny(p.Allow, m)
+}
+
+func matchesAny(list []muxrpc.Method, m muxrpc.Method) bool {
+	for _, prefix := range list {
+		if hasPrefix(m, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefix(m, prefix muxrpc.Method) bool {
+	if len(prefix) > len(m) {
+		return false
+	}
+	for i := range prefix {
+		if m[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Policy decides the permissions of a peer by its identity.
+type Policy struct {
+	// Peers holds the permissions of known peers, keyed by KeyID or CertID
+	Peers map[string]Permissions
+
+	// Default applies to authenticated peers that are not in Peers.
+	// Like Anonymous, it denies every call unless it is set.
+	Default Permissions
+
+	// Anonymous applies to peers without an identity, like plain TCP connections
+	Anonymous Permissions
+}
+
+// Permissions returns what the peer behind addr may do
+func (p Policy) Permissions(addr net.Addr) Permissions {
+	id, ok := PeerID(addr)
+	if !ok {
+		return p.Anonymous
+	}
+	if perms, has := p.Peers[id]; has {
+		return perms
+	}
+	return p.Default
+}
+
+// manifestMethod is always allowed since the session setup asks for it
+var manifestMethod = muxrpc.Method{"manifest"}
+
+// NewHandler checks the permissions of every incoming call against pol
+// and only passes the allowed ones on to h. The others are closed with ErrNotAuthorized.
+func NewHandler(pol Policy, h muxrpc.Handler) muxrpc.Handler {
+	return handler{pol: pol, root: h}
+}
+
+// Wrapper returns NewHandler as a muxrpc.HandlerWrapper
+func Wrapper(pol Policy) muxrpc.HandlerWrapper {
+	return func(h muxrpc.Handler) muxrpc.Handler {
+		return NewHandler(pol, h)
+	}
+}
+
+type handler struct {
+	pol  Policy
+	root muxrpc.Handler
+}
+
+func (h handler) Handled(m muxrpc.Method) bool { return h.root.Handled(m) }
+
+func (h handler) HandleConnect(ctx context.Context, edp muxrpc.Endpoint) {
+	h.root.HandleConnect(ctx, edp)
+}
+
+func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request) {
+	if req.Method.String() != manifestMethod.String() && !h.pol.Permissions(req.RemoteAddr()).Allowed(req.Method) {
+		req.CloseWithError(ErrNotAuthorized{Method: req.Method})
+		return
+	}
+	h.root.HandleCall(ctx, req)
+}
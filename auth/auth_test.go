@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: MIT
+
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2"
+	"go.cryptoscope.co/muxrpc/v2/muxtest"
+)
+
+func TestPermissions(t *testing.T) {
+	r := require.New(t)
+
+	p := Permissions{
+		Allow: []muxrpc.Method{{"whoami"}, {"blobs"}},
+		Deny:  []muxrpc.Method{{"blobs", "rm"}},
+	}
+	r.True(p.Allowed(muxrpc.Method{"whoami"}))
+	r.True(p.Allowed(muxrpc.Method{"blobs", "get"}))
+	r.False(p.Allowed(muxrpc.Method{"blobs", "rm"}))
+	r.False(p.Allowed(muxrpc.Method{"createHistoryStream"}))
+
+	r.False(Permissions{}.Allowed(muxrpc.Method{"anything"}), "the zero value denies")
+	r.False(Permissions{Allow: []muxrpc.Method{}}.Allowed(muxrpc.Method{"anything"}))
+
+	all := Permissions{AllowAll: true, Deny: []muxrpc.Method{{"blobs", "rm"}}}
+	r.True(all.Allowed(muxrpc.Method{"anything"}))
+	r.False(all.Allowed(muxrpc.Method{"blobs", "rm"}))
+}
+
+func TestHandler(t *testing.T) {
+	r := require.New(t)
+
+	pub, _, err := ed25519.GenerateKey(nil)
+	r.NoError(err)
+	other, _, err := ed25519.GenerateKey(nil)
+	r.NoError(err)
+
+	var fh muxrpc.FakeHandler
+	fh.HandledReturns(true)
+	fh.HandleCallCalls(func(ctx context.Context, req *muxrpc.Request) {
+		req.Return(ctx, "ok")
+	})
+
+	pol := Policy{
+		Peers: map[string]Permissions{
+			KeyID(pub): {Allow: []muxrpc.Method{{"whoami"}}},
+		},
+		Default: Permissions{Allow: []muxrpc.Method{}},
+	}
+
+	connect := func(key ed25519.PublicKey) *muxtest.Pair {
+		remote := muxrpc.SecretStreamAddr{Addr: muxtest.Addr("peer"), PubKey: key}
+		h := muxrpc.ApplyHandlerWrappers(&fh, Wrapper(pol))
+		p := muxtest.Connect(h, h, muxtest.Options{}, muxrpc.WithRemoteAddr(remote))
+		t.Cleanup(func() { p.Close() })
+		return p
+	}
+
+	ctx := context.Background()
+	var resp string
+
+	known := connect(pub)
+	r.NoError(known.A.Async(ctx, &resp, muxrpc.TypeString, muxrpc.Method{"whoami"}))
+	r.Equal("ok", resp)
+
+	err = known.A.Async(ctx, &resp, muxrpc.TypeString, muxrpc.Method{"publish"})
+	var ce *muxrpc.CallError
+	r.True(errors.As(err, &ce), "unexpected error: %v", err)
+	r.Equal("method:publish is not in list of allowed methods", ce.Message)
+
+	unknown := connect(other)
+	err = unknown.A.Async(ctx, &resp, muxrpc.TypeString, muxrpc.Method{"whoami"})
+	r.Error(err)
+}
+
+func TestZeroPolicy(t *testing.T) {
+	r := require.New(t)
+
+	var fh muxrpc.FakeHandler
+	fh.HandledReturns(true)
+	var passed int32
+	fh.HandleCallCalls(func(ctx context.Context, req *muxrpc.Request) {
+		if req.Method.String() != "manifest" {
+			atomic.AddInt32(&passed, 1)
+		}
+		req.Return(ctx, "ok")
+	})
+
+	pub, _, err := ed25519.GenerateKey(nil)
+	r.NoError(err)
+
+	ctx := context.Background()
+	for _, remote := range []net.Addr{
+		muxtest.Addr("anonymous"),
+		muxrpc.SecretStreamAddr{Addr: muxtest.Addr("peer"), PubKey: pub},
+	} {
+		h := muxrpc.ApplyHandlerWrappers(&fh, Wrapper(Policy{}))
+		p := muxtest.Connect(h, h, muxtest.Options{}, muxrpc.WithRemoteAddr(remote))
+		t.Cleanup(func() { p.Close() })
+
+		var resp string
+		err := p.A.Async(ctx, &resp, muxrpc.TypeString, muxrpc.Method{"whoami"})
+		var ce *muxrpc.CallError
+		r.True(errors.As(err, &ce), "unexpected error: %v", err)
+		r.Equal(ErrNotAuthorized{Method: muxrpc.Method{"whoami"}}.Error(), ce.Message)
+	}
+	r.Equal(int32(0), atomic.LoadInt32(&passed), "only the manifest may pass")
+}
@@ -0,0 +1,180 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"fmt"
+
+	"go.mindeco.de/log/level"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+)
+
+// DoBatch starts several calls at once. Their request packets are sent with a single write,
+// which saves a lot of overhead when many calls are made in one go, like requesting dozens of feeds after connecting.
+//
+// Each request needs its Type and Method, RawArgs are the encoded arguments and default to none.
+// Once DoBatch returned, use CallSource and CallSink of the requests to get at the replies and streams.
+// Streams start out with JSON encoding, use SetEncoding on the sink to change that.
+// If DoBatch returns an error, none of the calls were started.
+func (r *rpc) DoBatch(ctx context.Context, reqs ...*Request) error {
+	if err := r.Err(); err != nil {
+		return err
+	}
+
+	for _, req := range reqs {
+		if req.id != 0 {
+			return fmt.Errorf("muxrpc: request for %s was already started", req.Method)
+		}
+		switch req.Type {
+		case "async", "source", "sink", "duplex":
+		default:
+			return fmt.Errorf("muxrpc: can't batch call %s of type %q", req.Method, req.Type)
+		}
+		if err := r.checkCall(req.Method, req.Type); err != nil {
+			return err
+		}
+	}
+
+	slots := 0
+	for range reqs {
+		if err := r.acquireSlot(ctx); err != nil {
+			for ; slots > 0; slots-- {
+				r.releaseSlot()
+			}
+			return err
+		}
+		slots++
+	}
+
+	pkts := make([]codec.Packet, len(reqs))
+	for i, req := range reqs {
+		r.prepareBatched(ctx, req)
+		pkts[i].Flag = codec.FlagJSON.Set(req.Type.Flags())
+	}
+
+	err := func() error { // localize locking
+		r.rLock.Lock()
+		defer r.rLock.Unlock()
+
+		// see start
+		if r.serveCtx.Err() != nil {
+			return ErrSessionTerminated
+		}
+
+		for i, req := range reqs {
+			body, err := r.json.Marshal(wireRequest{Request: req, Encoding: req.enc, Trace: req.trace, Headers: req.headers})
+			if err != nil {
+				return fmt.Errorf("muxrpc: failed to encode request for %s: %w", req.Method, err)
+			}
+			pkts[i].Body = body
+		}
+
+		for i, req := range reqs {
+			id, err := r.nextID()
+			if err != nil {
+				for _, started := range reqs[:i] {
+					delete(r.reqs, started.id)
+				}
+				return err
+			}
+			pkts[i].Req = id
+			r.reqs[id] = req
+
+			req.id = id
+			req.sink.pkt.Req = id
+		}
+		return nil
+	}()
+	if err != nil {
+		for ; slots > 0; slots-- {
+			r.releaseSlot()
+		}
+		return err
+	}
+
+	for _, req := range reqs {
+		r.callStarted(req)
+	}
+	err = r.pkr.w.WritePackets(pkts)
+	if err == nil {
+		err = r.pkr.w.Flush()
+	}
+	if err != nil {
+		r.rLock.Lock()
+		for _, req := range reqs {
+			r.forgetRequest(req.id)
+		}
+		r.rLock.Unlock()
+		for _, req := range reqs {
+			req.source.Cancel(err)
+			req.abort()
+			r.reportOutcome(req, err)
+		}
+		return fmt.Errorf("muxrpc: error sending batch: %w", err)
+	}
+
+	level.Debug(r.logger).Log("event", "batch sent", "requests", len(reqs), "first", pkts[0].Req)
+
+	for _, req := range reqs {
+		go r.abortOnCancel(ctx, req)
+		if req.Type == "async" {
+			go r.awaitBatchedReply(ctx, req)
+		}
+	}
+	return nil
+}
+
+// prepareBatched sets up the streams of a request for DoBatch, like the individual call methods do
+func (r *rpc) prepareBatched(ctx context.Context, req *Request) {
+	reqCtx, cancel := context.WithCancel(ctx)
+	bodyCodec, enc := r.bodyCodec()
+
+	req.abort = cancel
+	req.enc = enc
+	req.state = new(requestState)
+	req.source = newByteSource(reqCtx, r.bpool, bodyCodec)
+	req.sink = newByteSink(reqCtx, r.pkr.w, bodyCodec)
+	req.sink.pkt.Flag = req.sink.pkt.Flag.Set(codec.FlagJSON).Set(req.Type.Flags())
+	req.holdsSlot = r.outstanding != nil
+	req.endpoint = r
+	req.remoteAddr = r.remote
+	req.started = r.clock.Now()
+	req.sink.now = r.clock.Now
+	r.instrumentSink(req)
+	r.trackMemory(req)
+	req.trace = traceFor(ctx)
+	req.headers = r.requestHeaders(ctx)
+	markLiveCall(ctx, req)
+
+	switch req.Type {
+	case "sink":
+		req.Stream = req.sink.AsStream()
+	case "duplex":
+		req.Stream = &streamDuplex{req.source.AsStream(), req.sink.AsStream()}
+	default:
+		req.Stream = req.source.AsStream()
+	}
+
+	if req.RawArgs == nil {
+		req.RawArgs = []byte("[]")
+	}
+}
+
+// awaitBatchedReply retires a batched async call once its reply arrived, like Async does after reading it.
+// The reply stays buffered in the source until the caller reads it.
+func (r *rpc) awaitBatchedReply(ctx context.Context, req *Request) {
+	if !req.source.Next(ctx) {
+		err := req.source.Err()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		r.reportOutcome(req, err)
+		return
+	}
+	r.reportOutcome(req, nil)
+	if ctx.Err() == nil {
+		r.retireRequest(req)
+	}
+}
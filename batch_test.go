@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoBatch(t *testing.T) {
+	r := require.New(t)
+
+	collected := make(chan []string, 1)
+
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(func(m Method) bool {
+		switch m.String() {
+		case "hello", "count", "collect":
+			return true
+		}
+		return false
+	})
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		switch req.Method.String() {
+		case "hello":
+			var args []string
+			if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+				req.CloseWithError(err)
+				return
+			}
+			req.Return(ctx, "hello, "+args[0])
+
+		case "count":
+			snk, err := req.ResponseSink()
+			if err != nil {
+				req.CloseWithError(err)
+				return
+			}
+			snk.SetEncoding(TypeString)
+			for i := 0; i < 3; i++ {
+				fmt.Fprint(snk, i)
+			}
+			snk.Close()
+
+		case "collect":
+			src, err := req.ResponseSource()
+			if err != nil {
+				req.CloseWithError(err)
+				return
+			}
+			var got []string
+			for src.Next(ctx) {
+				b, err := src.Bytes()
+				if err != nil {
+					break
+				}
+				got = append(got, string(b))
+			}
+			collected <- got
+			req.Close()
+		}
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2)
+
+	hello := &Request{Type: "async", Method: Method{"hello"}, RawArgs: json.RawMessage(`["batch"]`)}
+	count := &Request{Type: "source", Method: Method{"count"}}
+	collect := &Request{Type: "sink", Method: Method{"collect"}}
+
+	ctx := context.Background()
+	r.NoError(rpc1.DoBatch(ctx, hello, count, collect))
+
+	// a request can't be started twice
+	r.Error(rpc1.DoBatch(ctx, hello))
+
+	src, err := hello.CallSource()
+	r.NoError(err)
+	r.True(src.Next(ctx))
+	b, err := src.Bytes()
+	r.NoError(err)
+	r.Equal("hello, batch", string(b))
+
+	src, err = count.CallSource()
+	r.NoError(err)
+	var got []string
+	for src.Next(ctx) {
+		b, err := src.Bytes()
+		r.NoError(err)
+		got = append(got, string(b))
+	}
+	r.Equal([]string{"0", "1", "2"}, got)
+
+	_, err = collect.CallSource()
+	r.Error(err)
+	snk, err := collect.CallSink()
+	r.NoError(err)
+	snk.SetEncoding(TypeString)
+	fmt.Fprint(snk, "a")
+	fmt.Fprint(snk, "b")
+	r.NoError(snk.Close())
+	r.Equal([]string{"a", "b"}, <-collected)
+
+	err = rpc1.DoBatch(ctx, &Request{Type: "bogus", Method: Method{"hello"}})
+	r.Error(err)
+}
@@ -0,0 +1,13 @@
+// SPDX-License-Identifier: MIT
+
+// Package benchmarks measures muxrpc end to end: the latency of async calls, the throughput of source streams
+// with JSON and binary payloads, the allocations per packet and the contention between concurrent streams.
+// Every suite runs over an in-memory net.Pipe and over real TCP on the loopback interface.
+//
+// There is no code to import here, only benchmarks to run and compare before and after a change:
+//
+//	go test -run=NONE -bench=. -benchmem -count=10 ./benchmarks > old.txt
+//	# change something
+//	go test -run=NONE -bench=. -benchmem -count=10 ./benchmarks > new.txt
+//	benchstat old.txt new.txt
+package benchmarks
@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: MIT
+
+package benchmarks
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"go.cryptoscope.co/muxrpc/v2"
+)
+
+// BenchmarkAsyncLatency makes one call after the other and reports the median and the 99th percentile next to the mean.
+func BenchmarkAsyncLatency(b *testing.B) {
+	for _, tr := range transports {
+		b.Run(tr.name, func(b *testing.B) {
+			edp := connect(b, tr)
+			ctx := context.Background()
+
+			took := make([]time.Duration, b.N)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				var pong string
+				if err := edp.Async(ctx, &pong, muxrpc.TypeString, muxrpc.Method{"ping"}); err != nil {
+					b.Fatal(err)
+				}
+				took[i] = time.Since(start)
+			}
+			b.StopTimer()
+			reportPercentiles(b, took)
+		})
+	}
+}
+
+// BenchmarkAsyncParallel makes calls from GOMAXPROCS goroutines at once, which all share one connection.
+func BenchmarkAsyncParallel(b *testing.B) {
+	for _, tr := range transports {
+		b.Run(tr.name, func(b *testing.B) {
+			edp := connect(b, tr)
+			ctx := context.Background()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					var pong string
+					if err := edp.Async(ctx, &pong, muxrpc.TypeString, muxrpc.Method{"ping"}); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func reportPercentiles(b *testing.B, took []time.Duration) {
+	if len(took) == 0 {
+		return
+	}
+	sort.Slice(took, func(i, j int) bool { return took[i] < took[j] })
+	for _, p := range []int{50, 99} {
+		b.ReportMetric(float64(took[(len(took)-1)*p/100].Nanoseconds()), fmt.Sprintf("p%d-ns", p))
+	}
+}
@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: MIT
+
+package benchmarks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+
+	"go.mindeco.de/log"
+
+	"go.cryptoscope.co/muxrpc/v2"
+	"go.cryptoscope.co/muxrpc/v2/typemux"
+)
+
+// transport makes the two ends of a connection
+type transport struct {
+	name  string
+	conns func(testing.TB) (net.Conn, net.Conn)
+}
+
+var transports = []transport{
+	{"pipe", pipeConns},
+	{"tcp", tcpConns},
+}
+
+func pipeConns(testing.TB) (net.Conn, net.Conn) { return net.Pipe() }
+
+func tcpConns(t testing.TB) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+
+	c1, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2 := <-accepted
+	if c2 == nil {
+		t.FailNow()
+	}
+	return c1, c2
+}
+
+// payload is what the JSON sources send
+type payload struct {
+	Seq  int    `json:"seq"`
+	Text string `json:"text"`
+}
+
+// payloadSize is the size of the binary packets, about the size of an encoded payload
+const payloadSize = 64
+
+// server handles the methods the benchmarks call:
+//
+//	ping: async, returns "pong"
+//	json: source, sends the number of payloads from its first argument as JSON
+//	binary: source, sends the number of payloadSize byte packets from its first argument
+func server() muxrpc.Handler {
+	mux := typemux.New(log.NewNopLogger())
+	mux.RegisterAsync(muxrpc.Method{"ping"}, typemux.AsyncFunc(func(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+		return "pong", nil
+	}))
+
+	mux.RegisterSource(muxrpc.Method{"json"}, typemux.SourceFunc(func(ctx context.Context, req *muxrpc.Request, snk *muxrpc.ByteSink) error {
+		var n int
+		if err := req.ParseArgs(&n); err != nil {
+			return err
+		}
+		snk.SetEncoding(muxrpc.TypeJSON)
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for i := 0; i < n; i++ {
+			buf.Reset()
+			enc.Encode(payload{Seq: i, Text: "the quick brown fox jumps over the lazy dog"})
+			if _, err := snk.Write(buf.Bytes()); err != nil {
+				return err
+			}
+		}
+		return snk.Close()
+	}))
+
+	mux.RegisterSource(muxrpc.Method{"binary"}, typemux.SourceFunc(func(ctx context.Context, req *muxrpc.Request, snk *muxrpc.ByteSink) error {
+		var n int
+		if err := req.ParseArgs(&n); err != nil {
+			return err
+		}
+		snk.SetEncoding(muxrpc.TypeBinary)
+		data := bytes.Repeat([]byte{0xaa}, payloadSize)
+		for i := 0; i < n; i++ {
+			if _, err := snk.Write(data); err != nil {
+				return err
+			}
+		}
+		return snk.Close()
+	}))
+	return &mux
+}
+
+// connect serves the benchmark methods on one end of tr and returns the endpoint of the other end.
+// Both are terminated once the benchmark is done.
+func connect(b *testing.B, tr transport) muxrpc.Endpoint {
+	c1, c2 := tr.conns(b)
+	opts := []muxrpc.HandleOption{muxrpc.WithLogger(log.NewNopLogger())}
+
+	started := make(chan muxrpc.Endpoint)
+	go func() {
+		started <- muxrpc.Handle(muxrpc.NewPacker(c2), server(), opts...)
+	}()
+	client := muxrpc.Handle(muxrpc.NewPacker(c1), &muxrpc.FakeHandler{}, opts...)
+	srv := <-started
+
+	for _, edp := range []muxrpc.Endpoint{client, srv} {
+		go edp.(muxrpc.Server).Serve()
+	}
+	b.Cleanup(func() {
+		client.Terminate()
+		srv.Terminate()
+	})
+	return client
+}
+
+// drain reads the source to its end and returns the number of bytes it got
+func drain(ctx context.Context, src *muxrpc.ByteSource) (int, error) {
+	var total int
+	for src.Next(ctx) {
+		err := src.Reader(func(r io.Reader) error {
+			n, err := io.Copy(io.Discard, r)
+			total += int(n)
+			return err
+		})
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, src.Err()
+}
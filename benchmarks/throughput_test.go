@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: MIT
+
+package benchmarks
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"go.cryptoscope.co/muxrpc/v2"
+)
+
+// BenchmarkSource streams b.N packets over one source, so ns/op and allocs/op are per packet.
+func BenchmarkSource(b *testing.B) {
+	for _, enc := range []struct {
+		method string
+		re     muxrpc.RequestEncoding
+	}{
+		{"json", muxrpc.TypeJSON},
+		{"binary", muxrpc.TypeBinary},
+	} {
+		for _, tr := range transports {
+			b.Run(enc.method+"/"+tr.name, func(b *testing.B) {
+				edp := connect(b, tr)
+				ctx := context.Background()
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				src, err := edp.Source(ctx, enc.re, muxrpc.Method{enc.method}, b.N)
+				if err != nil {
+					b.Fatal(err)
+				}
+				n, err := drain(ctx, src)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				b.SetBytes(int64(n / b.N))
+			})
+		}
+	}
+}
+
+// BenchmarkConcurrentStreams spreads b.N packets over several sources that run at the same time,
+// to show how the streams of one connection get in each other's way.
+func BenchmarkConcurrentStreams(b *testing.B) {
+	for _, streams := range []int{1, 8, 64} {
+		for _, tr := range transports {
+			b.Run(fmt.Sprintf("%d/%s", streams, tr.name), func(b *testing.B) {
+				edp := connect(b, tr)
+				ctx := context.Background()
+
+				var (
+					wg    sync.WaitGroup
+					mu    sync.Mutex
+					total int
+				)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < streams; i++ {
+					count := b.N / streams
+					if i < b.N%streams {
+						count++
+					}
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						src, err := edp.Source(ctx, muxrpc.TypeBinary, muxrpc.Method{"binary"}, count)
+						if err != nil {
+							b.Error(err)
+							return
+						}
+						n, err := drain(ctx, src)
+						if err != nil {
+							b.Error(err)
+						}
+						mu.Lock()
+						total += n
+						mu.Unlock()
+					}()
+				}
+				wg.Wait()
+				b.StopTimer()
+				b.SetBytes(int64(total / b.N))
+			})
+		}
+	}
+}
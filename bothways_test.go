@@ -369,8 +369,10 @@ func TestBothwaysSource(t *testing.T) {
 	ctx := context.Background()
 
 	var rpc2 Endpoint
+	rpc2started := make(chan struct{})
 	go func() {
 		rpc2 = Handle(NewPacker(c2), &fh2)
+		close(rpc2started)
 		serve(ctx, rpc2.(Server), errc, serve2)
 	}()
 
@@ -381,6 +383,7 @@ func TestBothwaysSource(t *testing.T) {
 
 	rpc1 := Handle(dbgpacker, &fh1)
 	go serve(ctx, rpc1.(Server), errc, serve1)
+	<-rpc2started
 
 	go func() {
 		src, err := rpc1.Source(ctx, TypeString, Method{"whoami"})
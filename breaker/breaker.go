@@ -0,0 +1,203 @@
+// SPDX-License-Identifier: MIT
+
+// Package breaker implements a circuit breaker for calls to a muxrpc peer.
+//
+// A Breaker watches the outcomes of the calls made over an endpoint (see muxrpc.WithOutcomeHook).
+// Once the peer failed too many times in a row, the endpoint returned by Wrap refuses new calls with ErrCircuitOpen
+// instead of sending them, until a cooldown passed and a trial call succeeds.
+//
+//	b := breaker.New(breaker.Config{})
+//	edp := muxrpc.Handle(pkr, h, muxrpc.WithOutcomeHook(b.Observe))
+//	go edp.(muxrpc.Server).Serve()
+//	calls := breaker.Wrap(edp, b)
+//
+// Keep one Breaker per peer and reuse it when reconnecting, so that a reconnect doesn't reset it.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.cryptoscope.co/muxrpc/v2"
+)
+
+// ErrCircuitOpen is returned by the calls of a wrapped endpoint while its breaker is open.
+var ErrCircuitOpen = errors.New("muxrpc/breaker: circuit open")
+
+// State of a Breaker
+type State int
+
+// The states of a Breaker. It starts closed, lets calls through and counts failures.
+// Open refuses calls. Half-open lets a single trial call through, which decides if it closes or opens again.
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Config of a Breaker. The zero value uses the defaults.
+type Config struct {
+	// Failures is how many calls need to fail in a row to open the breaker. Defaults to 5.
+	Failures int
+
+	// Cooldown is how long the breaker stays open before it lets a trial call through. Defaults to 30 seconds.
+	Cooldown time.Duration
+
+	// IsFailure decides which errors count against the peer. Defaults to DefaultIsFailure.
+	IsFailure func(error) bool
+
+	// Clock times the cooldown. Defaults to muxrpc.SystemClock.
+	Clock muxrpc.Clock
+}
+
+// DefaultIsFailure counts all errors except the ones caused by the caller:
+// canceled contexts and calls to methods the peer doesn't have.
+// Exceeded deadlines do count, a peer that times out is as bad as one that errors.
+func DefaultIsFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var nsm muxrpc.ErrNoSuchMethod
+	return !errors.As(err, &nsm)
+}
+
+// Breaker tracks the health of a peer, see the package documentation.
+type Breaker struct {
+	cfg Config
+	now func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	since    time.Time // when the breaker opened or the trial call started
+}
+
+// New returns a closed breaker
+func New(cfg Config) *Breaker {
+	if cfg.Failures <= 0 {
+		cfg.Failures = 5
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 30 * time.Second
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = DefaultIsFailure
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = muxrpc.SystemClock
+	}
+	return &Breaker{cfg: cfg, now: cfg.Clock.Now}
+}
+
+// State returns the current state of the breaker
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Allow returns ErrCircuitOpen if a call should not be made right now.
+// After the cooldown it lets one trial call through. If that one doesn't report an outcome, another one is let through after the next cooldown.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Closed {
+		return nil
+	}
+	if b.now().Sub(b.since) < b.cfg.Cooldown {
+		return ErrCircuitOpen
+	}
+	b.state = HalfOpen
+	b.since = b.now()
+	return nil
+}
+
+// Observe records the outcome of a call. Pass it to muxrpc.WithOutcomeHook.
+func (b *Breaker) Observe(o muxrpc.CallOutcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.cfg.IsFailure(o.Err) {
+		if o.Err == nil {
+			b.state = Closed
+			b.failures = 0
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == HalfOpen || b.failures >= b.cfg.Failures {
+		b.state = Open
+		b.since = b.now()
+	}
+}
+
+// Wrap returns an endpoint that makes its calls over edp, as long as b allows them.
+// edp should report its outcomes to b, see the package documentation.
+func Wrap(edp muxrpc.Endpoint, b *Breaker) muxrpc.Endpoint {
+	return &endpoint{Endpoint: edp, b: b}
+}
+
+type endpoint struct {
+	muxrpc.Endpoint
+
+	b *Breaker
+}
+
+func (e *endpoint) Async(ctx context.Context, ret interface{}, re muxrpc.RequestEncoding, method muxrpc.Method, args ...interface{}) error {
+	if err := e.b.Allow(); err != nil {
+		return err
+	}
+	return e.Endpoint.Async(ctx, ret, re, method, args...)
+}
+
+func (e *endpoint) AsyncObj(ctx context.Context, ret interface{}, re muxrpc.RequestEncoding, method muxrpc.Method, opts interface{}) error {
+	if err := e.b.Allow(); err != nil {
+		return err
+	}
+	return e.Endpoint.AsyncObj(ctx, ret, re, method, opts)
+}
+
+func (e *endpoint) Source(ctx context.Context, re muxrpc.RequestEncoding, method muxrpc.Method, args ...interface{}) (*muxrpc.ByteSource, error) {
+	if err := e.b.Allow(); err != nil {
+		return nil, err
+	}
+	return e.Endpoint.Source(ctx, re, method, args...)
+}
+
+func (e *endpoint) Sink(ctx context.Context, re muxrpc.RequestEncoding, method muxrpc.Method, args ...interface{}) (*muxrpc.ByteSink, error) {
+	if err := e.b.Allow(); err != nil {
+		return nil, err
+	}
+	return e.Endpoint.Sink(ctx, re, method, args...)
+}
+
+func (e *endpoint) Duplex(ctx context.Context, re muxrpc.RequestEncoding, method muxrpc.Method, args ...interface{}) (*muxrpc.ByteSource, *muxrpc.ByteSink, error) {
+	if err := e.b.Allow(); err != nil {
+		return nil, nil, err
+	}
+	return e.Endpoint.Duplex(ctx, re, method, args...)
+}
+
+func (e *endpoint) DoBatch(ctx context.Context, reqs ...*muxrpc.Request) error {
+	if err := e.b.Allow(); err != nil {
+		return err
+	}
+	return e.Endpoint.DoBatch(ctx, reqs...)
+}
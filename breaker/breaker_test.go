@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: MIT
+
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2"
+	"go.cryptoscope.co/muxrpc/v2/muxtest"
+)
+
+func TestBreakerStates(t *testing.T) {
+	r := require.New(t)
+
+	now := time.Unix(0, 0)
+	b := New(Config{Failures: 2, Cooldown: time.Minute})
+	b.now = func() time.Time { return now }
+
+	fail := muxrpc.CallOutcome{Err: errors.New("boom")}
+	ok := muxrpc.CallOutcome{}
+
+	r.NoError(b.Allow())
+	b.Observe(fail)
+	b.Observe(ok) // resets the count
+	b.Observe(fail)
+	r.Equal(Closed, b.State())
+
+	// canceled calls are not the fault of the peer
+	b.Observe(muxrpc.CallOutcome{Err: context.Canceled})
+	r.Equal(Closed, b.State())
+
+	b.Observe(fail)
+	r.Equal(Open, b.State())
+	r.True(errors.Is(b.Allow(), ErrCircuitOpen))
+
+	// after the cooldown a single trial call goes through
+	now = now.Add(time.Minute)
+	r.NoError(b.Allow())
+	r.Equal(HalfOpen, b.State())
+	r.True(errors.Is(b.Allow(), ErrCircuitOpen))
+
+	b.Observe(fail)
+	r.Equal(Open, b.State())
+
+	now = now.Add(time.Minute)
+	r.NoError(b.Allow())
+	b.Observe(ok)
+	r.Equal(Closed, b.State())
+	r.NoError(b.Allow())
+}
+
+func TestWrap(t *testing.T) {
+	r := require.New(t)
+
+	var client, server muxrpc.FakeHandler
+	server.HandledCalls(func(m muxrpc.Method) bool { return m.String() == "flaky" })
+	server.HandleCallCalls(func(ctx context.Context, req *muxrpc.Request) {
+		req.CloseWithError(errors.New("not today"))
+	})
+
+	b := New(Config{Failures: 3, Cooldown: time.Hour})
+	p := muxtest.Connect(&client, &server, muxtest.Options{}, muxrpc.WithOutcomeHook(b.Observe))
+	defer p.Close()
+	edp := Wrap(p.A, b)
+
+	ctx := context.Background()
+	var resp string
+	for i := 0; i < 3; i++ {
+		err := edp.Async(ctx, &resp, muxrpc.TypeString, muxrpc.Method{"flaky"})
+		var ce *muxrpc.CallError
+		r.True(errors.As(err, &ce), "call %d: unexpected error: %v", i, err)
+	}
+	r.Equal(Open, b.State())
+
+	err := edp.Async(ctx, &resp, muxrpc.TypeString, muxrpc.Method{"flaky"})
+	r.True(errors.Is(err, ErrCircuitOpen))
+	_, err = edp.Source(ctx, muxrpc.TypeString, muxrpc.Method{"flaky"})
+	r.True(errors.Is(err, ErrCircuitOpen))
+	r.Equal(3, server.HandleCallCallCount())
+}
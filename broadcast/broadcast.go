@@ -0,0 +1,212 @@
+// SPDX-License-Identifier: MIT
+
+// Package broadcast fans frames out to many muxrpc streams, like the sinks of calls to all connected peers.
+//
+// Every stream gets its own queue and writer, so a slow or broken peer doesn't hold up the others.
+// Streams that fail or can't keep up are removed and closed, see Config.
+//
+//	b := broadcast.New(broadcast.Config{})
+//	for _, edp := range peers {
+//		b.Open(ctx, edp, muxrpc.TypeJSON, muxrpc.Method{"gossip", "push"})
+//	}
+//	b.Pour(msg)
+package broadcast
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.cryptoscope.co/muxrpc/v2"
+)
+
+// ErrTooSlow closes the streams that had too many frames queued, unless Config.DropFrames is set.
+var ErrTooSlow = errors.New("muxrpc/broadcast: stream too slow")
+
+// ErrClosed is returned when adding streams to or writing to a closed Broadcaster.
+var ErrClosed = errors.New("muxrpc/broadcast: closed")
+
+// Config of a Broadcaster
+type Config struct {
+	// QueueLen is the number of frames each stream can fall behind. Defaults to 64.
+	QueueLen int
+
+	// DropFrames drops new frames for streams with a full queue, instead of removing them with ErrTooSlow.
+	DropFrames bool
+
+	// OnRemove is called when a stream was removed, with the error that caused it (nil if it was removed with the remove function
+	// or ended by Close). It is called before the sink is closed, which can take a while for a stuck stream.
+	OnRemove func(snk *muxrpc.ByteSink, err error)
+}
+
+// Broadcaster writes frames to a changing set of streams
+type Broadcaster struct {
+	cfg Config
+
+	mu      sync.Mutex
+	streams map[*stream]struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+type stream struct {
+	snk   *muxrpc.ByteSink
+	queue chan []byte
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// New returns an empty Broadcaster
+func New(cfg Config) *Broadcaster {
+	if cfg.QueueLen <= 0 {
+		cfg.QueueLen = 64
+	}
+	return &Broadcaster{
+		cfg:     cfg,
+		streams: make(map[*stream]struct{}),
+	}
+}
+
+// Add lets snk receive all frames from now on. The returned function removes it again, without closing it.
+// snk can be the sink of an outgoing sink or duplex call or of an incoming source or duplex call.
+func (b *Broadcaster) Add(snk *muxrpc.ByteSink) (remove func(), err error) {
+	s := &stream{
+		snk:   snk,
+		queue: make(chan []byte, b.cfg.QueueLen),
+		stop:  make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	b.streams[s] = struct{}{}
+	b.wg.Add(1)
+	go b.send(s)
+
+	return func() { b.remove(s, nil, false) }, nil
+}
+
+// Open starts a sink call of method on edp and adds its sink.
+func (b *Broadcaster) Open(ctx context.Context, edp muxrpc.Endpoint, re muxrpc.RequestEncoding, method muxrpc.Method, args ...interface{}) error {
+	snk, err := edp.Sink(ctx, re, method, args...)
+	if err != nil {
+		return fmt.Errorf("muxrpc/broadcast: failed to open %s: %w", method, err)
+	}
+	if _, err := b.Add(snk); err != nil {
+		snk.Close()
+		return err
+	}
+	return nil
+}
+
+// Write queues frame for all streams and returns how many got it.
+// frame must not be modified afterwards, it is shared between the streams.
+func (b *Broadcaster) Write(frame []byte) (int, error) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return 0, ErrClosed
+	}
+
+	var (
+		n    int
+		slow []*stream
+	)
+	for s := range b.streams {
+		select {
+		case s.queue <- frame:
+			n++
+		default:
+			if !b.cfg.DropFrames {
+				delete(b.streams, s)
+				slow = append(slow, s)
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	// closing waits for a write that might be stuck, that's what made the stream slow in the first place
+	for _, s := range slow {
+		go b.finish(s, ErrTooSlow, true)
+	}
+	return n, nil
+}
+
+// Pour encodes v as JSON and writes it to all streams, see Write.
+func (b *Broadcaster) Pour(v interface{}) (int, error) {
+	frame, err := json.Marshal(v)
+	if err != nil {
+		return 0, fmt.Errorf("muxrpc/broadcast: failed to encode value: %w", err)
+	}
+	return b.Write(frame)
+}
+
+// Len returns the number of streams
+func (b *Broadcaster) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.streams)
+}
+
+// Close ends all streams once they sent what they have queued and waits for that.
+func (b *Broadcaster) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	for s := range b.streams {
+		close(s.queue)
+	}
+	b.mu.Unlock()
+
+	b.wg.Wait()
+	return nil
+}
+
+// send writes the queued frames of s until it is removed or the broadcaster closed
+func (b *Broadcaster) send(s *stream) {
+	defer b.wg.Done()
+	for {
+		select {
+		case frame, ok := <-s.queue:
+			if !ok {
+				b.finish(s, nil, true)
+				return
+			}
+			if _, err := s.snk.Write(frame); err != nil {
+				b.remove(s, err, true)
+				return
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// remove takes s out of the set and finishes it
+func (b *Broadcaster) remove(s *stream, err error, end bool) {
+	b.mu.Lock()
+	delete(b.streams, s)
+	b.mu.Unlock()
+	b.finish(s, err, end)
+}
+
+// finish stops sending to s and closes its sink with err if end is true
+func (b *Broadcaster) finish(s *stream, err error, end bool) {
+	s.once.Do(func() {
+		close(s.stop)
+
+		if b.cfg.OnRemove != nil {
+			b.cfg.OnRemove(s.snk, err)
+		}
+		if end {
+			s.snk.CloseWithError(err)
+		}
+	})
+}
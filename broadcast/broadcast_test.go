@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: MIT
+
+package broadcast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2"
+	"go.cryptoscope.co/muxrpc/v2/muxtest"
+)
+
+// collector handles "push" sink calls and passes on what it receives
+func collector(frames chan<- string, ended chan<- error) *muxrpc.FakeHandler {
+	var h muxrpc.FakeHandler
+	h.HandledCalls(func(m muxrpc.Method) bool { return m.String() == "push" })
+	h.HandleCallCalls(func(ctx context.Context, req *muxrpc.Request) {
+		src, err := req.ResponseSource()
+		if err != nil {
+			return
+		}
+		for src.Next(ctx) {
+			b, err := src.Bytes()
+			if err != nil {
+				break
+			}
+			frames <- string(b)
+		}
+		ended <- src.Err()
+		req.Close()
+	})
+	return &h
+}
+
+// stallConn blocks writes while stalled is open
+type stallConn struct {
+	*muxtest.Conn
+
+	mu      sync.Mutex
+	stalled chan struct{}
+}
+
+func (c *stallConn) stall() {
+	c.mu.Lock()
+	c.stalled = make(chan struct{})
+	c.mu.Unlock()
+}
+
+func (c *stallConn) release() {
+	c.mu.Lock()
+	close(c.stalled)
+	c.stalled = nil
+	c.mu.Unlock()
+}
+
+func (c *stallConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	wait := c.stalled
+	c.mu.Unlock()
+	if wait != nil {
+		<-wait
+	}
+	return c.Conn.Write(b)
+}
+
+func TestBroadcast(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	type peer struct {
+		frames chan string
+		ended  chan error
+	}
+	newPeer := func() peer { return peer{make(chan string, 100), make(chan error, 1)} }
+
+	// two peers over regular pipes
+	fast := []peer{newPeer(), newPeer()}
+	var edps []muxrpc.Endpoint
+	for _, p := range fast {
+		pair := muxtest.Connect(&muxrpc.FakeHandler{}, collector(p.frames, p.ended), muxtest.Options{})
+		defer pair.Close()
+		edps = append(edps, pair.A)
+	}
+
+	// and one whose connection stops accepting writes
+	slow := newPeer()
+	c1, c2 := muxtest.Pipe(muxtest.Options{})
+	sc := &stallConn{Conn: c1}
+	started := make(chan muxrpc.Endpoint)
+	go func() {
+		started <- muxrpc.Handle(muxrpc.NewPacker(c2), collector(slow.frames, slow.ended))
+	}()
+	slowEdp := muxrpc.Handle(muxrpc.NewPacker(sc), &muxrpc.FakeHandler{})
+	slowSrv := <-started
+	go slowEdp.(muxrpc.Server).Serve()
+	go slowSrv.(muxrpc.Server).Serve()
+	edps = append(edps, slowEdp)
+
+	removed := make(chan error, 3)
+	b := New(Config{
+		QueueLen: 4,
+		OnRemove: func(snk *muxrpc.ByteSink, err error) { removed <- err },
+	})
+	for _, edp := range edps {
+		r.NoError(b.Open(ctx, edp, muxrpc.TypeString, muxrpc.Method{"push"}))
+	}
+	r.Equal(3, b.Len())
+
+	sc.stall()
+	for i := 0; i < 20; i++ {
+		frame := fmt.Sprint(i)
+		_, err := b.Write([]byte(frame))
+		r.NoError(err)
+
+		// the fast peers get every frame while the slow one falls behind
+		for j, p := range fast {
+			select {
+			case got := <-p.frames:
+				r.Equal(frame, got, "peer %d", j)
+			case <-time.After(2 * time.Second):
+				t.Fatalf("peer %d did not get frame %d", j, i)
+			}
+		}
+	}
+	r.Equal(2, b.Len())
+	r.True(errors.Is(<-removed, ErrTooSlow))
+
+	sc.release()
+	slowEdp.Terminate()
+	slowSrv.Terminate()
+
+	// closing ends the remaining streams regularly
+	r.NoError(b.Close())
+	for j, p := range fast {
+		r.NoError(<-p.ended, "peer %d", j)
+		r.NoError(<-removed)
+	}
+
+	_, err := b.Write([]byte("late"))
+	r.True(errors.Is(err, ErrClosed))
+}
+
+func TestBroadcastDropFrames(t *testing.T) {
+	r := require.New(t)
+
+	frames, ended := make(chan string, 100), make(chan error, 1)
+	pair := muxtest.Connect(&muxrpc.FakeHandler{}, collector(frames, ended), muxtest.Options{})
+	defer pair.Close()
+
+	b := New(Config{DropFrames: true, QueueLen: 1})
+	r.NoError(b.Open(context.Background(), pair.A, muxrpc.TypeJSON, muxrpc.Method{"push"}))
+
+	for i := 0; i < 100; i++ {
+		_, err := b.Pour(map[string]int{"seq": i})
+		r.NoError(err)
+	}
+	r.Equal(1, b.Len(), "the stream should stay")
+
+	r.NoError(b.Close())
+	r.NoError(<-ended)
+	r.NotEmpty(frames)
+}
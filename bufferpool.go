@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// BufferPool recycles the buffers that hold the bodies of incoming packets until a source reads them, see WithBufferPool.
+// It's used by all the streams of an endpoint at once, so it needs to be safe for concurrent use.
+type BufferPool interface {
+	// Get returns an empty buffer, preferably with room for size bytes
+	Get(size int) *bytes.Buffer
+
+	// Put hands back a buffer that isn't used anymore
+	Put(*bytes.Buffer)
+}
+
+// DefaultBufferClasses are the size classes of the pool endpoints use unless WithBufferPool says otherwise:
+// small replies and stream ends, typical messages, messages close to the 8KiB limit of SSB once they are encoded, and blob chunks.
+var DefaultBufferClasses = []int{512, 2 << 10, 16 << 10, 64 << 10}
+
+// defaultBufferPool is shared by all endpoints that don't bring their own
+var defaultBufferPool, _ = NewSizeClassPool(DefaultBufferClasses...)
+
+// WithBufferPool makes the endpoint take the buffers for incoming packets from p.
+func WithBufferPool(p BufferPool) HandleOption {
+	return func(r *rpc) {
+		r.bpool = p
+	}
+}
+
+// NewSizeClassPool returns a pool that keeps buffers in one bucket per size class.
+// Get hands out a buffer from the smallest class that fits, bigger requests are allocated as needed.
+// Buffers that grew to more than twice the largest class are dropped as well, so a few huge packets don't pin memory.
+// The classes need to be positive and in ascending order.
+func NewSizeClassPool(classes ...int) (BufferPool, error) {
+	if len(classes) == 0 {
+		return nil, errors.New("muxrpc: buffer pool needs at least one size class")
+	}
+	for i, c := range classes {
+		if c <= 0 {
+			return nil, fmt.Errorf("muxrpc: invalid buffer size class: %d", c)
+		}
+		if i > 0 && c <= classes[i-1] {
+			return nil, fmt.Errorf("muxrpc: buffer size classes not ascending: %d after %d", c, classes[i-1])
+		}
+	}
+
+	p := &sizeClassPool{
+		classes: append([]int(nil), classes...),
+		pools:   make([]sync.Pool, len(classes)),
+	}
+	return p, nil
+}
+
+type sizeClassPool struct {
+	classes []int
+	pools   []sync.Pool
+}
+
+func (p *sizeClassPool) Get(size int) *bytes.Buffer {
+	for i, c := range p.classes {
+		if size > c {
+			continue
+		}
+		if b, ok := p.pools[i].Get().(*bytes.Buffer); ok {
+			return b
+		}
+		return bytes.NewBuffer(make([]byte, 0, c))
+	}
+	return bytes.NewBuffer(make([]byte, 0, size))
+}
+
+func (p *sizeClassPool) Put(b *bytes.Buffer) {
+	// the biggest class the buffer has room for
+	i := len(p.classes) - 1
+	if b.Cap() > 2*p.classes[i] {
+		return
+	}
+	for ; i >= 0; i-- {
+		if b.Cap() >= p.classes[i] {
+			b.Reset()
+			p.pools[i].Put(b)
+			return
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSizeClassPool(t *testing.T) {
+	r := require.New(t)
+
+	p, err := NewSizeClassPool(16, 64)
+	r.NoError(err)
+
+	for _, tc := range []struct{ size, cap int }{
+		{0, 16},
+		{16, 16},
+		{17, 64},
+		{1000, 1000},
+	} {
+		b := p.Get(tc.size)
+		r.Equal(0, b.Len())
+		r.True(b.Cap() >= tc.cap, "size %d: cap %d", tc.size, b.Cap())
+	}
+
+	// whatever comes back is empty and big enough
+	b := p.Get(64)
+	b.WriteString("leftovers")
+	p.Put(b)
+	b = p.Get(64)
+	r.Equal(0, b.Len())
+	r.True(b.Cap() >= 64)
+
+	for _, classes := range [][]int{nil, {0}, {64, 16}, {16, 16}} {
+		_, err := NewSizeClassPool(classes...)
+		r.Error(err, "classes: %v", classes)
+	}
+}
+
+func TestWithBufferPool(t *testing.T) {
+	r := require.New(t)
+
+	var fh FakeHandler
+	fh.HandledCalls(methodChecker("count"))
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		snk, err := req.ResponseSink()
+		if err != nil {
+			return
+		}
+		for i := 0; i < 10; i++ {
+			fmt.Fprint(snk, i)
+		}
+		snk.Close()
+	})
+
+	var pool countingPool
+	rpc1, _ := connectedPair(t, &FakeHandler{}, &fh, WithBufferPool(&pool))
+
+	ctx := context.Background()
+	src, err := rpc1.Source(ctx, TypeString, Method{"count"})
+	r.NoError(err)
+	var n int
+	for src.Next(ctx) {
+		_, err := src.Bytes()
+		r.NoError(err)
+		n++
+	}
+	r.NoError(src.Err())
+	r.Equal(10, n)
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	r.True(pool.gets >= 10)
+	r.Len(pool.puts, 10, "the buffers of the read frames should go back to the pool")
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+	"go.mindeco.de/log/level"
+)
+
+// TimeoutHeader is the header that carries the time the caller still waits for a reply, in milliseconds. See WithDeadlinePropagation.
+const TimeoutHeader = "timeout"
+
+// DeadlineExceededErrorName is the name of the CallError that ends calls whose handler missed the deadline of the caller.
+// Callers can check for it with errors.Is(err, context.DeadlineExceeded).
+const DeadlineExceededErrorName = "DeadlineExceededError"
+
+// WithDeadlinePropagation sends the deadline of the context a call is started with to the remote (see TimeoutHeader),
+// and ends incoming calls once the deadline their caller sent passed, which cancels the context of the handler.
+// Like headers, the deadline is ignored by peers that don't use this option, so both sides need it.
+// The deadline is passed as the time that's left, the clocks of the peers don't need to agree.
+func WithDeadlinePropagation() HandleOption {
+	return func(r *rpc) {
+		r.propagateDeadlines = true
+	}
+}
+
+// requestHeaders returns the headers to send with a call started with ctx, see WithHeaders and WithDeadlinePropagation
+func (r *rpc) requestHeaders(ctx context.Context) Headers {
+	h := HeadersFromContext(ctx)
+	deadline, ok := ctx.Deadline()
+	if !r.propagateDeadlines || !ok {
+		return h
+	}
+
+	withTimeout := make(Headers, len(h)+1)
+	for k, v := range h {
+		withTimeout[k] = v
+	}
+	left := deadline.Sub(r.clock.Now())
+	if left < 0 {
+		left = 0
+	}
+	// round up, so that the remote doesn't give up before we do
+	withTimeout[TimeoutHeader] = strconv.FormatInt(int64((left+time.Millisecond-1)/time.Millisecond), 10)
+	return withTimeout
+}
+
+// applyRemoteDeadline ends the incoming call req once the deadline its caller sent passed.
+// The returned context for the handler carries the deadline, so that calls it makes pass it on.
+func (r *rpc) applyRemoteDeadline(ctx context.Context, req *Request) context.Context {
+	if !r.propagateDeadlines {
+		return ctx
+	}
+	v, ok := req.headers[TimeoutHeader]
+	if !ok {
+		return ctx
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ms < 0 {
+		level.Debug(r.logger).Log("event", "invalid timeout header", "req", req.id, "trace", req.trace, "timeout", v)
+		return ctx
+	}
+
+	timeout := time.Duration(ms) * time.Millisecond
+	ctx, cancel := context.WithDeadline(ctx, r.clock.Now().Add(timeout))
+	r.clock.AfterFunc(timeout, func() {
+		defer cancel()
+		r.rLock.RLock()
+		_, active := r.reqs[req.id]
+		r.rLock.RUnlock()
+		if !active {
+			return
+		}
+		if !req.Type.Flags().Get(codec.FlagStream) && !req.markReplied() {
+			return // the handler replied in the meantime
+		}
+		r.closeStream(req, fmt.Errorf("muxrpc: %s: the deadline of the caller passed after %s: %w", req.Method, timeout, context.DeadlineExceeded))
+	})
+	return ctx
+}
@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadlinePropagation(t *testing.T) {
+	r := require.New(t)
+
+	handlerErr := make(chan error, 1)
+	var fh FakeHandler
+	fh.HandledCalls(func(m Method) bool {
+		switch m.String() {
+		case "timeout", "slow", "gaveUp":
+			return true
+		}
+		return false
+	})
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		switch req.Method.String() {
+		case "timeout":
+			req.Return(ctx, req.Headers().Get(TimeoutHeader))
+		case "slow":
+			if _, ok := ctx.Deadline(); !ok {
+				req.CloseWithError(errors.New("no deadline"))
+				return
+			}
+			<-ctx.Done()
+			handlerErr <- ctx.Err()
+		case "gaveUp":
+			req.CloseWithError(fmt.Errorf("downstream: %w", context.DeadlineExceeded))
+		}
+	})
+
+	rpc1, _ := connectedPair(t, &FakeHandler{}, &fh, WithDeadlinePropagation())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var resp string
+	r.NoError(rpc1.Async(ctx, &resp, TypeString, Method{"timeout"}))
+	ms, err := strconv.Atoi(resp)
+	r.NoError(err)
+	r.True(ms > 9000 && ms <= 10000, "timeout: %d", ms)
+
+	r.NoError(rpc1.Async(context.Background(), &resp, TypeString, Method{"timeout"}))
+	r.Equal("", resp)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = rpc1.Async(ctx, &resp, TypeString, Method{"slow"})
+	r.True(errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+	select {
+	case err := <-handlerErr:
+		r.Error(err, "the handler should be canceled")
+	case <-time.After(5 * time.Second):
+		r.Fail("the handler wasn't canceled")
+	}
+
+	// the error crosses the wire
+	err = rpc1.Async(context.Background(), &resp, TypeString, Method{"gaveUp"})
+	r.True(errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+	var ce *CallError
+	r.True(errors.As(err, &ce))
+	r.Equal(DeadlineExceededErrorName, ce.Name)
+}
+
+func TestDeadlinePropagationDisabled(t *testing.T) {
+	r := require.New(t)
+
+	var fh FakeHandler
+	fh.HandledCalls(methodChecker("timeout"))
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		_, hasDeadline := ctx.Deadline()
+		req.Return(ctx, fmt.Sprintf("%q %v", req.Headers().Get(TimeoutHeader), hasDeadline))
+	})
+
+	rpc1, _ := connectedPair(t, &FakeHandler{}, &fh)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var resp string
+	r.NoError(rpc1.Async(ctx, &resp, TypeString, Method{"timeout"}))
+	r.Equal(`"" false`, resp)
+}
@@ -0,0 +1,229 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+)
+
+// ErrChunkMismatch is returned by ChunkedReader if the received data doesn't match the size or hash the sender announced.
+var ErrChunkMismatch = errors.New("muxrpc: chunked transfer: data doesn't match trailer")
+
+// chunkTrailer is sent after the last chunk, separated from it by an empty frame
+type chunkTrailer struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ChunkedWriter sends a blob over a sink, split into frames of at most chunkSize bytes.
+// Once everything is written, Close sends an empty frame followed by a trailer with the total size and the SHA256 of the data,
+// so that a ChunkedReader on the other side can check that it got all of it.
+type ChunkedWriter struct {
+	snk       ByteSinker
+	chunkSize int
+
+	buf  []byte
+	size int64
+	hash hash.Hash
+
+	closed bool
+}
+
+// NewChunkedWriter returns a ChunkedWriter that writes to snk. It doesn't close snk.
+func NewChunkedWriter(snk ByteSinker, chunkSize int) *ChunkedWriter {
+	if chunkSize <= 0 {
+		panic("muxrpc: chunk size needs to be positive")
+	}
+	return &ChunkedWriter{
+		snk:       snk,
+		chunkSize: chunkSize,
+		buf:       make([]byte, 0, chunkSize),
+		hash:      sha256.New(),
+	}
+}
+
+// Write collects b and sends it once a chunk is full.
+func (cw *ChunkedWriter) Write(b []byte) (int, error) {
+	if cw.closed {
+		return 0, errors.New("muxrpc: chunked writer already closed")
+	}
+
+	written := 0
+	for len(b) > 0 {
+		n := cw.chunkSize - len(cw.buf)
+		if n > len(b) {
+			n = len(b)
+		}
+		cw.buf = append(cw.buf, b[:n]...)
+		b = b[n:]
+		written += n
+
+		if len(cw.buf) == cw.chunkSize {
+			if err := cw.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// ReadFrom sends everything from r, up until io.EOF.
+func (cw *ChunkedWriter) ReadFrom(r io.Reader) (int64, error) {
+	var total int64
+	chunk := make([]byte, cw.chunkSize)
+	for {
+		n, err := r.Read(chunk)
+		if n > 0 {
+			if _, werr := cw.Write(chunk[:n]); werr != nil {
+				return total, werr
+			}
+			total += int64(n)
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+func (cw *ChunkedWriter) flush() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	if _, err := cw.snk.Write(cw.buf); err != nil {
+		return fmt.Errorf("muxrpc: failed to send chunk: %w", err)
+	}
+	cw.hash.Write(cw.buf)
+	cw.size += int64(len(cw.buf))
+	cw.buf = cw.buf[:0]
+	return nil
+}
+
+// Close sends the rest of the data and the trailer. The sink stays open.
+func (cw *ChunkedWriter) Close() error {
+	if cw.closed {
+		return nil
+	}
+	cw.closed = true
+
+	if err := cw.flush(); err != nil {
+		return err
+	}
+
+	trailer, err := json.Marshal(chunkTrailer{
+		Size:   cw.size,
+		SHA256: hex.EncodeToString(cw.hash.Sum(nil)),
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := cw.snk.Write([]byte{}); err != nil {
+		return fmt.Errorf("muxrpc: failed to end chunks: %w", err)
+	}
+	if _, err := cw.snk.Write(trailer); err != nil {
+		return fmt.Errorf("muxrpc: failed to send chunk trailer: %w", err)
+	}
+	return nil
+}
+
+// ChunkedReader reassembles a blob sent by a ChunkedWriter.
+// Read returns io.EOF once the trailer was received and matched the data, ErrChunkMismatch if it didn't
+// and io.ErrUnexpectedEOF if the source ended before the trailer.
+type ChunkedReader struct {
+	ctx context.Context
+	src ByteSourcer
+
+	cur  *bytes.Reader
+	size int64
+	hash hash.Hash
+
+	err error // sticky, io.EOF once done
+}
+
+// NewChunkedReader returns a ChunkedReader that reads the chunks from src. ctx is used for waiting on new frames.
+func NewChunkedReader(ctx context.Context, src ByteSourcer) *ChunkedReader {
+	return &ChunkedReader{
+		ctx:  ctx,
+		src:  src,
+		cur:  bytes.NewReader(nil),
+		hash: sha256.New(),
+	}
+}
+
+func (cr *ChunkedReader) Read(b []byte) (int, error) {
+	for cr.cur.Len() == 0 {
+		if cr.err != nil {
+			return 0, cr.err
+		}
+		cr.err = cr.nextChunk()
+	}
+
+	n, _ := cr.cur.Read(b)
+	return n, nil
+}
+
+// nextChunk loads the next frame into cur. At the end it checks the trailer and returns io.EOF.
+func (cr *ChunkedReader) nextChunk() error {
+	frame, err := cr.nextFrame()
+	if err != nil {
+		return err
+	}
+
+	if len(frame) > 0 {
+		cr.hash.Write(frame)
+		cr.size += int64(len(frame))
+		cr.cur.Reset(frame)
+		return nil
+	}
+
+	// an empty frame ends the data, the trailer follows
+	frame, err = cr.nextFrame()
+	if err != nil {
+		return err
+	}
+	var trailer chunkTrailer
+	if err := json.Unmarshal(frame, &trailer); err != nil {
+		return fmt.Errorf("muxrpc: invalid chunk trailer: %w", err)
+	}
+	if trailer.Size != cr.size || trailer.SHA256 != hex.EncodeToString(cr.hash.Sum(nil)) {
+		return ErrChunkMismatch
+	}
+	return io.EOF
+}
+
+func (cr *ChunkedReader) nextFrame() ([]byte, error) {
+	if !cr.src.Next(cr.ctx) {
+		if err := sourceErr(cr.src); err != nil {
+			return nil, err
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	var frame []byte
+	err := cr.src.Reader(func(rd io.Reader) error {
+		var err error
+		frame, err = ioutil.ReadAll(rd)
+		return err
+	})
+	return frame, err
+}
+
+// sourceErr returns the error of src, if it has one
+func sourceErr(src ByteSourcer) error {
+	if e, ok := src.(interface{ Err() error }); ok {
+		return e.Err()
+	}
+	return nil
+}
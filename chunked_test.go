@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+)
+
+// sentFrames returns the bodies of the packets a test sink wrote to buf
+func sentFrames(t *testing.T, buf *bytes.Buffer) [][]byte {
+	pkts, err := codec.ReadAllPackets(codec.NewReader(buf))
+	require.NoError(t, err)
+	var frames [][]byte
+	for _, p := range pkts {
+		frames = append(frames, p.Body)
+	}
+	return frames
+}
+
+func TestChunkedTransfer(t *testing.T) {
+	r := require.New(t)
+
+	blob := make([]byte, 10000)
+	_, err := rand.Read(blob)
+	r.NoError(err)
+
+	var sent bytes.Buffer
+	cw := NewChunkedWriter(NewTestSink(&sent), 1024)
+	n, err := cw.ReadFrom(bytes.NewReader(blob))
+	r.NoError(err)
+	r.EqualValues(len(blob), n)
+	r.NoError(cw.Close())
+
+	frames := sentFrames(t, &sent)
+	r.Len(frames, 10+2, "expected 10 chunks, the separator and the trailer")
+	for _, f := range frames[:10] {
+		r.True(len(f) <= 1024, "chunk too big: %d", len(f))
+	}
+
+	src := NewTestSource(frames...)
+	got, err := ioutil.ReadAll(NewChunkedReader(context.Background(), src))
+	r.NoError(err)
+	r.Equal(blob, got)
+}
+
+func TestChunkedTransferMismatch(t *testing.T) {
+	r := require.New(t)
+
+	var sent bytes.Buffer
+	cw := NewChunkedWriter(NewTestSink(&sent), 4)
+	_, err := cw.Write([]byte("some data that gets tampered with"))
+	r.NoError(err)
+	r.NoError(cw.Close())
+
+	frames := sentFrames(t, &sent)
+	frames[1] = []byte("evil")
+
+	_, err = ioutil.ReadAll(NewChunkedReader(context.Background(), NewTestSource(frames...)))
+	r.Equal(ErrChunkMismatch, err)
+}
+
+func TestChunkedTransferTruncated(t *testing.T) {
+	r := require.New(t)
+
+	var sent bytes.Buffer
+	cw := NewChunkedWriter(NewTestSink(&sent), 4)
+	_, err := cw.Write([]byte("never finished"))
+	r.NoError(err)
+	// no Close, so no trailer
+
+	src := NewTestSource(sentFrames(t, &sent)...)
+	src.Cancel(nil) // the remote ended the stream
+
+	_, err = ioutil.ReadAll(NewChunkedReader(context.Background(), src))
+	r.Equal(io.ErrUnexpectedEOF, err)
+}
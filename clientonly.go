@@ -0,0 +1,33 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import "context"
+
+// WithClientOnly makes the endpoint refuse all calls from the remote with ErrNoSuchMethod, while it can still make calls itself.
+// It's meant for tools like command line clients or crawlers that should never serve anything.
+// The remote gets an empty manifest. Of the handler passed to Handle only HandleConnect is used and it may be nil.
+func WithClientOnly() HandleOption {
+	return func(r *rpc) {
+		r.clientOnly = true
+	}
+}
+
+// clientOnlyHandler only answers the manifest, see WithClientOnly
+type clientOnlyHandler struct {
+	connect ConnectHandler
+}
+
+func (clientOnlyHandler) Handled(m Method) bool {
+	return len(m) == 1 && m[0] == "manifest"
+}
+
+func (clientOnlyHandler) HandleCall(ctx context.Context, req *Request) {
+	req.Return(ctx, struct{}{})
+}
+
+func (h clientOnlyHandler) HandleConnect(ctx context.Context, edp Endpoint) {
+	if h.connect != nil {
+		h.connect.HandleConnect(ctx, edp)
+	}
+}
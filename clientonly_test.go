@@ -0,0 +1,49 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientOnly(t *testing.T) {
+	r := require.New(t)
+
+	c1, c2 := loPipe(t)
+
+	var fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("whoami"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		req.Return(ctx, "server")
+	})
+
+	var rpc2 Endpoint
+	started := make(chan struct{})
+	go func() {
+		rpc2 = Handle(NewPacker(c2), &fh2)
+		close(started)
+	}()
+	rpc1 := Handle(NewPacker(c1), nil, WithClientOnly())
+	<-started
+	go rpc1.(Server).Serve()
+	go rpc2.(Server).Serve()
+	defer rpc1.Terminate()
+	defer rpc2.Terminate()
+
+	ctx := context.Background()
+	var resp string
+	r.NoError(rpc1.Async(ctx, &resp, TypeString, Method{"whoami"}))
+	r.Equal("server", resp)
+
+	// the other way around is refused, the empty manifest already tells the remote
+	err := rpc2.Async(ctx, &resp, TypeString, Method{"whoami"})
+	var nsm ErrNoSuchMethod
+	r.True(errors.As(err, &nsm), "unexpected error: %v", err)
+
+	_, err = rpc2.Source(ctx, TypeString, Method{"whoami"})
+	r.True(errors.As(err, &nsm), "unexpected error: %v", err)
+}
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import "time"
+
+// Clock is the source of time for the timeouts and watchdogs of an endpoint, see WithClock.
+// Tests can use a virtual clock like muxtest.FakeClock to advance time deterministically instead of sleeping.
+type Clock interface {
+	Now() time.Time
+
+	// AfterFunc calls f in its own goroutine once d has passed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending call of Clock.AfterFunc. *time.Timer implements it.
+type Timer interface {
+	Stop() bool
+}
+
+// SystemClock is the real time of the operating system. Endpoints use it unless WithClock says otherwise.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+// WithClock lets the endpoint measure time with c, for its watchdog (WithCallTimeout and WithStreamIdleTimeout)
+// and the durations it reports to hooks.
+func WithClock(c Clock) HandleOption {
+	return func(r *rpc) {
+		r.clock = c
+	}
+}
+
+// clockOrSystem returns c or SystemClock if c is nil, for the policies that have an optional Clock
+func clockOrSystem(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
+
+// after returns a channel that is closed once d passed on c, and the timer behind it
+func after(c Clock, d time.Duration) (<-chan struct{}, Timer) {
+	done := make(chan struct{})
+	t := c.AfterFunc(d, func() { close(done) })
+	return done, t
+}
@@ -0,0 +1,245 @@
+/*
+muxcli calls methods of a muxrpc endpoint from the shell.
+
+	muxcli -addr localhost:8008 whoami
+	muxcli -addr ~/.ssb-go/socket createLogStream '{"limit":3}'
+	muxcli -addr localhost:8008 -manifest
+	muxcli -addr localhost:8008 -help friends
+
+It connects to the address, fetches the manifest of the remote and calls the method with the type the manifest lists,
+unless -type says otherwise. Only async (and sync) and source calls are supported.
+With -help it prints the methods the remote describes through its help method (see HandlerMux.ServeHelp),
+limited to the prefix given as argument, if any.
+
+Each argument after the method is a JSON value, arguments that aren't valid JSON are sent as strings.
+
+Results are printed as newline delimited JSON, one line for an async reply and one per frame of a source.
+Replies that aren't JSON are printed as JSON strings.
+
+Addresses that contain a slash are unix sockets, like the one go-sbot listens on, everything else is dialed with TCP.
+The connection can be wrapped in TLS with -tls. Secret-handshake isn't built in, since this module doesn't depend on secretstream,
+reach those peers through their unix socket instead.
+*/
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.cryptoscope.co/muxrpc/v2"
+)
+
+func check(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+func main() {
+	var (
+		addr         string
+		callType     string
+		useTLS       bool
+		insecure     bool
+		showManifest bool
+		showHelp     bool
+		timeout      time.Duration
+	)
+
+	set := flag.NewFlagSet("muxcli", flag.ExitOnError)
+	set.StringVar(&addr, "addr", "localhost:8008", "address to connect to, host:port or the path of a unix socket")
+	set.StringVar(&callType, "type", "", "call type (async or source), taken from the manifest if empty")
+	set.BoolVar(&useTLS, "tls", false, "connect with TLS")
+	set.BoolVar(&insecure, "insecure", false, "don't verify the TLS certificate of the remote")
+	set.BoolVar(&showManifest, "manifest", false, "print the manifest of the remote and exit")
+	set.BoolVar(&showHelp, "help", false, "print the methods the remote describes, under the prefix given as argument, and exit")
+	set.DurationVar(&timeout, "timeout", 0, "give up after this duration, 0 means no limit")
+	set.Usage = func() {
+		fmt.Fprintln(set.Output(), "usage: muxcli [flags] <method> [json args...]")
+		set.PrintDefaults()
+	}
+	set.Parse(os.Args[1:])
+
+	if !showManifest && !showHelp && set.NArg() < 1 {
+		set.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	edp, err := connect(ctx, addr, useTLS, insecure)
+	check(err)
+	defer edp.Terminate()
+
+	if showHelp {
+		var help muxrpc.Help
+		err = edp.Async(ctx, &help, muxrpc.TypeJSON, muxrpc.HelpMethod, parseArgs(set.Args())...)
+		if err != nil {
+			check(fmt.Errorf("failed to fetch help: %w", err))
+		}
+		fmt.Print(help.Usage())
+		return
+	}
+
+	var manifest json.RawMessage
+	err = edp.Async(ctx, &manifest, muxrpc.TypeJSON, muxrpc.Method{"manifest"})
+	if err != nil {
+		check(fmt.Errorf("failed to fetch manifest: %w", err))
+	}
+	if showManifest {
+		printManifest(os.Stdout, manifest)
+		return
+	}
+
+	method := strings.Split(set.Arg(0), ".")
+	args := parseArgs(set.Args()[1:])
+
+	if callType == "" {
+		types := make(map[string]string)
+		if err := flattenManifest(types, manifest, ""); err != nil {
+			check(fmt.Errorf("failed to read manifest: %w", err))
+		}
+		callType = types[set.Arg(0)]
+		if callType == "" {
+			check(fmt.Errorf("%s is not in the manifest of the remote, use -type to call it anyway", set.Arg(0)))
+		}
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	switch callType {
+	case "async", "sync":
+		var reply muxrpc.RawReply
+		err = edp.Async(ctx, &reply, muxrpc.TypeJSON, method, args...)
+		check(err)
+		check(out.Encode(asJSON(reply.Body)))
+
+	case "source":
+		src, err := edp.Source(ctx, muxrpc.TypeJSON, method, args...)
+		check(err)
+		for src.Next(ctx) {
+			frame, err := src.Bytes()
+			check(err)
+			check(out.Encode(asJSON(frame)))
+		}
+		check(src.Err())
+
+	default:
+		check(fmt.Errorf("can't call %s, type %q is not supported", set.Arg(0), callType))
+	}
+}
+
+// connect dials addr and runs an endpoint over the connection
+func connect(ctx context.Context, addr string, useTLS, insecure bool) (muxrpc.Endpoint, error) {
+	network := "tcp"
+	if strings.Contains(addr, "/") {
+		network = "unix"
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	var pkr *muxrpc.Packer
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		tc := tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: insecure})
+		if deadline, ok := ctx.Deadline(); ok {
+			tc.SetDeadline(deadline)
+		}
+		pkr, err = muxrpc.NewTLSPacker(tc)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tc.SetDeadline(time.Time{})
+	} else {
+		pkr = muxrpc.NewPacker(conn)
+	}
+
+	edp := muxrpc.Handle(pkr, &muxrpc.FakeHandler{})
+	go func() {
+		err := edp.(muxrpc.Server).Serve()
+		if err != nil && ctx.Err() == nil {
+			fmt.Fprintln(os.Stderr, "connection failed:", err)
+		}
+	}()
+	return edp, nil
+}
+
+// parseArgs turns the command-line arguments of the call into JSON values
+func parseArgs(args []string) []interface{} {
+	out := make([]interface{}, len(args))
+	for i, a := range args {
+		if json.Valid([]byte(a)) {
+			out[i] = json.RawMessage(a)
+		} else {
+			out[i] = a
+		}
+	}
+	return out
+}
+
+// asJSON returns a reply as a value that encodes to a single line of JSON
+func asJSON(body []byte) interface{} {
+	var compact bytes.Buffer
+	if json.Compact(&compact, body) == nil {
+		return json.RawMessage(compact.Bytes())
+	}
+	return string(body)
+}
+
+// flattenManifest collects the call types of a manifest by their dotted method names
+func flattenManifest(types map[string]string, manifest json.RawMessage, prefix string) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return err
+	}
+	for name, v := range m {
+		full := name
+		if prefix != "" {
+			full = prefix + "." + name
+		}
+		var typ string
+		if err := json.Unmarshal(v, &typ); err == nil {
+			types[full] = typ
+			continue
+		}
+		if err := flattenManifest(types, v, full); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printManifest prints the methods of a manifest sorted by name, one per line
+func printManifest(w io.Writer, manifest json.RawMessage) {
+	types := make(map[string]string)
+	check(flattenManifest(types, manifest, ""))
+
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%s\n", name, types[name])
+	}
+}
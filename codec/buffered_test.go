@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: MIT
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type countingWriter struct {
+	mu     sync.Mutex
+	writes int
+	buf    bytes.Buffer
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.writes++
+	return cw.buf.Write(b)
+}
+
+func (cw *countingWriter) count() int {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.writes
+}
+
+// gatedWriter blocks its first write until open is closed
+type gatedWriter struct {
+	countingWriter
+
+	once    sync.Once
+	started chan struct{}
+	open    chan struct{}
+}
+
+func (gw *gatedWriter) Write(b []byte) (int, error) {
+	gw.once.Do(func() {
+		close(gw.started)
+		<-gw.open
+	})
+	return gw.countingWriter.Write(b)
+}
+
+func TestBufferedWriterCoalesces(t *testing.T) {
+	gw := &gatedWriter{
+		started: make(chan struct{}),
+		open:    make(chan struct{}),
+	}
+	w := NewBufferedWriter(gw, 4096, time.Hour)
+
+	// nothing else is waiting, so the first one goes out right away
+	if err := w.WritePacket(Packet{Flag: FlagStream | FlagString, Req: 1, Body: []byte("zero")}); err != nil {
+		t.Fatal(err)
+	}
+	<-gw.started
+
+	// these pile up while the first write is stuck
+	for _, body := range []string{"one", "two", "three"} {
+		if err := w.WritePacket(Packet{Flag: FlagStream | FlagString, Req: 1, Body: []byte(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(gw.open)
+
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := gw.count(); n != 2 {
+		t.Fatalf("expected the waiting packets to be coalesced into one write, got %d writes", n)
+	}
+
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	checkBodies(t, &gw.buf, "zero", "one", "two", "three")
+}
+
+func TestBufferedWriterWriteError(t *testing.T) {
+	w := NewBufferedWriter(failingWriter{}, 4096, time.Hour)
+
+	if err := w.WritePacket(Packet{Flag: FlagJSON, Req: 1, Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err == nil {
+		t.Fatal("expected flush to return the write error")
+	}
+	if err := w.WritePacket(Packet{Flag: FlagJSON, Req: 1, Body: []byte("{}")}); err == nil {
+		t.Fatal("expected write after failed flush to error")
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("broken pipe") }
+
+func TestBufferedWriterFlush(t *testing.T) {
+	var cw countingWriter
+	w := NewBufferedWriter(&cw, 4096, time.Hour)
+
+	if err := w.WritePacket(Packet{Flag: FlagJSON, Req: 1, Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := cw.count(); n != 1 {
+		t.Fatalf("expected one write after flush, got %d", n)
+	}
+}
+
+func TestWritePackets(t *testing.T) {
+	var cw countingWriter
+	w := NewWriter(&cw)
+
+	pkts := []Packet{
+		{Flag: FlagJSON, Req: 1, Body: []byte("one")},
+		{Flag: FlagJSON | FlagStream, Req: 2, Body: []byte("two")},
+		{Flag: FlagJSON | FlagStream, Req: 3, Body: []byte("three")},
+	}
+	if err := w.WritePackets(pkts); err != nil {
+		t.Fatal(err)
+	}
+	if n := cw.count(); n != 1 {
+		t.Fatalf("expected a single write, got %d", n)
+	}
+	checkBodies(t, &cw.buf, "one", "two", "three")
+}
+
+func TestFlushUnderlying(t *testing.T) {
+	var cw countingWriter
+	bw := bufio.NewWriter(&cw)
+	w := NewWriter(bw)
+
+	if err := w.WritePacket(Packet{Flag: FlagJSON, Req: 1, Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	if n := cw.count(); n != 0 {
+		t.Fatalf("expected the packet to be buffered, got %d writes", n)
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := cw.count(); n != 1 {
+		t.Fatalf("expected flush to write the buffered packet, got %d writes", n)
+	}
+	checkBodies(t, &cw.buf, "{}")
+}
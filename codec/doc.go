@@ -15,5 +15,26 @@ Flags:
 
 	[ignored (4 bits), stream (1 bit), end/err (1 bit), type (2 bits)]
 	type = {0 => Buffer, 1 => String, 2 => JSON} # PacketType
+
+The package can be used on its own, without the RPC layer, for instance by proxies or test tools.
+Writer.WritePacket and Reader.ReadPacket handle complete packets.
+Bodies that shouldn't be held in memory completely can be streamed:
+Writer.WriteHeader returns a BodyWriter for the body of the packet,
+and Reader.NextHeader returns a reader that is limited to the body of the packet it read the header of.
+
+	hdr := codec.Header{Flag: codec.FlagStream, Len: uint32(size), Req: 1}
+	body, err := w.WriteHeader(hdr)
+	// check err
+	_, err = io.Copy(body, file)
+	// check err
+	err = body.Close()
+
+	var hdr codec.Header
+	body, err := r.NextHeader(&hdr)
+	// check err
+	_, err = io.Copy(dst, body)
+
+Writing the 9 zero bytes which end a packet stream is done by Writer.Close.
+Reader.ReadHeader and NextHeader return io.EOF once they read it.
 */
 package codec
@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: MIT
+
+package codec
+
+import (
+	"bytes"
+	"testing"
+)
+
+// FuzzPackets decodes its input as a packet stream and checks that encoding the packets again gives the same stream.
+// The regression inputs are in testdata/fuzz/FuzzPackets, run it with go test -fuzz FuzzPackets.
+func FuzzPackets(f *testing.F) {
+	f.Add([]byte{0x09, 0, 0, 0, 2, 0, 0, 0, 1, 'h', 'i'})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pkts, err := ReadAllPackets(NewReader(bytes.NewReader(data)))
+		if err != nil {
+			return
+		}
+
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		for _, p := range pkts {
+			if err := w.WritePacket(*p); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		again, err := ReadAllPackets(NewReader(&buf))
+		if err != nil {
+			t.Fatalf("re-encoded packets failed to decode: %s", err)
+		}
+		if len(again) != len(pkts) {
+			t.Fatalf("re-encoded %d packets but got %d back", len(pkts), len(again))
+		}
+		for i := range pkts {
+			if pkts[i].Flag != again[i].Flag || pkts[i].Req != again[i].Req || !bytes.Equal(pkts[i].Body, again[i].Body) {
+				t.Fatalf("packet %d changed: %+v != %+v", i, pkts[i], again[i])
+			}
+		}
+	})
+}
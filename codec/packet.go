@@ -3,6 +3,7 @@
 package codec
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 )
@@ -20,6 +21,46 @@ type Packet struct {
 	Body Body
 }
 
+// bodyPreviewLen is how much of the body Packet.String shows
+const bodyPreviewLen = 32
+
+// String renders the request id, the flags and the start of the body, for debug output.
+func (p Packet) String() string {
+	preview := []byte(p.Body)
+	var more string
+	if len(preview) > bodyPreviewLen {
+		preview = preview[:bodyPreviewLen]
+		more = "..."
+	}
+
+	var body string
+	if p.Flag.Get(FlagString) || p.Flag.Get(FlagJSON) {
+		body = fmt.Sprintf("%q%s", preview, more)
+	} else {
+		body = fmt.Sprintf("%x%s", preview, more)
+	}
+	return fmt.Sprintf("Packet{Req: %d, Flag: %s, Len: %d, Body: %s}", p.Req, p.Flag, len(p.Body), body)
+}
+
+// Validate checks that the packet can be sent as is and understood by the remote.
+// It rejects unknown flag bits, packets that are marked as string and JSON at the same time,
+// the request id 0 (which only the end of the packet stream uses) and JSON bodies that aren't valid JSON.
+func (p Packet) Validate() error {
+	if unknown := p.Flag &^ (FlagString | FlagJSON | FlagEndErr | FlagStream); unknown != 0 {
+		return fmt.Errorf("pkt-codec: invalid packet: unknown flag bits %08b", byte(unknown))
+	}
+	if p.Flag.Get(FlagString | FlagJSON) {
+		return fmt.Errorf("pkt-codec: invalid packet: string and JSON type at the same time")
+	}
+	if p.Req == 0 {
+		return fmt.Errorf("pkt-codec: invalid packet: request id 0")
+	}
+	if p.Flag.Get(FlagJSON) && !json.Valid(p.Body) {
+		return fmt.Errorf("pkt-codec: invalid packet: JSON flag but body is not valid JSON")
+	}
+	return nil
+}
+
 // Flag is the first byte of the Header
 type Flag byte
 
@@ -62,6 +103,9 @@ const (
 	FlagStream
 )
 
+// headerLen is the size of an encoded Header
+const headerLen = 9
+
 // Header is the wire representation of a packet header
 type Header struct {
 	Flag Flag
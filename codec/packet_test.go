@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: MIT
+
+package codec
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPacketString(t *testing.T) {
+	for _, tc := range []struct {
+		pkt  Packet
+		want string
+	}{
+		{
+			Packet{Flag: FlagJSON | FlagStream, Req: 3, Body: []byte(`{"a":1}`)},
+			`Packet{Req: 3, Flag: {FlagJSON, FlagStream}, Len: 7, Body: "{\"a\":1}"}`,
+		},
+		{
+			Packet{Req: -1, Body: []byte{0xde, 0xad}},
+			`Packet{Req: -1, Flag: {}, Len: 2, Body: dead}`,
+		},
+		{
+			Packet{Flag: FlagString, Req: 1, Body: []byte(strings.Repeat("a", 40))},
+			`Packet{Req: 1, Flag: {FlagString}, Len: 40, Body: "` + strings.Repeat("a", 32) + `"...}`,
+		},
+	} {
+		if got := tc.pkt.String(); got != tc.want {
+			t.Errorf("got  %s\nwant %s", got, tc.want)
+		}
+	}
+}
+
+func TestPacketValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		pkt   Packet
+		valid bool
+	}{
+		{"json", Packet{Flag: FlagJSON, Req: 1, Body: []byte(`[1,2]`)}, true},
+		{"end", Packet{Flag: FlagJSON | FlagEndErr | FlagStream, Req: -2, Body: []byte(`true`)}, true},
+		{"binary", Packet{Req: 1, Body: []byte{0xff}}, true},
+		{"string and json", Packet{Flag: FlagString | FlagJSON, Req: 1, Body: []byte(`1`)}, false},
+		{"unknown bits", Packet{Flag: 0x10 | FlagString, Req: 1}, false},
+		{"request zero", Packet{Flag: FlagString, Body: []byte("hi")}, false},
+		{"broken json", Packet{Flag: FlagJSON, Req: 1, Body: []byte(`{"a":`)}, false},
+	} {
+		err := tc.pkt.Validate()
+		if tc.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
@@ -3,6 +3,8 @@
 package codec
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -10,35 +12,75 @@ import (
 	"os"
 )
 
+// Reader decodes packets from a packet-stream
 type Reader struct{ r io.Reader }
 
+// NewReader creates a new packet-stream reader
 func NewReader(r io.Reader) *Reader { return &Reader{r} }
 
+// NewBufferedReader creates a packet-stream reader which reads from r in chunks of up to size bytes.
+// That saves a syscall or two per packet on connections with lots of small packets,
+// but costs size bytes of memory for as long as the reader is used.
+func NewBufferedReader(r io.Reader, size int) *Reader {
+	return &Reader{bufio.NewReaderSize(r, size)}
+}
+
 // ReadPacket decodes the header from the underlying reader, and reads as many bytes as specified in it
-// TODO: pass in packet pointer as arg to reduce allocations
 func (r Reader) ReadPacket() (*Packet, error) {
+	var p Packet
+	err := r.ReadPacketInto(&p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ReadPacketInto is like ReadPacket but decodes into the passed packet.
+// The body slice of p is reused if it has enough capacity, so one packet can be used to read a whole stream.
+func (r Reader) ReadPacketInto(p *Packet) error {
 	var hdr Header
 	err := r.ReadHeader(&hdr)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// copy header info
-	var p = Packet{
-		Flag: hdr.Flag,
-		Req:  hdr.Req,
-		Body: make([]byte, hdr.Len), // yiiikes! lot's of single-use allocations
+	p.Flag = hdr.Flag
+	p.Req = hdr.Req
+	if uint32(cap(p.Body)) >= hdr.Len {
+		p.Body = p.Body[:hdr.Len]
+		_, err = io.ReadFull(r.r, p.Body)
+	} else {
+		p.Body, err = r.readBody(hdr.Len)
 	}
-
-	_, err = io.ReadFull(r.r, p.Body)
 	if err != nil {
 		if errors.Is(err, os.ErrClosed) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
-			return nil, err
+			return err
 		}
-		return nil, fmt.Errorf("pkt-codec: read body failed: %w", err)
+		return fmt.Errorf("pkt-codec: read body failed: %w", err)
 	}
 
-	return &p, nil
+	return nil
+}
+
+// maxBodyPrealloc is how much readBody allocates before it saw the data.
+// The length in the header comes from the remote and could be up to 4GiB, even if it sends less.
+const maxBodyPrealloc = 64 * 1024
+
+// readBody reads a body of pktLen bytes, growing the buffer as the data arrives instead of trusting the length upfront
+func (r Reader) readBody(pktLen uint32) ([]byte, error) {
+	if pktLen <= maxBodyPrealloc {
+		body := make([]byte, pktLen)
+		_, err := io.ReadFull(r.r, body)
+		return body, err
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, maxBodyPrealloc))
+	_, err := io.CopyN(buf, r.r, int64(pktLen))
+	if err == io.EOF && buf.Len() > 0 {
+		err = io.ErrUnexpectedEOF // like io.ReadFull
+	}
+	return buf.Bytes(), err
 }
 
 // ReadHeader only reads the header packet data (flag, len, req id). Use the exposed io.Reader to read the body.
@@ -58,10 +100,21 @@ func (r Reader) ReadHeader(hdr *Header) error {
 	return nil
 }
 
+// NextHeader reads the next header into hdr and returns a reader for the body of that packet.
+// The body needs to be read completely before the next header can be read.
+func (r Reader) NextHeader(hdr *Header) (io.Reader, error) {
+	if err := r.ReadHeader(hdr); err != nil {
+		return nil, err
+	}
+	return r.NextBodyReader(hdr.Len), nil
+}
+
+// NextBodyReader returns a reader for the next pktLen bytes, which are the body of the packet whose header was just read.
 func (r Reader) NextBodyReader(pktLen uint32) io.Reader {
 	return io.LimitReader(r.r, int64(pktLen))
 }
 
+// ReadBodyInto copies the body of pktLen bytes to w.
 func (r Reader) ReadBodyInto(w io.Writer, pktLen uint32) error {
 	n, err := io.Copy(w, r.NextBodyReader(pktLen))
 	if err != nil {
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: MIT
+
+package codec
+
+import "sync"
+
+// writeScheduler serializes access to the underlying writer.
+// Control packets (stream ends and errors) are let through before waiting data packets,
+// so that cancellation doesn't queue up behind bulk transfers.
+// Data packets are queued per stream and the streams take turns (round-robin),
+// so that one fast producer can't monopolize the connection.
+type writeScheduler struct {
+	mu sync.Mutex
+
+	busy bool
+
+	control []chan struct{}
+	data    map[int32][]chan struct{}
+	order   []int32 // streams with waiting data packets, next turn first
+}
+
+// acquire blocks until it is the callers turn to write a packet for the stream req
+func (s *writeScheduler) acquire(control bool, req int32) {
+	s.mu.Lock()
+	if !s.busy {
+		s.busy = true
+		s.mu.Unlock()
+		return
+	}
+
+	turn := make(chan struct{})
+	if control {
+		s.control = append(s.control, turn)
+	} else {
+		if s.data == nil {
+			s.data = make(map[int32][]chan struct{})
+		}
+		q, queued := s.data[req]
+		if !queued {
+			s.order = append(s.order, req)
+		}
+		s.data[req] = append(q, turn)
+	}
+	s.mu.Unlock()
+
+	<-turn
+}
+
+// tryAcquire takes the turn if nobody has it or waits for it and returns false otherwise
+func (s *writeScheduler) tryAcquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.busy {
+		return false
+	}
+	s.busy = true
+	return true
+}
+
+// release hands the writer to the next waiting packet
+func (s *writeScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.control) > 0 {
+		next := s.control[0]
+		s.control = s.control[1:]
+		close(next)
+		return
+	}
+
+	if len(s.order) > 0 {
+		req := s.order[0]
+		s.order = s.order[1:]
+
+		q := s.data[req]
+		next := q[0]
+		if len(q) > 1 {
+			s.data[req] = q[1:]
+			s.order = append(s.order, req) // back of the line
+		} else {
+			delete(s.data, req)
+		}
+		close(next)
+		return
+	}
+
+	s.busy = false
+}
+
+func (s *writeScheduler) waiting() (control, data int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, q := range s.data {
+		data += len(q)
+	}
+	return len(s.control), data
+}
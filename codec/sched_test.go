@@ -0,0 +1,192 @@
+// SPDX-License-Identifier: MIT
+
+package codec
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingWriter blocks the first write until release is closed
+type blockingWriter struct {
+	once    sync.Once
+	entered chan struct{}
+	release chan struct{}
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (bw *blockingWriter) Write(b []byte) (int, error) {
+	bw.once.Do(func() {
+		close(bw.entered)
+		<-bw.release
+	})
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	return bw.buf.Write(b)
+}
+
+func TestWriterPrioritizesControlPackets(t *testing.T) {
+	bw := &blockingWriter{
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	w := NewWriter(bw)
+
+	var wg sync.WaitGroup
+	write := func(p Packet) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.WritePacket(p); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	waitFor := func(control, data int) {
+		for i := 0; i < 100; i++ {
+			c, d := w.sched.waiting()
+			if c == control && d == data {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("writers didn't queue up (want %d control, %d data)", control, data)
+	}
+
+	write(Packet{Flag: FlagStream, Req: 1, Body: []byte("first")})
+	<-bw.entered
+
+	write(Packet{Flag: FlagStream, Req: 1, Body: []byte("bulk")})
+	waitFor(0, 1)
+	write(Packet{Flag: FlagStream | FlagJSON | FlagEndErr, Req: 2, Body: []byte("true")})
+	waitFor(1, 1)
+
+	close(bw.release)
+	wg.Wait()
+
+	checkBodies(t, &bw.buf, "first", "true", "bulk")
+}
+
+func TestWriterTakesTurnsBetweenStreams(t *testing.T) {
+	bw := &blockingWriter{
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	w := NewWriter(bw)
+
+	var wg sync.WaitGroup
+	write := func(p Packet) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.WritePacket(p); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	waitFor := func(data int) {
+		for i := 0; i < 100; i++ {
+			if _, d := w.sched.waiting(); d == data {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("writers didn't queue up (want %d)", data)
+	}
+
+	write(Packet{Flag: FlagStream, Req: 1, Body: []byte("first")})
+	<-bw.entered
+
+	write(Packet{Flag: FlagStream, Req: 1, Body: []byte("a1")})
+	waitFor(1)
+	write(Packet{Flag: FlagStream, Req: 1, Body: []byte("a2")})
+	waitFor(2)
+	write(Packet{Flag: FlagStream, Req: 2, Body: []byte("b1")})
+	waitFor(3)
+
+	close(bw.release)
+	wg.Wait()
+
+	checkBodies(t, &bw.buf, "first", "a1", "b1", "a2")
+}
+
+func TestTryWritePacket(t *testing.T) {
+	bw := &blockingWriter{
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	w := NewWriter(bw)
+
+	done := make(chan error, 1)
+	go func() { done <- w.WritePacket(Packet{Flag: FlagStream, Req: 1, Body: []byte("first")}) }()
+	<-bw.entered
+
+	if err := w.TryWritePacket(Packet{Flag: FlagStream, Req: 2, Body: []byte("skipped")}); err != ErrWouldBlock {
+		t.Fatalf("expected ErrWouldBlock while another packet is written, got %v", err)
+	}
+
+	close(bw.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := w.TryWritePacket(Packet{Flag: FlagStream, Req: 2, Body: []byte("second")}); err != nil {
+		t.Fatal(err)
+	}
+
+	checkBodies(t, &bw.buf, "first", "second")
+}
+
+func TestTryWritePacketBuffered(t *testing.T) {
+	bw := &blockingWriter{
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	w := NewBufferedWriter(bw, 32, time.Hour)
+
+	// the flusher gets stuck on the first packet, the second one fills the buffer
+	if err := w.WritePacket(Packet{Flag: FlagStream, Req: 1, Body: []byte("first")}); err != nil {
+		t.Fatal(err)
+	}
+	<-bw.entered
+	if err := w.WritePacket(Packet{Flag: FlagStream, Req: 1, Body: bytes.Repeat([]byte("x"), 20)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.TryWritePacket(Packet{Flag: FlagStream, Req: 2, Body: []byte("skipped")}); err != ErrWouldBlock {
+		t.Fatalf("expected ErrWouldBlock with a full buffer, got %v", err)
+	}
+
+	close(bw.release)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	checkBodies(t, &bw.buf, "first", strings.Repeat("x", 20))
+}
+
+func checkBodies(t *testing.T, buf *bytes.Buffer, want ...string) {
+	r := NewReader(buf)
+	var got []string
+	for {
+		pkt, err := r.ReadPacket()
+		if err != nil {
+			break
+		}
+		got = append(got, string(pkt.Body))
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("packet %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+}
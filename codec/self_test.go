@@ -55,3 +55,30 @@ func TestSelf(t *testing.T) {
 	}
 	t.Logf("done. tested %d pkts", i)
 }
+
+func TestReadPacketIntoReusesBody(t *testing.T) {
+	var b bytes.Buffer
+
+	w := NewWriter(&b)
+	for _, want := range testPkts {
+		if err := w.WritePacket(want); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewReader(&b)
+	pkt := &Packet{Body: make([]byte, 0, 128)}
+	backing := &pkt.Body[:1][0]
+
+	for i, want := range testPkts {
+		if err := r.ReadPacketInto(pkt); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*pkt, want) {
+			t.Errorf("Pkt[%d]\n Got: %+v\nWant: %+v", i, pkt, want)
+		}
+		if len(pkt.Body) > 0 && &pkt.Body[0] != backing {
+			t.Errorf("Pkt[%d]: body was reallocated", i)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: MIT
+
+package codec
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamedBody(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		w    func(io.Writer) *Writer
+	}{
+		{"unbuffered", NewWriter},
+		{"buffered", func(w io.Writer) *Writer { return NewBufferedWriter(w, 64, time.Hour) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := tc.w(&buf)
+
+			if err := w.WritePacket(Packet{Flag: FlagString, Req: 1, Body: []byte("before")}); err != nil {
+				t.Fatal(err)
+			}
+
+			body := strings.Repeat("streamed ", 100)
+			bw, err := w.WriteHeader(Header{Flag: FlagString | FlagStream, Len: uint32(len(body)), Req: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// small chunks to make sure it doesn't depend on a single write
+			src := strings.NewReader(body)
+			if _, err := io.CopyBuffer(bw, src, make([]byte, 7)); err != nil {
+				t.Fatal(err)
+			}
+			if err := bw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := w.WritePacket(Packet{Flag: FlagString, Req: 3, Body: []byte("after")}); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r := NewReader(&buf)
+			for i, want := range []string{"before", body, "after"} {
+				var hdr Header
+				rd, err := r.NextHeader(&hdr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := ioutil.ReadAll(rd)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != want {
+					t.Errorf("packet %d: wrong body (len %d)", i, len(got))
+				}
+				if hdr.Req != int32(i+1) {
+					t.Errorf("packet %d: wrong request id %d", i, hdr.Req)
+				}
+			}
+
+			var hdr Header
+			if _, err := r.NextHeader(&hdr); err != io.EOF {
+				t.Fatalf("expected EOF after close packet, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStreamedBodyLength(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	bw, err := w.WriteHeader(Header{Flag: FlagString, Len: 4, Req: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bw.Write([]byte("too long")); err == nil {
+		t.Fatal("expected error for body exceeding the header length")
+	}
+	if _, err := bw.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := bw.Close(); err == nil {
+		t.Fatal("expected error for incomplete body")
+	}
+
+	// the writer is usable again after Close
+	done := make(chan error)
+	go func() { done <- w.WritePacket(Packet{Flag: FlagString, Req: 2, Body: []byte("next")}) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("writer still reserved after closing the body writer")
+	}
+}
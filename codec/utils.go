@@ -22,9 +22,7 @@ func ReadAllPackets(rd *Reader) ([]*Packet, error) {
 
 		pkt.Flag = hdr.Flag
 		pkt.Req = hdr.Req
-		pkt.Body = make([]byte, hdr.Len)
-
-		_, err = io.ReadFull(rd.NextBodyReader(hdr.Len), pkt.Body)
+		pkt.Body, err = rd.readBody(hdr.Len)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				break
@@ -3,51 +3,317 @@
 package codec
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"sync"
+	"time"
 )
 
+// Writer encodes packets to a packet-stream
 type Writer struct {
-	mu sync.Mutex
+	sched writeScheduler
 
 	w io.Writer
+
+	// only used by buffered writers
+	mu         sync.Mutex
+	cond       *sync.Cond // signaled when the flusher wrote something or stopped
+	pending    *bytes.Buffer
+	spare      *bytes.Buffer
+	size       int
+	flushDelay time.Duration
+	flushing   bool          // a flushLoop is running
+	kick       chan struct{} // nil for unbuffered writers
+	flushErr   error
 }
 
 // NewWriter creates a new packet-stream writer
 func NewWriter(w io.Writer) *Writer { return &Writer{w: w} }
 
-// WritePacket creates an header for the Packet and writes it and the body to the underlying writer
+// NewBufferedWriter creates a packet-stream writer which collects packets in a buffer of size bytes
+// and writes them to w in batches from a background goroutine, saving syscalls for lots of small packets.
+// WritePacket only blocks while the buffer is full.
+// Packets are sent right away if nothing else is waiting to be written or if they end a stream (FlagEndErr),
+// otherwise at the latest delay after the first one was buffered.
+// Packets that arrive while a batch is written go out with the next one.
+// Errors from the background writes are returned by the next call to WritePacket or Flush.
+func NewBufferedWriter(w io.Writer, size int, delay time.Duration) *Writer {
+	bw := &Writer{
+		w:          w,
+		pending:    new(bytes.Buffer),
+		spare:      new(bytes.Buffer),
+		size:       size,
+		flushDelay: delay,
+		kick:       make(chan struct{}, 1),
+	}
+	bw.cond = sync.NewCond(&bw.mu)
+	return bw
+}
+
+// ErrWouldBlock is returned by TryWritePacket if the packet can't be written right away.
+var ErrWouldBlock = errors.New("pkt-codec: write would block")
+
+// WritePacket creates an header for the Packet and writes it and the body to the underlying writer.
+// Concurrent calls are serialized. Packets with FlagEndErr set are written before waiting data packets,
+// which take turns per request ID.
 func (w *Writer) WritePacket(r Packet) error {
-	w.mu.Lock()
-	defer w.mu.Unlock()
+	isEnd := r.Flag.Get(FlagEndErr)
+	w.sched.acquire(isEnd, r.Req)
+	defer w.sched.release()
+	return w.writePacket(r, isEnd, false)
+}
+
+// TryWritePacket is like WritePacket but returns ErrWouldBlock instead of waiting,
+// if another packet is being written or waits for its turn, or if the buffer of a buffered writer is too full for r.
+// Writing to the underlying writer can still block, unbuffered writers can't tell if it would.
+func (w *Writer) TryWritePacket(r Packet) error {
+	if !w.sched.tryAcquire() {
+		return ErrWouldBlock
+	}
+	defer w.sched.release()
+	return w.writePacket(r, r.Flag.Get(FlagEndErr), true)
+}
+
+// writePacket does the work of WritePacket. The caller needs to have its turn from the scheduler.
+func (w *Writer) writePacket(r Packet, isEnd, try bool) error {
 	hdr := Header{
 		Flag: r.Flag,
 		Len:  uint32(len(r.Body)),
 		Req:  r.Req,
 	}
+
+	if w.kick == nil {
+		if err := binary.Write(w.w, binary.BigEndian, hdr); err != nil {
+			return fmt.Errorf("pkt-codec: header write failed: %w", err)
+		}
+		if _, err := w.w.Write(r.Body); err != nil {
+			return fmt.Errorf("pkt-codec: body write failed: %w", err)
+		}
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if try && w.flushErr == nil && w.pending.Len() > 0 && w.pending.Len()+headerLen+len(r.Body) > w.size {
+		return ErrWouldBlock
+	}
+	for w.flushErr == nil && w.pending.Len() >= w.size {
+		w.cond.Wait()
+	}
+	if w.flushErr != nil {
+		return fmt.Errorf("pkt-codec: previous flush failed: %w", w.flushErr)
+	}
+
+	binary.Write(w.pending, binary.BigEndian, hdr)
+	w.pending.Write(r.Body)
+
+	control, data := w.sched.waiting()
+	w.startFlushing(isEnd || control+data == 0 || w.pending.Len() >= w.size)
+	return nil
+}
+
+// WritePackets writes several packets in one go, without other packets in between.
+// Unbuffered writers pass them to the underlying writer with a single call.
+func (w *Writer) WritePackets(pkts []Packet) error {
+	if len(pkts) == 0 {
+		return nil
+	}
+
+	w.sched.acquire(false, pkts[0].Req)
+	defer w.sched.release()
+
+	var buf bytes.Buffer
+	if w.kick != nil {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		for w.flushErr == nil && w.pending.Len() >= w.size {
+			w.cond.Wait()
+		}
+		if w.flushErr != nil {
+			return fmt.Errorf("pkt-codec: previous flush failed: %w", w.flushErr)
+		}
+	}
+
+	for _, p := range pkts {
+		hdr := Header{
+			Flag: p.Flag,
+			Len:  uint32(len(p.Body)),
+			Req:  p.Req,
+		}
+		binary.Write(&buf, binary.BigEndian, hdr)
+		buf.Write(p.Body)
+	}
+
+	if w.kick == nil {
+		if _, err := w.w.Write(buf.Bytes()); err != nil {
+			return fmt.Errorf("pkt-codec: batch write failed: %w", err)
+		}
+		return nil
+	}
+
+	w.pending.Write(buf.Bytes())
+	w.startFlushing(true)
+	return nil
+}
+
+// WriteHeader writes the header of a packet and returns a BodyWriter to stream its body of hdr.Len bytes.
+// Other packets are held back until the body is complete and the BodyWriter is closed.
+// Buffered writers first flush what they have, the body is written to the underlying writer directly.
+func (w *Writer) WriteHeader(hdr Header) (*BodyWriter, error) {
+	w.sched.acquire(hdr.Flag.Get(FlagEndErr), hdr.Req)
+
+	if w.kick != nil {
+		if err := w.flush(); err != nil {
+			w.sched.release()
+			return nil, err
+		}
+	}
+
 	if err := binary.Write(w.w, binary.BigEndian, hdr); err != nil {
-		return fmt.Errorf("pkt-codec: header write failed: %w", err)
+		w.sched.release()
+		return nil, fmt.Errorf("pkt-codec: header write failed: %w", err)
+	}
+	return &BodyWriter{w: w, left: hdr.Len}, nil
+}
+
+// BodyWriter streams the body of a packet, see Writer.WriteHeader
+type BodyWriter struct {
+	w      *Writer
+	left   uint32
+	closed bool
+}
+
+// Write writes the next part of the body. It fails without writing anything if b exceeds the length from the header.
+func (bw *BodyWriter) Write(b []byte) (int, error) {
+	if bw.closed {
+		return 0, errors.New("pkt-codec: body writer already closed")
+	}
+	if uint64(len(b)) > uint64(bw.left) {
+		return 0, fmt.Errorf("pkt-codec: body is longer than its header says (%d bytes left, got %d)", bw.left, len(b))
+	}
+	n, err := bw.w.w.Write(b)
+	bw.left -= uint32(n)
+	if err != nil {
+		return n, fmt.Errorf("pkt-codec: body write failed: %w", err)
 	}
-	if _, err := w.w.Write(r.Body); err != nil {
-		return fmt.Errorf("pkt-codec: body write failed: %w", err)
+	return n, nil
+}
+
+// Close lets other packets be written again.
+// It returns an error if the body is incomplete, the stream can't be decoded after that.
+func (bw *BodyWriter) Close() error {
+	if bw.closed {
+		return nil
+	}
+	bw.closed = true
+	bw.w.sched.release()
+
+	if bw.left > 0 {
+		return fmt.Errorf("pkt-codec: body incomplete, %d bytes missing", bw.left)
 	}
 	return nil
 }
 
-// Close sends 9 zero bytes and also closes it's underlying writer if it is also an io.Closer
-func (w *Writer) Close() error {
+// Flush blocks until all buffered packets are written to the underlying writer.
+// If the underlying writer buffers as well (like a bufio.Writer), it is flushed too.
+// It takes its turn like a stream end, so it doesn't wait for data packets that are still queued.
+func (w *Writer) Flush() error {
+	w.sched.acquire(true, 0)
+	defer w.sched.release()
+	return w.flush()
+}
+
+// flush does the work of Flush. The caller needs to have its turn from the scheduler.
+func (w *Writer) flush() error {
+	if w.kick != nil {
+		w.mu.Lock()
+		if w.pending.Len() > 0 {
+			w.startFlushing(true)
+		}
+		for w.flushErr == nil && w.flushing {
+			w.cond.Wait()
+		}
+		err := w.flushErr
+		w.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("pkt-codec: previous flush failed: %w", err)
+		}
+	}
+
+	if f, ok := w.w.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return fmt.Errorf("pkt-codec: flushing underlying writer failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// startFlushing makes sure a flushLoop is running. If now is true, it doesn't wait for the flush delay.
+// The caller needs to hold w.mu.
+func (w *Writer) startFlushing(now bool) {
+	if !w.flushing {
+		w.flushing = true
+		go w.flushLoop()
+	}
+	if now {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// flushLoop writes the pending packets until there are none left.
+func (w *Writer) flushLoop() {
+	delay := time.NewTimer(w.flushDelay)
+	select {
+	case <-w.kick:
+		delay.Stop()
+	case <-delay.C:
+	}
+
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	_, err := w.w.Write([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0})
+	for w.pending.Len() > 0 {
+		out := w.pending
+		w.pending, w.spare = w.spare, nil
+
+		w.mu.Unlock()
+		_, err := w.w.Write(out.Bytes())
+		out.Reset()
+		w.mu.Lock()
+
+		w.spare = out
+		w.cond.Broadcast()
+		if err != nil {
+			w.flushErr = err
+			w.pending.Reset()
+			break
+		}
+	}
+	w.flushing = false
+	w.cond.Broadcast()
+}
+
+// Close sends 9 zero bytes and also closes it's underlying writer if it is also an io.Closer
+func (w *Writer) Close() error {
+	err := w.WritePacket(Packet{})
+	if err == nil {
+		err = w.Flush()
+	}
 	if err != nil {
-		return fmt.Errorf("pkt-codec: failed to write Close() packet: %w", err)
+		err = fmt.Errorf("pkt-codec: failed to write Close() packet: %w", err)
 	}
+
 	if c, ok := w.w.(io.Closer); ok {
-		if err := c.Close(); err != nil {
-			return fmt.Errorf("pkt-codec: failed to close underlying writer: %w", err)
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("pkt-codec: failed to close underlying writer: %w", cerr)
 		}
 	}
-	return nil
+	return err
 }
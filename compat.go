@@ -0,0 +1,34 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+// Compat enables workarounds for quirks of old JS peers (packet-stream and muxrpc),
+// which are refused by default since newer implementations don't have them. See WithCompat.
+type Compat struct {
+	// EmptyTypeIsAsync takes calls without a type as async calls.
+	EmptyTypeIsAsync bool
+
+	// LooseStreamFlag goes by the type of a new call if its stream flag doesn't fit, like a source call without it.
+	// The replies get the flag that fits the type.
+	LooseStreamFlag bool
+
+	// LooseEndBodies takes end packets with a null, false or empty body as regular ends, like true.
+	// Otherwise they are malformed (see ErrMalformedPacket).
+	LooseEndBodies bool
+}
+
+// WithCompat enables the workarounds in c for sessions with old peers.
+func WithCompat(c Compat) HandleOption {
+	return func(r *rpc) {
+		r.compat = c
+	}
+}
+
+// WithLegacyCompat enables all the workarounds of Compat.
+func WithLegacyCompat() HandleOption {
+	return WithCompat(Compat{
+		EmptyTypeIsAsync: true,
+		LooseStreamFlag:  true,
+		LooseEndBodies:   true,
+	})
+}
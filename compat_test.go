@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+)
+
+func TestCompat(t *testing.T) {
+	type oldCall struct {
+		name string
+		pkt  codec.Packet
+	}
+	calls := []oldCall{
+		{"call without type", codec.Packet{
+			Flag: codec.FlagJSON,
+			Body: []byte(`{"name":["hello"],"args":[]}`),
+		}},
+		{"source without stream flag", codec.Packet{
+			Flag: codec.FlagJSON,
+			Body: []byte(`{"name":["things"],"args":[],"type":"source"}`),
+		}},
+		{"async with stream flag", codec.Packet{
+			Flag: codec.FlagJSON | codec.FlagStream,
+			Body: []byte(`{"name":["hello"],"args":[],"type":"async"}`),
+		}},
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		r := require.New(t)
+		_, rd, w := rawPeer(t, anomalyHandler())
+
+		for i, c := range calls {
+			c.pkt.Req = int32(i + 1)
+			r.NoError(w.WritePacket(c.pkt))
+
+			refused, err := rd.ReadPacket()
+			r.NoError(err)
+			r.Equal(-c.pkt.Req, refused.Req, c.name)
+			r.True(refused.Flag.Get(codec.FlagEndErr), c.name)
+			var ce CallError
+			r.NoError(json.Unmarshal(refused.Body, &ce))
+			r.Contains(ce.Message, "unhandled request type", c.name)
+		}
+	})
+
+	t.Run("legacy", func(t *testing.T) {
+		r := require.New(t)
+		edp, rd, w := rawPeer(t, anomalyHandler(), WithLegacyCompat())
+
+		for i, c := range calls {
+			c.pkt.Req = int32(i + 1)
+			r.NoError(w.WritePacket(c.pkt))
+
+			reply, err := rd.ReadPacket()
+			r.NoError(err)
+			r.Equal(-c.pkt.Req, reply.Req, c.name)
+			r.False(reply.Flag.Get(codec.FlagEndErr), c.name)
+			if i == 1 {
+				r.Equal("thing", string(reply.Body))
+				r.True(reply.Flag.Get(codec.FlagStream), "replies fit the type")
+				end, err := rd.ReadPacket()
+				r.NoError(err)
+				r.True(end.Flag.Get(codec.FlagEndErr))
+				r.True(end.Flag.Get(codec.FlagStream))
+			} else {
+				r.Equal("world", string(reply.Body))
+				r.False(reply.Flag.Get(codec.FlagStream), "replies fit the type")
+			}
+		}
+
+		// old peers might end streams with null
+		ctx := context.Background()
+		src, err := edp.Source(ctx, TypeJSON, Method{"things"})
+		r.NoError(err)
+		call, err := rd.ReadPacket()
+		r.NoError(err)
+		r.NoError(w.WritePacket(codec.Packet{
+			Req:  -call.Req,
+			Flag: codec.FlagJSON | codec.FlagStream | codec.FlagEndErr,
+			Body: []byte("null"),
+		}))
+		r.False(src.Next(ctx))
+		r.NoError(src.Err())
+	})
+}
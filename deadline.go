@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// deadliner is the part of net.Conn that WithReadTimeout and WithWriteTimeout need
+type deadliner interface {
+	io.ReadWriter
+
+	SetReadDeadline(time.Time) error
+	SetWriteDeadline(time.Time) error
+}
+
+// deadlineConn moves the deadline of the connection before every read and write
+// and turns running into it into a StallError.
+type deadlineConn struct {
+	deadliner
+
+	read, write time.Duration
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	if c.read <= 0 {
+		return c.deadliner.Read(b)
+	}
+	if err := c.SetReadDeadline(time.Now().Add(c.read)); err != nil {
+		return 0, err
+	}
+	n, err := c.deadliner.Read(b)
+	if isTimeout(err) {
+		err = StallError{Op: "read", Timeout: c.read, Err: err}
+	}
+	return n, err
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	if c.write <= 0 {
+		return c.deadliner.Write(b)
+	}
+	if err := c.SetWriteDeadline(time.Now().Add(c.write)); err != nil {
+		return 0, err
+	}
+	n, err := c.deadliner.Write(b)
+	if isTimeout(err) {
+		err = StallError{Op: "write", Timeout: c.write, Err: err}
+	}
+	return n, err
+}
+
+// Flush passes on to connections that buffer writes, see Endpoint.Flush
+func (c *deadlineConn) Flush() error {
+	f, ok := c.deadliner.(interface{ Flush() error })
+	if !ok {
+		return nil
+	}
+	if c.write > 0 {
+		if err := c.SetWriteDeadline(time.Now().Add(c.write)); err != nil {
+			return err
+		}
+	}
+	err := f.Flush()
+	if isTimeout(err) {
+		err = StallError{Op: "write", Timeout: c.write, Err: err}
+	}
+	return err
+}
+
+func isTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var te interface{ Timeout() bool }
+	return errors.As(err, &te) && te.Timeout()
+}
@@ -38,7 +38,7 @@ func (lw *logWriter) work() (func(), chan error) {
 	go func() {
 		var (
 			err error
-			pkt *codec.Packet
+			pkt codec.Packet
 		)
 
 		defer func() {
@@ -52,7 +52,7 @@ func (lw *logWriter) work() (func(), chan error) {
 			default:
 			}
 
-			pkt, err = lw.r.ReadPacket()
+			err = lw.r.ReadPacketInto(&pkt)
 			if err != nil {
 				lw.l.Log("error", err)
 
@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+)
+
+// maxEndMessage is how much of the string body of an end packet is kept as the error message
+const maxEndMessage = 64 * 1024
+
+// readEndErr reads the body of an EndErr packet straight from the connection, see readEndBody.
+// streamErr is nil if the stream ended regularly, otherwise it's the *CallError sent by the remote
+// or a malformedError if the body is none of the known ones. err is only set if the connection failed.
+func (r *rpc) readEndErr(hdr codec.Header) (streamErr error, err error) {
+	rd := r.pkr.r.NextBodyReader(hdr.Len)
+
+	// the common case, without the buffering of readEndBody. true ends the stream no matter the flags.
+	if hdr.Len == 4 {
+		var body [4]byte
+		if _, err := io.ReadFull(rd, body[:]); err != nil {
+			return nil, err
+		}
+		if isTrue(body[:]) {
+			return nil, nil
+		}
+		rd = io.MultiReader(bytes.NewReader(body[:]), rd)
+	}
+
+	streamErr, err = readEndBody(hdr.Flag, rd, r.compat.LooseEndBodies)
+	if err != nil {
+		return skipMalformed(hdr.Req, rd, err)
+	}
+	// the decoder might stop before trailing whitespace
+	if _, err := io.Copy(ioutil.Discard, rd); err != nil {
+		return nil, err
+	}
+	return streamErr, nil
+}
+
+// readEndBody interprets the body of an EndErr packet, which ends a stream or replies to an async call with an error.
+// These are the bodies the JS implementation (packet-stream) sends:
+//
+//	true                                   the stream ended regularly
+//	{"name":..,"message":..,"stack":..}    the call failed, with the error flattened to those fields
+//	"message"                              the error of an older peer, as a JSON string or a string body (FlagString)
+//
+// With loose, null, false and empty bodies end the stream regularly as well, see Compat.LooseEndBodies.
+// streamErr is nil for regular ends and a *CallError otherwise.
+// err is set if the body is none of the above, rd might not be read completely then.
+func readEndBody(flag codec.Flag, rd io.Reader, loose bool) (streamErr error, err error) {
+	if flag.Get(codec.FlagString) {
+		msg, err := ioutil.ReadAll(io.LimitReader(rd, maxEndMessage))
+		if err != nil {
+			return nil, err
+		}
+		return &CallError{Name: "Error", Message: string(msg)}, nil
+	}
+
+	br := bufio.NewReaderSize(rd, 16)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		if loose {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("muxrpc: empty body of end packet")
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch first {
+	case '{':
+		var e CallError
+		if err := json.NewDecoder(br).Decode(&e); err != nil {
+			return nil, fmt.Errorf("muxrpc: failed to unmarshal error packet: %w", err)
+		}
+		return &e, nil
+
+	case '"':
+		var msg string
+		if err := json.NewDecoder(br).Decode(&msg); err != nil {
+			return nil, fmt.Errorf("muxrpc: failed to unmarshal error message: %w", err)
+		}
+		return &CallError{Name: "Error", Message: msg}, nil
+	}
+
+	// a literal, only the longest valid one has to fit
+	lit, err := ioutil.ReadAll(io.LimitReader(br, 8))
+	if err != nil {
+		return nil, err
+	}
+	lit = bytes.TrimSpace(lit)
+	if isTrue(lit) {
+		return nil, nil
+	}
+	if loose && (string(lit) == "null" || string(lit) == "false") {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("muxrpc: unexpected body of end packet: %q", lit)
+}
+
+// peekNonSpace skips leading whitespace and returns the first byte after it, without consuming it
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return c, br.UnreadByte()
+	}
+}
+
+func isTrue(data []byte) bool {
+	return len(data) == 4 &&
+		data[0] == 't' &&
+		data[1] == 'r' &&
+		data[2] == 'u' &&
+		data[3] == 'e'
+}
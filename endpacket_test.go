@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+)
+
+// endBodies are end packets as the JS implementation encodes them (packet-stream and packet-stream-codec):
+// stream.end() sends true, errors are flattened to name, message and stack, and strings get the string flag.
+// The loose ones are only taken with Compat.LooseEndBodies.
+var endBodies = []struct {
+	name string
+	flag codec.Flag
+	body string
+
+	want      *CallError // nil for regular ends
+	malformed bool
+	loose     bool // a regular end with Compat.LooseEndBodies, malformed otherwise
+}{
+	{name: "end", flag: codec.FlagJSON, body: "true"},
+	{name: "end with newline", flag: codec.FlagJSON, body: "true\n"},
+	{name: "null", flag: codec.FlagJSON, body: "null", loose: true},
+	{name: "empty", flag: codec.FlagJSON, body: "", loose: true},
+	{name: "false", flag: codec.FlagJSON, body: "false", loose: true},
+	{
+		name: "error",
+		flag: codec.FlagJSON,
+		body: `{"message":"not found","name":"Error","stack":"Error: not found\n    at Object.get (/srv/api.js:12:9)"}`,
+		want: &CallError{Name: "Error", Message: "not found", Stack: "Error: not found\n    at Object.get (/srv/api.js:12:9)"},
+	},
+	{
+		name: "type error",
+		flag: codec.FlagJSON,
+		body: `{"message":"cb is not a function","name":"TypeError","stack":"TypeError: cb is not a function"}`,
+		want: &CallError{Name: "TypeError", Message: "cb is not a function", Stack: "TypeError: cb is not a function"},
+	},
+	{name: "flattened string", flag: codec.FlagJSON, body: `{}`, want: &CallError{}},
+	{name: "json string", flag: codec.FlagJSON, body: `"no such stream"`, want: &CallError{Name: "Error", Message: "no such stream"}},
+	{name: "string", flag: codec.FlagString, body: "no such stream", want: &CallError{Name: "Error", Message: "no such stream"}},
+	{name: "number", flag: codec.FlagJSON, body: "42", malformed: true},
+	{name: "array", flag: codec.FlagJSON, body: `["an","error"]`, malformed: true},
+	{name: "truncated", flag: codec.FlagJSON, body: `{"message":"not fo`, malformed: true},
+}
+
+func TestReadEndBody(t *testing.T) {
+	for _, tc := range endBodies {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+
+			_, err := readEndBody(tc.flag|codec.FlagEndErr, bytes.NewReader([]byte(tc.body)), false)
+			if tc.malformed || tc.loose {
+				r.Error(err)
+			} else {
+				r.NoError(err)
+			}
+
+			streamErr, err := readEndBody(tc.flag|codec.FlagEndErr, bytes.NewReader([]byte(tc.body)), true)
+			if tc.malformed {
+				r.Error(err)
+				return
+			}
+			r.NoError(err)
+			if tc.want == nil {
+				r.NoError(streamErr)
+				return
+			}
+			r.Equal(tc.want, streamErr)
+		})
+	}
+}
+
+func TestEndPackets(t *testing.T) {
+	r := require.New(t)
+
+	edp, rd, w := rawPeer(t, &FakeHandler{})
+	ctx := context.Background()
+
+	for _, tc := range endBodies {
+		src, err := edp.Source(ctx, TypeJSON, Method{"things"})
+		r.NoError(err)
+		call, err := rd.ReadPacket()
+		r.NoError(err)
+
+		r.NoError(w.WritePacket(codec.Packet{
+			Req:  -call.Req,
+			Flag: tc.flag | codec.FlagStream | codec.FlagEndErr,
+			Body: []byte(tc.body),
+		}))
+		r.False(src.Next(ctx), tc.name)
+
+		switch {
+		case tc.malformed || tc.loose:
+			r.True(errors.Is(src.Err(), ErrMalformedPacket), "%s: %v", tc.name, src.Err())
+		case tc.want == nil:
+			r.NoError(src.Err(), tc.name)
+		default:
+			var ce *CallError
+			r.True(errors.As(src.Err(), &ce), "%s: %v", tc.name, src.Err())
+			r.Equal(tc.want, ce, tc.name)
+		}
+
+		// our end of the stream
+		end, err := rd.ReadPacket()
+		r.NoError(err)
+		r.Equal(call.Req, end.Req)
+		r.True(end.Flag.Get(codec.FlagEndErr))
+	}
+
+	_, ended := SessionEnd(edp)
+	r.False(ended)
+}
@@ -4,23 +4,56 @@ package muxrpc
 
 import (
 	"context"
+	"crypto/ed25519"
 	"net"
 )
 
-//go:generate counterfeiter -o fakeendpoint_test.go . Endpoint
+//go:generate counterfeiter -o fakeendpoint.go . Endpoint
 
 // Endpoint allows calling functions on the RPC peer.
 type Endpoint interface {
 	// The different call types:
 	Async(ctx context.Context, ret interface{}, tipe RequestEncoding, method Method, args ...interface{}) error
 
+	// AsyncObj is an async call with a single options object as its argument
+	AsyncObj(ctx context.Context, ret interface{}, tipe RequestEncoding, method Method, opts interface{}) error
+
 	Source(ctx context.Context, tipe RequestEncoding, method Method, args ...interface{}) (*ByteSource, error)
 	Sink(ctx context.Context, tipe RequestEncoding, method Method, args ...interface{}) (*ByteSink, error)
+
+	// SinkBytes is a sink call whose writes are sent as binary frames, like the chunks of a blob upload
+	SinkBytes(ctx context.Context, method Method, args ...interface{}) (*ByteSink, error)
+
 	Duplex(ctx context.Context, tipe RequestEncoding, method Method, args ...interface{}) (*ByteSource, *ByteSink, error)
 
+	// DoBatch starts several calls at once, sending their requests in one write
+	DoBatch(ctx context.Context, reqs ...*Request) error
+
+	// Flush blocks until the packets written so far are passed to the connection or ctx is canceled
+	Flush(ctx context.Context) error
+
 	// Terminate wraps up the RPC session
 	Terminate() error
 
+	// TerminateWithError wraps up the RPC session because of reason.
+	// The calls that are still running are closed with a *TerminatedError that carries reason,
+	// and ctx limits how long ending our streams cooperatively may take.
+	TerminateWithError(ctx context.Context, reason error) error
+
+	// Done is closed once the session is terminated
+	Done() <-chan struct{}
+
+	// Err returns nil until Done is closed and then why the session was terminated,
+	// ErrSessionTerminated or a *TerminatedError with the reason.
+	Err() error
+
 	// Remote returns the network address of the remote
 	Remote() net.Addr
+
+	// RemoteKey returns the public key the remote authenticated with, if the session runs over secretstream.
+	// See NewSecretStreamPacker.
+	RemoteKey() (ed25519.PublicKey, bool)
+
+	// Local returns the network address of our side of the connection
+	Local() net.Addr
 }
@@ -3,7 +3,7 @@
 package muxrpc
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	stderr "errors"
 	"fmt"
@@ -11,11 +11,59 @@ import (
 	"net"
 	"os"
 	"syscall"
+	"time"
 )
 
 // ErrSessionTerminated is returned once Terminate() was called  or the connection dies
 var ErrSessionTerminated = errors.New("muxrpc: session terminated")
 
+// ErrQuotaExceeded is used to end streams and sessions on which the remote sent more data than allowed, see WithStreamQuota and WithSessionQuota.
+var ErrQuotaExceeded = errors.New("muxrpc: quota exceeded")
+
+// ErrTooManyRequests is returned by calls that would exceed the limit set with WithMaxOutstandingRequests.
+var ErrTooManyRequests = errors.New("muxrpc: too many outstanding requests")
+
+// ErrCloseUnacknowledged is returned by AwaitRemoteClose if we stopped waiting for the remote to confirm the end of the stream,
+// because too many streams were waiting for that or because it took longer than WithRequestTTL.
+var ErrCloseUnacknowledged = errors.New("muxrpc: remote didn't acknowledge the end of the stream")
+
+// ErrStalled matches the errors of reads and writes on the connection that ran into the timeouts set with WithReadTimeout and WithWriteTimeout, see StallError.
+var ErrStalled = errors.New("muxrpc: connection stalled")
+
+// StallError is returned when the connection made no progress within the timeout set with WithReadTimeout or WithWriteTimeout.
+type StallError struct {
+	// Op is either "read" or "write"
+	Op      string
+	Timeout time.Duration
+
+	// Err is the deadline error of the connection
+	Err error
+}
+
+func (e StallError) Error() string {
+	return fmt.Sprintf("muxrpc: %s stalled for %s: %s", e.Op, e.Timeout, e.Err)
+}
+
+func (e StallError) Unwrap() error { return e.Err }
+
+// Is lets errors.Is(err, ErrStalled) match
+func (e StallError) Is(target error) bool { return target == ErrStalled }
+
+// TerminatedError ends the calls of a session that was terminated for a reason, see Endpoint.TerminateWithError.
+// It matches ErrSessionTerminated with errors.Is.
+type TerminatedError struct {
+	Reason error
+}
+
+func (e *TerminatedError) Error() string {
+	return fmt.Sprintf("muxrpc: session terminated: %s", e.Reason)
+}
+
+func (e *TerminatedError) Unwrap() error { return e.Reason }
+
+// Is lets errors.Is(err, ErrSessionTerminated) match
+func (e *TerminatedError) Is(target error) bool { return target == ErrSessionTerminated }
+
 var errSinkClosed = stderr.New("muxrpc: pour to closed sink")
 
 type ErrNoSuchMethod struct {
@@ -26,6 +74,22 @@ func (e ErrNoSuchMethod) Error() string {
 	return fmt.Sprintf("muxrpc: no such command: %s", e.Method)
 }
 
+// ErrMethodUnsupported is returned by calls that the manifest of the remote doesn't allow, see WithManifestGating.
+type ErrMethodUnsupported struct {
+	Method Method
+	Type   CallType
+
+	// Advertised is the type the remote lists for the method, empty if it doesn't list it or didn't send a manifest
+	Advertised string
+}
+
+func (e ErrMethodUnsupported) Error() string {
+	if e.Advertised == "" {
+		return fmt.Sprintf("muxrpc: remote does not support %s", e.Method)
+	}
+	return fmt.Sprintf("muxrpc: remote supports %s as %s, not %s", e.Method, e.Advertised, e.Type)
+}
+
 // CallError is returned when a call fails
 type CallError struct {
 	Name    string `json:"name"`
@@ -37,20 +101,9 @@ func (e CallError) Error() string {
 	return fmt.Sprintf("muxrpc CallError: %s - %s", e.Name, e.Message)
 }
 
-func parseError(data []byte) (*CallError, error) {
-	var e CallError
-
-	err := json.Unmarshal(data, &e)
-	if err != nil {
-		return nil, fmt.Errorf("muxrpc: failed to unmarshal error packet: %w", err)
-	}
-
-	// There are also TypeErrors and numerous other things we might get from this..
-	// if e.Name != "Error" {
-	// 	return nil, fmt.Errorf(`name is not "Error" but %q`, e.Name)
-	// }
-
-	return &e, nil
+// Is makes errors.Is(err, context.DeadlineExceeded) true for calls whose handler missed the deadline, see WithDeadlinePropagation.
+func (e CallError) Is(target error) bool {
+	return target == context.DeadlineExceeded && e.Name == DeadlineExceededErrorName
 }
 
 type ErrWrongStreamType struct{ ct CallType }
@@ -69,7 +122,7 @@ func IsSinkClosed(err error) bool {
 		return true
 	}
 
-	if err == ErrSessionTerminated {
+	if errors.Is(err, ErrSessionTerminated) {
 		return true
 	}
 
@@ -0,0 +1,179 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.mindeco.de/log/level"
+)
+
+// Names of the extensions this package implements, see WithExtensions.
+const (
+	// ExtensionCBOR is advertised by endpoints with WithCBOR
+	ExtensionCBOR = "cbor"
+
+	// ExtensionDeadline is advertised by endpoints with WithDeadlinePropagation
+	ExtensionDeadline = "deadline"
+)
+
+// ExtensionsMethod is answered by every endpoint itself, with the list of extensions it supports.
+// It takes the list of the caller as its only argument. It's not listed in manifests.
+var ExtensionsMethod = Method{"muxrpc", "extensions"}
+
+// WithExtensions makes the endpoint agree with the remote on the optional protocol features both of them support.
+// names are added to the extensions the options of the endpoint already imply, like ExtensionCBOR.
+// Handle asks the remote for its extensions right after the manifest, see Extensions for the outcome.
+// Remotes that don't know about extensions have none in common with us.
+func WithExtensions(names ...string) HandleOption {
+	return func(r *rpc) {
+		r.extensions.enabled = true
+		r.extensions.local = append(r.extensions.local, names...)
+	}
+}
+
+// Extensions returns the extensions that both sides of edp support, sorted by name.
+// Features that need the remote to play along can be switched on safely if they are in it.
+// Endpoints that were handled without WithExtensions only know them if the remote asked.
+func Extensions(edp Endpoint) []string {
+	en, ok := edp.(extensionNegotiator)
+	if !ok {
+		return nil
+	}
+	return en.negotiatedExtensions()
+}
+
+// HasExtension returns true if both sides of edp support the extension name, see Extensions.
+func HasExtension(edp Endpoint, name string) bool {
+	for _, ext := range Extensions(edp) {
+		if ext == name {
+			return true
+		}
+	}
+	return false
+}
+
+// extensionNegotiator is implemented by the endpoints returned from Handle
+type extensionNegotiator interface {
+	negotiatedExtensions() []string
+}
+
+type extensionSet struct {
+	enabled bool     // see WithExtensions
+	local   []string // names passed to WithExtensions
+
+	mu         sync.Mutex
+	negotiated []string
+}
+
+func (r *rpc) negotiatedExtensions() []string {
+	r.extensions.mu.Lock()
+	defer r.extensions.mu.Unlock()
+	return append([]string(nil), r.extensions.negotiated...)
+}
+
+func (r *rpc) hasExtension(name string) bool {
+	r.extensions.mu.Lock()
+	defer r.extensions.mu.Unlock()
+	for _, ext := range r.extensions.negotiated {
+		if ext == name {
+			return true
+		}
+	}
+	return false
+}
+
+// localExtensions returns what we support, sorted and without duplicates
+func (r *rpc) localExtensions() []string {
+	names := append([]string(nil), r.extensions.local...)
+	if r.cbor != nil {
+		names = append(names, ExtensionCBOR)
+	}
+	if r.propagateDeadlines {
+		names = append(names, ExtensionDeadline)
+	}
+	sort.Strings(names)
+
+	var uniq []string
+	for i, n := range names {
+		if i == 0 || n != names[i-1] {
+			uniq = append(uniq, n)
+		}
+	}
+	return uniq
+}
+
+// agreeOn stores the extensions we have in common with the remote, which supports remote
+func (r *rpc) agreeOn(remote []string) {
+	supported := make(map[string]bool, len(remote))
+	for _, n := range remote {
+		supported[n] = true
+	}
+
+	var common []string
+	for _, n := range r.localExtensions() {
+		if supported[n] {
+			common = append(common, n)
+		}
+	}
+
+	r.extensions.mu.Lock()
+	r.extensions.negotiated = common
+	r.extensions.mu.Unlock()
+}
+
+// negotiateExtensions asks the remote for its extensions, if WithExtensions was used
+func (r *rpc) negotiateExtensions() {
+	if !r.extensions.enabled {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.serveCtx, manifestTimeout)
+	defer cancel()
+
+	var remote []string
+	err := r.Async(ctx, &remote, TypeJSON, ExtensionsMethod, r.localExtensions())
+	if err != nil {
+		level.Debug(r.logger).Log("event", "extension negotiation failed", "err", err)
+		return
+	}
+	r.agreeOn(remote)
+}
+
+// extensionsHandler answers ExtensionsMethod and passes everything else on
+type extensionsHandler struct {
+	Handler
+
+	r *rpc
+}
+
+func isExtensionsMethod(m Method) bool {
+	return m.String() == ExtensionsMethod.String()
+}
+
+func (h extensionsHandler) Handled(m Method) bool {
+	return isExtensionsMethod(m) || h.Handler.Handled(m)
+}
+
+func (h extensionsHandler) HandleCall(ctx context.Context, req *Request) {
+	if !isExtensionsMethod(req.Method) {
+		h.Handler.HandleCall(ctx, req)
+		return
+	}
+
+	var args []json.RawMessage
+	var remote []string
+	if err := json.Unmarshal(req.RawArgs, &args); err == nil && len(args) > 0 {
+		err = json.Unmarshal(args[0], &remote)
+		if err != nil {
+			req.CloseWithError(fmt.Errorf("muxrpc: invalid list of extensions: %w", err))
+			return
+		}
+	}
+	h.r.agreeOn(remote)
+	req.Return(ctx, h.r.localExtensions())
+}
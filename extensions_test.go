@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// handledPair is like connectedPair but with different options for each side
+func handledPair(t *testing.T, h1, h2 Handler, opts1, opts2 []HandleOption) (Endpoint, Endpoint) {
+	c1, c2 := loPipe(t)
+
+	var rpc1 Endpoint
+	handled := make(chan struct{})
+	go func() {
+		rpc1 = Handle(NewPacker(c1), h1, opts1...)
+		close(handled)
+	}()
+	rpc2 := Handle(NewPacker(c2), h2, opts2...)
+	<-handled
+	t.Cleanup(func() {
+		rpc1.Terminate()
+		rpc2.Terminate()
+	})
+	return rpc1, rpc2
+}
+
+func TestExtensions(t *testing.T) {
+	r := require.New(t)
+
+	var fh2 FakeHandler
+	fh2.HandledCalls(func(m Method) bool {
+		return m.String() == "manifest" || m.String() == "hello"
+	})
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		switch req.Method.String() {
+		case "manifest":
+			// doesn't list CBORManifestMethod
+			req.Return(ctx, json.RawMessage(`{"hello":"async"}`))
+		case "hello":
+			req.Return(ctx, map[string]string{"hello": "world"})
+		}
+	})
+
+	var cbor1, cbor2 markedJSON
+	rpc1, rpc2 := handledPair(t, &FakeHandler{}, &fh2,
+		[]HandleOption{WithExtensions("compression", "flow"), WithCBOR(&cbor1)},
+		[]HandleOption{WithExtensions("flow", "flow"), WithCBOR(&cbor2)},
+	)
+
+	want := []string{ExtensionCBOR, "flow"}
+	r.Equal(want, Extensions(rpc1))
+	r.Equal(want, Extensions(rpc2))
+	r.True(HasExtension(rpc1, "flow"))
+	r.False(HasExtension(rpc1, "compression"))
+	r.False(HasExtension(rpc2, ExtensionDeadline))
+
+	// the negotiation itself might already use CBOR, depending on which side asked first
+	cbor1.mu.Lock()
+	before := cbor1.decoders
+	cbor1.mu.Unlock()
+
+	var ret map[string]string
+	r.NoError(rpc1.Async(context.Background(), &ret, TypeJSON, Method{"hello"}))
+	r.Equal("world", ret["hello"])
+
+	cbor1.mu.Lock()
+	defer cbor1.mu.Unlock()
+	r.Equal(before+1, cbor1.decoders, "reply not decoded as CBOR")
+}
+
+func TestExtensionsOneSided(t *testing.T) {
+	r := require.New(t)
+
+	rpc1, rpc2 := handledPair(t, &FakeHandler{}, &FakeHandler{},
+		[]HandleOption{WithExtensions("flow"), WithDeadlinePropagation(), WithManifestGating()},
+		nil,
+	)
+	r.Empty(Extensions(rpc1))
+	r.Empty(Extensions(rpc2))
+
+	// the remote still tells what it has, even though it didn't ask itself
+	var remote []string
+	r.NoError(rpc1.Async(context.Background(), &remote, TypeJSON, ExtensionsMethod, []string{"flow"}))
+	r.Empty(remote)
+
+	r.Nil(Extensions(&FakeEndpoint{}))
+}
@@ -0,0 +1,376 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrNoPeers is returned by calls to a FailoverEndpoint if it couldn't connect to any of its peers
+var ErrNoPeers = errors.New("muxrpc: no peer reachable")
+
+// errFailback ends the session to a less preferred peer once a FailoverEndpoint switched back to a better one
+var errFailback = errors.New("muxrpc: switched back to a preferred peer")
+
+// defaultFailoverCooldown is how long a failed peer is avoided, unless FailoverConfig says otherwise.
+const defaultFailoverCooldown = 30 * time.Second
+
+// FailoverPeer is one of the remotes a FailoverEndpoint can use
+type FailoverPeer struct {
+	Name string
+
+	// Connect establishes a session with the peer. Its context is the one of the call that needs the session,
+	// don't tie the session to it.
+	Connect func(context.Context) (Endpoint, error)
+}
+
+// FailoverConfig configures a FailoverEndpoint
+type FailoverConfig struct {
+	// Peers in the order of preference
+	Peers []FailoverPeer
+
+	// Cooldown is how long a peer is avoided after its session died or connecting to it failed, defaults to 30 seconds
+	Cooldown time.Duration
+
+	// Sticky keeps using a working peer even if a preferred one is healthy again.
+	// Without it, the next call after the cooldown of a preferred peer tries to switch back to it
+	// and terminates the session to the current peer if that works.
+	Sticky bool
+
+	// Clock measures the cooldowns, defaults to SystemClock
+	Clock Clock
+}
+
+// PeerHealth is what a FailoverEndpoint knows about one of its peers, see FailoverEndpoint.Health
+type PeerHealth struct {
+	Name string
+
+	// Connected is true for the peer the calls currently go to
+	Connected bool
+
+	// Healthy is false while the peer cools down after a failure
+	Healthy bool
+
+	// Failures counts the failures since the last successful connect
+	Failures    int
+	LastErr     error
+	LastFailure time.Time
+}
+
+// FailoverEndpoint is an Endpoint that spreads over a list of peers, like several pubs or the replicas of a service.
+// It connects to the first healthy peer once it's needed and uses that session until it dies.
+// Calls that fail because the session ended are repeated on the next peer,
+// so only use it for methods that are safe to repeat.
+// Streams are only moved to another peer if they couldn't be opened, once they run they end with their session.
+type FailoverEndpoint struct {
+	cfg   FailoverConfig
+	clock Clock
+
+	mu     sync.Mutex
+	health []PeerHealth
+	cur    Endpoint
+	curIdx int
+	last   int // the peer that worked last, for Sticky
+
+	termErr error
+	done    chan struct{}
+}
+
+var _ Endpoint = (*FailoverEndpoint)(nil)
+
+// NewFailoverEndpoint returns an endpoint for the peers of cfg. It doesn't connect until the first call.
+func NewFailoverEndpoint(cfg FailoverConfig) (*FailoverEndpoint, error) {
+	if len(cfg.Peers) == 0 {
+		return nil, errors.New("muxrpc: failover needs at least one peer")
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = defaultFailoverCooldown
+	}
+	f := &FailoverEndpoint{
+		cfg:    cfg,
+		clock:  clockOrSystem(cfg.Clock),
+		health: make([]PeerHealth, len(cfg.Peers)),
+		curIdx: -1,
+		last:   -1,
+		done:   make(chan struct{}),
+	}
+	for i, p := range cfg.Peers {
+		f.health[i] = PeerHealth{Name: p.Name, Healthy: true}
+	}
+	return f, nil
+}
+
+// Health returns the state of the peers, in the order of the config
+func (f *FailoverEndpoint) Health() []PeerHealth {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.clock.Now()
+	out := make([]PeerHealth, len(f.health))
+	for i, h := range f.health {
+		h.Healthy = f.healthy(i, now)
+		h.Connected = f.cur != nil && i == f.curIdx
+		out[i] = h
+	}
+	return out
+}
+
+// healthy returns false while peer i cools down
+func (f *FailoverEndpoint) healthy(i int, now time.Time) bool {
+	h := f.health[i]
+	return h.Failures == 0 || now.Sub(h.LastFailure) >= f.cfg.Cooldown
+}
+
+func (f *FailoverEndpoint) markFailed(i int, err error) {
+	h := &f.health[i]
+	h.Failures++
+	h.LastErr = err
+	h.LastFailure = f.clock.Now()
+}
+
+// current returns the session calls should use and connects one if there is none
+func (f *FailoverEndpoint) current(ctx context.Context) (Endpoint, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.termErr != nil {
+		return nil, f.termErr
+	}
+
+	now := f.clock.Now()
+	if f.cur != nil {
+		select {
+		case <-f.cur.Done():
+			f.markFailed(f.curIdx, f.cur.Err())
+			f.cur = nil
+		default:
+			better := f.betterPeers(now)
+			if f.cfg.Sticky || len(better) == 0 {
+				return f.cur, nil
+			}
+			old := f.cur
+			edp, i, err := f.connect(ctx, better)
+			if err != nil {
+				return old, nil // stay where we are
+			}
+			f.cur, f.curIdx, f.last = edp, i, i
+			go old.TerminateWithError(context.Background(), errFailback)
+			return edp, nil
+		}
+	}
+
+	edp, i, err := f.connect(ctx, f.order(now))
+	if err != nil {
+		return nil, err
+	}
+	f.cur, f.curIdx, f.last = edp, i, i
+	return edp, nil
+}
+
+// betterPeers returns the healthy peers that are preferred over the current one
+func (f *FailoverEndpoint) betterPeers(now time.Time) []int {
+	var better []int
+	for i := 0; i < f.curIdx; i++ {
+		if f.healthy(i, now) {
+			better = append(better, i)
+		}
+	}
+	return better
+}
+
+// order returns the peers in the order they should be tried: the healthy ones by preference, then the others.
+// With Sticky the one that worked last goes first, if it's healthy.
+func (f *FailoverEndpoint) order(now time.Time) []int {
+	var healthy, cooling []int
+	lastFirst := f.cfg.Sticky && f.last >= 0 && f.healthy(f.last, now)
+	if lastFirst {
+		healthy = append(healthy, f.last)
+	}
+	for i := range f.cfg.Peers {
+		switch {
+		case lastFirst && i == f.last:
+		case f.healthy(i, now):
+			healthy = append(healthy, i)
+		default:
+			cooling = append(cooling, i)
+		}
+	}
+	return append(healthy, cooling...)
+}
+
+// connect tries the peers until one of them accepts
+func (f *FailoverEndpoint) connect(ctx context.Context, peers []int) (Endpoint, int, error) {
+	var lastErr error
+	for _, i := range peers {
+		edp, err := f.cfg.Peers[i].Connect(ctx)
+		if err == nil {
+			f.health[i].Failures = 0
+			f.health[i].LastErr = nil
+			return edp, i, nil
+		}
+		f.markFailed(i, err)
+		lastErr = fmt.Errorf("%s: %w", f.cfg.Peers[i].Name, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if lastErr == nil {
+		return nil, -1, ErrNoPeers
+	}
+	return nil, -1, fmt.Errorf("%w, last error: %v", ErrNoPeers, lastErr)
+}
+
+// drop forgets the session edp after a call found it dead
+func (f *FailoverEndpoint) drop(edp Endpoint, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cur == edp {
+		f.markFailed(f.curIdx, err)
+		f.cur = nil
+	}
+}
+
+// do runs call on the current session and repeats it on the next one if the session ended
+func (f *FailoverEndpoint) do(ctx context.Context, call func(Endpoint) error) error {
+	var err error
+	for range f.cfg.Peers {
+		var edp Endpoint
+		edp, err = f.current(ctx)
+		if err != nil {
+			return err
+		}
+		err = call(edp)
+		if err == nil || !errors.Is(err, ErrSessionTerminated) || ctx.Err() != nil {
+			return err
+		}
+		f.drop(edp, err)
+	}
+	return err
+}
+
+func (f *FailoverEndpoint) Async(ctx context.Context, ret interface{}, re RequestEncoding, method Method, args ...interface{}) error {
+	return f.do(ctx, func(edp Endpoint) error {
+		return edp.Async(ctx, ret, re, method, args...)
+	})
+}
+
+func (f *FailoverEndpoint) AsyncObj(ctx context.Context, ret interface{}, re RequestEncoding, method Method, opts interface{}) error {
+	return f.do(ctx, func(edp Endpoint) error {
+		return edp.AsyncObj(ctx, ret, re, method, opts)
+	})
+}
+
+func (f *FailoverEndpoint) Source(ctx context.Context, re RequestEncoding, method Method, args ...interface{}) (src *ByteSource, err error) {
+	err = f.do(ctx, func(edp Endpoint) error {
+		src, err = edp.Source(ctx, re, method, args...)
+		return err
+	})
+	return src, err
+}
+
+func (f *FailoverEndpoint) Sink(ctx context.Context, re RequestEncoding, method Method, args ...interface{}) (snk *ByteSink, err error) {
+	err = f.do(ctx, func(edp Endpoint) error {
+		snk, err = edp.Sink(ctx, re, method, args...)
+		return err
+	})
+	return snk, err
+}
+
+func (f *FailoverEndpoint) SinkBytes(ctx context.Context, method Method, args ...interface{}) (snk *ByteSink, err error) {
+	err = f.do(ctx, func(edp Endpoint) error {
+		snk, err = edp.SinkBytes(ctx, method, args...)
+		return err
+	})
+	return snk, err
+}
+
+func (f *FailoverEndpoint) Duplex(ctx context.Context, re RequestEncoding, method Method, args ...interface{}) (src *ByteSource, snk *ByteSink, err error) {
+	err = f.do(ctx, func(edp Endpoint) error {
+		src, snk, err = edp.Duplex(ctx, re, method, args...)
+		return err
+	})
+	return src, snk, err
+}
+
+func (f *FailoverEndpoint) DoBatch(ctx context.Context, reqs ...*Request) error {
+	return f.do(ctx, func(edp Endpoint) error {
+		return edp.DoBatch(ctx, reqs...)
+	})
+}
+
+// connected returns the current session or nil
+func (f *FailoverEndpoint) connected() Endpoint {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cur
+}
+
+func (f *FailoverEndpoint) Flush(ctx context.Context) error {
+	if edp := f.connected(); edp != nil {
+		return edp.Flush(ctx)
+	}
+	return nil
+}
+
+func (f *FailoverEndpoint) Terminate() error {
+	return f.TerminateWithError(context.Background(), nil)
+}
+
+// TerminateWithError terminates the current session and makes all later calls fail
+func (f *FailoverEndpoint) TerminateWithError(ctx context.Context, reason error) error {
+	f.mu.Lock()
+	if f.termErr != nil {
+		f.mu.Unlock()
+		return nil
+	}
+	f.termErr = ErrSessionTerminated
+	if reason != nil {
+		f.termErr = &TerminatedError{Reason: reason}
+	}
+	cur := f.cur
+	f.cur = nil
+	close(f.done)
+	f.mu.Unlock()
+
+	if cur != nil {
+		return cur.TerminateWithError(ctx, reason)
+	}
+	return nil
+}
+
+// Done is closed once the endpoint is terminated, the sessions to its peers can end before that
+func (f *FailoverEndpoint) Done() <-chan struct{} { return f.done }
+
+func (f *FailoverEndpoint) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.termErr
+}
+
+// Remote returns the address of the current peer, or nil if there is no session
+func (f *FailoverEndpoint) Remote() net.Addr {
+	if edp := f.connected(); edp != nil {
+		return edp.Remote()
+	}
+	return nil
+}
+
+// RemoteKey returns the key of the peer of the current session, if there is one and it runs over secretstream
+func (f *FailoverEndpoint) RemoteKey() (ed25519.PublicKey, bool) {
+	if edp := f.connected(); edp != nil {
+		return edp.RemoteKey()
+	}
+	return nil, false
+}
+
+// Local returns the local address of the current session, or nil if there is none
+func (f *FailoverEndpoint) Local() net.Addr {
+	if edp := f.connected(); edp != nil {
+		return edp.Local()
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// stepClock is a Clock whose Now only moves when the test says so
+type stepClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *stepClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *stepClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+func (c *stepClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// testPeer is a peer for failover tests that answers whoami with its name.
+// seq is a live source that sends the three numbers after its argument and then stays open.
+type testPeer struct {
+	t    *testing.T
+	name string
+
+	mu     sync.Mutex
+	down   bool
+	server Endpoint
+	client Endpoint
+}
+
+func (p *testPeer) failover() FailoverPeer {
+	return FailoverPeer{Name: p.name, Connect: p.connect}
+}
+
+func (p *testPeer) connect(ctx context.Context) (Endpoint, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.down {
+		return nil, errors.New("connection refused")
+	}
+	var fh FakeHandler
+	fh.HandledCalls(func(m Method) bool { return m.String() == "whoami" || m.String() == "seq" })
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		if req.Method.String() == "whoami" {
+			req.Return(ctx, p.name)
+			return
+		}
+		var from int
+		if err := req.ParseArgs(&from); err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		snk, err := req.ResponseSink()
+		if err != nil {
+			return
+		}
+		for i := from + 1; i <= from+3; i++ {
+			fmt.Fprint(snk, i)
+		}
+		<-ctx.Done()
+	})
+	p.client, p.server = connectedPair(p.t, &FakeHandler{}, &fh)
+	return p.client, nil
+}
+
+// kill ends the session from the side of the peer and refuses new connections
+func (p *testPeer) kill() {
+	p.mu.Lock()
+	p.down = true
+	server, client := p.server, p.client
+	p.mu.Unlock()
+	server.Terminate()
+	<-client.Done()
+}
+
+func (p *testPeer) revive() {
+	p.mu.Lock()
+	p.down = false
+	p.mu.Unlock()
+}
+
+func whoami(t *testing.T, edp Endpoint) string {
+	var who string
+	err := edp.Async(context.Background(), &who, TypeString, Method{"whoami"})
+	require.NoError(t, err)
+	return who
+}
+
+func TestFailoverEndpoint(t *testing.T) {
+	r := require.New(t)
+
+	a, b := &testPeer{t: t, name: "a"}, &testPeer{t: t, name: "b"}
+	clock := &stepClock{now: time.Unix(0, 0)}
+	f, err := NewFailoverEndpoint(FailoverConfig{
+		Peers:    []FailoverPeer{a.failover(), b.failover()},
+		Cooldown: time.Minute,
+		Clock:    clock,
+	})
+	r.NoError(err)
+	defer f.Terminate()
+
+	r.Nil(f.Remote(), "not connected before the first call")
+	r.Equal("a", whoami(t, f))
+
+	a.kill()
+	r.Equal("b", whoami(t, f))
+
+	health := f.Health()
+	r.False(health[0].Healthy)
+	r.Equal(1, health[0].Failures)
+	r.True(errors.Is(health[0].LastErr, ErrSessionTerminated), "unexpected error: %v", health[0].LastErr)
+	r.False(health[0].Connected)
+	r.True(health[1].Healthy)
+	r.True(health[1].Connected)
+
+	// a is back, but still cooling down
+	a.revive()
+	r.Equal("b", whoami(t, f))
+
+	clock.Advance(time.Minute)
+	r.Equal("a", whoami(t, f), "should switch back to the preferred peer")
+	select {
+	case <-b.client.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("the session to b wasn't ended")
+	}
+
+	health = f.Health()
+	r.True(health[0].Connected)
+	r.Equal(0, health[0].Failures)
+
+	r.NoError(f.Terminate())
+	<-f.Done()
+	r.True(errors.Is(f.Err(), ErrSessionTerminated))
+	err = f.Async(context.Background(), nil, TypeString, Method{"whoami"})
+	r.True(errors.Is(err, ErrSessionTerminated), "unexpected error: %v", err)
+}
+
+func TestFailoverEndpointSticky(t *testing.T) {
+	r := require.New(t)
+
+	a, b := &testPeer{t: t, name: "a"}, &testPeer{t: t, name: "b"}
+	clock := &stepClock{now: time.Unix(0, 0)}
+	f, err := NewFailoverEndpoint(FailoverConfig{
+		Peers:    []FailoverPeer{a.failover(), b.failover()},
+		Cooldown: time.Minute,
+		Sticky:   true,
+		Clock:    clock,
+	})
+	r.NoError(err)
+	defer f.Terminate()
+
+	r.Equal("a", whoami(t, f))
+	a.kill()
+	r.Equal("b", whoami(t, f))
+
+	a.revive()
+	clock.Advance(time.Minute)
+	r.Equal("b", whoami(t, f), "should stay with the working peer")
+}
+
+func TestFailoverEndpointNoPeers(t *testing.T) {
+	r := require.New(t)
+
+	a, b := &testPeer{t: t, name: "a", down: true}, &testPeer{t: t, name: "b", down: true}
+	f, err := NewFailoverEndpoint(FailoverConfig{Peers: []FailoverPeer{a.failover(), b.failover()}})
+	r.NoError(err)
+
+	_, err = f.Source(context.Background(), TypeJSON, Method{"feed"})
+	r.True(errors.Is(err, ErrNoPeers), "unexpected error: %v", err)
+	r.Contains(err.Error(), "connection refused")
+
+	_, err = NewFailoverEndpoint(FailoverConfig{})
+	r.Error(err)
+}
@@ -3,6 +3,7 @@ package muxrpc
 
 import (
 	"context"
+	"crypto/ed25519"
 	"net"
 	"sync"
 )
@@ -23,6 +24,43 @@ type FakeEndpoint struct {
 	asyncReturnsOnCall map[int]struct {
 		result1 error
 	}
+	AsyncObjStub        func(context.Context, interface{}, RequestEncoding, Method, interface{}) error
+	asyncObjMutex       sync.RWMutex
+	asyncObjArgsForCall []struct {
+		arg1 context.Context
+		arg2 interface{}
+		arg3 RequestEncoding
+		arg4 Method
+		arg5 interface{}
+	}
+	asyncObjReturns struct {
+		result1 error
+	}
+	asyncObjReturnsOnCall map[int]struct {
+		result1 error
+	}
+	DoBatchStub        func(context.Context, ...*Request) error
+	doBatchMutex       sync.RWMutex
+	doBatchArgsForCall []struct {
+		arg1 context.Context
+		arg2 []*Request
+	}
+	doBatchReturns struct {
+		result1 error
+	}
+	doBatchReturnsOnCall map[int]struct {
+		result1 error
+	}
+	DoneStub        func() <-chan struct{}
+	doneMutex       sync.RWMutex
+	doneArgsForCall []struct {
+	}
+	doneReturns struct {
+		result1 <-chan struct{}
+	}
+	doneReturnsOnCall map[int]struct {
+		result1 <-chan struct{}
+	}
 	DuplexStub        func(context.Context, RequestEncoding, Method, ...interface{}) (*ByteSource, *ByteSink, error)
 	duplexMutex       sync.RWMutex
 	duplexArgsForCall []struct {
@@ -41,6 +79,37 @@ type FakeEndpoint struct {
 		result2 *ByteSink
 		result3 error
 	}
+	ErrStub        func() error
+	errMutex       sync.RWMutex
+	errArgsForCall []struct {
+	}
+	errReturns struct {
+		result1 error
+	}
+	errReturnsOnCall map[int]struct {
+		result1 error
+	}
+	FlushStub        func(context.Context) error
+	flushMutex       sync.RWMutex
+	flushArgsForCall []struct {
+		arg1 context.Context
+	}
+	flushReturns struct {
+		result1 error
+	}
+	flushReturnsOnCall map[int]struct {
+		result1 error
+	}
+	LocalStub        func() net.Addr
+	localMutex       sync.RWMutex
+	localArgsForCall []struct {
+	}
+	localReturns struct {
+		result1 net.Addr
+	}
+	localReturnsOnCall map[int]struct {
+		result1 net.Addr
+	}
 	RemoteStub        func() net.Addr
 	remoteMutex       sync.RWMutex
 	remoteArgsForCall []struct {
@@ -51,6 +120,18 @@ type FakeEndpoint struct {
 	remoteReturnsOnCall map[int]struct {
 		result1 net.Addr
 	}
+	RemoteKeyStub        func() (ed25519.PublicKey, bool)
+	remoteKeyMutex       sync.RWMutex
+	remoteKeyArgsForCall []struct {
+	}
+	remoteKeyReturns struct {
+		result1 ed25519.PublicKey
+		result2 bool
+	}
+	remoteKeyReturnsOnCall map[int]struct {
+		result1 ed25519.PublicKey
+		result2 bool
+	}
 	SinkStub        func(context.Context, RequestEncoding, Method, ...interface{}) (*ByteSink, error)
 	sinkMutex       sync.RWMutex
 	sinkArgsForCall []struct {
@@ -67,6 +148,21 @@ type FakeEndpoint struct {
 		result1 *ByteSink
 		result2 error
 	}
+	SinkBytesStub        func(context.Context, Method, ...interface{}) (*ByteSink, error)
+	sinkBytesMutex       sync.RWMutex
+	sinkBytesArgsForCall []struct {
+		arg1 context.Context
+		arg2 Method
+		arg3 []interface{}
+	}
+	sinkBytesReturns struct {
+		result1 *ByteSink
+		result2 error
+	}
+	sinkBytesReturnsOnCall map[int]struct {
+		result1 *ByteSink
+		result2 error
+	}
 	SourceStub        func(context.Context, RequestEncoding, Method, ...interface{}) (*ByteSource, error)
 	sourceMutex       sync.RWMutex
 	sourceArgsForCall []struct {
@@ -93,6 +189,18 @@ type FakeEndpoint struct {
 	terminateReturnsOnCall map[int]struct {
 		result1 error
 	}
+	TerminateWithErrorStub        func(context.Context, error) error
+	terminateWithErrorMutex       sync.RWMutex
+	terminateWithErrorArgsForCall []struct {
+		arg1 context.Context
+		arg2 error
+	}
+	terminateWithErrorReturns struct {
+		result1 error
+	}
+	terminateWithErrorReturnsOnCall map[int]struct {
+		result1 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -162,6 +270,186 @@ func (fake *FakeEndpoint) AsyncReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
+func (fake *FakeEndpoint) AsyncObj(arg1 context.Context, arg2 interface{}, arg3 RequestEncoding, arg4 Method, arg5 interface{}) error {
+	fake.asyncObjMutex.Lock()
+	ret, specificReturn := fake.asyncObjReturnsOnCall[len(fake.asyncObjArgsForCall)]
+	fake.asyncObjArgsForCall = append(fake.asyncObjArgsForCall, struct {
+		arg1 context.Context
+		arg2 interface{}
+		arg3 RequestEncoding
+		arg4 Method
+		arg5 interface{}
+	}{arg1, arg2, arg3, arg4, arg5})
+	stub := fake.AsyncObjStub
+	fakeReturns := fake.asyncObjReturns
+	fake.recordInvocation("AsyncObj", []interface{}{arg1, arg2, arg3, arg4, arg5})
+	fake.asyncObjMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3, arg4, arg5)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeEndpoint) AsyncObjCallCount() int {
+	fake.asyncObjMutex.RLock()
+	defer fake.asyncObjMutex.RUnlock()
+	return len(fake.asyncObjArgsForCall)
+}
+
+func (fake *FakeEndpoint) AsyncObjCalls(stub func(context.Context, interface{}, RequestEncoding, Method, interface{}) error) {
+	fake.asyncObjMutex.Lock()
+	defer fake.asyncObjMutex.Unlock()
+	fake.AsyncObjStub = stub
+}
+
+func (fake *FakeEndpoint) AsyncObjArgsForCall(i int) (context.Context, interface{}, RequestEncoding, Method, interface{}) {
+	fake.asyncObjMutex.RLock()
+	defer fake.asyncObjMutex.RUnlock()
+	argsForCall := fake.asyncObjArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4, argsForCall.arg5
+}
+
+func (fake *FakeEndpoint) AsyncObjReturns(result1 error) {
+	fake.asyncObjMutex.Lock()
+	defer fake.asyncObjMutex.Unlock()
+	fake.AsyncObjStub = nil
+	fake.asyncObjReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeEndpoint) AsyncObjReturnsOnCall(i int, result1 error) {
+	fake.asyncObjMutex.Lock()
+	defer fake.asyncObjMutex.Unlock()
+	fake.AsyncObjStub = nil
+	if fake.asyncObjReturnsOnCall == nil {
+		fake.asyncObjReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.asyncObjReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeEndpoint) DoBatch(arg1 context.Context, arg2 ...*Request) error {
+	fake.doBatchMutex.Lock()
+	ret, specificReturn := fake.doBatchReturnsOnCall[len(fake.doBatchArgsForCall)]
+	fake.doBatchArgsForCall = append(fake.doBatchArgsForCall, struct {
+		arg1 context.Context
+		arg2 []*Request
+	}{arg1, arg2})
+	stub := fake.DoBatchStub
+	fakeReturns := fake.doBatchReturns
+	fake.recordInvocation("DoBatch", []interface{}{arg1, arg2})
+	fake.doBatchMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2...)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeEndpoint) DoBatchCallCount() int {
+	fake.doBatchMutex.RLock()
+	defer fake.doBatchMutex.RUnlock()
+	return len(fake.doBatchArgsForCall)
+}
+
+func (fake *FakeEndpoint) DoBatchCalls(stub func(context.Context, ...*Request) error) {
+	fake.doBatchMutex.Lock()
+	defer fake.doBatchMutex.Unlock()
+	fake.DoBatchStub = stub
+}
+
+func (fake *FakeEndpoint) DoBatchArgsForCall(i int) (context.Context, []*Request) {
+	fake.doBatchMutex.RLock()
+	defer fake.doBatchMutex.RUnlock()
+	argsForCall := fake.doBatchArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeEndpoint) DoBatchReturns(result1 error) {
+	fake.doBatchMutex.Lock()
+	defer fake.doBatchMutex.Unlock()
+	fake.DoBatchStub = nil
+	fake.doBatchReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeEndpoint) DoBatchReturnsOnCall(i int, result1 error) {
+	fake.doBatchMutex.Lock()
+	defer fake.doBatchMutex.Unlock()
+	fake.DoBatchStub = nil
+	if fake.doBatchReturnsOnCall == nil {
+		fake.doBatchReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.doBatchReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeEndpoint) Done() <-chan struct{} {
+	fake.doneMutex.Lock()
+	ret, specificReturn := fake.doneReturnsOnCall[len(fake.doneArgsForCall)]
+	fake.doneArgsForCall = append(fake.doneArgsForCall, struct {
+	}{})
+	stub := fake.DoneStub
+	fakeReturns := fake.doneReturns
+	fake.recordInvocation("Done", []interface{}{})
+	fake.doneMutex.Unlock()
+	if stub != nil {
+		return stub()
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeEndpoint) DoneCallCount() int {
+	fake.doneMutex.RLock()
+	defer fake.doneMutex.RUnlock()
+	return len(fake.doneArgsForCall)
+}
+
+func (fake *FakeEndpoint) DoneCalls(stub func() <-chan struct{}) {
+	fake.doneMutex.Lock()
+	defer fake.doneMutex.Unlock()
+	fake.DoneStub = stub
+}
+
+func (fake *FakeEndpoint) DoneReturns(result1 <-chan struct{}) {
+	fake.doneMutex.Lock()
+	defer fake.doneMutex.Unlock()
+	fake.DoneStub = nil
+	fake.doneReturns = struct {
+		result1 <-chan struct{}
+	}{result1}
+}
+
+func (fake *FakeEndpoint) DoneReturnsOnCall(i int, result1 <-chan struct{}) {
+	fake.doneMutex.Lock()
+	defer fake.doneMutex.Unlock()
+	fake.DoneStub = nil
+	if fake.doneReturnsOnCall == nil {
+		fake.doneReturnsOnCall = make(map[int]struct {
+			result1 <-chan struct{}
+		})
+	}
+	fake.doneReturnsOnCall[i] = struct {
+		result1 <-chan struct{}
+	}{result1}
+}
+
 func (fake *FakeEndpoint) Duplex(arg1 context.Context, arg2 RequestEncoding, arg3 Method, arg4 ...interface{}) (*ByteSource, *ByteSink, error) {
 	fake.duplexMutex.Lock()
 	ret, specificReturn := fake.duplexReturnsOnCall[len(fake.duplexArgsForCall)]
@@ -232,6 +520,173 @@ func (fake *FakeEndpoint) DuplexReturnsOnCall(i int, result1 *ByteSource, result
 	}{result1, result2, result3}
 }
 
+func (fake *FakeEndpoint) Err() error {
+	fake.errMutex.Lock()
+	ret, specificReturn := fake.errReturnsOnCall[len(fake.errArgsForCall)]
+	fake.errArgsForCall = append(fake.errArgsForCall, struct {
+	}{})
+	stub := fake.ErrStub
+	fakeReturns := fake.errReturns
+	fake.recordInvocation("Err", []interface{}{})
+	fake.errMutex.Unlock()
+	if stub != nil {
+		return stub()
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeEndpoint) ErrCallCount() int {
+	fake.errMutex.RLock()
+	defer fake.errMutex.RUnlock()
+	return len(fake.errArgsForCall)
+}
+
+func (fake *FakeEndpoint) ErrCalls(stub func() error) {
+	fake.errMutex.Lock()
+	defer fake.errMutex.Unlock()
+	fake.ErrStub = stub
+}
+
+func (fake *FakeEndpoint) ErrReturns(result1 error) {
+	fake.errMutex.Lock()
+	defer fake.errMutex.Unlock()
+	fake.ErrStub = nil
+	fake.errReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeEndpoint) ErrReturnsOnCall(i int, result1 error) {
+	fake.errMutex.Lock()
+	defer fake.errMutex.Unlock()
+	fake.ErrStub = nil
+	if fake.errReturnsOnCall == nil {
+		fake.errReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.errReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeEndpoint) Flush(arg1 context.Context) error {
+	fake.flushMutex.Lock()
+	ret, specificReturn := fake.flushReturnsOnCall[len(fake.flushArgsForCall)]
+	fake.flushArgsForCall = append(fake.flushArgsForCall, struct {
+		arg1 context.Context
+	}{arg1})
+	stub := fake.FlushStub
+	fakeReturns := fake.flushReturns
+	fake.recordInvocation("Flush", []interface{}{arg1})
+	fake.flushMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeEndpoint) FlushCallCount() int {
+	fake.flushMutex.RLock()
+	defer fake.flushMutex.RUnlock()
+	return len(fake.flushArgsForCall)
+}
+
+func (fake *FakeEndpoint) FlushCalls(stub func(context.Context) error) {
+	fake.flushMutex.Lock()
+	defer fake.flushMutex.Unlock()
+	fake.FlushStub = stub
+}
+
+func (fake *FakeEndpoint) FlushArgsForCall(i int) context.Context {
+	fake.flushMutex.RLock()
+	defer fake.flushMutex.RUnlock()
+	argsForCall := fake.flushArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeEndpoint) FlushReturns(result1 error) {
+	fake.flushMutex.Lock()
+	defer fake.flushMutex.Unlock()
+	fake.FlushStub = nil
+	fake.flushReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeEndpoint) FlushReturnsOnCall(i int, result1 error) {
+	fake.flushMutex.Lock()
+	defer fake.flushMutex.Unlock()
+	fake.FlushStub = nil
+	if fake.flushReturnsOnCall == nil {
+		fake.flushReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.flushReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeEndpoint) Local() net.Addr {
+	fake.localMutex.Lock()
+	ret, specificReturn := fake.localReturnsOnCall[len(fake.localArgsForCall)]
+	fake.localArgsForCall = append(fake.localArgsForCall, struct {
+	}{})
+	stub := fake.LocalStub
+	fakeReturns := fake.localReturns
+	fake.recordInvocation("Local", []interface{}{})
+	fake.localMutex.Unlock()
+	if stub != nil {
+		return stub()
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeEndpoint) LocalCallCount() int {
+	fake.localMutex.RLock()
+	defer fake.localMutex.RUnlock()
+	return len(fake.localArgsForCall)
+}
+
+func (fake *FakeEndpoint) LocalCalls(stub func() net.Addr) {
+	fake.localMutex.Lock()
+	defer fake.localMutex.Unlock()
+	fake.LocalStub = stub
+}
+
+func (fake *FakeEndpoint) LocalReturns(result1 net.Addr) {
+	fake.localMutex.Lock()
+	defer fake.localMutex.Unlock()
+	fake.LocalStub = nil
+	fake.localReturns = struct {
+		result1 net.Addr
+	}{result1}
+}
+
+func (fake *FakeEndpoint) LocalReturnsOnCall(i int, result1 net.Addr) {
+	fake.localMutex.Lock()
+	defer fake.localMutex.Unlock()
+	fake.LocalStub = nil
+	if fake.localReturnsOnCall == nil {
+		fake.localReturnsOnCall = make(map[int]struct {
+			result1 net.Addr
+		})
+	}
+	fake.localReturnsOnCall[i] = struct {
+		result1 net.Addr
+	}{result1}
+}
+
 func (fake *FakeEndpoint) Remote() net.Addr {
 	fake.remoteMutex.Lock()
 	ret, specificReturn := fake.remoteReturnsOnCall[len(fake.remoteArgsForCall)]
@@ -285,6 +740,62 @@ func (fake *FakeEndpoint) RemoteReturnsOnCall(i int, result1 net.Addr) {
 	}{result1}
 }
 
+func (fake *FakeEndpoint) RemoteKey() (ed25519.PublicKey, bool) {
+	fake.remoteKeyMutex.Lock()
+	ret, specificReturn := fake.remoteKeyReturnsOnCall[len(fake.remoteKeyArgsForCall)]
+	fake.remoteKeyArgsForCall = append(fake.remoteKeyArgsForCall, struct {
+	}{})
+	stub := fake.RemoteKeyStub
+	fakeReturns := fake.remoteKeyReturns
+	fake.recordInvocation("RemoteKey", []interface{}{})
+	fake.remoteKeyMutex.Unlock()
+	if stub != nil {
+		return stub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeEndpoint) RemoteKeyCallCount() int {
+	fake.remoteKeyMutex.RLock()
+	defer fake.remoteKeyMutex.RUnlock()
+	return len(fake.remoteKeyArgsForCall)
+}
+
+func (fake *FakeEndpoint) RemoteKeyCalls(stub func() (ed25519.PublicKey, bool)) {
+	fake.remoteKeyMutex.Lock()
+	defer fake.remoteKeyMutex.Unlock()
+	fake.RemoteKeyStub = stub
+}
+
+func (fake *FakeEndpoint) RemoteKeyReturns(result1 ed25519.PublicKey, result2 bool) {
+	fake.remoteKeyMutex.Lock()
+	defer fake.remoteKeyMutex.Unlock()
+	fake.RemoteKeyStub = nil
+	fake.remoteKeyReturns = struct {
+		result1 ed25519.PublicKey
+		result2 bool
+	}{result1, result2}
+}
+
+func (fake *FakeEndpoint) RemoteKeyReturnsOnCall(i int, result1 ed25519.PublicKey, result2 bool) {
+	fake.remoteKeyMutex.Lock()
+	defer fake.remoteKeyMutex.Unlock()
+	fake.RemoteKeyStub = nil
+	if fake.remoteKeyReturnsOnCall == nil {
+		fake.remoteKeyReturnsOnCall = make(map[int]struct {
+			result1 ed25519.PublicKey
+			result2 bool
+		})
+	}
+	fake.remoteKeyReturnsOnCall[i] = struct {
+		result1 ed25519.PublicKey
+		result2 bool
+	}{result1, result2}
+}
+
 func (fake *FakeEndpoint) Sink(arg1 context.Context, arg2 RequestEncoding, arg3 Method, arg4 ...interface{}) (*ByteSink, error) {
 	fake.sinkMutex.Lock()
 	ret, specificReturn := fake.sinkReturnsOnCall[len(fake.sinkArgsForCall)]
@@ -352,6 +863,72 @@ func (fake *FakeEndpoint) SinkReturnsOnCall(i int, result1 *ByteSink, result2 er
 	}{result1, result2}
 }
 
+func (fake *FakeEndpoint) SinkBytes(arg1 context.Context, arg2 Method, arg3 ...interface{}) (*ByteSink, error) {
+	fake.sinkBytesMutex.Lock()
+	ret, specificReturn := fake.sinkBytesReturnsOnCall[len(fake.sinkBytesArgsForCall)]
+	fake.sinkBytesArgsForCall = append(fake.sinkBytesArgsForCall, struct {
+		arg1 context.Context
+		arg2 Method
+		arg3 []interface{}
+	}{arg1, arg2, arg3})
+	stub := fake.SinkBytesStub
+	fakeReturns := fake.sinkBytesReturns
+	fake.recordInvocation("SinkBytes", []interface{}{arg1, arg2, arg3})
+	fake.sinkBytesMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3...)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeEndpoint) SinkBytesCallCount() int {
+	fake.sinkBytesMutex.RLock()
+	defer fake.sinkBytesMutex.RUnlock()
+	return len(fake.sinkBytesArgsForCall)
+}
+
+func (fake *FakeEndpoint) SinkBytesCalls(stub func(context.Context, Method, ...interface{}) (*ByteSink, error)) {
+	fake.sinkBytesMutex.Lock()
+	defer fake.sinkBytesMutex.Unlock()
+	fake.SinkBytesStub = stub
+}
+
+func (fake *FakeEndpoint) SinkBytesArgsForCall(i int) (context.Context, Method, []interface{}) {
+	fake.sinkBytesMutex.RLock()
+	defer fake.sinkBytesMutex.RUnlock()
+	argsForCall := fake.sinkBytesArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeEndpoint) SinkBytesReturns(result1 *ByteSink, result2 error) {
+	fake.sinkBytesMutex.Lock()
+	defer fake.sinkBytesMutex.Unlock()
+	fake.SinkBytesStub = nil
+	fake.sinkBytesReturns = struct {
+		result1 *ByteSink
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeEndpoint) SinkBytesReturnsOnCall(i int, result1 *ByteSink, result2 error) {
+	fake.sinkBytesMutex.Lock()
+	defer fake.sinkBytesMutex.Unlock()
+	fake.SinkBytesStub = nil
+	if fake.sinkBytesReturnsOnCall == nil {
+		fake.sinkBytesReturnsOnCall = make(map[int]struct {
+			result1 *ByteSink
+			result2 error
+		})
+	}
+	fake.sinkBytesReturnsOnCall[i] = struct {
+		result1 *ByteSink
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeEndpoint) Source(arg1 context.Context, arg2 RequestEncoding, arg3 Method, arg4 ...interface{}) (*ByteSource, error) {
 	fake.sourceMutex.Lock()
 	ret, specificReturn := fake.sourceReturnsOnCall[len(fake.sourceArgsForCall)]
@@ -472,21 +1049,101 @@ func (fake *FakeEndpoint) TerminateReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
+func (fake *FakeEndpoint) TerminateWithError(arg1 context.Context, arg2 error) error {
+	fake.terminateWithErrorMutex.Lock()
+	ret, specificReturn := fake.terminateWithErrorReturnsOnCall[len(fake.terminateWithErrorArgsForCall)]
+	fake.terminateWithErrorArgsForCall = append(fake.terminateWithErrorArgsForCall, struct {
+		arg1 context.Context
+		arg2 error
+	}{arg1, arg2})
+	stub := fake.TerminateWithErrorStub
+	fakeReturns := fake.terminateWithErrorReturns
+	fake.recordInvocation("TerminateWithError", []interface{}{arg1, arg2})
+	fake.terminateWithErrorMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeEndpoint) TerminateWithErrorCallCount() int {
+	fake.terminateWithErrorMutex.RLock()
+	defer fake.terminateWithErrorMutex.RUnlock()
+	return len(fake.terminateWithErrorArgsForCall)
+}
+
+func (fake *FakeEndpoint) TerminateWithErrorCalls(stub func(context.Context, error) error) {
+	fake.terminateWithErrorMutex.Lock()
+	defer fake.terminateWithErrorMutex.Unlock()
+	fake.TerminateWithErrorStub = stub
+}
+
+func (fake *FakeEndpoint) TerminateWithErrorArgsForCall(i int) (context.Context, error) {
+	fake.terminateWithErrorMutex.RLock()
+	defer fake.terminateWithErrorMutex.RUnlock()
+	argsForCall := fake.terminateWithErrorArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeEndpoint) TerminateWithErrorReturns(result1 error) {
+	fake.terminateWithErrorMutex.Lock()
+	defer fake.terminateWithErrorMutex.Unlock()
+	fake.TerminateWithErrorStub = nil
+	fake.terminateWithErrorReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeEndpoint) TerminateWithErrorReturnsOnCall(i int, result1 error) {
+	fake.terminateWithErrorMutex.Lock()
+	defer fake.terminateWithErrorMutex.Unlock()
+	fake.TerminateWithErrorStub = nil
+	if fake.terminateWithErrorReturnsOnCall == nil {
+		fake.terminateWithErrorReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.terminateWithErrorReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeEndpoint) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
 	fake.asyncMutex.RLock()
 	defer fake.asyncMutex.RUnlock()
+	fake.asyncObjMutex.RLock()
+	defer fake.asyncObjMutex.RUnlock()
+	fake.doBatchMutex.RLock()
+	defer fake.doBatchMutex.RUnlock()
+	fake.doneMutex.RLock()
+	defer fake.doneMutex.RUnlock()
 	fake.duplexMutex.RLock()
 	defer fake.duplexMutex.RUnlock()
+	fake.errMutex.RLock()
+	defer fake.errMutex.RUnlock()
+	fake.flushMutex.RLock()
+	defer fake.flushMutex.RUnlock()
+	fake.localMutex.RLock()
+	defer fake.localMutex.RUnlock()
 	fake.remoteMutex.RLock()
 	defer fake.remoteMutex.RUnlock()
+	fake.remoteKeyMutex.RLock()
+	defer fake.remoteKeyMutex.RUnlock()
 	fake.sinkMutex.RLock()
 	defer fake.sinkMutex.RUnlock()
+	fake.sinkBytesMutex.RLock()
+	defer fake.sinkBytesMutex.RUnlock()
 	fake.sourceMutex.RLock()
 	defer fake.sourceMutex.RUnlock()
 	fake.terminateMutex.RLock()
 	defer fake.terminateMutex.RUnlock()
+	fake.terminateWithErrorMutex.RLock()
+	defer fake.terminateWithErrorMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
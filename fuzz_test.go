@@ -0,0 +1,45 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"go.mindeco.de/log"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+)
+
+// fuzzConn reads the fuzz input and drops everything that is written to it
+type fuzzConn struct{ *bytes.Reader }
+
+func (fuzzConn) Write(b []byte) (int, error) { return ioutil.Discard.Write(b) }
+func (fuzzConn) Close() error                { return nil }
+
+// FuzzRequest decodes its input as the body of a new call, like the serve loop does for a packet from the remote.
+// The regression inputs are in testdata/fuzz/FuzzRequest, run it with go test -fuzz FuzzRequest.
+func FuzzRequest(f *testing.F) {
+	f.Add([]byte(`{"name":["whoami"],"args":[],"type":"async"}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := &rpc{
+			pkr:    NewPacker(fuzzConn{bytes.NewReader(data)}),
+			json:   StdJSON,
+			logger: log.NewNopLogger(),
+			clock:  SystemClock,
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		hdr := codec.Header{
+			Flag: codec.FlagJSON,
+			Len:  uint32(len(data)),
+			Req:  -1,
+		}
+		r.parseNewRequest(&hdr, ctx)
+	})
+}
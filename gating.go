@@ -0,0 +1,45 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+// WithManifestGating checks our calls strictly against the manifest of the remote.
+// Calls to methods it doesn't list or with a different call type fail right away with ErrMethodUnsupported,
+// and so do all calls if the remote didn't send a manifest.
+// This avoids waiting for remotes that never answer calls to methods they don't know.
+// Without it, only calls to methods missing from a received manifest fail early, with ErrNoSuchMethod.
+func WithManifestGating() HandleOption {
+	return func(r *rpc) {
+		r.manifestGating = true
+	}
+}
+
+// checkCall decides if a call can be made, based on the manifest of the remote
+func (r *rpc) checkCall(method Method, typ CallType) error {
+	if isExtensionsMethod(method) || isHealthMethod(method) {
+		return nil // answered by the endpoint itself and not listed in manifests
+	}
+	if r.manifestGating {
+		return r.checkAdvertised(method, typ)
+	}
+	if _, ok := r.manifest.Handled(method); !ok {
+		return ErrNoSuchMethod{Method: method}
+	}
+	return nil
+}
+
+// checkAdvertised only lets calls through that the manifest of the remote lists with a matching type
+func (r *rpc) checkAdvertised(method Method, typ CallType) error {
+	advertised, ok := r.manifest.Handled(method)
+	if !ok || advertised == "" || !typeMatches(typ, advertised) {
+		return ErrMethodUnsupported{Method: method, Type: typ, Advertised: advertised}
+	}
+	return nil
+}
+
+// typeMatches compares the type of a call with the one from a manifest, where async calls can also be called sync
+func typeMatches(typ CallType, advertised string) bool {
+	if typ == "async" {
+		return advertised == "async" || advertised == "sync"
+	}
+	return string(typ) == advertised
+}
@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestGating(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		manifest string // empty means the remote doesn't send one
+
+		method     string
+		advertised string // of the expected ErrMethodUnsupported, "ok" if the call should go through
+	}{
+		{"listed", `{"hello":"async","feed":"source"}`, "hello", "ok"},
+		{"unlisted", `{"hello":"async"}`, "nope", ""},
+		{"wrong type", `{"hello":"async","feed":"source"}`, "feed", "source"},
+		{"no manifest", ``, "hello", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+
+			var fh1 FakeHandler
+
+			var fh2 FakeHandler
+			fh2.HandledReturns(true)
+			fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+				switch req.Method.String() {
+				case "manifest":
+					if tc.manifest == "" {
+						req.CloseWithError(fmt.Errorf("no manifest here"))
+						return
+					}
+					req.Return(ctx, json.RawMessage(tc.manifest))
+				default:
+					req.Return(ctx, "world")
+				}
+			})
+
+			c1, c2 := loPipe(t)
+			var rpc1 Endpoint
+			handled := make(chan struct{})
+			go func() {
+				rpc1 = Handle(NewPacker(c1), &fh1, WithManifestGating())
+				close(handled)
+			}()
+			rpc2 := Handle(NewPacker(c2), &fh2)
+			<-handled
+			go rpc1.(Server).Serve()
+			go rpc2.(Server).Serve()
+			defer rpc1.Terminate()
+			defer rpc2.Terminate()
+
+			var ret string
+			err := rpc1.Async(context.Background(), &ret, TypeString, Method{tc.method})
+			if tc.advertised == "ok" {
+				r.NoError(err)
+				r.Equal("world", ret)
+				return
+			}
+
+			var unsupported ErrMethodUnsupported
+			r.True(errors.As(err, &unsupported), "unexpected error: %v", err)
+			r.Equal(tc.method, unsupported.Method.String())
+			r.Equal(tc.advertised, unsupported.Advertised)
+
+			// nothing was sent for it, only the manifest call reached the remote
+			r.Equal(1, fh2.HandleCallCallCount())
+		})
+	}
+}
+
+func TestAsyncTypeAuto(t *testing.T) {
+	r := require.New(t)
+
+	var fh1 FakeHandler
+
+	var fh2 FakeHandler
+	fh2.HandledReturns(true)
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		switch req.Method.String() {
+		case "manifest":
+			req.Return(ctx, json.RawMessage(`{"whoami":"sync","info":"async","name":"async","feed":"source"}`))
+		case "whoami":
+			req.Return(ctx, "server")
+		case "info":
+			req.Return(ctx, map[string]int{"peers": 3})
+		case "name":
+			req.Return(ctx, json.RawMessage(`"bob"`))
+		}
+	})
+
+	c1, c2 := loPipe(t)
+	var rpc1 Endpoint
+	handled := make(chan struct{})
+	go func() {
+		rpc1 = Handle(NewPacker(c1), &fh1)
+		close(handled)
+	}()
+	rpc2 := Handle(NewPacker(c2), &fh2)
+	<-handled
+	go rpc1.(Server).Serve()
+	go rpc2.(Server).Serve()
+	defer rpc1.Terminate()
+	defer rpc2.Terminate()
+
+	ctx := context.Background()
+
+	var who string
+	r.NoError(rpc1.Async(ctx, &who, TypeAuto, Method{"whoami"}))
+	r.Equal("server", who)
+
+	var info map[string]int
+	r.NoError(rpc1.Async(ctx, &info, TypeAuto, Method{"info"}))
+	r.Equal(3, info["peers"])
+
+	// JSON strings are decoded
+	var name string
+	r.NoError(rpc1.Async(ctx, &name, TypeAuto, Method{"name"}))
+	r.Equal("bob", name)
+
+	// string replies can't go into other types
+	err := rpc1.Async(ctx, &info, TypeAuto, Method{"whoami"})
+	r.Error(err)
+
+	// only methods the manifest lists as async or sync
+	var unsupported ErrMethodUnsupported
+	err = rpc1.Async(ctx, &info, TypeAuto, Method{"feed"})
+	r.True(errors.As(err, &unsupported), "unexpected error: %v", err)
+	r.Equal("source", unsupported.Advertised)
+
+	err = rpc1.Async(ctx, &info, TypeAuto, Method{"nope"})
+	r.True(errors.As(err, &unsupported), "unexpected error: %v", err)
+
+	// other calls don't know TypeAuto
+	_, err = rpc1.Source(ctx, TypeAuto, Method{"feed"})
+	r.Error(err)
+}
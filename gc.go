@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+	"go.mindeco.de/log/level"
+)
+
+// ErrRequestExpired ends requests that had no activity for longer than allowed by WithRequestTTL.
+var ErrRequestExpired = errors.New("muxrpc: request expired")
+
+// WithRequestTTL closes and forgets requests on which nothing was sent or received for ttl,
+// in both directions. It cleans up after buggy peers that never answer or close what they started.
+// It also stops waiting for the remote to confirm the end of streams we closed ttl ago, see ErrCloseUnacknowledged.
+// Requests marked as live are left alone, see MarkLive and WithLiveCall.
+// The requests are checked every ttl/2, so one can stay up to one and a half ttl. Zero disables it.
+func WithRequestTTL(ttl time.Duration) HandleOption {
+	return func(r *rpc) {
+		r.requestTTL = ttl
+	}
+}
+
+type liveCallContextKey struct{}
+
+// WithLiveCall marks the calls started with ctx as live, like a source that only sends new messages as they arrive.
+// WithRequestTTL doesn't expire them, no matter how quiet they are.
+func WithLiveCall(ctx context.Context) context.Context {
+	return context.WithValue(ctx, liveCallContextKey{}, true)
+}
+
+// markLiveCall marks req as live if it's started with a context from WithLiveCall
+func markLiveCall(ctx context.Context, req *Request) {
+	if live, _ := ctx.Value(liveCallContextKey{}).(bool); live {
+		req.MarkLive()
+	}
+}
+
+// lastActivity returns when data was last sent or received on req, or when it started if there was none
+func (req *Request) lastActivity() time.Time {
+	last := atomic.LoadInt64(&req.state.lastReceived)
+	if w := atomic.LoadInt64(&req.sink.lastWrite); w > last {
+		last = w
+	}
+	if last == 0 {
+		return req.started
+	}
+	return time.Unix(0, last)
+}
+
+// sweepRequests expires stale requests until the session ends
+func (r *rpc) sweepRequests() {
+	for {
+		tick, t := after(r.clock, r.requestTTL/2)
+		select {
+		case <-tick:
+		case <-r.serveCtx.Done():
+			t.Stop()
+			return
+		}
+		r.expireStale()
+	}
+}
+
+func (r *rpc) expireStale() {
+	now := r.clock.Now()
+	var stale []*Request
+	r.rLock.RLock()
+	for _, req := range r.reqs {
+		if !req.IsLive() && now.Sub(req.lastActivity()) >= r.requestTTL {
+			stale = append(stale, req)
+		}
+	}
+	r.rLock.RUnlock()
+
+	r.rLock.Lock()
+	for _, req := range r.reqsUnacked {
+		if now.Sub(req.state.unackedSince) >= r.requestTTL {
+			r.giveUpAck(req)
+		}
+	}
+	r.rLock.Unlock()
+
+	for _, req := range stale {
+		if !req.Type.Flags().Get(codec.FlagStream) && !req.markReplied() {
+			continue // the handler replied in the meantime
+		}
+		level.Warn(r.logger).Log("event", "request expired", "req", req.id, "trace", req.trace, "method", req.Method.String())
+		r.closeStream(req, fmt.Errorf("muxrpc: %s had no activity for %s: %w", req.Method, r.requestTTL, ErrRequestExpired))
+	}
+}
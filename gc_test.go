@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+)
+
+func TestRequestTTL(t *testing.T) {
+	r := require.New(t)
+
+	gone := make(chan struct{})
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("stale"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		// open the stream but never send or close it
+		<-req.ConsumerGone()
+		close(gone)
+	})
+
+	rpc1, rpc2 := connectedPair(t, &fh1, &fh2, WithRequestTTL(100*time.Millisecond))
+
+	ctx := context.Background()
+	src, err := rpc1.Source(ctx, TypeString, Method{"stale"})
+	r.NoError(err)
+
+	r.False(src.Next(ctx))
+	// either side can notice first
+	r.Error(src.Err())
+	r.Contains(src.Err().Error(), "request expired")
+
+	select {
+	case <-gone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler wasn't told that the stream ended")
+	}
+
+	for _, edp := range []Endpoint{rpc1, rpc2} {
+		r.Eventually(func() bool {
+			n, _ := OpenRequests(edp)
+			return n == 0
+		}, time.Second, 10*time.Millisecond, "expired request wasn't forgotten")
+	}
+}
+
+func TestRequestTTLLive(t *testing.T) {
+	r := require.New(t)
+
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("live"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		req.MarkLive()
+		snk, err := req.ResponseSink()
+		if err != nil {
+			return
+		}
+		// quiet for longer than the ttl, then one message
+		time.Sleep(300 * time.Millisecond)
+		fmt.Fprint(snk, "news")
+		snk.Close()
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2, WithRequestTTL(100*time.Millisecond))
+
+	ctx := context.Background()
+	src, err := rpc1.Source(WithLiveCall(ctx), TypeString, Method{"live"})
+	r.NoError(err)
+
+	r.True(src.Next(ctx), "live stream expired: %v", src.Err())
+	msg, err := src.Bytes()
+	r.NoError(err)
+	r.Equal("news", string(msg))
+	r.False(src.Next(ctx))
+	r.NoError(src.Err())
+}
+
+// unackedCount returns how many of our closed streams wait for the remote to confirm their end
+func unackedCount(edp Endpoint) int {
+	rpc := edp.(*rpc)
+	rpc.rLock.RLock()
+	defer rpc.rLock.RUnlock()
+	return len(rpc.reqsUnacked)
+}
+
+func TestRequestTTLUnacked(t *testing.T) {
+	r := require.New(t)
+
+	edp, rd, _ := rawPeer(t, &FakeHandler{}, WithRequestTTL(100*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := edp.Source(ctx, TypeJSON, Method{"things"})
+	r.NoError(err)
+	call, err := rd.ReadPacket()
+	r.NoError(err)
+
+	rpc := edp.(*rpc)
+	rpc.rLock.RLock()
+	req := rpc.reqs[call.Req]
+	rpc.rLock.RUnlock()
+	r.NotNil(req)
+
+	// we end the stream, the remote never confirms it
+	cancel()
+	end, err := rd.ReadPacket()
+	r.NoError(err)
+	r.True(end.Flag.Get(codec.FlagEndErr))
+	r.Equal(1, unackedCount(edp))
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer waitCancel()
+	err = req.sink.AwaitRemoteClose(waitCtx)
+	r.True(errors.Is(err, ErrCloseUnacknowledged), "unexpected error: %v", err)
+	r.Equal(0, unackedCount(edp))
+}
+
+func TestUnackedBound(t *testing.T) {
+	r := require.New(t)
+
+	edp, rd, _ := rawPeer(t, &FakeHandler{})
+	go func() {
+		for {
+			if _, err := rd.ReadPacket(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ctx := context.Background()
+	before, _ := OpenRequests(edp)
+	const streams = maxUnacked + 10
+	for i := 0; i < streams; i++ {
+		callCtx, cancel := context.WithCancel(ctx)
+		_, err := edp.Source(callCtx, TypeJSON, Method{"things"})
+		r.NoError(err)
+		cancel()
+	}
+
+	// the peer never confirms the ends, only the newest ones are kept
+	r.Eventually(func() bool {
+		n, _ := OpenRequests(edp)
+		return n == before
+	}, 5*time.Second, 10*time.Millisecond)
+	r.Equal(maxUnacked, unackedCount(edp))
+}
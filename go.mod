@@ -1,15 +1,25 @@
 module go.cryptoscope.co/muxrpc/v2
 
-go 1.15
+go 1.18
 
 require (
 	github.com/dustin/go-humanize v1.0.0
-	github.com/hashicorp/errwrap v1.1.0 // indirect
 	github.com/hashicorp/go-multierror v1.1.0
 	github.com/karrick/bufpool v1.2.0
-	github.com/karrick/gopool v1.2.2 // indirect
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.4.0
 	go.cryptoscope.co/luigi v0.3.5
 	go.mindeco.de v1.12.0
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/go-logfmt/logfmt v0.4.0 // indirect
+	github.com/go-stack/stack v1.8.0 // indirect
+	github.com/hashicorp/errwrap v1.1.0 // indirect
+	github.com/karrick/gopool v1.2.2 // indirect
+	github.com/kr/logfmt v0.0.0-20140226030751-b84e30acd515 // indirect
+	github.com/oxtoacart/bpool v0.0.0-20190524125616-8c0b41497736 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
+)
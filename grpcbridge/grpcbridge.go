@@ -0,0 +1,244 @@
+// SPDX-License-Identifier: MIT
+
+// Package grpcbridge exposes muxrpc methods as gRPC methods, so that SSB peers can be reached from gRPC based infrastructure.
+//
+// Each registered gRPC method is forwarded to a muxrpc method on a target endpoint:
+// unary calls become async calls, server streams become sources, client streams become sinks and bidi streams become duplex calls.
+//
+// The package doesn't depend on grpc itself. Messages are raw frames, handled by Codec, and calls are served from an unknown service handler:
+//
+//	b := grpcbridge.New(edp)
+//	b.Register("/ssb.Whoami/Get", muxrpc.Method{"whoami"}, "async")
+//
+//	srv := grpc.NewServer(
+//		grpc.ForceServerCodec(grpcbridge.Codec{}),
+//		grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+//			name, _ := grpc.MethodFromServerStream(stream)
+//			return b.Handle(name, stream)
+//		}),
+//	)
+//
+// The first message of every call holds the arguments as a JSON array, an empty message means no arguments.
+// The messages after it are the frames of the muxrpc stream, passed on unchanged in both directions.
+// Client streams get a single empty message as their reply once the target confirmed the end of the sink.
+// Most muxrpc handlers stop answering a duplex call once its input ended, so bidi clients should read the replies before closing their side.
+package grpcbridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"go.cryptoscope.co/muxrpc/v2"
+)
+
+// ServerStream is the part of grpc.ServerStream the bridge needs
+type ServerStream interface {
+	Context() context.Context
+	SendMsg(m interface{}) error
+	RecvMsg(m interface{}) error
+}
+
+// Frame is the message type of bridged calls. It holds a muxrpc frame or the arguments of a call.
+type Frame []byte
+
+// Codec is a gRPC codec (it implements encoding.Codec from grpc) that passes Frames through unchanged
+type Codec struct{}
+
+// Name returns the content-subtype of the codec
+func (Codec) Name() string { return "muxrpc-frame" }
+
+// Marshal returns the bytes of a Frame or *Frame
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	switch f := v.(type) {
+	case Frame:
+		return f, nil
+	case *Frame:
+		return *f, nil
+	case []byte:
+		return f, nil
+	default:
+		return nil, fmt.Errorf("muxrpc/grpcbridge: can't marshal %T", v)
+	}
+}
+
+// Unmarshal copies data into a *Frame
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	f, ok := v.(*Frame)
+	if !ok {
+		return fmt.Errorf("muxrpc/grpcbridge: can't unmarshal into %T", v)
+	}
+	*f = append((*f)[:0], data...)
+	return nil
+}
+
+// ErrUnknownMethod is returned by Handle for gRPC methods that aren't registered
+var ErrUnknownMethod = errors.New("muxrpc/grpcbridge: unknown method")
+
+type route struct {
+	method muxrpc.Method
+	typ    muxrpc.CallType
+}
+
+// Bridge maps gRPC methods to muxrpc methods of a target endpoint
+type Bridge struct {
+	target muxrpc.Endpoint
+
+	mu     sync.RWMutex
+	routes map[string]route
+}
+
+// New returns a Bridge without methods that forwards calls to target
+func New(target muxrpc.Endpoint) *Bridge {
+	return &Bridge{
+		target: target,
+		routes: make(map[string]route),
+	}
+}
+
+// Register forwards calls to the gRPC method fullMethod ("/package.Service/Method") to the muxrpc method m.
+// typ decides the kind of the gRPC method, it is one of async (or sync), source, sink and duplex.
+func (b *Bridge) Register(fullMethod string, m muxrpc.Method, typ muxrpc.CallType) error {
+	switch typ {
+	case "async", "sync", "source", "sink", "duplex":
+	default:
+		return fmt.Errorf("muxrpc/grpcbridge: unsupported call type %q for %s", typ, m)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.routes[fullMethod] = route{method: m, typ: typ}
+	return nil
+}
+
+// RegisterManifest registers all methods of a muxrpc manifest, given as dotted method names and their call types,
+// as methods of the gRPC service with the name service. A method like blobs.get becomes /service/blobs.get.
+// Methods with other call types are skipped.
+func (b *Bridge) RegisterManifest(service string, manifest map[string]string) {
+	for name, typ := range manifest {
+		b.Register("/"+service+"/"+name, strings.Split(name, "."), muxrpc.CallType(typ))
+	}
+}
+
+// Methods returns the registered gRPC methods and the call types they are forwarded with
+func (b *Bridge) Methods() map[string]muxrpc.CallType {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	ms := make(map[string]muxrpc.CallType, len(b.routes))
+	for name, r := range b.routes {
+		ms[name] = r.typ
+	}
+	return ms
+}
+
+// Handle serves a call to the gRPC method fullMethod on stream by forwarding it to the target.
+// It returns when the call is done. Errors of the muxrpc call are returned as they are, gRPC reports them as Unknown.
+func (b *Bridge) Handle(fullMethod string, stream ServerStream) error {
+	b.mu.RLock()
+	r, ok := b.routes[fullMethod]
+	b.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownMethod, fullMethod)
+	}
+
+	var first Frame
+	if err := stream.RecvMsg(&first); err != nil {
+		return fmt.Errorf("muxrpc/grpcbridge: failed to receive arguments: %w", err)
+	}
+	args, err := parseArgs(first)
+	if err != nil {
+		return err
+	}
+
+	ctx := stream.Context()
+	switch r.typ {
+	case "async", "sync":
+		var reply muxrpc.RawReply
+		if err := b.target.Async(ctx, &reply, muxrpc.TypeJSON, r.method, args...); err != nil {
+			return err
+		}
+		return stream.SendMsg(Frame(reply.Body))
+
+	case "source":
+		src, err := b.target.Source(ctx, muxrpc.TypeJSON, r.method, args...)
+		if err != nil {
+			return err
+		}
+		return sendFrames(ctx, src, stream)
+
+	case "sink":
+		snk, err := b.target.Sink(ctx, muxrpc.TypeJSON, r.method, args...)
+		if err != nil {
+			return err
+		}
+		if err := recvFrames(stream, snk); err != nil {
+			return err
+		}
+		if err := snk.AwaitRemoteClose(ctx); err != nil {
+			return err
+		}
+		return stream.SendMsg(Frame{})
+
+	case "duplex":
+		src, snk, err := b.target.Duplex(ctx, muxrpc.TypeJSON, r.method, args...)
+		if err != nil {
+			return err
+		}
+		go recvFrames(stream, snk)
+		return sendFrames(ctx, src, stream)
+	}
+	return fmt.Errorf("muxrpc/grpcbridge: unsupported call type %q", r.typ)
+}
+
+// parseArgs splits the JSON array of arguments in the first message of a call
+func parseArgs(first Frame) ([]interface{}, error) {
+	if len(first) == 0 {
+		return nil, nil
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(first, &raw); err != nil {
+		return nil, fmt.Errorf("muxrpc/grpcbridge: arguments are not a JSON array: %w", err)
+	}
+	args := make([]interface{}, len(raw))
+	for i, a := range raw {
+		args[i] = a
+	}
+	return args, nil
+}
+
+// sendFrames sends the frames of src as messages until it ends
+func sendFrames(ctx context.Context, src *muxrpc.ByteSource, stream ServerStream) error {
+	for src.Next(ctx) {
+		frame, err := src.Bytes()
+		if err != nil {
+			return err
+		}
+		if err := stream.SendMsg(Frame(frame)); err != nil {
+			src.Cancel(err)
+			return err
+		}
+	}
+	return src.Err()
+}
+
+// recvFrames writes the messages of stream to snk until the client closes its side, then closes snk
+func recvFrames(stream ServerStream, snk *muxrpc.ByteSink) error {
+	for {
+		var f Frame
+		err := stream.RecvMsg(&f)
+		if errors.Is(err, io.EOF) {
+			return snk.Close()
+		}
+		if err != nil {
+			snk.CloseWithError(err)
+			return err
+		}
+		if _, err := snk.Write(f); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: MIT
+
+package grpcbridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2"
+	"go.cryptoscope.co/muxrpc/v2/muxtest"
+)
+
+// fakeStream plays the gRPC client: it hands out the queued messages and collects what the bridge sends
+type fakeStream struct {
+	ctx  context.Context
+	recv chan Frame
+
+	mu   sync.Mutex
+	sent []string
+}
+
+func newFakeStream(msgs ...string) *fakeStream {
+	s := &fakeStream{ctx: context.Background(), recv: make(chan Frame, len(msgs))}
+	for _, m := range msgs {
+		s.recv <- Frame(m)
+	}
+	close(s.recv)
+	return s
+}
+
+func (s *fakeStream) Context() context.Context { return s.ctx }
+
+func (s *fakeStream) SendMsg(m interface{}) error {
+	b, err := Codec{}.Marshal(m)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, string(b))
+	return nil
+}
+
+func (s *fakeStream) RecvMsg(m interface{}) error {
+	f, ok := <-s.recv
+	if !ok {
+		return io.EOF
+	}
+	return Codec{}.Unmarshal(f, m)
+}
+
+func (s *fakeStream) messages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sent
+}
+
+func TestBridge(t *testing.T) {
+	r := require.New(t)
+
+	collected := make(chan string, 10)
+	var server muxrpc.FakeHandler
+	server.HandledReturns(true)
+	server.HandleCallCalls(func(ctx context.Context, req *muxrpc.Request) {
+		switch req.Method.String() {
+		case "manifest":
+			req.Return(ctx, json.RawMessage(`{"manifest":"sync","add":"async","nums":"source","collect":"sink","echo":"duplex"}`))
+		case "add":
+			var args []int
+			json.Unmarshal(req.RawArgs, &args)
+			req.Return(ctx, map[string]int{"sum": args[0] + args[1]})
+		case "nums":
+			snk, _ := req.ResponseSink()
+			for i := 1; i <= 3; i++ {
+				fmt.Fprint(snk, i)
+			}
+			snk.Close()
+		case "collect":
+			src, _ := req.ResponseSource()
+			for src.Next(ctx) {
+				b, _ := src.Bytes()
+				collected <- string(b)
+			}
+			close(collected)
+			req.Close()
+		case "echo":
+			src, _ := req.ResponseSource()
+			snk, _ := req.ResponseSink()
+			for src.Next(ctx) {
+				b, _ := src.Bytes()
+				snk.Write(b)
+			}
+			req.Close()
+		}
+	})
+
+	p := muxtest.Connect(&muxrpc.FakeHandler{}, &server, muxtest.Options{})
+	defer p.Close()
+
+	b := New(p.A)
+	r.NoError(b.Register("/calc.Calc/Add", muxrpc.Method{"add"}, "async"))
+	b.RegisterManifest("test.Test", map[string]string{"nums": "source", "collect": "sink", "echo": "duplex", "skipped": "weird"})
+	r.Equal(map[string]muxrpc.CallType{
+		"/calc.Calc/Add":     "async",
+		"/test.Test/nums":    "source",
+		"/test.Test/collect": "sink",
+		"/test.Test/echo":    "duplex",
+	}, b.Methods())
+
+	// unary
+	s := newFakeStream(`[1,2]`)
+	r.NoError(b.Handle("/calc.Calc/Add", s))
+	r.Equal([]string{`{"sum":3}`}, s.messages())
+
+	// server stream
+	s = newFakeStream(``)
+	r.NoError(b.Handle("/test.Test/nums", s))
+	r.Equal([]string{"1", "2", "3"}, s.messages())
+
+	// client stream
+	s = newFakeStream(`[]`, "a", "b")
+	r.NoError(b.Handle("/test.Test/collect", s))
+	r.Equal([]string{""}, s.messages())
+	var got []string
+	for c := range collected {
+		got = append(got, c)
+	}
+	r.Equal([]string{"a", "b"}, got)
+
+	// bidi
+	s = &fakeStream{ctx: context.Background(), recv: make(chan Frame, 1)}
+	s.recv <- Frame(`[]`)
+	done := make(chan error, 1)
+	go func() { done <- b.Handle("/test.Test/echo", s) }()
+	for i, msg := range []string{"x", "y"} {
+		s.recv <- Frame(msg)
+		r.Eventually(func() bool { return len(s.messages()) == i+1 }, time.Second, 10*time.Millisecond)
+	}
+	close(s.recv)
+	r.NoError(<-done)
+	r.Equal([]string{"x", "y"}, s.messages())
+
+	err := b.Handle("/test.Test/skipped", newFakeStream(`[]`))
+	r.True(errors.Is(err, ErrUnknownMethod), "unexpected error: %v", err)
+
+	err = b.Handle("/calc.Calc/Add", newFakeStream(`{"not":"an array"}`))
+	r.Error(err)
+}
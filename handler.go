@@ -7,7 +7,7 @@ import (
 	"fmt"
 )
 
-//go:generate counterfeiter -o fakehandler_test.go . Handler
+//go:generate counterfeiter -o fakehandler.go . Handler
 
 // Handler allows handling connections.
 // When we are being called, HandleCall is called.
@@ -41,7 +41,9 @@ func ApplyHandlerWrappers(h Handler, hws ...HandlerWrapper) Handler {
 }
 
 type HandlerMux struct {
-	handlers map[string]Handler
+	handlers   map[string]Handler
+	validators map[string]ArgsValidator
+	docs       map[string]MethodDoc
 }
 
 func (hm *HandlerMux) Handled(m Method) bool {
@@ -54,6 +56,11 @@ func (hm *HandlerMux) Handled(m Method) bool {
 }
 
 func (hm *HandlerMux) HandleCall(ctx context.Context, req *Request) {
+	if err := hm.validate(req); err != nil {
+		req.CloseWithError(err)
+		return
+	}
+
 	for i := len(req.Method); i > 0; i-- {
 		m := req.Method[:i]
 		h, ok := hm.handlers[m.String()]
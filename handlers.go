@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"time"
+
+	"go.mindeco.de/log/level"
+)
+
+// defaultHandlerGrace is how long Serve waits for handlers to return, unless WithHandlerGracePeriod is used.
+const defaultHandlerGrace = time.Second
+
+// WithHandlerGracePeriod sets how long Serve waits for running handlers to return once the session ended.
+// Their contexts are canceled when the session ends, so handlers that respect them are done quickly.
+// Handlers that are still running after d are left behind and logged. Zero makes Serve return right away.
+func WithHandlerGracePeriod(d time.Duration) HandleOption {
+	return func(r *rpc) {
+		r.handlerGrace = d
+	}
+}
+
+// spawn runs fn, a call of HandleCall for req or of HandleConnect if req is nil, in the handler group of the session
+func (r *rpc) spawn(req *Request, fn func()) {
+	r.handlers.Add(1)
+	if r.leaks != nil {
+		r.leaks.handlerStarted(req, r.clock.Now())
+	}
+	go func() {
+		defer r.handlers.Done()
+		if r.leaks != nil {
+			defer r.handlerReturned(req)
+		}
+		fn()
+	}()
+}
+
+// waitHandlers waits until all handlers returned or the grace period is over
+func (r *rpc) waitHandlers() {
+	if r.handlerGrace <= 0 {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		r.handlers.Wait()
+		close(done)
+	}()
+
+	wake, timer := after(r.clock, r.handlerGrace)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-wake:
+		level.Warn(r.logger).Log("event", "handlers still running", "grace", r.handlerGrace)
+	}
+}
@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeWaitsForHandlers(t *testing.T) {
+	r := require.New(t)
+
+	c1, c2 := loPipe(t)
+
+	var returned int32
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("slow"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		<-ctx.Done()
+		time.Sleep(100 * time.Millisecond) // cleaning up
+		atomic.StoreInt32(&returned, 1)
+	})
+
+	var rpc2 Endpoint
+	started := make(chan struct{})
+	go func() {
+		rpc2 = Handle(NewPacker(c2), &fh2, WithHandlerGracePeriod(time.Second))
+		close(started)
+	}()
+	rpc1 := Handle(NewPacker(c1), &fh1, WithTerminateGracePeriod(0))
+	<-started
+
+	errc1, errc2 := make(chan error, 1), make(chan error, 1)
+	go func() { errc1 <- rpc1.(Server).Serve() }()
+	go func() { errc2 <- rpc2.(Server).Serve() }()
+
+	_, err := rpc1.Source(context.Background(), TypeString, Method{"slow"})
+	r.NoError(err)
+	time.Sleep(50 * time.Millisecond)
+
+	r.NoError(rpc1.Terminate())
+	select {
+	case err := <-errc2:
+		r.NoError(err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("serve did not return")
+	}
+	r.EqualValues(1, atomic.LoadInt32(&returned), "Serve returned before the handler")
+	r.NoError(<-errc1)
+}
+
+func TestHandlerGracePeriod(t *testing.T) {
+	r := require.New(t)
+
+	c1, c2 := loPipe(t)
+
+	block := make(chan struct{})
+	defer close(block)
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("stuck"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		<-block // ignores ctx
+	})
+
+	var rpc2 Endpoint
+	started := make(chan struct{})
+	go func() {
+		rpc2 = Handle(NewPacker(c2), &fh2, WithHandlerGracePeriod(50*time.Millisecond))
+		close(started)
+	}()
+	rpc1 := Handle(NewPacker(c1), &fh1, WithTerminateGracePeriod(0))
+	<-started
+
+	errc := make(chan error, 1)
+	go rpc1.(Server).Serve()
+	go func() { errc <- rpc2.(Server).Serve() }()
+
+	_, err := rpc1.Source(context.Background(), TypeString, Method{"stuck"})
+	r.NoError(err)
+	time.Sleep(50 * time.Millisecond)
+
+	r.NoError(rpc1.Terminate())
+	select {
+	case err := <-errc:
+		r.NoError(err)
+	case <-time.After(time.Second):
+		t.Fatal("serve waited longer than the grace period")
+	}
+}
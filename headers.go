@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"strings"
+)
+
+// Headers are metadata of a call, like auth tokens or feature hints, sent along with the request.
+// Peers that don't know about them ignore them, so they are a forward-compatible place for things that aren't arguments.
+// Keys are case-insensitive, they are sent in lower case.
+type Headers map[string]string
+
+// Get returns the value of key, or an empty string if it isn't set
+func (h Headers) Get(key string) string {
+	return h[strings.ToLower(key)]
+}
+
+type headersContextKey struct{}
+
+// WithHeaders makes the calls started with ctx send h. Headers that ctx already carries are kept, unless h overrides them.
+func WithHeaders(ctx context.Context, h Headers) context.Context {
+	merged := make(Headers)
+	for k, v := range HeadersFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range h {
+		merged[strings.ToLower(k)] = v
+	}
+	return context.WithValue(ctx, headersContextKey{}, merged)
+}
+
+// HeadersFromContext returns the headers that calls started with ctx would send, see WithHeaders.
+// Unlike the trace ID, the headers of an incoming request are not passed on to the calls its handler makes.
+func HeadersFromContext(ctx context.Context) Headers {
+	h, _ := ctx.Value(headersContextKey{}).(Headers)
+	return h
+}
+
+// Headers returns the headers the remote sent with the request, or nil if there were none.
+// The map must not be modified.
+func (req *Request) Headers() Headers { return req.headers }
+
+// normalizeHeaders lower-cases the keys sent by the remote
+func normalizeHeaders(h Headers) Headers {
+	for k, v := range h {
+		if lk := strings.ToLower(k); lk != k {
+			delete(h, k)
+			h[lk] = v
+		}
+	}
+	return h
+}
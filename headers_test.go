@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaders(t *testing.T) {
+	r := require.New(t)
+
+	var fh1, fh2 FakeHandler
+	fh1.HandledCalls(methodChecker("back"))
+	fh1.HandleCallCalls(func(ctx context.Context, req *Request) {
+		req.Return(ctx, len(req.Headers()))
+	})
+	fh2.HandledCalls(methodChecker("whoami"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		// headers aren't passed on to the calls of a handler
+		var back int
+		if err := req.Endpoint().Async(ctx, &back, TypeJSON, Method{"back"}); err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		h := req.Headers()
+		req.Return(ctx, fmt.Sprintf("%s %s %d", h.Get("Auth-Token"), h.Get("feature"), back))
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2)
+
+	ctx := WithHeaders(context.Background(), Headers{"Auth-Token": "secret", "feature": "a"})
+	ctx = WithHeaders(ctx, Headers{"Feature": "b"})
+	r.Equal(Headers{"auth-token": "secret", "feature": "b"}, HeadersFromContext(ctx))
+
+	var resp string
+	r.NoError(rpc1.Async(ctx, &resp, TypeString, Method{"whoami"}))
+	r.Equal("secret b 0", resp)
+
+	r.NoError(rpc1.Async(context.Background(), &resp, TypeString, Method{"whoami"}))
+	r.Equal("  0", resp)
+	r.Nil(HeadersFromContext(context.Background()))
+}
+
+func TestHeadersWire(t *testing.T) {
+	r := require.New(t)
+
+	body, err := StdJSON.Marshal(wireRequest{Request: &Request{Method: Method{"foo"}, Type: "async"}})
+	r.NoError(err)
+	r.NotContains(string(body), "headers", "peers without headers shouldn't see the field")
+
+	body, err = StdJSON.Marshal(wireRequest{Request: &Request{Method: Method{"foo"}, Type: "async"}, Headers: Headers{"k": "v"}})
+	r.NoError(err)
+	r.Contains(string(body), `"headers":{"k":"v"}`)
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// HealthMethod is answered by endpoints with WithHealthCheck, so that monitoring can probe muxrpc servers the same way.
+// It's an async call without arguments, which returns a HealthStatus. It's not listed in manifests.
+var HealthMethod = Method{"muxrpc", "health"}
+
+// HealthStatus is the reply to HealthMethod
+type HealthStatus struct {
+	Status string `json:"status"` // always "ok", failing checks reply with an error instead
+}
+
+// HealthCheck tells if the application behind an endpoint can serve calls, see WithHealthCheck.
+type HealthCheck func(ctx context.Context) error
+
+// WithHealthCheck makes the endpoint answer HealthMethod itself. If check isn't nil, it's run for every probe
+// and its error is sent back instead of the status, so that the prober sees why the endpoint isn't healthy.
+// Otherwise the endpoint is healthy as long as it answers. See Ping for the other side.
+func WithHealthCheck(check HealthCheck) HandleOption {
+	return func(r *rpc) {
+		r.health = true
+		r.healthCheck = check
+	}
+}
+
+// Ping calls HealthMethod on the remote and returns how long the reply took.
+// Remotes without WithHealthCheck answer with ErrNoSuchMethod (as a *CallError), the round trip worked for those as well.
+func Ping(ctx context.Context, edp Endpoint) (rtt time.Duration, err error) {
+	start := time.Now()
+	var status HealthStatus
+	err = edp.Async(ctx, &status, TypeJSON, HealthMethod)
+	rtt = time.Since(start)
+	if err != nil {
+		return rtt, err
+	}
+	if status.Status != "ok" {
+		return rtt, fmt.Errorf("muxrpc: unexpected health status %q", status.Status)
+	}
+	return rtt, nil
+}
+
+func isHealthMethod(m Method) bool {
+	return m.String() == HealthMethod.String()
+}
+
+// healthHandler answers HealthMethod and passes everything else on
+type healthHandler struct {
+	Handler
+
+	check HealthCheck
+}
+
+func (h healthHandler) Handled(m Method) bool {
+	return isHealthMethod(m) || h.Handler.Handled(m)
+}
+
+func (h healthHandler) HandleCall(ctx context.Context, req *Request) {
+	if !isHealthMethod(req.Method) {
+		h.Handler.HandleCall(ctx, req)
+		return
+	}
+
+	if h.check != nil {
+		if err := h.check(ctx); err != nil {
+			req.CloseWithError(fmt.Errorf("muxrpc: health check failed: %w", err))
+			return
+		}
+	}
+	req.Return(ctx, HealthStatus{Status: "ok"})
+}
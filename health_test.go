@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// manifestHandler only answers the manifest call, which doesn't list the health method
+func manifestHandler() *FakeHandler {
+	var fh FakeHandler
+	fh.HandledCalls(methodChecker("manifest"))
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		req.Return(ctx, json.RawMessage(`{"hello":"async"}`))
+	})
+	return &fh
+}
+
+func TestHealthCheck(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	// healthy as long as it answers
+	rpc1, _ := handledPair(t, &FakeHandler{}, manifestHandler(), nil, []HandleOption{WithHealthCheck(nil)})
+	rtt, err := Ping(ctx, rpc1)
+	r.NoError(err)
+	r.True(rtt > 0)
+
+	// the check tells why it isn't
+	down := errors.New("database unreachable")
+	rpc1, _ = handledPair(t, &FakeHandler{}, manifestHandler(), nil, []HandleOption{WithHealthCheck(func(context.Context) error { return down })})
+	rtt, err = Ping(ctx, rpc1)
+	var ce *CallError
+	r.True(errors.As(err, &ce), "unexpected error: %v", err)
+	r.Contains(ce.Message, "database unreachable")
+	r.True(rtt > 0)
+
+	// remotes without it
+	rpc1, _ = handledPair(t, &FakeHandler{}, manifestHandler(), nil, nil)
+	_, err = Ping(ctx, rpc1)
+	r.True(errors.As(err, &ce), "unexpected error: %v", err)
+	r.Contains(ce.Message, "no such command")
+}
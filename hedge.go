@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+)
+
+// HedgedAsync does the same Async call on several endpoints, like multiple connections to the same peer or to mirrors of it.
+// It starts with the first endpoint and, as long as no attempt succeeded, adds the next one after each delay.
+// The first successful reply is stored in ret and the other attempts are canceled.
+// If all attempts fail, the error of the last one to fail is returned.
+// Only use it for idempotent methods.
+func HedgedAsync(ctx context.Context, delay time.Duration, edps []Endpoint, ret interface{}, re RequestEncoding, method Method, args ...interface{}) error {
+	if len(edps) == 0 {
+		return errors.New("muxrpc: no endpoints to call")
+	}
+
+	rv := reflect.ValueOf(ret)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("muxrpc: hedged call needs a non-nil pointer to store the result in")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		val reflect.Value
+		err error
+	}
+	results := make(chan result, len(edps))
+
+	start := func(edp Endpoint) {
+		// every attempt decodes into its own value so they don't race
+		val := reflect.New(rv.Elem().Type())
+		go func() {
+			err := edp.Async(ctx, val.Interface(), re, method, args...)
+			results <- result{val, err}
+		}()
+	}
+
+	start(edps[0])
+	started, failed := 1, 0
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var lastErr error
+	for {
+		select {
+		case res := <-results:
+			if res.err == nil {
+				rv.Elem().Set(res.val.Elem())
+				return nil
+			}
+			lastErr = res.err
+			failed++
+			if failed == len(edps) {
+				return lastErr
+			}
+			if failed == started { // nothing in flight, don't wait for the delay
+				start(edps[started])
+				started++
+			}
+
+		case <-timer.C:
+			if started < len(edps) {
+				start(edps[started])
+				started++
+				timer.Reset(delay)
+			}
+
+		case <-ctx.Done():
+			if lastErr != nil {
+				return lastErr
+			}
+			return ctx.Err()
+		}
+	}
+}
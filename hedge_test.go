@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHedgedAsync(t *testing.T) {
+	r := require.New(t)
+
+	handler := func(delay time.Duration, fail bool) *FakeHandler {
+		var fh FakeHandler
+		fh.HandledCalls(methodChecker("whoami"))
+		fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+			if fail {
+				req.CloseWithError(errors.New("broken mirror"))
+				return
+			}
+			req.Return(ctx, req.RemoteAddr().Network()+" "+delay.String())
+		})
+		return &fh
+	}
+
+	var client FakeHandler
+	slow, _ := connectedPair(t, &client, handler(time.Second, false))
+	fast, _ := connectedPair(t, &client, handler(0, false))
+	broken, _ := connectedPair(t, &client, handler(0, true))
+
+	ctx := context.Background()
+
+	// the second endpoint overtakes the slow first one
+	var resp string
+	start := time.Now()
+	err := HedgedAsync(ctx, 20*time.Millisecond, []Endpoint{slow, fast}, &resp, TypeString, Method{"whoami"})
+	r.NoError(err)
+	r.Equal("tcp 0s", resp)
+	r.True(time.Since(start) < 500*time.Millisecond, "should not wait for the slow endpoint")
+
+	// failures start the next attempt right away
+	resp = ""
+	err = HedgedAsync(ctx, time.Hour, []Endpoint{broken, fast}, &resp, TypeString, Method{"whoami"})
+	r.NoError(err)
+	r.Equal("tcp 0s", resp)
+
+	err = HedgedAsync(ctx, time.Millisecond, []Endpoint{broken}, &resp, TypeString, Method{"whoami"})
+	var ce *CallError
+	r.True(errors.As(err, &ce), "expected CallError, got %v", err)
+	r.Equal("broken mirror", ce.Message)
+}
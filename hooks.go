@@ -0,0 +1,230 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.cryptoscope.co/luigi"
+)
+
+// CallInfo describes a call to the hooks registered with OnCallStart and OnCallEnd.
+type CallInfo struct {
+	Method  Method
+	Type    CallType
+	TraceID string
+
+	// Incoming is true for calls the remote started and false for the ones we made
+	Incoming bool
+
+	// the rest is only set for OnCallEnd
+
+	Duration time.Duration
+
+	// BytesIn and BytesOut count the bodies received and sent for the call, without its arguments
+	BytesIn, BytesOut int64
+
+	// Err is nil if the call ended regularly
+	Err error
+}
+
+// CallHook is called with the calls of an endpoint, see OnCallStart and OnCallEnd.
+// It is called from the goroutines of the session and should not block.
+type CallHook func(CallInfo)
+
+// StreamData describes a frame of a call to the hooks registered with OnStreamData.
+type StreamData struct {
+	// Req is the id of the request on this endpoint, negative for calls the remote started
+	Req    int32
+	Method Method
+
+	// Inbound is true for frames we received and false for the ones we sent
+	Inbound bool
+
+	// Size is the length of the body of the frame
+	Size int
+}
+
+// StreamDataHook is called with the frames of the calls of an endpoint, see OnStreamData.
+// It is called from the goroutines of the session, while they read or write the frame, and must not block.
+type StreamDataHook func(StreamData)
+
+// callHooks holds the hooks of an endpoint
+type callHooks struct {
+	mu         sync.RWMutex
+	next       int
+	start, end map[int]CallHook
+	data       map[int]StreamDataHook
+}
+
+// hookHolder is implemented by the endpoints returned from Handle
+type hookHolder interface {
+	callHooks() *callHooks
+}
+
+// OnCallStart registers hook to be called when a call on edp starts, in either direction.
+// Together with OnCallEnd it's a light way to collect metrics or log calls, without wrapping handlers.
+// The returned function removes the hook again.
+// ok is false if edp doesn't support hooks, which is only the case for endpoints not created by Handle.
+func OnCallStart(edp Endpoint, hook CallHook) (remove func(), ok bool) {
+	return addHook(edp, hook, false)
+}
+
+// OnCallEnd registers hook to be called when a call on edp ended, see OnCallStart.
+func OnCallEnd(edp Endpoint, hook CallHook) (remove func(), ok bool) {
+	return addHook(edp, hook, true)
+}
+
+func addHook(edp Endpoint, hook CallHook, end bool) (func(), bool) {
+	hh, ok := edp.(hookHolder)
+	if !ok {
+		return func() {}, false
+	}
+	h := hh.callHooks()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hooks := &h.start
+	if end {
+		hooks = &h.end
+	}
+	if *hooks == nil {
+		*hooks = make(map[int]CallHook)
+	}
+	id := h.next
+	h.next++
+	(*hooks)[id] = hook
+
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(*hooks, id)
+	}, true
+}
+
+// OnStreamData registers hook to be called for every frame that is sent or received on the calls of edp,
+// so that bandwidth accounting or fair-usage policies don't need to tap all packets.
+// It only sees the bodies of the calls after they started, not their arguments or ends, like CallInfo.BytesIn and BytesOut.
+// The returned function removes the hook again. ok is false if edp doesn't support hooks, see OnCallStart.
+func OnStreamData(edp Endpoint, hook StreamDataHook) (remove func(), ok bool) {
+	hh, ok := edp.(hookHolder)
+	if !ok {
+		return func() {}, false
+	}
+	h := hh.callHooks()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.data == nil {
+		h.data = make(map[int]StreamDataHook)
+	}
+	id := h.next
+	h.next++
+	h.data[id] = hook
+
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.data, id)
+	}, true
+}
+
+func (r *rpc) callHooks() *callHooks { return &r.hooks }
+
+// get returns a copy of the start or end hooks, so that they can be called without holding the lock
+func (h *callHooks) get(end bool) []CallHook {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	hooks := h.start
+	if end {
+		hooks = h.end
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+	list := make([]CallHook, 0, len(hooks))
+	for _, hook := range hooks {
+		list = append(list, hook)
+	}
+	return list
+}
+
+// callStarted runs the start hooks for req
+func (r *rpc) callStarted(req *Request) {
+	hooks := r.hooks.get(false)
+	if len(hooks) == 0 {
+		return
+	}
+
+	info := CallInfo{
+		Method:   req.Method,
+		Type:     req.Type,
+		TraceID:  req.trace,
+		Incoming: req.id < 0,
+	}
+	for _, hook := range hooks {
+		hook(info)
+	}
+}
+
+// callEnded runs the end hooks for req. The callers make sure it's only called once per call.
+func (r *rpc) callEnded(req *Request, err error) {
+	hooks := r.hooks.get(true)
+	if len(hooks) == 0 {
+		return
+	}
+
+	if errors.Is(err, io.EOF) || luigi.IsEOS(err) {
+		err = nil
+	}
+	info := CallInfo{
+		Method:   req.Method,
+		Type:     req.Type,
+		TraceID:  req.trace,
+		Incoming: req.id < 0,
+
+		Duration: r.clock.Now().Sub(req.started),
+		BytesIn:  atomic.LoadInt64(&req.state.received),
+		BytesOut: atomic.LoadInt64(&req.sink.written),
+		Err:      err,
+	}
+	for _, hook := range hooks {
+		hook(info)
+	}
+}
+
+// streamData runs the data hooks for a frame of req
+func (r *rpc) streamData(req *Request, inbound bool, size int) {
+	r.hooks.mu.RLock()
+	if len(r.hooks.data) == 0 {
+		r.hooks.mu.RUnlock()
+		return
+	}
+	hooks := make([]StreamDataHook, 0, len(r.hooks.data))
+	for _, hook := range r.hooks.data {
+		hooks = append(hooks, hook)
+	}
+	r.hooks.mu.RUnlock()
+
+	sd := StreamData{
+		Req:     req.id,
+		Method:  req.Method,
+		Inbound: inbound,
+		Size:    size,
+	}
+	for _, hook := range hooks {
+		hook(sd)
+	}
+}
+
+// instrumentSink makes the sink of req report its frames to the data hooks and its slow writes, see WithWriteStallHook
+func (r *rpc) instrumentSink(req *Request) {
+	req.sink.sent = func(n int) { r.streamData(req, false, n) }
+	if r.stallThreshold > 0 {
+		req.sink.watchWrite = func(n int) func() { return r.watchWrite(req, n) }
+	}
+}
@@ -0,0 +1,193 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallHooks(t *testing.T) {
+	r := require.New(t)
+
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("numbers"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		snk, err := req.ResponseSink()
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		snk.SetEncoding(TypeString)
+		snk.Write([]byte("one"))
+		snk.Write([]byte("two"))
+		snk.Close()
+	})
+
+	rpc1, rpc2 := connectedPair(t, &fh1, &fh2)
+
+	type event struct {
+		end  bool
+		info CallInfo
+	}
+	events := make(chan event, 10)
+	_, ok := OnCallStart(rpc1, func(ci CallInfo) { events <- event{false, ci} })
+	r.True(ok)
+	removeEnd, ok := OnCallEnd(rpc1, func(ci CallInfo) { events <- event{true, ci} })
+	r.True(ok)
+	_, ok = OnCallEnd(rpc2, func(ci CallInfo) { events <- event{true, ci} })
+	r.True(ok)
+
+	_, ok = OnCallStart(&FakeEndpoint{}, func(CallInfo) {})
+	r.False(ok)
+
+	next := func() event {
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(2 * time.Second):
+			t.Fatal("no hook called")
+			return event{}
+		}
+	}
+
+	ctx := context.Background()
+	src, err := rpc1.Source(ctx, TypeString, Method{"numbers"})
+	r.NoError(err)
+	for src.Next(ctx) {
+		_, err := src.Bytes()
+		r.NoError(err)
+	}
+
+	ev := next()
+	r.False(ev.end)
+	r.Equal("numbers", ev.info.Method.String())
+	r.Equal(CallType("source"), ev.info.Type)
+	r.False(ev.info.Incoming)
+	r.NotEmpty(ev.info.TraceID)
+
+	// the ends of both sides, in either order
+	var caller, callee CallInfo
+	for i := 0; i < 2; i++ {
+		ev = next()
+		r.True(ev.end)
+		if ev.info.Incoming {
+			callee = ev.info
+		} else {
+			caller = ev.info
+		}
+	}
+	r.NoError(caller.Err)
+	r.EqualValues(6, caller.BytesIn)
+	r.EqualValues(0, caller.BytesOut)
+	r.NoError(callee.Err)
+	r.EqualValues(6, callee.BytesOut)
+	r.Equal(caller.TraceID, callee.TraceID)
+
+	removeEnd()
+	var resp string
+	rpc1.Async(ctx, &resp, TypeString, Method{"unknown"})
+	ev = next()
+	r.False(ev.end, "only the start hook is left for rpc1")
+	ev = next()
+	r.True(ev.end)
+	r.True(ev.info.Incoming)
+	r.Error(ev.info.Err)
+
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected hook call: %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestStreamDataHooks(t *testing.T) {
+	r := require.New(t)
+
+	var fh FakeHandler
+	fh.HandledCalls(methodChecker("echo"))
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		src, err := req.ResponseSource()
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		snk, err := req.ResponseSink()
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		snk.SetEncoding(TypeBinary)
+		for src.Next(ctx) {
+			b, err := src.Bytes()
+			if err != nil {
+				break
+			}
+			snk.Write(b)
+		}
+		snk.Close()
+	})
+
+	rpc1, rpc2 := connectedPair(t, &FakeHandler{}, &fh)
+
+	frames := make(chan StreamData, 10)
+	remove, ok := OnStreamData(rpc1, func(sd StreamData) { frames <- sd })
+	r.True(ok)
+	var inbound []StreamData
+	var mu sync.Mutex
+	_, ok = OnStreamData(rpc2, func(sd StreamData) {
+		mu.Lock()
+		inbound = append(inbound, sd)
+		mu.Unlock()
+	})
+	r.True(ok)
+
+	_, ok = OnStreamData(&FakeEndpoint{}, func(StreamData) {})
+	r.False(ok)
+
+	ctx := context.Background()
+	src, snk, err := rpc1.Duplex(ctx, TypeBinary, Method{"echo"})
+	r.NoError(err)
+
+	var got []StreamData
+	for _, frame := range []string{"ab", "cde"} {
+		_, err = snk.Write([]byte(frame))
+		r.NoError(err)
+		r.True(src.Next(ctx))
+		b, err := src.Bytes()
+		r.NoError(err)
+		r.Equal(frame, string(b))
+		got = append(got, <-frames, <-frames)
+	}
+	r.NoError(snk.Close())
+	r.False(src.Next(ctx))
+
+	r.Len(got, 4)
+	for i, size := range []int{2, 2, 3, 3} {
+		sd := got[i]
+		r.Equal(i%2 == 1, sd.Inbound, "frame %d", i)
+		r.Equal(size, sd.Size)
+		r.Equal("echo", sd.Method.String())
+		r.True(sd.Req > 0, "our own call")
+	}
+
+	mu.Lock()
+	r.Len(inbound, 4)
+	r.True(inbound[0].Inbound)
+	r.Equal(2, inbound[0].Size)
+	r.True(inbound[0].Req < 0, "a call of the remote")
+	mu.Unlock()
+
+	remove()
+	var resp string
+	r.Error(rpc1.Async(ctx, &resp, TypeString, Method{"unknown"}))
+	select {
+	case sd := <-frames:
+		t.Fatalf("unexpected hook call: %+v", sd)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
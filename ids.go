@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IDAllocator hands out the ids of the requests an endpoint starts, see WithIDAllocator.
+// The remote sees them negated, so they need to be positive.
+type IDAllocator interface {
+	// Next returns the id for a new request. inUse reports ids that still belong to open requests, which must not be returned.
+	// The endpoint calls it with its request lock held, so it should be quick and must not start calls itself.
+	Next(inUse func(id int32) bool) int32
+}
+
+// WithIDAllocator makes the endpoint take the ids of its requests from a, instead of counting up from one.
+func WithIDAllocator(a IDAllocator) HandleOption {
+	return func(r *rpc) {
+		r.ids = a
+	}
+}
+
+// SequentialIDs counts up from one and starts over once the ids run out. It's what endpoints use by default.
+func SequentialIDs() IDAllocator {
+	return &stridedIDs{first: 1, step: 1}
+}
+
+// InterleavedIDs hands out only odd or only even ids, so that the two sides of a session can use different ones.
+// That keeps the ids unique across both directions, which makes the logs of symmetric sessions easier to follow.
+func InterleavedIDs(odd bool) IDAllocator {
+	if odd {
+		return &stridedIDs{first: 1, step: 2}
+	}
+	return &stridedIDs{first: 2, step: 2}
+}
+
+// stridedIDs adds step to the last id, without taking a lock
+type stridedIDs struct {
+	first, step int32
+
+	last int32
+}
+
+func (s *stridedIDs) Next(inUse func(int32) bool) int32 {
+	for {
+		last := atomic.LoadInt32(&s.last)
+		next := last + s.step
+		if last == 0 || next <= 0 { // not started yet or wrapped around
+			next = s.first
+		}
+		if !atomic.CompareAndSwapInt32(&s.last, last, next) {
+			continue
+		}
+		if !inUse(next) {
+			return next
+		}
+	}
+}
+
+// RandomIDs picks ids at random, which shakes out code that relies on their order. src may be nil.
+func RandomIDs(src rand.Source) IDAllocator {
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+	return &randomIDs{rnd: rand.New(src)}
+}
+
+type randomIDs struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func (ri *randomIDs) Next(inUse func(int32) bool) int32 {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	for {
+		id := ri.rnd.Int31()
+		if id > 0 && !inUse(id) {
+			return id
+		}
+	}
+}
+
+// nextID allocates the id of a new request. The caller needs to hold rLock.
+func (r *rpc) nextID() (int32, error) {
+	id := r.ids.Next(func(id int32) bool {
+		_, open := r.reqs[id]
+		_, unacked := r.reqsUnacked[id]
+		return open || unacked
+	})
+	if id <= 0 {
+		return 0, fmt.Errorf("muxrpc: invalid request id from allocator: %d", id)
+	}
+	return id, nil
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIDAllocators(t *testing.T) {
+	r := require.New(t)
+
+	free := func(int32) bool { return false }
+
+	seq := SequentialIDs()
+	r.Equal(int32(1), seq.Next(free))
+	r.Equal(int32(2), seq.Next(free))
+	r.Equal(int32(4), seq.Next(func(id int32) bool { return id == 3 }))
+
+	odd, even := InterleavedIDs(true), InterleavedIDs(false)
+	for _, want := range []int32{1, 3, 5} {
+		r.Equal(want, odd.Next(free))
+	}
+	for _, want := range []int32{2, 4, 6} {
+		r.Equal(want, even.Next(free))
+	}
+
+	// starts over instead of overflowing
+	wrap := &stridedIDs{first: 2, step: 2, last: math.MaxInt32 - 1}
+	r.Equal(int32(2), wrap.Next(free))
+
+	rnd := RandomIDs(rand.NewSource(1))
+	seen := make(map[int32]bool)
+	for i := 0; i < 100; i++ {
+		id := rnd.Next(func(id int32) bool { return seen[id] })
+		r.True(id > 0)
+		r.False(seen[id])
+		seen[id] = true
+	}
+}
+
+type badIDs struct{}
+
+func (badIDs) Next(func(int32) bool) int32 { return -1 }
+
+func TestWithIDAllocator(t *testing.T) {
+	r := require.New(t)
+
+	var fh FakeHandler
+	fh.HandledCalls(methodChecker("id"))
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		req.Return(ctx, req.ID())
+	})
+
+	rpc1, _ := connectedPair(t, &FakeHandler{}, &fh, WithIDAllocator(InterleavedIDs(false)))
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		var id int32
+		r.NoError(rpc1.Async(ctx, &id, TypeJSON, Method{"id"}))
+		r.True(id < 0 && id%2 == 0, "id: %d", id)
+	}
+
+	var id int32
+	rpc2, _ := connectedPair(t, &FakeHandler{}, &fh, WithIDAllocator(badIDs{}))
+	r.Error(rpc2.Async(ctx, &id, TypeJSON, Method{"id"}))
+}
@@ -0,0 +1,29 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// JSONCodec encodes and decodes the JSON bodies of calls, their replies and the values of legacy streams.
+// The bodies of errors and the manifest always use encoding/json.
+type JSONCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	NewDecoder(r io.Reader) JSONDecoder
+}
+
+// JSONDecoder reads JSON values from a stream, like *json.Decoder does.
+type JSONDecoder interface {
+	Decode(v interface{}) error
+}
+
+// StdJSON is the default JSONCodec, it uses encoding/json.
+var StdJSON JSONCodec = stdJSON{}
+
+type stdJSON struct{}
+
+func (stdJSON) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (stdJSON) NewDecoder(r io.Reader) JSONDecoder { return json.NewDecoder(r) }
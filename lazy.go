@@ -0,0 +1,257 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// ErrQueueFull is returned by calls to a LazyEndpoint that is still connecting and already has as many calls waiting as allowed.
+var ErrQueueFull = errors.New("muxrpc: call queue full")
+
+// defaultLazyQueue is how many calls may wait for the connection of a LazyEndpoint, unless NewLazyEndpoint says otherwise.
+const defaultLazyQueue = 128
+
+// LazyEndpoint is an Endpoint that can be used before its connection is established.
+// Calls that are made while it connects wait for the connection and are started once it's there.
+// If connecting fails, the waiting calls and all later ones fail with that error.
+//
+// It saves applications that make calls from many places at startup from having to wait for the connection first.
+type LazyEndpoint struct {
+	ready chan struct{} // closed once connecting finished, edp and err are set then
+	edp   Endpoint
+	err   error
+
+	queue chan struct{} // holds a token for each call that waits for the connection
+
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	termErr error // set if the endpoint is terminated before it's connected
+
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+var _ Endpoint = (*LazyEndpoint)(nil)
+
+// NewLazyEndpoint calls connect in the background and returns an endpoint that uses the result.
+// At most maxQueued calls wait for the connection, more fail with ErrQueueFull. Zero or less allows 128.
+// Canceling ctx while connect runs aborts connecting.
+func NewLazyEndpoint(ctx context.Context, connect func(context.Context) (Endpoint, error), maxQueued int) *LazyEndpoint {
+	if maxQueued <= 0 {
+		maxQueued = defaultLazyQueue
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	l := &LazyEndpoint{
+		ready:  make(chan struct{}),
+		queue:  make(chan struct{}, maxQueued),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go l.connect(ctx, connect)
+	return l
+}
+
+func (l *LazyEndpoint) connect(ctx context.Context, connect func(context.Context) (Endpoint, error)) {
+	edp, err := connect(ctx)
+	if err != nil {
+		err = fmt.Errorf("muxrpc: failed to connect: %w", err)
+	}
+
+	l.mu.Lock()
+	if l.termErr != nil {
+		if edp != nil {
+			edp.Terminate()
+		}
+		edp, err = nil, l.termErr
+	}
+	l.edp, l.err = edp, err
+	close(l.ready)
+	l.mu.Unlock()
+
+	if err != nil {
+		l.finish()
+		return
+	}
+	go func() {
+		<-edp.Done()
+		l.finish()
+	}()
+}
+
+func (l *LazyEndpoint) finish() {
+	l.doneOnce.Do(func() {
+		l.cancel()
+		close(l.done)
+	})
+}
+
+// endpoint waits for the connection, if the queue has room for one more call
+func (l *LazyEndpoint) endpoint(ctx context.Context) (Endpoint, error) {
+	select {
+	case <-l.ready:
+		return l.edp, l.err
+	default:
+	}
+
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		return nil, ErrQueueFull
+	}
+	defer func() { <-l.queue }()
+
+	select {
+	case <-l.ready:
+		return l.edp, l.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Connected returns the underlying endpoint, or false if it isn't connected (yet)
+func (l *LazyEndpoint) Connected() (Endpoint, bool) {
+	select {
+	case <-l.ready:
+		return l.edp, l.edp != nil
+	default:
+		return nil, false
+	}
+}
+
+func (l *LazyEndpoint) Async(ctx context.Context, ret interface{}, re RequestEncoding, method Method, args ...interface{}) error {
+	edp, err := l.endpoint(ctx)
+	if err != nil {
+		return err
+	}
+	return edp.Async(ctx, ret, re, method, args...)
+}
+
+func (l *LazyEndpoint) AsyncObj(ctx context.Context, ret interface{}, re RequestEncoding, method Method, opts interface{}) error {
+	edp, err := l.endpoint(ctx)
+	if err != nil {
+		return err
+	}
+	return edp.AsyncObj(ctx, ret, re, method, opts)
+}
+
+func (l *LazyEndpoint) Source(ctx context.Context, re RequestEncoding, method Method, args ...interface{}) (*ByteSource, error) {
+	edp, err := l.endpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return edp.Source(ctx, re, method, args...)
+}
+
+func (l *LazyEndpoint) Sink(ctx context.Context, re RequestEncoding, method Method, args ...interface{}) (*ByteSink, error) {
+	edp, err := l.endpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return edp.Sink(ctx, re, method, args...)
+}
+
+func (l *LazyEndpoint) SinkBytes(ctx context.Context, method Method, args ...interface{}) (*ByteSink, error) {
+	edp, err := l.endpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return edp.SinkBytes(ctx, method, args...)
+}
+
+func (l *LazyEndpoint) Duplex(ctx context.Context, re RequestEncoding, method Method, args ...interface{}) (*ByteSource, *ByteSink, error) {
+	edp, err := l.endpoint(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return edp.Duplex(ctx, re, method, args...)
+}
+
+func (l *LazyEndpoint) DoBatch(ctx context.Context, reqs ...*Request) error {
+	edp, err := l.endpoint(ctx)
+	if err != nil {
+		return err
+	}
+	return edp.DoBatch(ctx, reqs...)
+}
+
+// Flush returns right away while the endpoint connects, since nothing was written yet
+func (l *LazyEndpoint) Flush(ctx context.Context) error {
+	if edp, ok := l.Connected(); ok {
+		return edp.Flush(ctx)
+	}
+	return nil
+}
+
+func (l *LazyEndpoint) Terminate() error {
+	return l.TerminateWithError(context.Background(), nil)
+}
+
+// TerminateWithError aborts connecting, if it didn't finish yet, or terminates the connected endpoint.
+// Calls that wait for the connection fail with the termination error.
+func (l *LazyEndpoint) TerminateWithError(ctx context.Context, reason error) error {
+	l.mu.Lock()
+	select {
+	case <-l.ready:
+		l.mu.Unlock()
+		if l.edp == nil {
+			return nil // connecting failed, there is nothing to terminate
+		}
+		return l.edp.TerminateWithError(ctx, reason)
+	default:
+	}
+	defer l.mu.Unlock()
+	if l.termErr == nil {
+		l.termErr = ErrSessionTerminated
+		if reason != nil {
+			l.termErr = &TerminatedError{Reason: reason}
+		}
+	}
+	l.cancel()
+	return nil
+}
+
+// Done is closed once connecting failed or the connected endpoint is done
+func (l *LazyEndpoint) Done() <-chan struct{} { return l.done }
+
+func (l *LazyEndpoint) Err() error {
+	select {
+	case <-l.done:
+	default:
+		return nil
+	}
+	if l.edp != nil {
+		return l.edp.Err()
+	}
+	return l.err
+}
+
+// Remote returns nil until the endpoint is connected
+func (l *LazyEndpoint) Remote() net.Addr {
+	if edp, ok := l.Connected(); ok {
+		return edp.Remote()
+	}
+	return nil
+}
+
+// RemoteKey returns false until the endpoint is connected
+func (l *LazyEndpoint) RemoteKey() (ed25519.PublicKey, bool) {
+	if edp, ok := l.Connected(); ok {
+		return edp.RemoteKey()
+	}
+	return nil, false
+}
+
+// Local returns nil until the endpoint is connected
+func (l *LazyEndpoint) Local() net.Addr {
+	if edp, ok := l.Connected(); ok {
+		return edp.Local()
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLazyEndpoint(t *testing.T) {
+	r := require.New(t)
+
+	var fh FakeHandler
+	fh.HandledCalls(methodChecker("whoami"))
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		req.Return(ctx, "bob")
+	})
+	client, _ := connectedPair(t, &FakeHandler{}, &fh)
+
+	release := make(chan struct{})
+	lazy := NewLazyEndpoint(context.Background(), func(ctx context.Context) (Endpoint, error) {
+		<-release
+		return client, nil
+	}, 2)
+
+	_, connected := lazy.Connected()
+	r.False(connected)
+	r.Nil(lazy.Remote())
+	r.NoError(lazy.Flush(context.Background()))
+
+	ctx := context.Background()
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			var who string
+			err := lazy.Async(ctx, &who, TypeString, Method{"whoami"})
+			if err == nil && who != "bob" {
+				err = errors.New("wrong reply: " + who)
+			}
+			results <- err
+		}()
+	}
+	r.Eventually(func() bool { return len(lazy.queue) == 2 }, time.Second, 10*time.Millisecond)
+
+	var who string
+	err := lazy.Async(ctx, &who, TypeString, Method{"whoami"})
+	r.True(errors.Is(err, ErrQueueFull), "unexpected error: %v", err)
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-results:
+			r.NoError(err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("queued call wasn't started")
+		}
+	}
+
+	edp, connected := lazy.Connected()
+	r.True(connected)
+	r.Equal(client, edp)
+	r.NoError(lazy.Async(ctx, &who, TypeString, Method{"whoami"}))
+	r.Equal("bob", who)
+
+	r.NoError(lazy.Terminate())
+	select {
+	case <-lazy.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("not done after termination")
+	}
+	r.True(errors.Is(lazy.Err(), ErrSessionTerminated))
+}
+
+func TestLazyEndpointConnectFails(t *testing.T) {
+	r := require.New(t)
+
+	failure := errors.New("no route to host")
+	release := make(chan struct{})
+	lazy := NewLazyEndpoint(context.Background(), func(ctx context.Context) (Endpoint, error) {
+		<-release
+		return nil, failure
+	}, 0)
+
+	queued := make(chan error, 1)
+	go func() {
+		_, err := lazy.Source(context.Background(), TypeJSON, Method{"feed"})
+		queued <- err
+	}()
+	r.Eventually(func() bool { return len(lazy.queue) == 1 }, time.Second, 10*time.Millisecond)
+	close(release)
+
+	err := <-queued
+	r.True(errors.Is(err, failure), "unexpected error: %v", err)
+
+	<-lazy.Done()
+	r.True(errors.Is(lazy.Err(), failure))
+	_, _, err = lazy.Duplex(context.Background(), TypeJSON, Method{"chat"})
+	r.True(errors.Is(err, failure), "unexpected error: %v", err)
+}
+
+func TestLazyEndpointTerminateWhileConnecting(t *testing.T) {
+	r := require.New(t)
+
+	canceled := make(chan struct{})
+	lazy := NewLazyEndpoint(context.Background(), func(ctx context.Context) (Endpoint, error) {
+		<-ctx.Done()
+		close(canceled)
+		return nil, ctx.Err()
+	}, 0)
+
+	queued := make(chan error, 1)
+	go func() {
+		queued <- lazy.Async(context.Background(), nil, TypeJSON, Method{"whoami"})
+	}()
+	r.Eventually(func() bool { return len(lazy.queue) == 1 }, time.Second, 10*time.Millisecond)
+
+	reason := errors.New("shutting down")
+	r.NoError(lazy.TerminateWithError(context.Background(), reason))
+	<-canceled
+
+	err := <-queued
+	var te *TerminatedError
+	r.True(errors.As(err, &te), "unexpected error: %v", err)
+	r.Equal(reason, te.Reason)
+	<-lazy.Done()
+	r.Equal(err, lazy.Err())
+}
@@ -0,0 +1,217 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mindeco.de/log/level"
+)
+
+// defaultLeakGrace is how long handlers get to return after the session ended, unless WithLeakCheck says otherwise.
+const defaultLeakGrace = time.Second
+
+// WithLeakCheck makes the endpoint track the goroutines it spawns for its handler and the calls the remote started.
+// Once the session ended, handlers that didn't return within grace and calls that their handler returned from
+// without closing them are logged as warnings and reported by CheckLeaks. Zero or less uses a grace period of one second.
+// It's meant for tests and debugging, the bookkeeping costs a map update per call.
+func WithLeakCheck(grace time.Duration) HandleOption {
+	return func(r *rpc) {
+		if grace <= 0 {
+			grace = defaultLeakGrace
+		}
+		r.leaks = &leakTracker{
+			grace:     grace,
+			running:   make(map[*Request]time.Time),
+			abandoned: make(map[*Request]struct{}),
+			checked:   make(chan struct{}),
+		}
+	}
+}
+
+// LeakedCall describes a handler or call that outlived its session
+type LeakedCall struct {
+	// Method and Type are empty for HandleConnect
+	Method Method
+	Type   CallType
+
+	// ID is the request number on the connection, see Request.ID
+	ID int32
+
+	Started time.Time
+}
+
+func (c LeakedCall) String() string {
+	if c.Method == nil {
+		return "HandleConnect"
+	}
+	return fmt.Sprintf("%s %s (req %d)", c.Type, c.Method, c.ID)
+}
+
+// LeakError lists what outlived a session, see WithLeakCheck
+type LeakError struct {
+	// Handlers are HandleConnect and HandleCall invocations that didn't return within the grace period
+	Handlers []LeakedCall
+
+	// Requests are calls from the remote that their handlers returned from without closing them
+	// and that were still open when the session ended.
+	Requests []LeakedCall
+}
+
+func (e *LeakError) Error() string {
+	var parts []string
+	if len(e.Handlers) > 0 {
+		parts = append(parts, fmt.Sprintf("%d handlers still running: %s", len(e.Handlers), joinCalls(e.Handlers)))
+	}
+	if len(e.Requests) > 0 {
+		parts = append(parts, fmt.Sprintf("%d requests left open: %s", len(e.Requests), joinCalls(e.Requests)))
+	}
+	return "muxrpc: leaks after session end: " + strings.Join(parts, "; ")
+}
+
+func joinCalls(calls []LeakedCall) string {
+	strs := make([]string, len(calls))
+	for i, c := range calls {
+		strs[i] = c.String()
+	}
+	return strings.Join(strs, ", ")
+}
+
+// leakChecker is implemented by the endpoints returned from Handle
+type leakChecker interface {
+	checkLeaks() error
+}
+
+// CheckLeaks returns a *LeakError if handlers or calls of edp outlived its session, or nil if there were none.
+// It waits until the grace period of WithLeakCheck is over or all handlers returned.
+// It returns an error as well if the session is still running or leak checking isn't enabled.
+func CheckLeaks(edp Endpoint) error {
+	lc, ok := edp.(leakChecker)
+	if !ok {
+		return errors.New("muxrpc: leak check not supported by this endpoint")
+	}
+	return lc.checkLeaks()
+}
+
+func (r *rpc) checkLeaks() error {
+	if r.leaks == nil {
+		return errors.New("muxrpc: leak check not enabled, see WithLeakCheck")
+	}
+	if _, ended := SessionEnd(r); !ended {
+		return errors.New("muxrpc: leak check: session still running")
+	}
+	<-r.leaks.checked
+	if r.leaks.result == nil {
+		return nil
+	}
+	return r.leaks.result
+}
+
+// leakTracker keeps the handlers that are running and the calls the session ended, see WithLeakCheck
+type leakTracker struct {
+	grace time.Duration
+
+	mu        sync.Mutex
+	running   map[*Request]time.Time // HandleConnect is stored under nil
+	abandoned map[*Request]struct{}  // calls that were open when their handler returned
+	idle      chan struct{}          // closed once running is empty, set while the check waits
+	openAtEnd []LeakedCall
+
+	checked chan struct{} // closed once result is set
+	result  *LeakError
+}
+
+// handlerStarted notes that a handler for req (nil for HandleConnect) is running
+func (lt *leakTracker) handlerStarted(req *Request, now time.Time) {
+	lt.mu.Lock()
+	lt.running[req] = now
+	lt.mu.Unlock()
+}
+
+// handlerReturned notes that the handler of req returned and whether it left req open while the session was still running
+func (r *rpc) handlerReturned(req *Request) {
+	var open bool
+	if req != nil && r.serveCtx.Err() == nil {
+		r.rLock.RLock()
+		open = r.reqs[req.id] == req
+		r.rLock.RUnlock()
+	}
+
+	lt := r.leaks
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	delete(lt.running, req)
+	if open {
+		lt.abandoned[req] = struct{}{}
+	}
+	if len(lt.running) == 0 && lt.idle != nil {
+		close(lt.idle)
+		lt.idle = nil
+	}
+}
+
+// ended notes the calls Terminate had to end that their handlers abandoned
+func (lt *leakTracker) ended(reqs []*Request) {
+	if lt == nil {
+		return
+	}
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	for _, req := range reqs {
+		if _, ok := lt.abandoned[req]; ok {
+			lt.openAtEnd = append(lt.openAtEnd, req.leakedCall(req.started))
+		}
+	}
+	lt.abandoned = make(map[*Request]struct{})
+}
+
+// reportLeaks waits for the handlers to return and reports those that don't and the calls nobody closed.
+// It's started once the session ended.
+func (r *rpc) reportLeaks() {
+	lt := r.leaks
+
+	lt.mu.Lock()
+	idle := make(chan struct{})
+	if len(lt.running) == 0 {
+		close(idle)
+	} else {
+		lt.idle = idle
+	}
+	lt.mu.Unlock()
+
+	wake, timer := after(r.clock, lt.grace)
+	select {
+	case <-idle:
+		timer.Stop()
+	case <-wake:
+	}
+
+	lt.mu.Lock()
+	lt.idle = nil
+	var leaks LeakError
+	for req, started := range lt.running {
+		if req == nil {
+			leaks.Handlers = append(leaks.Handlers, LeakedCall{Started: started})
+			continue
+		}
+		leaks.Handlers = append(leaks.Handlers, req.leakedCall(started))
+	}
+	leaks.Requests = lt.openAtEnd
+	if len(leaks.Handlers) > 0 || len(leaks.Requests) > 0 {
+		lt.result = &leaks
+	}
+	close(lt.checked)
+	lt.mu.Unlock()
+
+	if lt.result != nil {
+		level.Warn(r.logger).Log("event", "leak check", "err", lt.result)
+	}
+}
+
+func (req *Request) leakedCall(started time.Time) LeakedCall {
+	return LeakedCall{Method: req.Method, Type: req.Type, ID: req.id, Started: started}
+}
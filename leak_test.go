@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeakCheck(t *testing.T) {
+	r := require.New(t)
+
+	block := make(chan struct{})
+	defer close(block)
+
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(func(m Method) bool {
+		switch m.String() {
+		case "clean", "abandon", "stuck":
+			return true
+		}
+		return false
+	})
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		switch req.Method.String() {
+		case "clean":
+			req.Return(ctx, "ok")
+		case "abandon":
+			snk, err := req.ResponseSink()
+			if err != nil {
+				return
+			}
+			snk.Write([]byte("bye")) // but never closes the stream
+		case "stuck":
+			<-block // ignores ctx
+		}
+	})
+
+	rpc1, rpc2 := connectedPair(t, &fh1, &fh2, WithLeakCheck(100*time.Millisecond), WithTerminateGracePeriod(0))
+
+	r.Error(CheckLeaks(rpc2), "session still running")
+
+	ctx := context.Background()
+	var res string
+	r.NoError(rpc1.Async(ctx, &res, TypeString, Method{"clean"}))
+
+	src, err := rpc1.Source(ctx, TypeBinary, Method{"abandon"})
+	r.NoError(err)
+	r.True(src.Next(ctx))
+	_, err = rpc1.Source(ctx, TypeBinary, Method{"stuck"})
+	r.NoError(err)
+	time.Sleep(50 * time.Millisecond)
+
+	r.NoError(rpc2.Terminate())
+	r.Eventually(func() bool { _, ended := SessionEnd(rpc2); return ended }, 2*time.Second, 10*time.Millisecond)
+
+	err = CheckLeaks(rpc2)
+	var leaks *LeakError
+	r.True(errors.As(err, &leaks), "unexpected error: %v", err)
+
+	r.Len(leaks.Handlers, 1)
+	r.Equal(Method{"stuck"}, leaks.Handlers[0].Method)
+	r.Len(leaks.Requests, 1)
+	r.Equal(Method{"abandon"}, leaks.Requests[0].Method)
+	r.Equal(CallType("source"), leaks.Requests[0].Type)
+	r.True(leaks.Requests[0].ID < 0)
+
+	r.NoError(CheckLeaks(rpc1), "the caller has no leaks")
+}
+
+func TestLeakCheckDisabled(t *testing.T) {
+	r := require.New(t)
+
+	rpc1, _ := connectedPair(t, &FakeHandler{}, &FakeHandler{})
+	r.Error(CheckLeaks(rpc1))
+	r.Error(CheckLeaks(&FakeEndpoint{}))
+}
@@ -0,0 +1,49 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"sync/atomic"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+	"go.mindeco.de/log/level"
+)
+
+// WithLenientRequestIDs accepts traffic from peers that get the sign of request ids wrong,
+// instead of ending the session once they start a call with a negative id or reply with a positive one.
+// Packets whose id fits none of the open requests but would with the sign flipped are taken as if it were flipped,
+// and positive ids that aren't ours start new calls. Only the first fixed id of a session is logged as a warning.
+//
+// It can't tell a reply with the wrong sign from a new call with an id we use as well, so only use it with peers that need it.
+func WithLenientRequestIDs() HandleOption {
+	return func(r *rpc) {
+		r.lenientIDs = true
+	}
+}
+
+// normalizeID fixes the id of an incoming packet if the remote got its sign wrong, see WithLenientRequestIDs
+func (r *rpc) normalizeID(hdr *codec.Header) {
+	if !r.lenientIDs || hdr.Req == 0 {
+		return
+	}
+
+	r.rLock.RLock()
+	known := func(id int32) bool {
+		_, open := r.reqs[id]
+		_, unacked := r.reqsUnacked[id]
+		_, closed := r.reqsClosed[id]
+		return open || unacked || closed
+	}
+	flip := !known(hdr.Req) && (known(-hdr.Req) || hdr.Req > 0)
+	r.rLock.RUnlock()
+	if !flip {
+		return
+	}
+
+	lvl := level.Debug(r.logger)
+	if atomic.AddUint32(&r.flippedIDs, 1) == 1 {
+		lvl = level.Warn(r.logger)
+	}
+	lvl.Log("event", "remote sent request id with the wrong sign", "req", -hdr.Req, "flags", hdr.Flag)
+	hdr.Req = -hdr.Req
+}
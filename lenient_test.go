@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+)
+
+// sloppyPeer answers the manifest call and starts a call to hello with the wrong sign.
+// With sloppyReply, it gets the sign of the reply to the manifest call wrong as well.
+func sloppyPeer(t *testing.T, sloppyReply bool, opts ...HandleOption) (Endpoint, *codec.Reader) {
+	r := require.New(t)
+
+	var fh FakeHandler
+	fh.HandledCalls(methodChecker("hello"))
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		req.Return(ctx, "world")
+	})
+
+	c1, c2 := loPipe(t)
+	handled := make(chan Endpoint)
+	go func() {
+		handled <- Handle(NewPacker(c1), &fh, opts...)
+	}()
+
+	rd, w := codec.NewReader(c2), codec.NewWriter(c2)
+	manifest, err := rd.ReadPacket()
+	r.NoError(err)
+	r.True(manifest.Req > 0)
+	replyID := -manifest.Req
+	if sloppyReply {
+		replyID = manifest.Req
+	}
+	r.NoError(w.WritePacket(codec.Packet{
+		Req:  replyID,
+		Flag: codec.FlagJSON,
+		Body: []byte(`{"hello":"async"}`),
+	}))
+
+	edp := <-handled
+	t.Cleanup(func() { edp.Terminate() })
+	go edp.(Server).Serve()
+
+	r.NoError(w.WritePacket(codec.Packet{
+		Req:  -5, // should be positive
+		Flag: codec.FlagJSON,
+		Body: []byte(`{"name":["hello"],"args":[],"type":"async"}`),
+	}))
+	return edp, rd
+}
+
+func TestLenientRequestIDs(t *testing.T) {
+	r := require.New(t)
+
+	edp, rd := sloppyPeer(t, true, WithLenientRequestIDs())
+
+	reply, err := rd.ReadPacket()
+	r.NoError(err)
+	r.Equal(int32(-5), reply.Req)
+	r.Equal("world", string(reply.Body))
+
+	_, ended := SessionEnd(edp)
+	r.False(ended)
+	r.True(edp.(*rpc).manifest.advertises(Method{"hello"}), "the manifest with the wrong id should be taken")
+}
+
+func TestStrictRequestIDs(t *testing.T) {
+	r := require.New(t)
+
+	edp, _ := sloppyPeer(t, false, WithStrictPackets())
+	r.Eventually(func() bool {
+		_, ended := SessionEnd(edp)
+		return ended
+	}, 5*time.Second, 10*time.Millisecond, "the session should end on the bad id")
+	end, _ := SessionEnd(edp)
+	r.True(errors.Is(end, ErrProtocolViolation), "unexpected end: %v", end)
+}
@@ -0,0 +1,23 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import "sync/atomic"
+
+// MarkLive marks an incoming stream as long-lived, like a source that keeps sending new messages as they arrive (live: true).
+// Such streams can be quiet for a long time, so WithStreamIdleTimeout and WithRequestTTL don't end them.
+// Handlers should watch ConsumerGone instead, to stop producing once the remote lost interest.
+func (req *Request) MarkLive() {
+	atomic.StoreUint32(&req.state.live, 1)
+}
+
+// IsLive returns true if the stream was marked with MarkLive
+func (req *Request) IsLive() bool {
+	return atomic.LoadUint32(&req.state.live) == 1
+}
+
+// ConsumerGone is closed once the stream ended: the remote closed or aborted it, the session ended, or it was closed on our side.
+// Handlers of live streams use it to unsubscribe from whatever feeds the stream.
+func (req *Request) ConsumerGone() <-chan struct{} {
+	return req.sink.streamCtx.Done()
+}
@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+	"go.mindeco.de/log/level"
+)
+
+// ErrMalformedPacket matches the errors of streams whose packets couldn't be parsed, like a new call that isn't valid JSON or an end packet with a broken error body.
+// Only the request the packet belongs to fails with it, the session goes on.
+var ErrMalformedPacket = errors.New("muxrpc: malformed packet")
+
+// malformedError is the parse error of a packet whose body was skipped completely, so that the next header can be read.
+type malformedError struct {
+	req int32
+	err error
+}
+
+func (e malformedError) Error() string {
+	return fmt.Sprintf("muxrpc: malformed packet on req %d: %s", e.req, e.err)
+}
+
+func (e malformedError) Unwrap() error { return e.err }
+
+func (e malformedError) Is(target error) bool { return target == ErrMalformedPacket }
+
+// skipMalformed discards what's left of the body in rd after parsing it failed with err.
+// If that works, the packet can be dropped and malformed wraps err. Otherwise the connection is broken and fatal is the read error.
+func skipMalformed(req int32, rd io.Reader, err error) (malformed, fatal error) {
+	if _, rerr := io.Copy(ioutil.Discard, rd); rerr != nil {
+		return nil, fmt.Errorf("%s (skipping the body failed: %w)", err, rerr)
+	}
+	return malformedError{req: req, err: err}, nil
+}
+
+// refuseMalformed answers a new call whose request couldn't be parsed with an error and ignores the rest of its packets.
+// Needs to be called with rLock held.
+func (r *rpc) refuseMalformed(hdr *codec.Header, me malformedError) error {
+	level.Warn(r.logger).Log("event", "malformed request", "req", hdr.Req, "len", hdr.Len, "flags", hdr.Flag, "err", me.err)
+
+	errPkt, err := newEndErrPacket(hdr.Req, hdr.Flag.Get(codec.FlagStream), me)
+	if err != nil {
+		return err
+	}
+	if err := r.pkr.w.WritePacket(errPkt); err != nil {
+		return err
+	}
+	r.reqsClosed[hdr.Req] = closedLocally
+	return nil
+}
@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+)
+
+// rawPeer answers the manifest call of a served endpoint and returns the codec of the other end of the connection
+func rawPeer(t *testing.T, h Handler, opts ...HandleOption) (Endpoint, *codec.Reader, *codec.Writer) {
+	r := require.New(t)
+
+	c1, c2 := loPipe(t)
+	handled := make(chan Endpoint)
+	go func() {
+		handled <- Handle(NewPacker(c1), h, opts...)
+	}()
+
+	rd, w := codec.NewReader(c2), codec.NewWriter(c2)
+	manifest, err := rd.ReadPacket()
+	r.NoError(err)
+	r.NoError(w.WritePacket(codec.Packet{
+		Req:  -manifest.Req,
+		Flag: codec.FlagJSON,
+		Body: []byte(`{"hello":"async","things":"source"}`),
+	}))
+
+	edp := <-handled
+	t.Cleanup(func() { edp.Terminate() })
+	go edp.(Server).Serve()
+	return edp, rd, w
+}
+
+func TestMalformedPackets(t *testing.T) {
+	r := require.New(t)
+
+	var fh FakeHandler
+	fh.HandledCalls(methodChecker("hello"))
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		req.Return(ctx, "world")
+	})
+	edp, rd, w := rawPeer(t, &fh)
+	ctx := context.Background()
+
+	checkAlive := func(id int32) {
+		r.NoError(w.WritePacket(codec.Packet{
+			Req:  id,
+			Flag: codec.FlagJSON,
+			Body: []byte(`{"name":["hello"],"args":[],"type":"async"}`),
+		}))
+		reply, err := rd.ReadPacket()
+		r.NoError(err)
+		r.Equal(-id, reply.Req)
+		r.Equal("world", string(reply.Body))
+
+		_, ended := SessionEnd(edp)
+		r.False(ended)
+	}
+
+	// a call that isn't JSON is refused
+	r.NoError(w.WritePacket(codec.Packet{
+		Req:  1,
+		Flag: codec.FlagJSON | codec.FlagStream,
+		Body: []byte(`{"name":["hello"],`),
+	}))
+	refused, err := rd.ReadPacket()
+	r.NoError(err)
+	r.Equal(int32(-1), refused.Req)
+	r.True(refused.Flag.Get(codec.FlagEndErr))
+	r.True(refused.Flag.Get(codec.FlagStream))
+	var ce CallError
+	r.NoError(json.Unmarshal(refused.Body, &ce))
+	r.Contains(ce.Message, "malformed packet")
+
+	// the rest of that stream is dropped
+	r.NoError(w.WritePacket(codec.Packet{
+		Req:  1,
+		Flag: codec.FlagStream,
+		Body: []byte("more"),
+	}))
+	checkAlive(2)
+
+	// end packets with broken bodies only end their stream
+	for i, body := range []string{`{"message":`, "nope"} {
+		src, err := edp.Source(ctx, TypeJSON, Method{"things"})
+		r.NoError(err)
+
+		call, err := rd.ReadPacket()
+		r.NoError(err)
+		r.NoError(w.WritePacket(codec.Packet{
+			Req:  -call.Req,
+			Flag: codec.FlagJSON | codec.FlagStream | codec.FlagEndErr,
+			Body: []byte(body),
+		}))
+
+		r.False(src.Next(ctx))
+		r.True(errors.Is(src.Err(), ErrMalformedPacket), "unexpected error: %v", src.Err())
+
+		// our end of the stream is closed as well
+		end, err := rd.ReadPacket()
+		r.NoError(err)
+		r.Equal(call.Req, end.Req)
+		r.True(end.Flag.Get(codec.FlagEndErr))
+
+		checkAlive(int32(3 + i))
+	}
+}
@@ -0,0 +1,218 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"go.mindeco.de/log/level"
+)
+
+// ErrMemoryExceeded ends the streams that were shed because a MemoryBudget ran out, see MemoryShed.
+var ErrMemoryExceeded = errors.New("muxrpc: memory budget exceeded")
+
+// MemoryPolicy decides what happens when the bytes buffered for incoming streams would exceed a MemoryBudget.
+type MemoryPolicy uint
+
+const (
+	// MemoryBackpressure stops reading from the connection until the streams were read far enough.
+	// muxrpc has no flow control per stream, so this pauses all streams of the session (and of all sessions sharing the budget),
+	// which can deadlock consumers that only read one stream once another one made progress.
+	MemoryBackpressure MemoryPolicy = iota
+
+	// MemoryShed closes the stream that buffers the most bytes with ErrMemoryExceeded and drops the frames it didn't read yet.
+	MemoryShed
+)
+
+// MemoryBudget caps the bytes that are buffered for incoming streams, because their consumers didn't read them yet.
+// One budget can be shared by many endpoints to put a cap on the whole process, see WithMemoryBudget.
+// The cap is a soft one: a frame is always accepted if nothing else is buffered, and endpoints sharing a budget can overshoot by a frame each.
+type MemoryBudget struct {
+	max    int64
+	policy MemoryPolicy
+
+	mu      sync.Mutex
+	used    int64
+	streams map[*memCharge]struct{} // the streams that hold some of used, for shedding
+	freed   chan struct{}           // closed and replaced once bytes were released, if someone waits for it
+	waiting bool
+}
+
+// NewMemoryBudget returns a budget for max bytes that applies policy once it is exhausted.
+func NewMemoryBudget(max int64, policy MemoryPolicy) *MemoryBudget {
+	return &MemoryBudget{
+		max:     max,
+		policy:  policy,
+		streams: make(map[*memCharge]struct{}),
+		freed:   make(chan struct{}),
+	}
+}
+
+// Used returns the bytes that are currently buffered by the streams using the budget
+func (b *MemoryBudget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// Max returns the size of the budget
+func (b *MemoryBudget) Max() int64 { return b.max }
+
+// WithMemoryBudget counts the bytes buffered for the incoming streams of the endpoint against b.
+// It can be used more than once, for example with one budget for the endpoint and one shared by all of them.
+func WithMemoryBudget(b *MemoryBudget) HandleOption {
+	return func(r *rpc) {
+		r.budgets = append(r.budgets, b)
+	}
+}
+
+// WithMemoryLimit caps the bytes buffered for the incoming streams of the endpoint at max, see MemoryBudget.
+func WithMemoryLimit(max int64, policy MemoryPolicy) HandleOption {
+	return WithMemoryBudget(NewMemoryBudget(max, policy))
+}
+
+// BufferedBytes returns how many bytes the endpoint currently holds for incoming frames that weren't read yet.
+// It returns -1 for endpoints not created by Handle.
+func BufferedBytes(edp Endpoint) int64 {
+	r, ok := edp.(interface{ bufferedBytes() int64 })
+	if !ok {
+		return -1
+	}
+	return r.bufferedBytes()
+}
+
+func (r *rpc) bufferedBytes() int64 { return atomic.LoadInt64(&r.buffered) }
+
+// memCharge counts the bytes buffered by one stream, against the endpoint and its budgets
+type memCharge struct {
+	held     int64  // accessed atomically
+	endpoint *int64 // the total of the endpoint
+	budgets  []*MemoryBudget
+
+	shed func(error)
+}
+
+func (mc *memCharge) charge(n int) {
+	atomic.AddInt64(&mc.held, int64(n))
+	atomic.AddInt64(mc.endpoint, int64(n))
+	for _, b := range mc.budgets {
+		b.charge(mc, int64(n))
+	}
+}
+
+func (mc *memCharge) uncharge(n int) {
+	atomic.AddInt64(&mc.held, -int64(n))
+	atomic.AddInt64(mc.endpoint, -int64(n))
+	for _, b := range mc.budgets {
+		b.uncharge(mc, int64(n))
+	}
+}
+
+func (b *MemoryBudget) charge(mc *memCharge, n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used += n
+	b.streams[mc] = struct{}{}
+}
+
+func (b *MemoryBudget) uncharge(mc *memCharge, n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+	if atomic.LoadInt64(&mc.held) <= 0 {
+		delete(b.streams, mc)
+	}
+	if b.waiting {
+		close(b.freed)
+		b.freed = make(chan struct{})
+		b.waiting = false
+	}
+}
+
+// reserve waits or sheds streams until n more bytes fit into the budget
+func (b *MemoryBudget) reserve(ctx context.Context, n int64) error {
+	var shed map[*memCharge]struct{}
+	for {
+		b.mu.Lock()
+		if b.used == 0 || b.used+n <= b.max {
+			b.mu.Unlock()
+			return nil
+		}
+
+		if b.policy == MemoryBackpressure {
+			freed := b.freed
+			b.waiting = true
+			b.mu.Unlock()
+			select {
+			case <-freed:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+
+		var (
+			victim *memCharge
+			most   int64
+		)
+		for mc := range b.streams {
+			if _, done := shed[mc]; done {
+				continue
+			}
+			if held := atomic.LoadInt64(&mc.held); victim == nil || held > most {
+				victim, most = mc, held
+			}
+		}
+		b.mu.Unlock()
+
+		if victim == nil {
+			// what's left can't be shed, like frames that are being read right now
+			return nil
+		}
+		if shed == nil {
+			shed = make(map[*memCharge]struct{})
+		}
+		shed[victim] = struct{}{}
+		victim.shed(ErrMemoryExceeded)
+	}
+}
+
+// reserveMemory makes room for a frame of n bytes in the budgets of the endpoint
+func (r *rpc) reserveMemory(n uint32) error {
+	for _, b := range r.budgets {
+		if err := b.reserve(r.serveCtx, int64(n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trackMemory makes the source of req count what it buffers.
+// It's called where requests are created, before the serve loop or a handler can see them.
+func (r *rpc) trackMemory(req *Request) {
+	var once sync.Once
+	req.source.buf.track(&memCharge{
+		endpoint: &r.buffered,
+		budgets:  r.budgets,
+		shed: func(err error) {
+			once.Do(func() { r.shedStream(req, err) })
+		},
+	})
+}
+
+// shedStream drops what the source of req buffered and closes the stream with err, if it is still open
+func (r *rpc) shedStream(req *Request, err error) {
+	req.source.drop(err)
+	level.Warn(r.logger).Log("event", "stream shed", "req", req.id, "trace", req.trace, "method", req.Method.String())
+
+	r.rLock.RLock()
+	active := r.reqs[req.id] == req
+	r.rLock.RUnlock()
+	if active {
+		// closing can wait for the connection, don't hold up the serve loop that needs the memory
+		go r.closeStream(req, err)
+	}
+}
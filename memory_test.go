@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// framesHandler answers source calls to "one", "two" and "five" with that many frames of 100 bytes
+func framesHandler() *FakeHandler {
+	counts := map[string]int{"one": 1, "two": 2, "five": 5}
+
+	var fh FakeHandler
+	fh.HandledCalls(func(m Method) bool {
+		_, ok := counts[m.String()]
+		return ok
+	})
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		snk, err := req.ResponseSink()
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		snk.SetEncoding(TypeBinary)
+		for i := 0; i < counts[req.Method.String()]; i++ {
+			if _, err := snk.Write(bytes.Repeat([]byte{byte(i)}, 100)); err != nil {
+				return
+			}
+		}
+		snk.Close()
+	})
+	return &fh
+}
+
+func TestMemoryShed(t *testing.T) {
+	r := require.New(t)
+
+	budget := NewMemoryBudget(250, MemoryShed)
+	rpc1, _ := handledPair(t, &FakeHandler{}, framesHandler(), []HandleOption{WithMemoryBudget(budget)}, nil)
+
+	ctx := context.Background()
+	big, err := rpc1.Source(ctx, TypeBinary, Method{"two"})
+	r.NoError(err)
+	r.Eventually(func() bool { return BufferedBytes(rpc1) == 200 }, time.Second, 5*time.Millisecond)
+	r.EqualValues(200, budget.Used())
+
+	// the next frame doesn't fit, so the stream that holds the most is dropped
+	small, err := rpc1.Source(ctx, TypeBinary, Method{"one"})
+	r.NoError(err)
+	r.True(small.Next(ctx))
+	b, err := small.Bytes()
+	r.NoError(err)
+	r.Len(b, 100)
+	r.False(small.Next(ctx))
+	r.NoError(small.Err())
+
+	r.False(big.Next(ctx))
+	r.True(errors.Is(big.Err(), ErrMemoryExceeded), "unexpected error: %v", big.Err())
+
+	r.Eventually(func() bool { return BufferedBytes(rpc1) == 0 }, time.Second, 5*time.Millisecond)
+	r.EqualValues(0, budget.Used())
+	r.EqualValues(-1, BufferedBytes(&FakeEndpoint{}))
+}
+
+func TestMemoryBackpressure(t *testing.T) {
+	r := require.New(t)
+
+	budget := NewMemoryBudget(250, MemoryBackpressure)
+	rpc1, _ := handledPair(t, &FakeHandler{}, framesHandler(), []HandleOption{WithMemoryBudget(budget)}, nil)
+
+	ctx := context.Background()
+	src, err := rpc1.Source(ctx, TypeBinary, Method{"five"})
+	r.NoError(err)
+
+	// the third frame waits until the first ones were read
+	r.Eventually(func() bool { return budget.Used() == 200 }, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	r.EqualValues(200, budget.Used())
+
+	var n int
+	for src.Next(ctx) {
+		b, err := src.Bytes()
+		r.NoError(err)
+		r.Equal(bytes.Repeat([]byte{byte(n)}, 100), b)
+		n++
+	}
+	r.NoError(src.Err())
+	r.Equal(5, n)
+	r.EqualValues(0, budget.Used())
+	r.EqualValues(0, BufferedBytes(rpc1))
+}
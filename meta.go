@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import "sync"
+
+// MetaKey identifies a value in the per-connection metadata of an endpoint, see SetMeta and GetMeta.
+// The type parameter fixes the type of the value. Keys are compared by identity,
+// so create them once with NewMetaKey, usually as package variables.
+type MetaKey[T any] struct {
+	name string
+}
+
+// NewMetaKey returns a new key. The name is only used for debugging.
+func NewMetaKey[T any](name string) *MetaKey[T] {
+	return &MetaKey[T]{name: name}
+}
+
+func (k *MetaKey[T]) String() string { return k.name }
+
+// metadata holds the values of an endpoint, it lives as long as the endpoint.
+type metadata struct {
+	mu     sync.Mutex
+	values map[interface{}]interface{}
+}
+
+// metaHolder is implemented by the endpoints returned from Handle
+type metaHolder interface {
+	metadata() *metadata
+}
+
+// SetMeta stores value under key on the endpoint, so that handlers can keep per-peer state
+// (like negotiated versions or an auth level) without maintaining a map keyed by endpoints.
+// It returns false if edp doesn't support metadata, which is only the case for endpoints not created by Handle.
+func SetMeta[T any](edp Endpoint, key *MetaKey[T], value T) bool {
+	mh, ok := edp.(metaHolder)
+	if !ok {
+		return false
+	}
+	md := mh.metadata()
+	md.mu.Lock()
+	defer md.mu.Unlock()
+	if md.values == nil {
+		md.values = make(map[interface{}]interface{})
+	}
+	md.values[key] = value
+	return true
+}
+
+// GetMeta returns the value stored under key on the endpoint and whether there was one.
+func GetMeta[T any](edp Endpoint, key *MetaKey[T]) (T, bool) {
+	var zero T
+	mh, ok := edp.(metaHolder)
+	if !ok {
+		return zero, false
+	}
+	md := mh.metadata()
+	md.mu.Lock()
+	defer md.mu.Unlock()
+	v, has := md.values[key]
+	if !has {
+		return zero, false
+	}
+	return v.(T), true
+}
+
+// DeleteMeta removes the value stored under key from the endpoint.
+func DeleteMeta[T any](edp Endpoint, key *MetaKey[T]) {
+	mh, ok := edp.(metaHolder)
+	if !ok {
+		return
+	}
+	md := mh.metadata()
+	md.mu.Lock()
+	defer md.mu.Unlock()
+	delete(md.values, key)
+}
@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var authLevelKey = NewMetaKey[int]("authLevel")
+
+func TestEndpointMeta(t *testing.T) {
+	r := require.New(t)
+
+	var fh1, fh2 FakeHandler
+	fh2.HandledReturns(true)
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		switch req.Method.String() {
+		case "login":
+			SetMeta(req.Endpoint(), authLevelKey, 3)
+			req.Return(ctx, "ok")
+		case "level":
+			lvl, ok := GetMeta(req.Endpoint(), authLevelKey)
+			if !ok {
+				req.CloseWithError(ErrNoSuchMethod{Method: req.Method})
+				return
+			}
+			req.Return(ctx, lvl)
+		default:
+			req.CloseWithError(ErrNoSuchMethod{Method: req.Method})
+		}
+	})
+
+	rpc1, rpc2 := connectedPair(t, &fh1, &fh2)
+
+	ctx := context.Background()
+	var lvl int
+	err := rpc1.Async(ctx, &lvl, TypeJSON, Method{"level"})
+	r.Error(err, "no level before login")
+
+	var ok string
+	r.NoError(rpc1.Async(ctx, &ok, TypeString, Method{"login"}))
+
+	r.NoError(rpc1.Async(ctx, &lvl, TypeJSON, Method{"level"}))
+	r.Equal(3, lvl)
+
+	got, has := GetMeta(rpc2, authLevelKey)
+	r.True(has)
+	r.Equal(3, got)
+
+	_, has = GetMeta(rpc1, authLevelKey)
+	r.False(has, "metadata is per endpoint")
+
+	DeleteMeta(rpc2, authLevelKey)
+	_, has = GetMeta(rpc2, authLevelKey)
+	r.False(has)
+
+	r.False(SetMeta(new(FakeEndpoint), authLevelKey, 1))
+}
@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: MIT
+
+// Package mock provides fakes of the muxrpc interfaces, so that handlers and callers can be unit-tested without real connections.
+//
+// The fakes are generated with counterfeiter: set the behaviour with the Returns and Calls methods
+// and check the calls with CallCount and ArgsForCall.
+//
+//	var edp mock.FakeEndpoint
+//	edp.AsyncCalls(func(ctx context.Context, ret interface{}, enc muxrpc.RequestEncoding, m muxrpc.Method, args ...interface{}) error {
+//		*ret.(*string) = "alice"
+//		return nil
+//	})
+//	name, err := lookupName(ctx, &edp) // the code under test
+package mock
+
+import (
+	"io"
+
+	"go.cryptoscope.co/muxrpc/v2"
+)
+
+//go:generate counterfeiter -o fakestream.go go.cryptoscope.co/muxrpc/v2.Stream
+
+// FakeEndpoint is a fake muxrpc.Endpoint. It is the same type the muxrpc package uses for its own tests.
+type FakeEndpoint = muxrpc.FakeEndpoint
+
+// FakeHandler is a fake muxrpc.Handler. It is the same type the muxrpc package uses for its own tests.
+type FakeHandler = muxrpc.FakeHandler
+
+// NewSource returns a source that yields frames and then ends, for faking the results of Source and Duplex calls
+func NewSource(frames ...[]byte) *muxrpc.ByteSource {
+	src := muxrpc.NewTestSource(frames...)
+	src.Cancel(nil)
+	return src
+}
+
+// NewSink returns a sink that writes the packets of the stream to w, for faking Sink and Duplex calls
+func NewSink(w io.Writer) *muxrpc.ByteSink {
+	return muxrpc.NewTestSink(w)
+}
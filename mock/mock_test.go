@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: MIT
+
+package mock
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2"
+	"go.cryptoscope.co/muxrpc/v2/codec"
+)
+
+func TestFakeEndpoint(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	var edp FakeEndpoint
+	edp.AsyncCalls(func(ctx context.Context, ret interface{}, enc muxrpc.RequestEncoding, m muxrpc.Method, args ...interface{}) error {
+		*ret.(*string) = "alice"
+		return nil
+	})
+	edp.SourceReturns(NewSource([]byte("a"), []byte("b")), nil)
+
+	var caller muxrpc.Endpoint = &edp
+	var name string
+	r.NoError(caller.Async(ctx, &name, muxrpc.TypeString, muxrpc.Method{"whoami"}))
+	r.Equal("alice", name)
+	r.Equal(1, edp.AsyncCallCount())
+	_, _, _, m, _ := edp.AsyncArgsForCall(0)
+	r.Equal("whoami", m.String())
+
+	src, err := caller.Source(ctx, muxrpc.TypeString, muxrpc.Method{"letters"})
+	r.NoError(err)
+	var got []string
+	for src.Next(ctx) {
+		b, err := src.Bytes()
+		r.NoError(err)
+		got = append(got, string(b))
+	}
+	r.NoError(src.Err())
+	r.Equal([]string{"a", "b"}, got)
+}
+
+func TestNewSink(t *testing.T) {
+	r := require.New(t)
+
+	var buf bytes.Buffer
+	snk := NewSink(&buf)
+	snk.SetEncoding(muxrpc.TypeString)
+	_, err := snk.Write([]byte("hello"))
+	r.NoError(err)
+
+	pkt, err := codec.NewReader(&buf).ReadPacket()
+	r.NoError(err)
+	r.Equal("hello", string(pkt.Body))
+	r.True(pkt.Flag.Get(codec.FlagString))
+}
+
+func TestFakeStream(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	var s FakeStream
+	s.NextReturnsOnCall(0, "first", nil)
+	var stream muxrpc.Stream = &s
+
+	v, err := stream.Next(ctx)
+	r.NoError(err)
+	r.Equal("first", v)
+
+	r.NoError(stream.Pour(ctx, 42))
+	r.Equal(1, s.PourCallCount())
+	_, poured := s.PourArgsForCall(0)
+	r.Equal(42, poured)
+}
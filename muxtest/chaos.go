@@ -0,0 +1,213 @@
+// SPDX-License-Identifier: MIT
+
+package muxtest
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+)
+
+// ErrChaosDropped is returned by writes on a ChaosConn after its policy dropped the connection.
+var ErrChaosDropped = errors.New("muxtest: connection dropped by chaos policy")
+
+// ChaosPolicy describes how a ChaosConn misbehaves.
+// All random decisions are drawn from a source seeded with Seed,
+// so the same sequence of writes misbehaves the same way again.
+type ChaosPolicy struct {
+	Seed int64
+
+	// MaxDelay holds every outgoing packet back for a random duration up to this value.
+	MaxDelay time.Duration
+
+	// ReorderWindow lets a packet overtake up to this many packets that were written before it.
+	// Packets of the same request are never reordered, the stream protocol relies on their order.
+	ReorderWindow int
+
+	// TruncateProb is the probability per packet that only a random prefix of it is sent before the connection is closed.
+	TruncateProb float64
+
+	// DropProb is the probability per packet that the connection is closed before it is sent.
+	DropProb float64
+}
+
+// ChaosConn wraps a connection and applies a ChaosPolicy to the muxrpc packets written to it.
+// Writes are queued and sent by a background goroutine, reads are passed through untouched.
+type ChaosConn struct {
+	net.Conn
+
+	policy ChaosPolicy
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	rand    *rand.Rand
+	partial []byte // bytes of an incomplete packet
+	queue   []chaosPacket
+	closing bool
+	err     error // set once the underlying conn failed or was dropped
+	done    chan struct{}
+}
+
+type chaosPacket struct {
+	req  int32
+	end  bool // the goodbye packet that ends the session
+	data []byte
+	due  time.Time
+}
+
+// Chaos wraps conn so that writes to it follow policy.
+// Use it as the connection of a Packer: muxrpc.NewPacker(muxtest.Chaos(conn, policy))
+func Chaos(conn net.Conn, policy ChaosPolicy) *ChaosConn {
+	c := &ChaosConn{
+		Conn:   conn,
+		policy: policy,
+		rand:   rand.New(rand.NewSource(policy.Seed)),
+		done:   make(chan struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	go c.sendLoop()
+	return c
+}
+
+const headerLen = 9
+
+// Write splits b into packets and queues them for sending.
+func (c *ChaosConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.closing {
+		return 0, io.ErrClosedPipe
+	}
+
+	c.partial = append(c.partial, b...)
+	for len(c.partial) >= headerLen {
+		var hdr codec.Header
+		hdr.Flag = codec.Flag(c.partial[0])
+		hdr.Len = binary.BigEndian.Uint32(c.partial[1:5])
+		hdr.Req = int32(binary.BigEndian.Uint32(c.partial[5:9]))
+
+		pktLen := headerLen + int(hdr.Len)
+		if len(c.partial) < pktLen {
+			break
+		}
+
+		data := make([]byte, pktLen)
+		copy(data, c.partial)
+		c.partial = c.partial[pktLen:]
+
+		var delay time.Duration
+		if c.policy.MaxDelay > 0 {
+			delay = time.Duration(c.rand.Int63n(int64(c.policy.MaxDelay)))
+		}
+		c.queue = append(c.queue, chaosPacket{
+			req:  hdr.Req,
+			end:  hdr == codec.Header{},
+			data: data,
+			due:  time.Now().Add(delay),
+		})
+	}
+	c.cond.Broadcast()
+	return len(b), nil
+}
+
+// Close waits until the queued packets are sent and closes the underlying connection.
+func (c *ChaosConn) Close() error {
+	c.mu.Lock()
+	c.closing = true
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	<-c.done
+	return c.Conn.Close()
+}
+
+// next picks the packet to send next. It is called with the lock held.
+func (c *ChaosConn) next() int {
+	window := c.policy.ReorderWindow
+	if window >= len(c.queue) {
+		window = len(c.queue) - 1
+	}
+
+	pick := 0
+	seen := make(map[int32]struct{}, window+1)
+	for i := 0; i <= window; i++ {
+		p := c.queue[i]
+		if _, blocked := seen[p.req]; blocked {
+			continue
+		}
+		seen[p.req] = struct{}{}
+		if p.end && i > 0 {
+			break // nothing overtakes the end of the session
+		}
+		if p.due.Before(c.queue[pick].due) {
+			pick = i
+		}
+	}
+	return pick
+}
+
+func (c *ChaosConn) sendLoop() {
+	defer close(c.done)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		for len(c.queue) == 0 && !c.closing && c.err == nil {
+			c.cond.Wait()
+		}
+		if c.err != nil || len(c.queue) == 0 {
+			return
+		}
+
+		i := c.next()
+		pkt := c.queue[i]
+		if wait := time.Until(pkt.due); wait > 0 {
+			// unlock while waiting so that new packets can be queued, they might be due earlier
+			c.mu.Unlock()
+			time.Sleep(wait)
+			c.mu.Lock()
+			continue
+		}
+		c.queue = append(c.queue[:i], c.queue[i+1:]...)
+
+		var data []byte
+		drop := c.rand.Float64() < c.policy.DropProb
+		if !drop {
+			data = pkt.data
+			if c.rand.Float64() < c.policy.TruncateProb {
+				data = data[:c.rand.Intn(len(data))]
+				drop = true
+			}
+		}
+		if drop {
+			// fail new writes before the other side notices
+			c.err = ErrChaosDropped
+			c.queue = nil
+		}
+
+		c.mu.Unlock()
+		_, err := c.Conn.Write(data)
+		if drop {
+			c.Conn.Close()
+		}
+		c.mu.Lock()
+
+		if drop {
+			return
+		}
+		if err != nil {
+			c.err = err
+			c.queue = nil
+			return
+		}
+	}
+}
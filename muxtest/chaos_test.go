@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: MIT
+
+package muxtest
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+)
+
+func TestChaosReorderKeepsRequestOrder(t *testing.T) {
+	r := require.New(t)
+	a, b := Pipe(Options{})
+
+	c := Chaos(a, ChaosPolicy{
+		Seed:          42,
+		MaxDelay:      5 * time.Millisecond,
+		ReorderWindow: 4,
+	})
+
+	go func() {
+		w := codec.NewWriter(c)
+		for i := 0; i < 20; i++ {
+			req := int32(i%3 + 1)
+			w.WritePacket(codec.Packet{Flag: codec.FlagStream, Req: req, Body: []byte{byte(i)}})
+		}
+		w.Close()
+	}()
+
+	pkts, err := codec.ReadAllPackets(codec.NewReader(b))
+	r.NoError(err)
+	r.Len(pkts, 20)
+
+	var reordered bool
+	last := map[int32]int{}
+	for i, p := range pkts {
+		seq := int(p.Body[0])
+		if prev, has := last[p.Req]; has {
+			r.Less(prev, seq, "packets of request %d were reordered", p.Req)
+		}
+		last[p.Req] = seq
+		if seq != i {
+			reordered = true
+		}
+	}
+	r.True(reordered, "expected at least some packets to be reordered")
+}
+
+func TestChaosDrop(t *testing.T) {
+	r := require.New(t)
+	a, b := Pipe(Options{})
+
+	c := Chaos(a, ChaosPolicy{DropProb: 1})
+
+	w := codec.NewWriter(c)
+	r.NoError(w.WritePacket(codec.Packet{Req: 1, Body: []byte("lost")}))
+
+	_, err := codec.NewReader(b).ReadPacket()
+	r.Equal(io.EOF, err)
+
+	err = w.WritePacket(codec.Packet{Req: 1, Body: []byte("too late")})
+	r.True(errors.Is(err, ErrChaosDropped), "expected %v", ErrChaosDropped)
+}
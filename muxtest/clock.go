@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: MIT
+
+package muxtest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"go.cryptoscope.co/muxrpc/v2"
+)
+
+// FakeClock is a muxrpc.Clock that only moves when Advance is called,
+// so tests of timeouts and watchdogs don't have to sleep. Pass it to muxrpc.WithClock or the Clock of a policy.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	due   time.Time
+	f     func()
+}
+
+// NewFakeClock returns a clock that starts at start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+var _ muxrpc.Clock = (*FakeClock)(nil)
+
+// Now returns the current virtual time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// AfterFunc calls f once the clock was advanced by d
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) muxrpc.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, due: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Timers returns how many AfterFunc calls are pending.
+// Use it to wait until the code under test set up its timers, before advancing the clock.
+func (c *FakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// Advance moves the clock forward by d and calls the functions of the timers that are due, in the order they are due.
+// Unlike with real timers, they are called one after the other before Advance returns,
+// which includes timers they set up themselves if those are due as well.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].due.Before(c.timers[j].due) })
+		if len(c.timers) == 0 || c.timers[0].due.After(target) {
+			break
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		c.now = t.due
+
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+	c.now = target
+	c.mu.Unlock()
+}
+
+// Stop removes the timer. It returns false if it already fired or was stopped.
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
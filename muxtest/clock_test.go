@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: MIT
+
+package muxtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2"
+)
+
+func TestFakeClock(t *testing.T) {
+	r := require.New(t)
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := NewFakeClock(start)
+
+	var fired []string
+	clk.AfterFunc(2*time.Second, func() { fired = append(fired, "two") })
+	clk.AfterFunc(time.Second, func() {
+		fired = append(fired, "one")
+		clk.AfterFunc(500*time.Millisecond, func() { fired = append(fired, "one and a half") })
+	})
+	stopped := clk.AfterFunc(time.Second, func() { fired = append(fired, "stopped") })
+	r.True(stopped.Stop())
+	r.False(stopped.Stop())
+	r.Equal(2, clk.Timers())
+
+	clk.Advance(time.Second)
+	r.Equal([]string{"one"}, fired)
+	r.Equal(start.Add(time.Second), clk.Now())
+
+	clk.Advance(time.Hour)
+	r.Equal([]string{"one", "one and a half", "two"}, fired)
+	r.Equal(0, clk.Timers())
+	r.Equal(start.Add(time.Hour+time.Second), clk.Now())
+}
+
+func TestFakeClockCallTimeout(t *testing.T) {
+	r := require.New(t)
+
+	clk := NewFakeClock(time.Now())
+
+	started := make(chan struct{})
+	var h muxrpc.FakeHandler
+	h.HandledCalls(func(m muxrpc.Method) bool { return m.String() == "slow" })
+	h.HandleCallCalls(func(ctx context.Context, req *muxrpc.Request) {
+		close(started)
+		<-ctx.Done() // never replies on its own
+	})
+
+	p := LoopbackPair(t, nil, &h, muxrpc.WithClock(clk), muxrpc.WithCallTimeout(time.Minute))
+
+	errc := make(chan error, 1)
+	go func() {
+		var resp string
+		errc <- p.A.Async(context.TODO(), &resp, muxrpc.TypeString, muxrpc.Method{"slow"})
+	}()
+
+	// the watchdog of B is armed before the handler is called
+	<-started
+	select {
+	case err := <-errc:
+		t.Fatalf("call ended before the timeout: %v", err)
+	default:
+	}
+
+	clk.Advance(time.Minute)
+	err := <-errc
+	var ce *muxrpc.CallError
+	r.True(errors.As(err, &ce), "unexpected error: %v", err)
+}
@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: MIT
+
+package muxtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"go.cryptoscope.co/muxrpc/v2"
+)
+
+// Pair are two endpoints talking to each other over a Pipe
+type Pair struct {
+	A, B         muxrpc.Endpoint
+	ConnA, ConnB *Conn
+
+	wg   sync.WaitGroup
+	errs [2]error
+}
+
+// Connect handles h1 and h2 on the two ends of a new Pipe and runs their Serve loops in the background.
+// Both sides are set up concurrently, since Handle waits for the manifest of the other side.
+func Connect(h1, h2 muxrpc.Handler, opts Options, hopts ...muxrpc.HandleOption) *Pair {
+	var p Pair
+	p.ConnA, p.ConnB = Pipe(opts)
+
+	started := make(chan struct{})
+	go func() {
+		p.B = muxrpc.Handle(muxrpc.NewPacker(p.ConnB), h2, hopts...)
+		close(started)
+	}()
+	p.A = muxrpc.Handle(muxrpc.NewPacker(p.ConnA), h1, hopts...)
+	<-started
+
+	for i, edp := range []muxrpc.Endpoint{p.A, p.B} {
+		srv, ok := edp.(muxrpc.Server)
+		if !ok {
+			panic(fmt.Sprintf("muxtest: endpoint %T can't be served", edp))
+		}
+		p.wg.Add(1)
+		go func(i int, srv muxrpc.Server) {
+			defer p.wg.Done()
+			err := srv.Serve()
+			if err != nil && !errors.Is(err, context.Canceled) {
+				p.errs[i] = fmt.Errorf("muxtest: serve of endpoint %d failed: %w", i, err)
+			}
+		}(i, srv)
+	}
+
+	return &p
+}
+
+// LoopbackPair connects h1 and h2 like Connect over a Pipe without latency or limits, for tests.
+// A nil handler is replaced by one that doesn't handle any calls.
+// Both endpoints are terminated when the test ends, and failures of their Serve loops are reported to t.
+func LoopbackPair(t testing.TB, h1, h2 muxrpc.Handler, hopts ...muxrpc.HandleOption) *Pair {
+	t.Helper()
+	if h1 == nil {
+		h1 = &muxrpc.FakeHandler{}
+	}
+	if h2 == nil {
+		h2 = &muxrpc.FakeHandler{}
+	}
+
+	p := Connect(h1, h2, Options{}, hopts...)
+	t.Cleanup(func() {
+		if err := p.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return p
+}
+
+// Wait blocks until both Serve loops returned and returns the first of their errors.
+func (p *Pair) Wait() error {
+	p.wg.Wait()
+	for _, err := range p.errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close terminates both endpoints and waits for their Serve loops.
+func (p *Pair) Close() error {
+	p.A.Terminate()
+	p.B.Terminate()
+	return p.Wait()
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: MIT
+
+package muxtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2"
+)
+
+func whoamiHandler() *muxrpc.FakeHandler {
+	var h muxrpc.FakeHandler
+	h.HandledCalls(func(m muxrpc.Method) bool { return m.String() == "whoami" })
+	h.HandleCallCalls(func(ctx context.Context, req *muxrpc.Request) {
+		req.Return(ctx, "you are a test")
+	})
+	return &h
+}
+
+func TestConnectSlowLink(t *testing.T) {
+	r := require.New(t)
+
+	p := Connect(whoamiHandler(), whoamiHandler(), Options{
+		Latency:        10 * time.Millisecond,
+		BytesPerSecond: 64 * 1024,
+		MaxSegment:     5,
+	})
+
+	var resp string
+	err := p.A.Async(context.TODO(), &resp, muxrpc.TypeString, muxrpc.Method{"whoami"})
+	r.NoError(err)
+	r.Equal("you are a test", resp)
+
+	r.NoError(p.Close())
+}
+
+func TestConnectDisconnect(t *testing.T) {
+	r := require.New(t)
+
+	p := Connect(whoamiHandler(), whoamiHandler(), Options{})
+
+	p.ConnA.Disconnect()
+
+	done := make(chan error)
+	go func() { done <- p.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve loops did not return after disconnect")
+	}
+
+	var resp string
+	err := p.B.Async(context.TODO(), &resp, muxrpc.TypeString, muxrpc.Method{"whoami"})
+	r.Error(err)
+}
+
+func TestLoopbackPair(t *testing.T) {
+	r := require.New(t)
+
+	p := LoopbackPair(t, nil, whoamiHandler())
+
+	var resp string
+	err := p.A.Async(context.TODO(), &resp, muxrpc.TypeString, muxrpc.Method{"whoami"})
+	r.NoError(err)
+	r.Equal("you are a test", resp)
+
+	// the nil handler on A doesn't handle anything
+	err = p.B.Async(context.TODO(), &resp, muxrpc.TypeString, muxrpc.Method{"whoami"})
+	r.Error(err)
+}
@@ -0,0 +1,272 @@
+// SPDX-License-Identifier: MIT
+
+// Package muxtest contains helpers for testing code that talks muxrpc.
+//
+// Pipe returns an in-memory connection pair that can simulate a bad network
+// (latency, limited bandwidth, fragmented reads and disconnects) and Connect
+// wires two handlers together over such a pair.
+package muxtest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrDisconnected is returned by reads and writes on a Conn after Disconnect was called on either side.
+var ErrDisconnected = errors.New("muxtest: connection disconnected")
+
+// Options configure the simulated link between the two ends of a Pipe.
+// The zero value is an instant link without faults.
+type Options struct {
+	// Latency is how long written data takes to become readable on the other side.
+	Latency time.Duration
+
+	// BytesPerSecond limits the throughput of each direction.
+	// Writes block until their data is "on the wire". Zero means unlimited.
+	BytesPerSecond int
+
+	// MaxSegment splits writes into segments of at most this many bytes.
+	// A read never spans two segments, so readers see partial packets. Zero disables splitting.
+	MaxSegment int
+
+	// DisconnectAfter calls Disconnect once more than this many bytes were written in total (both directions).
+	// Zero disables it.
+	DisconnectAfter int64
+}
+
+// Pipe returns two connected ends of an in-memory connection.
+// Unlike net.Pipe, writes are buffered and don't wait for the other side to read.
+func Pipe(opts Options) (*Conn, *Conn) {
+	l := &link{opts: opts}
+	ab, ba := newHalf(l), newHalf(l)
+	l.halves = [2]*half{ab, ba}
+
+	a := &Conn{link: l, rx: ba, tx: ab, local: Addr("a"), remote: Addr("b")}
+	b := &Conn{link: l, rx: ab, tx: ba, local: Addr("b"), remote: Addr("a")}
+	return a, b
+}
+
+// Addr is the net.Addr of a Pipe end
+type Addr string
+
+// Network returns "muxtest"
+func (Addr) Network() string { return "muxtest" }
+
+func (a Addr) String() string { return string(a) }
+
+// link is the state shared by both directions
+type link struct {
+	opts   Options
+	halves [2]*half
+
+	mu           sync.Mutex
+	written      int64
+	disconnected bool
+}
+
+// disconnect breaks both directions and wakes up everyone waiting on them
+func (l *link) disconnect() {
+	l.mu.Lock()
+	l.disconnected = true
+	l.mu.Unlock()
+
+	for _, h := range l.halves {
+		h.mu.Lock()
+		h.segments = nil
+		h.mu.Unlock()
+		h.wake()
+	}
+}
+
+func (l *link) isDisconnected() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.disconnected
+}
+
+// account adds n written bytes and reports whether the link is still up
+func (l *link) account(n int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.disconnected {
+		return false
+	}
+	l.written += int64(n)
+	limit := l.opts.DisconnectAfter
+	return limit <= 0 || l.written <= limit
+}
+
+type segment struct {
+	data []byte
+	at   time.Time // when it becomes readable
+}
+
+// half is one direction of the link
+type half struct {
+	link *link
+
+	mu       sync.Mutex
+	segments []segment
+	wireFree time.Time // when the previous write finished transmitting
+	closed   bool      // the writing side closed
+	gone     bool      // the reading side closed
+
+	notify chan struct{}
+}
+
+func newHalf(l *link) *half {
+	return &half{link: l, notify: make(chan struct{}, 1)}
+}
+
+func (h *half) wake() {
+	select {
+	case h.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Conn is one end of a Pipe. It implements net.Conn.
+// Deadlines are accepted but not enforced.
+type Conn struct {
+	link   *link
+	rx, tx *half
+
+	local, remote Addr
+
+	closeOnce sync.Once
+}
+
+var _ net.Conn = (*Conn)(nil)
+
+// Read returns buffered data once its latency expired
+func (c *Conn) Read(b []byte) (int, error) {
+	h := c.rx
+	for {
+		if c.link.isDisconnected() {
+			return 0, ErrDisconnected
+		}
+
+		h.mu.Lock()
+		if h.gone {
+			h.mu.Unlock()
+			return 0, io.ErrClosedPipe
+		}
+		if len(h.segments) == 0 {
+			closed := h.closed
+			h.mu.Unlock()
+			if closed {
+				return 0, io.EOF
+			}
+			<-h.notify
+			continue
+		}
+
+		seg := &h.segments[0]
+		wait := time.Until(seg.at)
+		if wait <= 0 {
+			n := copy(b, seg.data)
+			seg.data = seg.data[n:]
+			if len(seg.data) == 0 {
+				h.segments = h.segments[1:]
+			}
+			h.mu.Unlock()
+			return n, nil
+		}
+		h.mu.Unlock()
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-h.notify:
+			t.Stop()
+		}
+	}
+}
+
+// Write queues b for the other side. It only blocks to honor Options.BytesPerSecond.
+func (c *Conn) Write(b []byte) (int, error) {
+	h := c.tx
+	opts := c.link.opts
+
+	var written int
+	for len(b) > 0 {
+		chunk := b
+		if opts.MaxSegment > 0 && len(chunk) > opts.MaxSegment {
+			chunk = chunk[:opts.MaxSegment]
+		}
+
+		if !c.link.account(len(chunk)) {
+			c.link.disconnect()
+			return written, ErrDisconnected
+		}
+
+		h.mu.Lock()
+		if h.closed || h.gone {
+			h.mu.Unlock()
+			return written, io.ErrClosedPipe
+		}
+
+		now := time.Now()
+		sent := now
+		if opts.BytesPerSecond > 0 {
+			if h.wireFree.After(now) {
+				sent = h.wireFree
+			}
+			sent = sent.Add(time.Duration(len(chunk)) * time.Second / time.Duration(opts.BytesPerSecond))
+			h.wireFree = sent
+		}
+
+		data := make([]byte, len(chunk))
+		copy(data, chunk)
+		h.segments = append(h.segments, segment{data: data, at: sent.Add(opts.Latency)})
+		h.mu.Unlock()
+		h.wake()
+
+		if wait := time.Until(sent); wait > 0 {
+			time.Sleep(wait)
+		}
+
+		written += len(chunk)
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
+
+// Close closes this end. The other side can read what was already written and then gets io.EOF.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.tx.mu.Lock()
+		c.tx.closed = true
+		c.tx.mu.Unlock()
+		c.tx.wake()
+
+		c.rx.mu.Lock()
+		c.rx.gone = true
+		c.rx.segments = nil
+		c.rx.mu.Unlock()
+		c.rx.wake()
+	})
+	return nil
+}
+
+// Disconnect simulates a broken link.
+// Pending data is dropped and all reads and writes on both ends fail with ErrDisconnected.
+func (c *Conn) Disconnect() { c.link.disconnect() }
+
+// LocalAddr returns the address of this end
+func (c *Conn) LocalAddr() net.Addr { return c.local }
+
+// RemoteAddr returns the address of the other end
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
+
+// SetDeadline is a no-op
+func (c *Conn) SetDeadline(time.Time) error { return nil }
+
+// SetReadDeadline is a no-op
+func (c *Conn) SetReadDeadline(time.Time) error { return nil }
+
+// SetWriteDeadline is a no-op
+func (c *Conn) SetWriteDeadline(time.Time) error { return nil }
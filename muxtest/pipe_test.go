@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: MIT
+
+package muxtest
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeLatency(t *testing.T) {
+	r := require.New(t)
+	a, b := Pipe(Options{Latency: 50 * time.Millisecond})
+
+	start := time.Now()
+	n, err := a.Write([]byte("hello"))
+	r.NoError(err)
+	r.Equal(5, n)
+	r.Less(int64(time.Since(start)), int64(50*time.Millisecond), "write should not wait for latency")
+
+	buf := make([]byte, 10)
+	n, err = b.Read(buf)
+	r.NoError(err)
+	r.Equal("hello", string(buf[:n]))
+	r.GreaterOrEqual(int64(time.Since(start)), int64(50*time.Millisecond))
+}
+
+func TestPipeBandwidth(t *testing.T) {
+	r := require.New(t)
+	a, b := Pipe(Options{BytesPerSecond: 1000})
+
+	go func() {
+		a.Write(make([]byte, 100))
+		a.Write(make([]byte, 100))
+		a.Close()
+	}()
+
+	start := time.Now()
+	data, err := ioutil.ReadAll(b)
+	r.NoError(err)
+	r.Len(data, 200)
+	r.GreaterOrEqual(int64(time.Since(start)), int64(200*time.Millisecond))
+}
+
+func TestPipeSegments(t *testing.T) {
+	r := require.New(t)
+	a, b := Pipe(Options{MaxSegment: 3})
+
+	_, err := a.Write([]byte("abcdefgh"))
+	r.NoError(err)
+	r.NoError(a.Close())
+
+	var reads []string
+	buf := make([]byte, 10)
+	for {
+		n, err := b.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		r.NoError(err)
+		reads = append(reads, string(buf[:n]))
+	}
+	r.Equal([]string{"abc", "def", "gh"}, reads)
+}
+
+func TestPipeDisconnect(t *testing.T) {
+	r := require.New(t)
+	a, b := Pipe(Options{})
+
+	readErr := make(chan error)
+	go func() {
+		_, err := b.Read(make([]byte, 1))
+		readErr <- err
+	}()
+
+	a.Disconnect()
+	r.True(errors.Is(<-readErr, ErrDisconnected), "expected %v", ErrDisconnected)
+
+	_, err := b.Write([]byte("x"))
+	r.True(errors.Is(err, ErrDisconnected), "expected %v", ErrDisconnected)
+}
+
+func TestPipeDisconnectAfter(t *testing.T) {
+	r := require.New(t)
+	a, b := Pipe(Options{DisconnectAfter: 4, MaxSegment: 2})
+
+	n, err := a.Write([]byte("abcdef"))
+	r.True(errors.Is(err, ErrDisconnected), "expected %v", ErrDisconnected)
+	r.Equal(4, n)
+
+	_, err = b.Read(make([]byte, 10))
+	r.True(errors.Is(err, ErrDisconnected), "expected %v", ErrDisconnected)
+}
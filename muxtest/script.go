@@ -0,0 +1,276 @@
+// SPDX-License-Identifier: MIT
+
+package muxtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.cryptoscope.co/muxrpc/v2"
+)
+
+// Script plays the remote side for the code under test. Declare the calls it should receive with Expect,
+// connect it with Connect and let the code under test make its calls.
+// Calls nobody expected fail and are reported to the test, so are expected calls that didn't happen by the end of the test.
+//
+//	s := muxtest.NewScript(t)
+//	s.Expect("whoami").Returns("alice")
+//	s.Expect("createHistoryStream").WithArgs(map[string]string{"id": "@feed"}).Streams("msg1", "msg2")
+//	s.Expect("blobs.add").Consumes("blob")
+//	p := s.Connect(nil)
+//	runCodeUnderTest(p.A)
+type Script struct {
+	t testing.TB
+
+	mu           sync.Mutex
+	expectations []*Expectation
+}
+
+// Expectation is a call a Script waits for and how it answers it
+type Expectation struct {
+	method   muxrpc.Method
+	typ      muxrpc.CallType
+	args     interface{} // JSON array the arguments have to match, nil for any
+	argsSet  bool
+	reply    interface{}
+	frames   []interface{}
+	consumes []string
+	err      error
+
+	called bool
+}
+
+// NewScript returns a Script without expectations that reports to t
+func NewScript(t testing.TB) *Script {
+	s := &Script{t: t}
+	t.Cleanup(s.verify)
+	return s
+}
+
+// Expect adds a call to the dotted method name. Each expectation is used for one call, in the order they were added.
+// Without further setup the call is async and returns nothing.
+func (s *Script) Expect(method string) *Expectation {
+	e := &Expectation{method: muxrpc.Method(strings.Split(method, "."))}
+	s.mu.Lock()
+	s.expectations = append(s.expectations, e)
+	s.mu.Unlock()
+	return e
+}
+
+// WithArgs restricts the expectation to calls with these arguments, compared by their JSON encoding
+func (e *Expectation) WithArgs(args ...interface{}) *Expectation {
+	e.args = normalizeJSON(args)
+	e.argsSet = true
+	return e
+}
+
+// Returns makes the expectation an async call that returns v
+func (e *Expectation) Returns(v interface{}) *Expectation {
+	e.reply = v
+	return e
+}
+
+// Streams makes the expectation a source call that sends frames and then ends.
+// Together with Consumes it becomes a duplex call.
+func (e *Expectation) Streams(frames ...interface{}) *Expectation {
+	e.frames = frames
+	return e
+}
+
+// Consumes makes the expectation a sink call that has to receive exactly these frames, compared as strings.
+// Together with Streams it becomes a duplex call, which reads all of these frames before it sends its own.
+func (e *Expectation) Consumes(frames ...string) *Expectation {
+	e.consumes = frames
+	return e
+}
+
+// Fails ends the call with err instead of an answer. Streams send their frames before that.
+func (e *Expectation) Fails(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// Type sets the call type explicitly, for example sync
+func (e *Expectation) Type(typ muxrpc.CallType) *Expectation {
+	e.typ = typ
+	return e
+}
+
+func (e *Expectation) callType() muxrpc.CallType {
+	switch {
+	case e.typ != "":
+		return e.typ
+	case e.frames != nil && e.consumes != nil:
+		return "duplex"
+	case e.frames != nil:
+		return "source"
+	case e.consumes != nil:
+		return "sink"
+	default:
+		return "async"
+	}
+}
+
+func (e *Expectation) String() string {
+	if e.argsSet {
+		args, _ := json.Marshal(e.args)
+		return fmt.Sprintf("%s %s%s", e.callType(), e.method, args)
+	}
+	return fmt.Sprintf("%s %s", e.callType(), e.method)
+}
+
+// Connect connects h to the script with LoopbackPair. A is the endpoint of h, B the one of the script.
+func (s *Script) Connect(h muxrpc.Handler, hopts ...muxrpc.HandleOption) *Pair {
+	s.t.Helper()
+	if h == nil {
+		h = &muxrpc.FakeHandler{}
+	}
+	return LoopbackPair(s.t, h, s, hopts...)
+}
+
+var _ muxrpc.Handler = (*Script)(nil)
+
+// Handled returns true for all methods, so that unexpected calls reach HandleCall and get reported
+func (s *Script) Handled(muxrpc.Method) bool { return true }
+
+// HandleConnect does nothing
+func (s *Script) HandleConnect(ctx context.Context, edp muxrpc.Endpoint) {}
+
+// HandleCall answers the call with the first unused expectation that matches it
+func (s *Script) HandleCall(ctx context.Context, req *muxrpc.Request) {
+	if req.Method.String() == "manifest" {
+		req.Return(ctx, s.manifest())
+		return
+	}
+
+	e := s.match(req)
+	if e == nil {
+		s.t.Errorf("muxtest: unexpected %s call to %s with args %s", req.Type, req.Method, req.RawArgs)
+		req.CloseWithError(fmt.Errorf("muxtest: unexpected call to %s", req.Method))
+		return
+	}
+
+	switch req.Type {
+	case "async", "sync":
+		if e.err != nil {
+			req.CloseWithError(e.err)
+			return
+		}
+		req.Return(ctx, e.reply)
+
+	case "source", "sink", "duplex":
+		var got []string
+		if e.consumes != nil {
+			src, err := req.ResponseSource()
+			if err != nil {
+				s.t.Errorf("muxtest: %s: %v", e, err)
+				req.CloseWithError(err)
+				return
+			}
+			for len(got) < len(e.consumes) && src.Next(ctx) {
+				b, err := src.Bytes()
+				if err != nil {
+					break
+				}
+				got = append(got, string(b))
+			}
+			if !reflect.DeepEqual(got, e.consumes) {
+				s.t.Errorf("muxtest: %s received %q, expected %q", e, got, e.consumes)
+			}
+		}
+
+		if e.frames != nil {
+			snk, err := req.ResponseSink()
+			if err != nil {
+				s.t.Errorf("muxtest: %s: %v", e, err)
+				req.CloseWithError(err)
+				return
+			}
+			snk.SetEncoding(muxrpc.TypeJSON)
+			for _, f := range e.frames {
+				b, err := json.Marshal(f)
+				if err != nil {
+					s.t.Errorf("muxtest: %s can't encode frame %v: %v", e, f, err)
+					break
+				}
+				if _, err := snk.Write(b); err != nil {
+					break
+				}
+			}
+		}
+
+		if e.err != nil {
+			req.CloseWithError(e.err)
+		} else {
+			req.Close()
+		}
+	}
+}
+
+// match finds the expectation for req and marks it as used
+func (s *Script) match(req *muxrpc.Request) *Expectation {
+	var args interface{}
+	json.Unmarshal(req.RawArgs, &args)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.expectations {
+		if e.called || !reflect.DeepEqual(e.method, req.Method) || e.callType() != req.Type {
+			continue
+		}
+		if e.argsSet && !reflect.DeepEqual(e.args, args) {
+			continue
+		}
+		e.called = true
+		return e
+	}
+	return nil
+}
+
+// manifest lists the expected methods, so that the caller doesn't reject calls to them
+func (s *Script) manifest() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := map[string]interface{}{"manifest": "sync"}
+	for _, e := range s.expectations {
+		level := m
+		for _, part := range e.method[:len(e.method)-1] {
+			sub, ok := level[part].(map[string]interface{})
+			if !ok {
+				sub = make(map[string]interface{})
+				level[part] = sub
+			}
+			level = sub
+		}
+		level[e.method[len(e.method)-1]] = string(e.callType())
+	}
+	return m
+}
+
+// verify reports the expectations that weren't used
+func (s *Script) verify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.expectations {
+		if !e.called {
+			s.t.Errorf("muxtest: expected %s wasn't called", e)
+		}
+	}
+}
+
+// normalizeJSON returns v the way it looks after a round trip through JSON, for comparing it with decoded arguments
+func normalizeJSON(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("muxtest: can't encode %v: %v", v, err))
+	}
+	var out interface{}
+	json.Unmarshal(b, &out)
+	return out
+}
@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: MIT
+
+package muxtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2"
+)
+
+// recordingTB collects the errors a Script reports instead of failing the test
+type recordingTB struct {
+	testing.TB
+
+	mu       sync.Mutex
+	errs     []string
+	cleanups []func()
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Errorf(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingTB) Error(args ...interface{}) {
+	r.Errorf("%s", fmt.Sprint(args...))
+}
+
+func (r *recordingTB) Cleanup(f func()) { r.cleanups = append(r.cleanups, f) }
+
+func (r *recordingTB) finish() []string {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.errs
+}
+
+func TestScript(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		setup func(s *Script)
+		run   func(edp muxrpc.Endpoint) error
+		errs  int
+	}{
+		{
+			name:  "async",
+			setup: func(s *Script) { s.Expect("whoami").Returns("alice") },
+			run: func(edp muxrpc.Endpoint) error {
+				var who string
+				if err := edp.Async(ctx, &who, muxrpc.TypeString, muxrpc.Method{"whoami"}); err != nil {
+					return err
+				}
+				if who != "alice" {
+					return fmt.Errorf("wrong reply: %s", who)
+				}
+				return nil
+			},
+		},
+		{
+			name:  "args and source",
+			setup: func(s *Script) { s.Expect("feeds.history").WithArgs(map[string]int{"limit": 2}).Streams(1, 2) },
+			run: func(edp muxrpc.Endpoint) error {
+				src, err := edp.Source(ctx, muxrpc.TypeJSON, muxrpc.Method{"feeds", "history"}, map[string]int{"limit": 2})
+				if err != nil {
+					return err
+				}
+				var n int
+				for src.Next(ctx) {
+					if _, err := src.Bytes(); err != nil {
+						return err
+					}
+					n++
+				}
+				if n != 2 {
+					return fmt.Errorf("got %d frames", n)
+				}
+				return src.Err()
+			},
+		},
+		{
+			name:  "sink",
+			setup: func(s *Script) { s.Expect("blobs.add").Consumes("a", "b") },
+			run: func(edp muxrpc.Endpoint) error {
+				snk, err := edp.Sink(ctx, muxrpc.TypeString, muxrpc.Method{"blobs", "add"})
+				if err != nil {
+					return err
+				}
+				snk.Write([]byte("a"))
+				snk.Write([]byte("b"))
+				return snk.CloseWithErrorAndWait(ctx, nil)
+			},
+		},
+		{
+			name:  "failure",
+			setup: func(s *Script) { s.Expect("whoami").Fails(errors.New("nope")) },
+			run: func(edp muxrpc.Endpoint) error {
+				var who string
+				err := edp.Async(ctx, &who, muxrpc.TypeString, muxrpc.Method{"whoami"})
+				if err == nil {
+					return errors.New("expected an error")
+				}
+				return nil
+			},
+		},
+		{
+			name:  "wrong args",
+			setup: func(s *Script) { s.Expect("whoami").WithArgs(1).Returns("alice") },
+			run: func(edp muxrpc.Endpoint) error {
+				var who string
+				edp.Async(ctx, &who, muxrpc.TypeString, muxrpc.Method{"whoami"}, 2)
+				return nil
+			},
+			errs: 2, // the unexpected call and the missing one
+		},
+		{
+			name:  "not called",
+			setup: func(s *Script) { s.Expect("whoami") },
+			run:   func(edp muxrpc.Endpoint) error { return nil },
+			errs:  1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+
+			rec := &recordingTB{TB: t}
+			s := NewScript(rec)
+			tc.setup(s)
+			p := s.Connect(nil)
+
+			r.NoError(tc.run(p.A))
+			r.Len(rec.finish(), tc.errs, "errors: %v", rec.errs)
+		})
+	}
+}
@@ -0,0 +1,343 @@
+// SPDX-License-Identifier: MIT
+
+package muxtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"go.cryptoscope.co/muxrpc/v2"
+)
+
+// StressConfig describes the load Stress puts on a pair of endpoints.
+// Zero values are replaced by the defaults mentioned for each field.
+type StressConfig struct {
+	// Seed makes the mix of calls and their sizes reproducible
+	Seed int64
+
+	// Calls is the number of calls each side makes, defaults to 200
+	Calls int
+
+	// MaxFrames and MaxFrameSize limit the streams, they default to 16 frames of up to 4096 bytes
+	MaxFrames    int
+	MaxFrameSize int
+
+	// CancelProb is the probability that a call is canceled while it runs
+	CancelProb float64
+
+	// Timeout is how long the whole run may take before it is reported as a deadlock, defaults to 30 seconds
+	Timeout time.Duration
+
+	// Conn configures the pipe between the endpoints and Options are passed to both of them
+	Conn    Options
+	Options []muxrpc.HandleOption
+}
+
+func (cfg *StressConfig) defaults() {
+	if cfg.Calls <= 0 {
+		cfg.Calls = 200
+	}
+	if cfg.MaxFrames <= 0 {
+		cfg.MaxFrames = 16
+	}
+	if cfg.MaxFrameSize <= 0 {
+		cfg.MaxFrameSize = 4096
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+}
+
+var (
+	stressAsync  = muxrpc.Method{"stress", "async"}
+	stressSource = muxrpc.Method{"stress", "source"}
+	stressSink   = muxrpc.Method{"stress", "sink"}
+	stressDuplex = muxrpc.Method{"stress", "duplex"}
+)
+
+// Stress connects two endpoints and lets both of them make lots of concurrent calls of all types to each other,
+// with random sizes and cancellations. It fails t if a call returns wrong data or an unexpected error,
+// if the run doesn't finish within the timeout, if calls stay open after all of them returned,
+// or if goroutines are left over once the endpoints are closed.
+// Because of the last check, tests that use it shouldn't run in parallel to others.
+func Stress(t testing.TB, cfg StressConfig) {
+	t.Helper()
+	cfg.defaults()
+
+	baseline := runtime.NumGoroutine()
+
+	p := Connect(stressHandler{}, stressHandler{}, cfg.Conn, cfg.Options...)
+
+	done := make(chan struct{})
+	var failures []error
+	var mu sync.Mutex
+	fail := func(err error) {
+		mu.Lock()
+		failures = append(failures, err)
+		mu.Unlock()
+	}
+
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for side, edp := range []muxrpc.Endpoint{p.A, p.B} {
+			rnd := rand.New(rand.NewSource(cfg.Seed + int64(side)))
+			for i := 0; i < cfg.Calls; i++ {
+				c := stressCall{
+					edp:    edp,
+					kind:   rnd.Intn(4),
+					frames: rnd.Intn(cfg.MaxFrames) + 1,
+					size:   rnd.Intn(cfg.MaxFrameSize) + 1,
+					cancel: rnd.Float64() < cfg.CancelProb,
+					delay:  time.Duration(rnd.Intn(2000)) * time.Microsecond,
+				}
+				wg.Add(1)
+				go func(side, i int) {
+					defer wg.Done()
+					if err := c.run(); err != nil {
+						fail(fmt.Errorf("call %d of side %d: %w", i, side, err))
+					}
+				}(side, i)
+			}
+		}
+		wg.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(cfg.Timeout):
+		buf := make([]byte, 1<<20)
+		n := runtime.Stack(buf, true)
+		t.Fatalf("muxtest: stress run didn't finish within %s, goroutines:\n%s", cfg.Timeout, buf[:n])
+	}
+
+	for _, err := range failures {
+		t.Error(err)
+	}
+
+	for i, edp := range []muxrpc.Endpoint{p.A, p.B} {
+		if !eventually(5*time.Second, func() bool { n, _ := muxrpc.OpenRequests(edp); return n == 0 }) {
+			n, _ := muxrpc.OpenRequests(edp)
+			t.Errorf("muxtest: endpoint %d still has %d open requests after all calls returned", i, n)
+		}
+	}
+
+	if err := p.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if !eventually(15*time.Second, func() bool { return runtime.NumGoroutine() <= baseline }) {
+		buf := make([]byte, 1<<20)
+		n := runtime.Stack(buf, true)
+		t.Errorf("muxtest: %d goroutines leaked:\n%s", runtime.NumGoroutine()-baseline, buf[:n])
+	}
+}
+
+// eventually polls cond until it returns true or d passed
+func eventually(d time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(d)
+	for {
+		if cond() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type stressCall struct {
+	edp          muxrpc.Endpoint
+	kind         int
+	frames, size int
+	cancel       bool
+	delay        time.Duration
+}
+
+// frame returns the content of the i-th frame of a stream
+func stressFrame(i, size int) []byte {
+	return bytes.Repeat([]byte{byte('a' + i%26)}, size)
+}
+
+func (c stressCall) run() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if c.cancel {
+		t := time.AfterFunc(c.delay, cancel)
+		defer t.Stop()
+	}
+
+	err := c.call(ctx)
+	if err != nil && ctx.Err() != nil {
+		return nil // canceled calls may fail in any way
+	}
+	return err
+}
+
+func (c stressCall) call(ctx context.Context) error {
+	switch c.kind {
+	case 0:
+		payload := string(stressFrame(c.frames, c.size))
+		var resp string
+		if err := c.edp.Async(ctx, &resp, muxrpc.TypeString, stressAsync, payload); err != nil {
+			return err
+		}
+		if resp != payload {
+			return fmt.Errorf("async echo returned %d bytes instead of %d", len(resp), len(payload))
+		}
+		return nil
+
+	case 1:
+		src, err := c.edp.Source(ctx, muxrpc.TypeBinary, stressSource, c.frames, c.size)
+		if err != nil {
+			return err
+		}
+		return readFrames(ctx, src, c.frames, c.size)
+
+	case 2:
+		snk, err := c.edp.Sink(ctx, muxrpc.TypeBinary, stressSink, c.frames, c.size)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < c.frames; i++ {
+			if _, err := snk.Write(stressFrame(i, c.size)); err != nil {
+				return err
+			}
+		}
+		return snk.CloseWithErrorAndWait(ctx, nil)
+
+	default:
+		src, snk, err := c.edp.Duplex(ctx, muxrpc.TypeBinary, stressDuplex)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < c.frames; i++ {
+			if _, err := snk.Write(stressFrame(i, c.size)); err != nil {
+				return err
+			}
+			if !src.Next(ctx) {
+				return fmt.Errorf("duplex echo ended after %d frames: %v", i, src.Err())
+			}
+			got, err := src.Bytes()
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(got, stressFrame(i, c.size)) {
+				return fmt.Errorf("duplex echo frame %d is wrong", i)
+			}
+		}
+		if err := snk.Close(); err != nil {
+			return err
+		}
+		if src.Next(ctx) {
+			return errors.New("duplex echo sent more frames than it got")
+		}
+		return src.Err()
+	}
+}
+
+// readFrames checks that src sends the frames a stress source sends
+func readFrames(ctx context.Context, src *muxrpc.ByteSource, frames, size int) error {
+	var i int
+	for ; src.Next(ctx); i++ {
+		got, err := src.Bytes()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, stressFrame(i, size)) {
+			return fmt.Errorf("frame %d is wrong", i)
+		}
+	}
+	if err := src.Err(); err != nil {
+		return err
+	}
+	if i != frames {
+		return fmt.Errorf("got %d frames instead of %d", i, frames)
+	}
+	return nil
+}
+
+// stressHandler serves the calls of Stress
+type stressHandler struct{}
+
+func (stressHandler) Handled(m muxrpc.Method) bool {
+	return len(m) == 2 && m[0] == "stress"
+}
+
+func (stressHandler) HandleConnect(ctx context.Context, edp muxrpc.Endpoint) {}
+
+func (stressHandler) HandleCall(ctx context.Context, req *muxrpc.Request) {
+	switch req.Method[1] {
+	case "async":
+		var payload string
+		if err := req.ParseArgs(&payload); err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		req.Return(ctx, payload)
+
+	case "source":
+		var frames, size int
+		if err := req.ParseArgs(&frames, &size); err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		snk, err := req.ResponseSink()
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		for i := 0; i < frames; i++ {
+			if _, err := snk.Write(stressFrame(i, size)); err != nil {
+				return
+			}
+		}
+		req.Close()
+
+	case "sink":
+		var frames, size int
+		if err := req.ParseArgs(&frames, &size); err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		src, err := req.ResponseSource()
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		req.CloseWithError(readFrames(ctx, src, frames, size))
+
+	case "duplex":
+		src, err := req.ResponseSource()
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		snk, err := req.ResponseSink()
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		for src.Next(ctx) {
+			b, err := src.Bytes()
+			if err != nil {
+				break
+			}
+			if _, err := snk.Write(b); err != nil {
+				return
+			}
+		}
+		req.CloseWithError(src.Err())
+
+	default:
+		req.CloseWithError(fmt.Errorf("muxtest: unknown stress method %s", req.Method))
+	}
+}
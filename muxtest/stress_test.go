@@ -0,0 +1,15 @@
+// SPDX-License-Identifier: MIT
+
+package muxtest
+
+import (
+	"testing"
+)
+
+func TestStress(t *testing.T) {
+	Stress(t, StressConfig{Seed: 1})
+}
+
+func TestStressCancellations(t *testing.T) {
+	Stress(t, StressConfig{Seed: 2, Calls: 100, CancelProb: 0.3})
+}
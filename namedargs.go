@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// AsyncObj is like Async but passes opts as the single options object many JS APIs expect, instead of positional arguments.
+// opts can be a struct or a map. Members that encode as null are left out, so that the remote applies its defaults.
+// A nil opts sends an empty object.
+func (r *rpc) AsyncObj(ctx context.Context, ret interface{}, re RequestEncoding, method Method, opts interface{}) error {
+	obj, err := marshalNamedArgs(r.json, opts)
+	if err != nil {
+		return fmt.Errorf("muxrpc(%s): %w", method, err)
+	}
+	return r.Async(ctx, ret, re, method, obj)
+}
+
+// marshalNamedArgs encodes v as a JSON object without its null members
+func marshalNamedArgs(jc JSONCodec, v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return json.RawMessage(`{}`), nil
+	}
+
+	data, err := jc.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling named arguments: %w", err)
+	}
+
+	var members map[string]json.RawMessage
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) || json.Unmarshal(data, &members) != nil {
+		return nil, fmt.Errorf("named arguments need to be an object, not %T", v)
+	}
+
+	var stripped bool
+	for k, m := range members {
+		if bytes.Equal(m, nullBytes) {
+			delete(members, k)
+			stripped = true
+		}
+	}
+	if !stripped {
+		return data, nil
+	}
+	return json.Marshal(members)
+}
+
+var nullBytes = []byte("null")
+
+// DecodeNamedArgs decodes the options object of a call made with AsyncObj, or a similar call from a JS peer, into v.
+// v is left as it is if the call has no arguments, so it can be prepared with defaults.
+func (req *Request) DecodeNamedArgs(v interface{}) error {
+	args, err := req.rawArgList()
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 || bytes.Equal(args[0], nullBytes) {
+		return nil
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("muxrpc: expected one options object, got %d arguments", len(args))
+	}
+	if !bytes.HasPrefix(args[0], []byte("{")) {
+		return fmt.Errorf("muxrpc: expected an options object, got %s", args[0])
+	}
+	if err := json.Unmarshal(args[0], v); err != nil {
+		return fmt.Errorf("muxrpc: invalid options object: %w", err)
+	}
+	return nil
+}
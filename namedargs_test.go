@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalNamedArgs(t *testing.T) {
+	type opts struct {
+		Limit   int     `json:"limit,omitempty"`
+		Reverse bool    `json:"reverse"`
+		Gt      *int    `json:"gt"`
+		Keys    *bool   `json:"keys,omitempty"`
+		Extra   *string `json:"extra"`
+	}
+
+	for _, tc := range []struct {
+		v   interface{}
+		exp string
+	}{
+		{nil, `{}`},
+		{opts{}, `{"reverse":false}`},
+		{opts{Limit: 10, Reverse: true}, `{"limit":10,"reverse":true}`},
+		{map[string]interface{}{"live": true, "old": nil}, `{"live":true}`},
+		{&opts{Limit: 1}, `{"limit":1,"reverse":false}`},
+	} {
+		got, err := marshalNamedArgs(StdJSON, tc.v)
+		require.NoError(t, err, "%#v", tc.v)
+		require.JSONEq(t, tc.exp, string(got), "%#v", tc.v)
+	}
+
+	for _, v := range []interface{}{"nope", []int{1, 2}, 23} {
+		_, err := marshalNamedArgs(StdJSON, v)
+		require.Error(t, err, "%#v", v)
+	}
+}
+
+func TestAsyncObj(t *testing.T) {
+	r := require.New(t)
+
+	type query struct {
+		Limit int    `json:"limit"`
+		Dest  string `json:"dest,omitempty"`
+	}
+
+	var fh1 FakeHandler
+
+	var fh2 FakeHandler
+	fh2.HandledReturns(true)
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		if req.Method.String() == "manifest" {
+			req.Return(ctx, json.RawMessage(`{"query":"async"}`))
+			return
+		}
+		q := query{Limit: 5, Dest: "default"}
+		if err := req.DecodeNamedArgs(&q); err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		req.Return(ctx, q)
+	})
+
+	c1, c2 := loPipe(t)
+	var rpc1 Endpoint
+	handled := make(chan struct{})
+	go func() {
+		rpc1 = Handle(NewPacker(c1), &fh1)
+		close(handled)
+	}()
+	rpc2 := Handle(NewPacker(c2), &fh2)
+	<-handled
+	go rpc1.(Server).Serve()
+	go rpc2.(Server).Serve()
+	defer rpc1.Terminate()
+	defer rpc2.Terminate()
+
+	ctx := context.Background()
+
+	var got query
+	r.NoError(rpc1.AsyncObj(ctx, &got, TypeJSON, Method{"query"}, query{Limit: 10}))
+	r.Equal(query{Limit: 10, Dest: "default"}, got, "omitted member should keep its default")
+
+	r.NoError(rpc1.AsyncObj(ctx, &got, TypeJSON, Method{"query"}, nil))
+	r.Equal(query{Limit: 5, Dest: "default"}, got)
+
+	// plain calls without arguments keep the defaults too
+	r.NoError(rpc1.Async(ctx, &got, TypeJSON, Method{"query"}))
+	r.Equal(query{Limit: 5, Dest: "default"}, got)
+
+	// positional arguments are refused
+	err := rpc1.Async(ctx, &got, TypeJSON, Method{"query"}, 1, 2)
+	r.Error(err)
+	err = rpc1.Async(ctx, &got, TypeJSON, Method{"query"}, "foo")
+	r.Error(err)
+
+	r.Error(rpc1.AsyncObj(ctx, &got, TypeJSON, Method{"query"}, "foo"))
+}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// WriteNDJSON writes the frames of src to w as newline-delimited JSON, one value per line, until src ends.
+// The values are compacted, so ones that span several lines still take up a single one.
+// It returns how many values it wrote, and the error of src if it ended with one.
+func WriteNDJSON(ctx context.Context, w io.Writer, src ByteSourcer) (int, error) {
+	bw := bufio.NewWriter(w)
+	var (
+		n    int
+		line bytes.Buffer
+	)
+	for src.Next(ctx) {
+		line.Reset()
+		err := src.Reader(func(rd io.Reader) error {
+			var frame bytes.Buffer
+			if _, err := frame.ReadFrom(rd); err != nil {
+				return err
+			}
+			return json.Compact(&line, frame.Bytes())
+		})
+		if err != nil {
+			return n, fmt.Errorf("muxrpc: value %d is not valid JSON: %w", n+1, err)
+		}
+		line.WriteByte('\n')
+		if _, err := bw.Write(line.Bytes()); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := bw.Flush(); err != nil {
+		return n, err
+	}
+	return n, sourceErr(src)
+}
+
+// ReadNDJSON sends each line of r to snk as a JSON frame, until r ends.
+// Empty lines are skipped, a line that isn't valid JSON stops it with an error that names the line.
+// It returns how many values it sent. snk isn't closed, that's up to the caller, with or without the error.
+func ReadNDJSON(r io.Reader, snk ByteSinker) (int, error) {
+	if es, ok := snk.(interface{ SetEncoding(RequestEncoding) }); ok {
+		es.SetEncoding(TypeJSON)
+	}
+
+	br := bufio.NewReader(r)
+	var n int
+	for lineNo := 1; ; lineNo++ {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			if !json.Valid(line) {
+				return n, fmt.Errorf("muxrpc: ndjson line %d is not valid JSON", lineNo)
+			}
+			if _, werr := snk.Write(bytes.TrimSpace(line)); werr != nil {
+				return n, werr
+			}
+			n++
+		}
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+)
+
+func TestWriteNDJSON(t *testing.T) {
+	r := require.New(t)
+
+	src := NewTestSource(
+		[]byte(`{"seq":1}`),
+		[]byte("{\n  \"seq\": 2,\n  \"text\": \"two\\nlines\"\n}"),
+		[]byte(`[3]`),
+	)
+	src.Cancel(nil)
+
+	var out bytes.Buffer
+	n, err := WriteNDJSON(context.Background(), &out, src)
+	r.NoError(err)
+	r.Equal(3, n)
+	r.Equal("{\"seq\":1}\n{\"seq\":2,\"text\":\"two\\nlines\"}\n[3]\n", out.String())
+
+	failure := errors.New("remote failed")
+	src = NewTestSource([]byte(`true`))
+	src.Cancel(failure)
+	out.Reset()
+	n, err = WriteNDJSON(context.Background(), &out, src)
+	r.Equal(1, n)
+	r.Equal(failure, err)
+	r.Equal("true\n", out.String())
+
+	src = NewTestSource([]byte(`{"broken"`))
+	src.Cancel(nil)
+	_, err = WriteNDJSON(context.Background(), io.Discard, src)
+	r.Error(err)
+}
+
+func TestReadNDJSON(t *testing.T) {
+	r := require.New(t)
+
+	var wire bytes.Buffer
+	snk := NewTestSink(&wire)
+
+	n, err := ReadNDJSON(strings.NewReader("{\"seq\":1}\n\n  {\"seq\":2}  \r\n[3]"), snk)
+	r.NoError(err)
+	r.Equal(3, n)
+
+	rd := codec.NewReader(&wire)
+	for _, want := range []string{`{"seq":1}`, `{"seq":2}`, `[3]`} {
+		pkt, err := rd.ReadPacket()
+		r.NoError(err)
+		r.True(pkt.Flag.Get(codec.FlagJSON), "not sent as JSON: %s", pkt.Flag)
+		r.Equal(want, string(pkt.Body))
+	}
+
+	n, err = ReadNDJSON(strings.NewReader("{\"seq\":1}\nnope\n"), NewTestSink(io.Discard))
+	r.Equal(1, n)
+	r.Error(err)
+	r.Contains(err.Error(), "line 2")
+}
@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// CallOutcome describes how a call we made to the remote went.
+type CallOutcome struct {
+	Remote net.Addr
+
+	Method Method
+	Type   CallType
+
+	// Err is nil if the call succeeded.
+	// For async calls it's the error returned by Async, including context errors if the caller gave up.
+	// Streams report once they end, with the error the remote closed them with or ErrSessionTerminated.
+	Err error
+
+	Duration time.Duration
+}
+
+// OutcomeHook receives the outcome of every call we make, see WithOutcomeHook.
+// It is called from the goroutines of the session and should not block.
+type OutcomeHook func(CallOutcome)
+
+// WithOutcomeHook passes the outcome of every call we make to hook.
+// Together with a wrapped Endpoint that refuses calls, this is enough to build a circuit breaker, see the breaker package.
+// Calls that fail before anything is sent, like the ones to methods the remote doesn't list in its manifest, are not reported.
+func WithOutcomeHook(hook OutcomeHook) HandleOption {
+	return func(r *rpc) {
+		r.outcome = hook
+	}
+}
+
+// reportOutcome passes the end of a call we made to the outcome hook. Only the first report of a call counts.
+// It also runs the OnCallEnd hooks for it.
+func (r *rpc) reportOutcome(req *Request, err error) {
+	if req.id <= 0 {
+		return
+	}
+	if !atomic.CompareAndSwapUint32(&req.state.reported, 0, 1) {
+		return
+	}
+	r.callEnded(req, err)
+	if r.outcome == nil {
+		return
+	}
+	r.outcome(CallOutcome{
+		Remote:   r.remote,
+		Method:   req.Method,
+		Type:     req.Type,
+		Err:      err,
+		Duration: r.clock.Now().Sub(req.started),
+	})
+}
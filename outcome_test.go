@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutcomeHook(t *testing.T) {
+	r := require.New(t)
+
+	outcomes := make(chan CallOutcome, 10)
+	hook := func(o CallOutcome) {
+		if o.Method.String() != "manifest" {
+			outcomes <- o
+		}
+	}
+
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(func(m Method) bool {
+		return m.String() == "whoami" || m.String() == "fail"
+	})
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		if req.Method.String() == "fail" {
+			req.CloseWithError(errors.New("nope"))
+			return
+		}
+		req.Return(ctx, "you")
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2, WithOutcomeHook(hook))
+
+	next := func() CallOutcome {
+		select {
+		case o := <-outcomes:
+			return o
+		case <-time.After(2 * time.Second):
+			t.Fatal("no outcome")
+			return CallOutcome{}
+		}
+	}
+
+	ctx := context.Background()
+	var resp string
+	r.NoError(rpc1.Async(ctx, &resp, TypeString, Method{"whoami"}))
+	o := next()
+	r.Equal("whoami", o.Method.String())
+	r.Equal(CallType("async"), o.Type)
+	r.NoError(o.Err)
+	r.NotNil(o.Remote)
+
+	err := rpc1.Async(ctx, &resp, TypeString, Method{"fail"})
+	r.Error(err)
+	o = next()
+	r.Equal("fail", o.Method.String())
+	var ce *CallError
+	r.True(errors.As(o.Err, &ce))
+
+	src, err := rpc1.Source(ctx, TypeString, Method{"fail"})
+	r.NoError(err)
+	r.False(src.Next(ctx))
+	o = next()
+	r.Equal(CallType("source"), o.Type)
+	r.True(errors.As(o.Err, &ce))
+	r.Equal("nope", ce.Message)
+
+	// callers giving up count as well
+	tctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {})
+	err = rpc1.Async(tctx, &resp, TypeString, Method{"whoami"})
+	r.Error(err)
+	o = next()
+	r.True(errors.Is(o.Err, context.DeadlineExceeded), "unexpected error: %v", o.Err)
+
+	select {
+	case o := <-outcomes:
+		t.Fatalf("unexpected outcome: %+v", o)
+	default:
+	}
+}
@@ -4,23 +4,87 @@ package muxrpc
 
 import (
 	"context"
+	"crypto/ed25519"
 	stderr "errors"
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"go.cryptoscope.co/muxrpc/v2/codec"
 )
 
+// PackerOption configures a Packer
+type PackerOption func(*Packer)
+
+// WithWriteCoalescing makes the packer collect outgoing packets in a buffer of bufSize bytes
+// and send them in batches from a background goroutine, at the latest after flushDelay.
+// This greatly reduces the number of syscalls for chatty streams with lots of small packets.
+func WithWriteCoalescing(bufSize int, flushDelay time.Duration) PackerOption {
+	return func(pkr *Packer) {
+		pkr.writeBufSize = bufSize
+		pkr.flushDelay = flushDelay
+	}
+}
+
+// WithReadTimeout makes reads from the connection fail with a StallError if no data arrives for d.
+// The remote then has to send something at least every d, even if it has nothing to say.
+// It needs a connection that supports deadlines, like a net.Conn.
+func WithReadTimeout(d time.Duration) PackerOption {
+	return func(pkr *Packer) {
+		pkr.readTimeout = d
+	}
+}
+
+// WithWriteTimeout makes writes to the connection fail with a StallError if they don't make progress for d,
+// for instance because the remote stopped reading.
+// It needs a connection that supports deadlines, like a net.Conn.
+func WithWriteTimeout(d time.Duration) PackerOption {
+	return func(pkr *Packer) {
+		pkr.writeTimeout = d
+	}
+}
+
+// WithReadBuffer makes the packer read from the connection in chunks of up to size bytes, instead of reading each header and body on its own.
+// Bigger buffers suit fast links with big packets, like replication on a LAN, while small devices might prefer little or none.
+// Zero or less reads without a buffer, which is the default.
+func WithReadBuffer(size int) PackerOption {
+	return func(pkr *Packer) {
+		pkr.readBufSize = size
+	}
+}
+
 // NewPacker takes an io.ReadWriteCloser and returns a Packer.
-func NewPacker(rwc io.ReadWriteCloser) *Packer {
-	return &Packer{
-		r: codec.NewReader(rwc),
-		w: codec.NewWriter(rwc),
+func NewPacker(rwc io.ReadWriteCloser, opts ...PackerOption) *Packer {
+	pkr := &Packer{
 		c: rwc,
 
 		closing: make(chan struct{}),
 	}
+
+	for _, o := range opts {
+		o(pkr)
+	}
+
+	var rw io.ReadWriter = rwc
+	if pkr.readTimeout > 0 || pkr.writeTimeout > 0 {
+		if dc, ok := rwc.(deadliner); ok {
+			rw = &deadlineConn{deadliner: dc, read: pkr.readTimeout, write: pkr.writeTimeout}
+		}
+	}
+
+	if pkr.readBufSize > 0 {
+		pkr.r = codec.NewBufferedReader(rw, pkr.readBufSize)
+	} else {
+		pkr.r = codec.NewReader(rw)
+	}
+	if pkr.writeBufSize > 0 {
+		pkr.w = codec.NewBufferedWriter(rw, pkr.writeBufSize, pkr.flushDelay)
+	} else {
+		pkr.w = codec.NewWriter(rw)
+	}
+
+	return pkr
 }
 
 // Packer is a duplex stream that sends and receives *codec.Packet values.
@@ -33,12 +97,39 @@ type Packer struct {
 	w *codec.Writer
 	c io.Closer
 
+	// the key of the peer, if the connection is a secretstream, see NewSecretStreamPacker
+	remoteKey ed25519.PublicKey
+
+	readBufSize  int
+	writeBufSize int
+	flushDelay   time.Duration
+
+	readTimeout, writeTimeout time.Duration
+
 	cl        sync.Mutex
 	closeErr  error
 	closeOnce sync.Once
 	closing   chan struct{}
 }
 
+// closeFlushTimeout is how long Close waits for buffered packets to be written before closing the connection anyway.
+const closeFlushTimeout = time.Second
+
+// flushBeforeClose tries to get out what is still buffered.
+// It gives up after closeFlushTimeout, closing the connection also ends a write that is stuck on it.
+func (pkr *Packer) flushBeforeClose() {
+	flushed := make(chan struct{})
+	go func() {
+		pkr.w.Flush()
+		close(flushed)
+	}()
+
+	select {
+	case <-flushed:
+	case <-time.After(closeFlushTimeout):
+	}
+}
+
 // Next returns the next packet from the underlying stream.
 func (pkr *Packer) NextHeader(ctx context.Context, hdr *codec.Header) error {
 	pkr.rl.Lock()
@@ -91,6 +182,9 @@ func (pkr *Packer) Close() error {
 	var err error
 
 	pkr.closeOnce.Do(func() {
+		if pkr.writeBufSize > 0 {
+			pkr.flushBeforeClose()
+		}
 		err = pkr.c.Close()
 		close(pkr.closing)
 	})
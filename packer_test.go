@@ -8,8 +8,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"reflect"
 	"testing"
+	"time"
 
 	"go.cryptoscope.co/muxrpc/v2/codec"
 )
@@ -88,3 +90,161 @@ func TestPacker(t *testing.T) {
 
 	t.Log("this error should be about pouring to a closed sink:", err)
 }
+
+func TestPackerWriteCoalescing(t *testing.T) {
+	c1, c2 := loPipe(t)
+
+	pkr1 := NewPacker(c1, WithWriteCoalescing(4096, 10*time.Millisecond))
+	pkr2 := NewPacker(c2)
+
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		err := pkr1.w.WritePacket(codec.Packet{
+			Req:  1,
+			Flag: codec.FlagString | codec.FlagStream,
+			Body: []byte(fmt.Sprint("pkt", i)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		var hdr codec.Header
+		if err := pkr2.NextHeader(ctx, &hdr); err != nil {
+			t.Fatal(err)
+		}
+		var buf = new(bytes.Buffer)
+		if err := pkr2.r.ReadBodyInto(buf, hdr.Len); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := buf.String(), fmt.Sprint("pkt", i); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+
+	if err := pkr1.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPackerCloseWithStuckWrite(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []PackerOption
+	}{
+		{"unbuffered", nil},
+		{"buffered", []PackerOption{WithWriteCoalescing(4096, time.Millisecond)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// nobody reads from the other end, so writes block
+			c1, c2 := net.Pipe()
+			defer c2.Close()
+			pkr := NewPacker(c1, tc.opts...)
+
+			go pkr.w.WritePacket(codec.Packet{
+				Req:  1,
+				Flag: codec.FlagString | codec.FlagStream,
+				Body: []byte("stuck"),
+			})
+			time.Sleep(10 * time.Millisecond)
+
+			closed := make(chan error, 1)
+			go func() { closed <- pkr.Close() }()
+
+			select {
+			case err := <-closed:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(2 * closeFlushTimeout):
+				t.Fatal("close blocked on the stuck write")
+			}
+		})
+	}
+}
+
+func TestPackerTimeouts(t *testing.T) {
+	// nobody reads or writes on the other end
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	pkr := NewPacker(c1, WithReadTimeout(50*time.Millisecond), WithWriteTimeout(50*time.Millisecond))
+	defer pkr.Close()
+
+	err := pkr.w.WritePacket(codec.Packet{
+		Req:  1,
+		Flag: codec.FlagString | codec.FlagStream,
+		Body: []byte("stuck"),
+	})
+	var se StallError
+	if !errors.Is(err, ErrStalled) || !errors.As(err, &se) {
+		t.Fatalf("expected write to stall, got %v", err)
+	}
+	if se.Op != "write" || se.Timeout != 50*time.Millisecond {
+		t.Fatalf("unexpected stall error: %+v", se)
+	}
+
+	var hdr codec.Header
+	err = pkr.NextHeader(context.Background(), &hdr)
+	if !errors.Is(err, ErrStalled) || !errors.As(err, &se) {
+		t.Fatalf("expected read to stall, got %v", err)
+	}
+	if se.Op != "read" {
+		t.Fatalf("unexpected stall error: %+v", se)
+	}
+}
+
+// countingConn counts the reads from its buffer
+type countingConn struct {
+	bytes.Buffer
+	reads int
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	c.reads++
+	return c.Buffer.Read(p)
+}
+
+func (c *countingConn) Close() error { return nil }
+
+func TestPackerReadBuffer(t *testing.T) {
+	const n = 10
+	for _, tc := range []struct {
+		name     string
+		opts     []PackerOption
+		maxReads int
+	}{
+		{"unbuffered", nil, 4 * n},
+		{"buffered", []PackerOption{WithReadBuffer(4096)}, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var conn countingConn
+			w := codec.NewWriter(&conn)
+			for i := 0; i < n; i++ {
+				err := w.WritePacket(codec.Packet{Req: 1, Flag: codec.FlagString | codec.FlagStream, Body: []byte(fmt.Sprint("pkt", i))})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			pkr := NewPacker(&conn, tc.opts...)
+			for i := 0; i < n; i++ {
+				var hdr codec.Header
+				if err := pkr.NextHeader(context.Background(), &hdr); err != nil {
+					t.Fatal(err)
+				}
+				var buf bytes.Buffer
+				if err := pkr.r.ReadBodyInto(&buf, hdr.Len); err != nil {
+					t.Fatal(err)
+				}
+				if got, want := buf.String(), fmt.Sprint("pkt", i); got != want {
+					t.Fatalf("got %q, want %q", got, want)
+				}
+			}
+			if conn.reads > tc.maxReads {
+				t.Fatalf("%d reads for %d packets", conn.reads, n)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"crypto/ed25519"
+
+	"go.mindeco.de/log/level"
+)
+
+// PeerClassifier sorts a peer into a class like "friend", "follower" or "stranger", by the public key it authenticated with.
+// ok is false if the connection doesn't tell the key, see RemoteKey.
+type PeerClassifier func(key ed25519.PublicKey, ok bool) string
+
+// WithPeerHandlers serves each connection with the handler set of the class its peer is in,
+// so that public servers can offer fewer methods to peers they don't know without checking the caller in every handler.
+// The class is decided once, before the first call is handled, and the handler of it also gets HandleConnect.
+// Peers of classes that aren't in handlers are served by the handler passed to Handle.
+// The remote only sees the methods of its handler set, including in the manifest if that handler answers it.
+func WithPeerHandlers(classify PeerClassifier, handlers map[string]Handler) HandleOption {
+	pc := &peerClasses{
+		classify: classify,
+		handlers: make(map[string]Handler, len(handlers)),
+	}
+	for class, h := range handlers {
+		pc.handlers[class] = h
+	}
+	return func(r *rpc) {
+		r.peerClasses = pc
+	}
+}
+
+type peerClasses struct {
+	classify PeerClassifier
+	handlers map[string]Handler
+}
+
+// PeerClass returns the class WithPeerHandlers put the peer of edp in.
+// It returns false for endpoints that don't use WithPeerHandlers.
+func PeerClass(edp Endpoint) (string, bool) {
+	pc, ok := edp.(peerClasser)
+	if !ok {
+		return "", false
+	}
+	return pc.peerClassOf()
+}
+
+type peerClasser interface {
+	peerClassOf() (string, bool)
+}
+
+func (r *rpc) peerClassOf() (string, bool) {
+	return r.peerClass, r.peerClasses != nil
+}
+
+// selectPeerHandler classifies the remote and replaces root with the handler of its class
+func (r *rpc) selectPeerHandler() {
+	key, ok := r.RemoteKey()
+	r.peerClass = r.peerClasses.classify(key, ok)
+	if h, has := r.peerClasses.handlers[r.peerClass]; has {
+		r.root = h
+	}
+	level.Debug(r.logger).Log("event", "peer classified", "class", r.peerClass)
+}
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerHandlers(t *testing.T) {
+	friend := ed25519.PublicKey(bytes.Repeat([]byte{1}, ed25519.PublicKeySize))
+	stranger := ed25519.PublicKey(bytes.Repeat([]byte{2}, ed25519.PublicKeySize))
+
+	classify := func(key ed25519.PublicKey, ok bool) string {
+		if ok && bytes.Equal(key, friend) {
+			return "friend"
+		}
+		return "stranger"
+	}
+
+	newHandler := func(manifest string, methods ...string) (*FakeHandler, chan struct{}) {
+		var fh FakeHandler
+		fh.HandledCalls(func(m Method) bool {
+			for _, name := range append(methods, "manifest") {
+				if m.String() == name {
+					return true
+				}
+			}
+			return false
+		})
+		fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+			if req.Method.String() == "manifest" {
+				req.Return(ctx, json.RawMessage(manifest))
+				return
+			}
+			req.Return(ctx, req.Method.String())
+		})
+		connected := make(chan struct{})
+		fh.HandleConnectCalls(func(context.Context, Endpoint) { close(connected) })
+		return &fh, connected
+	}
+
+	for _, tc := range []struct {
+		key     ed25519.PublicKey
+		class   string
+		allowed bool
+	}{
+		{friend, "friend", true},
+		{stranger, "stranger", false},
+		{nil, "stranger", false},
+	} {
+		r := require.New(t)
+
+		friends, friendConnected := newHandler(`{"public":"async","private":"async"}`, "public", "private")
+		strangers, strangerConnected := newHandler(`{"public":"async"}`, "public")
+
+		var remote net.Addr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8008}
+		if tc.key != nil {
+			remote = SecretStreamAddr{Addr: remote, PubKey: tc.key}
+		}
+		client, server := handledPair(t, &FakeHandler{}, &FakeHandler{}, nil, []HandleOption{
+			WithRemoteAddr(remote),
+			WithPeerHandlers(classify, map[string]Handler{"friend": friends, "stranger": strangers}),
+		})
+
+		class, ok := PeerClass(server)
+		r.True(ok)
+		r.Equal(tc.class, class)
+		_, ok = PeerClass(client)
+		r.False(ok)
+
+		if tc.class == "friend" {
+			<-friendConnected
+		} else {
+			<-strangerConnected
+		}
+
+		ctx := context.Background()
+		var resp string
+		r.NoError(client.Async(ctx, &resp, TypeString, Method{"public"}))
+		r.Equal("public", resp)
+
+		err := client.Async(ctx, &resp, TypeString, Method{"private"})
+		if tc.allowed {
+			r.NoError(err)
+			r.Equal("private", resp)
+		} else {
+			var nsm ErrNoSuchMethod
+			r.True(errors.As(err, &nsm), "unexpected error: %v", err)
+		}
+	}
+}
+
+func TestPeerHandlersFallback(t *testing.T) {
+	r := require.New(t)
+
+	var fallback FakeHandler
+	fallback.HandledCalls(methodChecker("fallback"))
+	fallback.HandleCallCalls(func(ctx context.Context, req *Request) {
+		req.Return(ctx, "fallback")
+	})
+
+	// no key and no handler for the class, so the one passed to Handle serves it
+	classify := func(ed25519.PublicKey, bool) string { return "unknown" }
+	client, server := handledPair(t, &FakeHandler{}, &fallback, nil, []HandleOption{
+		WithPeerHandlers(classify, map[string]Handler{"friend": &FakeHandler{}}),
+	})
+
+	class, ok := PeerClass(server)
+	r.True(ok)
+	r.Equal("unknown", class)
+
+	var resp string
+	r.NoError(client.Async(context.Background(), &resp, TypeString, Method{"fallback"}))
+	r.Equal("fallback", resp)
+}
@@ -0,0 +1,165 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"fmt"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+)
+
+// RawReply holds the reply of an async call as it was sent, without decoding it.
+// Pass a *RawReply to Async to get one, and pass it to Request.Return to send it on unchanged.
+type RawReply struct {
+	Encoding RequestEncoding
+	Body     []byte
+}
+
+// encodingOf returns the RequestEncoding a packet with flag was sent with
+func encodingOf(flag codec.Flag) RequestEncoding {
+	switch {
+	case flag.Get(codec.FlagJSON):
+		return TypeJSON
+	case flag.Get(codec.FlagString):
+		return TypeString
+	default:
+		return TypeBinary
+	}
+}
+
+// ProxyHandler forwards incoming calls to another endpoint, like a gateway or a room server does.
+// Arguments and replies are passed on unchanged and streams are piped both ways.
+// If the caller goes away, the forwarded call is aborted, and the other way around.
+//
+// Streams are forwarded frame by frame. Frames are sent on with the encoding of the last frame that arrived,
+// which is only a problem for streams that mix encodings and have several frames in flight.
+type ProxyHandler struct {
+	target  Endpoint
+	handled func(Method) bool
+}
+
+// NewProxyHandler returns a handler that forwards the calls for which handled returns true to target.
+// If handled is nil, all calls are forwarded. That includes the manifest, so the caller sees the one of target.
+func NewProxyHandler(target Endpoint, handled func(Method) bool) *ProxyHandler {
+	return &ProxyHandler{target: target, handled: handled}
+}
+
+var _ Handler = (*ProxyHandler)(nil)
+
+// Handled returns true for the methods that are forwarded
+func (p *ProxyHandler) Handled(m Method) bool {
+	return p.handled == nil || p.handled(m)
+}
+
+// HandleConnect does nothing
+func (p *ProxyHandler) HandleConnect(ctx context.Context, edp Endpoint) {}
+
+// HandleCall makes the same call on the target and passes on what comes back
+func (p *ProxyHandler) HandleCall(ctx context.Context, req *Request) {
+	raw, err := req.rawArgList()
+	if err != nil {
+		req.CloseWithError(err)
+		return
+	}
+	args := make([]interface{}, len(raw))
+	for i, a := range raw {
+		args[i] = a
+	}
+
+	switch req.Type {
+	case "async", "sync":
+		var reply RawReply
+		if err := p.target.Async(ctx, &reply, TypeJSON, req.Method, args...); err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		req.Return(ctx, reply)
+
+	case "source":
+		src, err := p.target.Source(ctx, TypeJSON, req.Method, args...)
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		req.CloseWithError(pipeFrames(ctx, src, req.sink))
+
+	case "sink":
+		fwdCtx, cancel := forwardContext(req)
+		defer cancel()
+
+		snk, err := p.target.Sink(fwdCtx, TypeJSON, req.Method, args...)
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		err = pipeFrames(fwdCtx, req.source, snk)
+		snk.CloseWithError(err)
+		req.CloseWithError(err)
+
+	case "duplex":
+		fwdCtx, cancel := forwardContext(req)
+		defer cancel()
+
+		src, snk, err := p.target.Duplex(fwdCtx, TypeJSON, req.Method, args...)
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		go func() {
+			snk.CloseWithError(pipeFrames(fwdCtx, req.source, snk))
+		}()
+		req.CloseWithError(pipeFrames(fwdCtx, src, req.sink))
+
+	default:
+		req.CloseWithError(fmt.Errorf("muxrpc: can't proxy call type %q", req.Type))
+	}
+}
+
+// forwardContext returns the context for a forwarded call that the caller sends data on.
+// The context of the handler can't be used for those, it is canceled as soon as the caller ends its side,
+// while the forwarded call still has to pass on what was buffered and end regularly.
+// It keeps the trace ID of req and is canceled when the session of the caller ends.
+func forwardContext(req *Request) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(WithTraceID(context.Background(), req.TraceID()))
+	if req.endpoint != nil {
+		go func() {
+			select {
+			case <-req.endpoint.serveCtx.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return ctx, cancel
+}
+
+// pipeFrames copies the frames of src to snk, with their encoding, until src ends.
+// It returns the error that ended src or the one of a failed write.
+func pipeFrames(ctx context.Context, src *ByteSource, snk *ByteSink) error {
+	for src.Next(ctx) {
+		frame, err := src.Bytes()
+		if err != nil {
+			return err
+		}
+		snk.setEncodingFlag(src.lastFlag())
+		if _, err := snk.Write(frame); err != nil {
+			return err
+		}
+	}
+	return src.Err()
+}
+
+// lastFlag returns the flag of the last packet that arrived
+func (bs *ByteSource) lastFlag() codec.Flag {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.hdrFlag
+}
+
+// setEncodingFlag replaces the encoding of the following writes with the one from flag
+func (bs *ByteSink) setEncodingFlag(flag codec.Flag) {
+	bs.closedMu.Lock()
+	defer bs.closedMu.Unlock()
+	bs.pkt.Flag = withEncoding(bs.pkt.Flag, flag)
+}
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyHandler(t *testing.T) {
+	r := require.New(t)
+
+	collected := make(chan string, 10)
+	var server FakeHandler
+	server.HandledReturns(true)
+	server.HandleCallCalls(func(ctx context.Context, req *Request) {
+		switch req.Method.String() {
+		case "manifest":
+			req.Return(ctx, json.RawMessage(`{"manifest":"sync","whoami":"async","info":"async","fail":"async","nums":"source","collect":"sink","echo":"duplex"}`))
+		case "whoami":
+			req.Return(ctx, "server")
+		case "info":
+			var args []int
+			json.Unmarshal(req.RawArgs, &args)
+			req.Return(ctx, map[string]int{"sum": args[0] + args[1]})
+		case "fail":
+			req.CloseWithError(errors.New("not today"))
+		case "nums":
+			snk, _ := req.ResponseSink()
+			for i := 1; i <= 3; i++ {
+				fmt.Fprint(snk, i)
+			}
+			snk.Close()
+		case "collect":
+			src, _ := req.ResponseSource()
+			for src.Next(ctx) {
+				b, _ := src.Bytes()
+				collected <- string(b)
+			}
+			close(collected)
+			req.Close()
+		case "echo":
+			src, _ := req.ResponseSource()
+			snk, _ := req.ResponseSink()
+			for src.Next(ctx) {
+				b, _ := src.Bytes()
+				snk.Write(b)
+			}
+			req.Close()
+		}
+	})
+
+	toServer, _ := connectedPair(t, &FakeHandler{}, &server)
+	client, _ := connectedPair(t, &FakeHandler{}, NewProxyHandler(toServer, nil))
+
+	ctx := context.Background()
+
+	var who string
+	r.NoError(client.Async(ctx, &who, TypeString, Method{"whoami"}))
+	r.Equal("server", who)
+
+	var info map[string]int
+	r.NoError(client.Async(ctx, &info, TypeJSON, Method{"info"}, 1, 2))
+	r.Equal(3, info["sum"])
+
+	err := client.Async(ctx, &who, TypeString, Method{"fail"})
+	var ce *CallError
+	r.True(errors.As(err, &ce), "unexpected error: %v", err)
+	r.Equal("not today", ce.Message)
+
+	src, err := client.Source(ctx, TypeString, Method{"nums"})
+	r.NoError(err)
+	var nums []string
+	for src.Next(ctx) {
+		b, err := src.Bytes()
+		r.NoError(err)
+		nums = append(nums, string(b))
+	}
+	r.NoError(src.Err())
+	r.Equal([]string{"1", "2", "3"}, nums)
+
+	snk, err := client.Sink(ctx, TypeString, Method{"collect"})
+	r.NoError(err)
+	fmt.Fprint(snk, "a")
+	fmt.Fprint(snk, "b")
+	r.NoError(snk.Close())
+	var got []string
+	for s := range collected {
+		got = append(got, s)
+	}
+	r.Equal([]string{"a", "b"}, got)
+
+	dsrc, dsnk, err := client.Duplex(ctx, TypeString, Method{"echo"})
+	r.NoError(err)
+	for _, s := range []string{"x", "y"} {
+		fmt.Fprint(dsnk, s)
+		r.True(dsrc.Next(ctx), "echo ended: %v", dsrc.Err())
+		b, err := dsrc.Bytes()
+		r.NoError(err)
+		r.Equal(s, string(b))
+	}
+	r.NoError(dsnk.Close())
+	r.False(dsrc.Next(ctx))
+	r.NoError(dsrc.Err())
+}
+
+func TestRawReply(t *testing.T) {
+	r := require.New(t)
+
+	var server FakeHandler
+	server.HandledCalls(methodChecker("raw"))
+	server.HandleCallCalls(func(ctx context.Context, req *Request) {
+		req.Return(ctx, &RawReply{Encoding: TypeString, Body: []byte("plain")})
+	})
+	client, _ := connectedPair(t, &FakeHandler{}, &server)
+
+	var reply RawReply
+	r.NoError(client.Async(context.Background(), &reply, TypeJSON, Method{"raw"}))
+	r.Equal(TypeString, reply.Encoding)
+	r.Equal("plain", string(reply.Body))
+}
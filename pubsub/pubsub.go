@@ -0,0 +1,207 @@
+// SPDX-License-Identifier: MIT
+
+// Package pubsub implements topics over muxrpc duplex streams.
+//
+// A Server keeps a set of registered topics. Clients join a topic with Subscribe,
+// which makes a duplex call: what the client writes is published to the topic,
+// and everything published to it, including the own messages, is read from it in the order the server saw it.
+// Subscriptions end when either side closes the stream, the server cleans them up on its own.
+//
+//	srv := pubsub.NewServer(broadcast.Config{})
+//	srv.Register("chat")
+//	edp := muxrpc.Handle(pkr, srv)
+//
+//	sub, err := pubsub.Subscribe(ctx, edp, "chat")
+//	sub.Publish([]byte("hello"))
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.cryptoscope.co/muxrpc/v2"
+	"go.cryptoscope.co/muxrpc/v2/broadcast"
+)
+
+// Method is the duplex method clients call to join a topic
+var Method = muxrpc.Method{"pubsub", "subscribe"}
+
+// ErrNoSuchTopic is sent to clients that subscribe to a topic that isn't registered
+var ErrNoSuchTopic = errors.New("muxrpc/pubsub: no such topic")
+
+type subscribeArgs struct {
+	Topic string `json:"topic"`
+}
+
+// Server handles subscriptions to its topics. It is a muxrpc.Handler, use it as the root handler or register it for Method in a muxrpc.HandlerMux.
+type Server struct {
+	cfg broadcast.Config
+
+	mu     sync.Mutex
+	topics map[string]*broadcast.Broadcaster
+}
+
+// NewServer returns a Server without topics. cfg is used for the broadcaster of each topic, see the broadcast package.
+func NewServer(cfg broadcast.Config) *Server {
+	return &Server{
+		cfg:    cfg,
+		topics: make(map[string]*broadcast.Broadcaster),
+	}
+}
+
+// Register adds a topic. Registering it again does nothing.
+func (s *Server) Register(topic string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, has := s.topics[topic]; !has {
+		s.topics[topic] = broadcast.New(s.cfg)
+	}
+}
+
+// Unregister removes a topic and ends its subscriptions
+func (s *Server) Unregister(topic string) error {
+	s.mu.Lock()
+	b, has := s.topics[topic]
+	delete(s.topics, topic)
+	s.mu.Unlock()
+	if !has {
+		return nil
+	}
+	return b.Close()
+}
+
+// Publish sends msg to the subscribers of topic
+func (s *Server) Publish(topic string, msg []byte) error {
+	b, err := s.topic(topic)
+	if err != nil {
+		return err
+	}
+	_, err = b.Write(msg)
+	return err
+}
+
+// Subscribers returns the number of subscriptions to topic
+func (s *Server) Subscribers(topic string) int {
+	b, err := s.topic(topic)
+	if err != nil {
+		return 0
+	}
+	return b.Len()
+}
+
+// Close ends all subscriptions and removes all topics
+func (s *Server) Close() error {
+	s.mu.Lock()
+	topics := s.topics
+	s.topics = make(map[string]*broadcast.Broadcaster)
+	s.mu.Unlock()
+
+	for _, b := range topics {
+		b.Close()
+	}
+	return nil
+}
+
+func (s *Server) topic(name string) (*broadcast.Broadcaster, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, has := s.topics[name]
+	if !has {
+		return nil, fmt.Errorf("%w: %q", ErrNoSuchTopic, name)
+	}
+	return b, nil
+}
+
+// Handled returns true for Method
+func (s *Server) Handled(m muxrpc.Method) bool { return m.String() == Method.String() }
+
+// HandleConnect does nothing
+func (s *Server) HandleConnect(ctx context.Context, edp muxrpc.Endpoint) {}
+
+// HandleCall runs a subscription until the client ends it or the topic goes away
+func (s *Server) HandleCall(ctx context.Context, req *muxrpc.Request) {
+	if req.Type != "duplex" {
+		req.CloseWithError(fmt.Errorf("muxrpc/pubsub: %s is a duplex call, not %s", Method, req.Type))
+		return
+	}
+
+	var args subscribeArgs
+	if err := req.DecodeNamedArgs(&args); err != nil {
+		req.CloseWithError(err)
+		return
+	}
+	b, err := s.topic(args.Topic)
+	if err != nil {
+		req.CloseWithError(err)
+		return
+	}
+
+	src, err := req.ResponseSource()
+	if err != nil {
+		req.CloseWithError(err)
+		return
+	}
+	snk, err := req.ResponseSink()
+	if err != nil {
+		req.CloseWithError(err)
+		return
+	}
+
+	remove, err := b.Add(snk)
+	if err != nil {
+		req.CloseWithError(err)
+		return
+	}
+	defer remove()
+
+	for src.Next(ctx) {
+		msg, err := src.Bytes()
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		if _, err := b.Write(msg); err != nil {
+			req.CloseWithError(err)
+			return
+		}
+	}
+	req.CloseWithError(src.Err())
+}
+
+var _ muxrpc.Handler = (*Server)(nil)
+
+// Subscription is the client side of a subscription to a topic. It is not safe for concurrent reads,
+// but Publish can be called while another goroutine reads.
+type Subscription struct {
+	src *muxrpc.ByteSource
+	snk *muxrpc.ByteSink
+}
+
+// Subscribe joins topic on the server at edp
+func Subscribe(ctx context.Context, edp muxrpc.Endpoint, topic string) (*Subscription, error) {
+	src, snk, err := edp.Duplex(ctx, muxrpc.TypeBinary, Method, subscribeArgs{Topic: topic})
+	if err != nil {
+		return nil, fmt.Errorf("muxrpc/pubsub: failed to subscribe to %q: %w", topic, err)
+	}
+	return &Subscription{src: src, snk: snk}, nil
+}
+
+// Publish sends msg to everyone on the topic
+func (sub *Subscription) Publish(msg []byte) error {
+	_, err := sub.snk.Write(msg)
+	return err
+}
+
+// Next blocks until there is a new message or the subscription ended
+func (sub *Subscription) Next(ctx context.Context) bool { return sub.src.Next(ctx) }
+
+// Bytes returns the current message
+func (sub *Subscription) Bytes() ([]byte, error) { return sub.src.Bytes() }
+
+// Err returns the error that ended the subscription, nil if it ended regularly
+func (sub *Subscription) Err() error { return sub.src.Err() }
+
+// Close leaves the topic. Messages that are already on their way can still be read.
+func (sub *Subscription) Close() error { return sub.snk.Close() }
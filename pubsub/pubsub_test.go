@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: MIT
+
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2"
+	"go.cryptoscope.co/muxrpc/v2/broadcast"
+	"go.cryptoscope.co/muxrpc/v2/muxtest"
+)
+
+func next(t *testing.T, sub *Subscription) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if !sub.Next(ctx) {
+		t.Fatalf("subscription ended: %v", sub.Err())
+	}
+	msg, err := sub.Bytes()
+	require.NoError(t, err)
+	return string(msg)
+}
+
+func TestPubSub(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	srv := NewServer(broadcast.Config{})
+	srv.Register("chat")
+	defer srv.Close()
+
+	// two clients on their own connections to the same server
+	p1 := muxtest.Connect(&muxrpc.FakeHandler{}, srv, muxtest.Options{})
+	defer p1.Close()
+	p2 := muxtest.Connect(&muxrpc.FakeHandler{}, srv, muxtest.Options{})
+	defer p2.Close()
+
+	alice, err := Subscribe(ctx, p1.A, "chat")
+	r.NoError(err)
+	bob, err := Subscribe(ctx, p2.A, "chat")
+	r.NoError(err)
+	r.Eventually(func() bool { return srv.Subscribers("chat") == 2 }, time.Second, 10*time.Millisecond)
+
+	r.NoError(alice.Publish([]byte("hi bob")))
+	r.Equal("hi bob", next(t, bob))
+	r.Equal("hi bob", next(t, alice))
+
+	r.NoError(srv.Publish("chat", []byte("server says hi")))
+	r.Equal("server says hi", next(t, alice))
+	r.Equal("server says hi", next(t, bob))
+
+	// leaving is cleaned up on the server
+	r.NoError(bob.Close())
+	r.Eventually(func() bool { return srv.Subscribers("chat") == 1 }, time.Second, 10*time.Millisecond)
+	for bob.Next(ctx) {
+		bob.Bytes()
+	}
+	r.NoError(bob.Err())
+
+	// unknown topics are refused
+	nope, err := Subscribe(ctx, p2.A, "nope")
+	r.NoError(err, "the duplex call itself is started")
+	r.False(nope.Next(ctx))
+	var ce *muxrpc.CallError
+	r.True(errors.As(nope.Err(), &ce), "unexpected error: %v", nope.Err())
+	r.Contains(ce.Message, "no such topic")
+
+	// removing the topic ends the subscriptions
+	r.NoError(srv.Unregister("chat"))
+	for alice.Next(ctx) {
+		alice.Bytes()
+	}
+	r.NoError(alice.Err())
+	r.Equal(0, srv.Subscribers("chat"))
+}
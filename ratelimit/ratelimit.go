@@ -0,0 +1,230 @@
+// SPDX-License-Identifier: MIT
+
+// Package ratelimit limits how often peers may call methods, using token buckets per method prefix and peer.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"go.cryptoscope.co/muxrpc/v2"
+	"go.cryptoscope.co/muxrpc/v2/auth"
+)
+
+// ErrRateLimited is returned to callers that exceeded the limit of a method.
+type ErrRateLimited struct {
+	Method muxrpc.Method
+
+	// RetryAfter is how long it takes until the next call would be accepted
+	RetryAfter time.Duration
+}
+
+func (e ErrRateLimited) Error() string {
+	return fmt.Sprintf("muxrpc: rate limit exceeded for %s, retry in %s", e.Method, e.RetryAfter)
+}
+
+// Limit configures a token bucket
+type Limit struct {
+	// Rate is the number of calls per second that refill the bucket
+	Rate float64
+
+	// Burst is the size of the bucket, how many calls can be made at once
+	Burst int
+}
+
+// Rule limits the calls to all methods starting with Method, like Method{"blobs"} for blobs.get and blobs.has.
+type Rule struct {
+	Method muxrpc.Method
+	Limit  Limit
+}
+
+// Config decides which calls are limited
+type Config struct {
+	// Rules apply to all peers. If more than one rule matches a method, the longest prefix wins.
+	// Methods without a matching rule are not limited.
+	Rules []Rule
+
+	// Peers replaces Rules for specific peers, keyed by auth.KeyID or auth.CertID.
+	Peers map[string][]Rule
+
+	// Shared makes all peers draw from the same buckets, instead of having their own.
+	Shared bool
+
+	// Clock refills the buckets, muxrpc.SystemClock if it is nil
+	Clock muxrpc.Clock
+}
+
+// maxIdleBuckets is the number of buckets after which full ones are dropped
+const maxIdleBuckets = 1024
+
+// Limiter holds the buckets of all peers
+type Limiter struct {
+	cfg Config
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[bucketKey]*bucket
+}
+
+type bucketKey struct {
+	peer   string
+	method string
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// New returns a limiter for cfg
+func New(cfg Config) *Limiter {
+	if cfg.Clock == nil {
+		cfg.Clock = muxrpc.SystemClock
+	}
+	return &Limiter{
+		cfg:     cfg,
+		now:     cfg.Clock.Now,
+		buckets: make(map[bucketKey]*bucket),
+	}
+}
+
+// PeerKey returns what the buckets of the peer behind addr are keyed by.
+// That is its auth.PeerID or, for anonymous peers, their IP address so that reconnecting doesn't reset the limits.
+func PeerKey(addr net.Addr) string {
+	if id, ok := auth.PeerID(addr); ok {
+		return id
+	}
+	if ta, ok := muxrpc.TCPAddr(addr); ok {
+		return ta.IP.String()
+	}
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+// Allow takes a token for a call of m by peer (see PeerKey).
+// If there is none, it returns an ErrRateLimited.
+func (l *Limiter) Allow(peer string, m muxrpc.Method) error {
+	rule, ok := l.match(peer, m)
+	if !ok {
+		return nil
+	}
+
+	key := bucketKey{method: rule.Method.String()}
+	if !l.cfg.Shared {
+		key.peer = peer
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, has := l.buckets[key]
+	if !has {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.dropFull(now)
+		}
+		b = &bucket{tokens: float64(rule.Limit.Burst), last: now, limit: rule.Limit}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+
+	if b.tokens < 1 {
+		var wait time.Duration
+		if b.limit.Rate > 0 {
+			wait = time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+		}
+		return ErrRateLimited{Method: m, RetryAfter: wait}
+	}
+	b.tokens--
+	return nil
+}
+
+// match returns the rule with the longest prefix of m
+func (l *Limiter) match(peer string, m muxrpc.Method) (Rule, bool) {
+	rules := l.cfg.Rules
+	if pr, has := l.cfg.Peers[peer]; has {
+		rules = pr
+	}
+
+	var (
+		best  Rule
+		found bool
+	)
+	for _, r := range rules {
+		if !hasPrefix(m, r.Method) {
+			continue
+		}
+		if !found || len(r.Method) > len(best.Method) {
+			best, found = r, true
+		}
+	}
+	return best, found
+}
+
+// dropFull forgets buckets that refilled completely, they are the same as new ones
+func (l *Limiter) dropFull(now time.Time) {
+	for k, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+	b.tokens += elapsed * b.limit.Rate
+	if max := float64(b.limit.Burst); b.tokens > max {
+		b.tokens = max
+	}
+}
+
+func hasPrefix(m, prefix muxrpc.Method) bool {
+	if len(prefix) > len(m) {
+		return false
+	}
+	for i := range prefix {
+		if m[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// NewHandler rejects calls that exceed the limits of l with ErrRateLimited and passes the others on to h.
+func NewHandler(l *Limiter, h muxrpc.Handler) muxrpc.Handler {
+	return handler{lim: l, root: h}
+}
+
+// Wrapper returns NewHandler as a muxrpc.HandlerWrapper
+func Wrapper(l *Limiter) muxrpc.HandlerWrapper {
+	return func(h muxrpc.Handler) muxrpc.Handler {
+		return NewHandler(l, h)
+	}
+}
+
+type handler struct {
+	lim  *Limiter
+	root muxrpc.Handler
+}
+
+func (h handler) Handled(m muxrpc.Method) bool { return h.root.Handled(m) }
+
+func (h handler) HandleConnect(ctx context.Context, edp muxrpc.Endpoint) {
+	h.root.HandleConnect(ctx, edp)
+}
+
+func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request) {
+	if err := h.lim.Allow(PeerKey(req.RemoteAddr()), req.Method); err != nil {
+		req.CloseWithError(err)
+		return
+	}
+	h.root.HandleCall(ctx, req)
+}
@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: MIT
+
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2"
+	"go.cryptoscope.co/muxrpc/v2/muxtest"
+)
+
+func TestLimiter(t *testing.T) {
+	r := require.New(t)
+
+	now := time.Unix(0, 0)
+	l := New(Config{
+		Rules: []Rule{
+			{Method: muxrpc.Method{"createHistoryStream"}, Limit: Limit{Rate: 1, Burst: 2}},
+			{Method: muxrpc.Method{"blobs"}, Limit: Limit{Rate: 10, Burst: 10}},
+			{Method: muxrpc.Method{"blobs", "get"}, Limit: Limit{Rate: 1, Burst: 1}},
+		},
+		Peers: map[string][]Rule{
+			"trusted": nil,
+		},
+	})
+	l.now = func() time.Time { return now }
+
+	chs := muxrpc.Method{"createHistoryStream"}
+	r.NoError(l.Allow("alice", chs))
+	r.NoError(l.Allow("alice", chs))
+	err := l.Allow("alice", chs)
+	var rle ErrRateLimited
+	r.True(errors.As(err, &rle))
+	r.Equal(time.Second, rle.RetryAfter)
+
+	// buckets are per peer
+	r.NoError(l.Allow("bob", chs))
+
+	// and refill over time
+	now = now.Add(time.Second)
+	r.NoError(l.Allow("alice", chs))
+	r.Error(l.Allow("alice", chs))
+
+	// the longest prefix wins
+	r.NoError(l.Allow("alice", muxrpc.Method{"blobs", "get"}))
+	r.Error(l.Allow("alice", muxrpc.Method{"blobs", "get"}))
+	r.NoError(l.Allow("alice", muxrpc.Method{"blobs", "has"}))
+
+	// unmatched methods and peers with their own (empty) rules aren't limited
+	for i := 0; i < 5; i++ {
+		r.NoError(l.Allow("alice", muxrpc.Method{"whoami"}))
+		r.NoError(l.Allow("trusted", chs))
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := require.New(t)
+
+	var fh muxrpc.FakeHandler
+	fh.HandledReturns(true)
+	fh.HandleCallCalls(func(ctx context.Context, req *muxrpc.Request) {
+		req.Return(ctx, "ok")
+	})
+
+	l := New(Config{Rules: []Rule{{Method: muxrpc.Method{"whoami"}, Limit: Limit{Rate: 0.01, Burst: 1}}}})
+	h := muxrpc.ApplyHandlerWrappers(&fh, Wrapper(l))
+	p := muxtest.Connect(h, h, muxtest.Options{})
+	defer p.Close()
+
+	ctx := context.Background()
+	var resp string
+	r.NoError(p.A.Async(ctx, &resp, muxrpc.TypeString, muxrpc.Method{"whoami"}))
+	r.Equal("ok", resp)
+
+	err := p.A.Async(ctx, &resp, muxrpc.TypeString, muxrpc.Method{"whoami"})
+	var ce *muxrpc.CallError
+	r.True(errors.As(err, &ce), "unexpected error: %v", err)
+	r.Contains(ce.Message, "rate limit exceeded")
+}
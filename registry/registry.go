@@ -0,0 +1,253 @@
+// SPDX-License-Identifier: MIT
+
+// Package registry keeps track of the live endpoints by the public key of their peer,
+// so that code like replication schedulers has one place to find the connection to a peer.
+//
+// Endpoints are removed again once their session ends. Subscribers are told about every change.
+//
+//	reg := registry.New()
+//	edp := muxrpc.Handle(pkr, reg.Wrap(h))
+//
+//	if edp, ok := reg.Get(key); ok {
+//		edp.Async(ctx, &v, muxrpc.TypeJSON, muxrpc.Method{"whoami"})
+//	}
+package registry
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"sort"
+	"sync"
+
+	"go.cryptoscope.co/muxrpc/v2"
+)
+
+// ErrNoKey is returned by Add for endpoints whose remote address doesn't tell the key of the peer, see muxrpc.RemoteKey.
+var ErrNoKey = errors.New("muxrpc/registry: remote address has no public key")
+
+// EventType tells what happened to an entry of the registry
+type EventType uint
+
+const (
+	// Added is sent when an endpoint was added for a key
+	Added EventType = iota + 1
+
+	// Removed is sent when an endpoint was removed, because its session ended, Remove was called or another endpoint replaced it
+	Removed
+)
+
+func (t EventType) String() string {
+	switch t {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// Event is a change of the registry
+type Event struct {
+	Type     EventType
+	Key      ed25519.PublicKey
+	Endpoint muxrpc.Endpoint
+}
+
+// Registry maps the public keys of peers to their endpoints. The zero value is not usable, use New.
+type Registry struct {
+	mu    sync.Mutex
+	peers map[string]muxrpc.Endpoint
+
+	// evMu serializes the delivery of events, so subscribers see them in the order they happened
+	evMu   sync.Mutex
+	subs   map[int]func(Event)
+	nextID int
+}
+
+// New returns an empty Registry
+func New() *Registry {
+	return &Registry{
+		peers: make(map[string]muxrpc.Endpoint),
+		subs:  make(map[int]func(Event)),
+	}
+}
+
+// Add registers edp under the key of its peer, see AddKey.
+func (r *Registry) Add(edp muxrpc.Endpoint) (prev muxrpc.Endpoint, err error) {
+	key, ok := edp.RemoteKey()
+	if !ok {
+		return nil, ErrNoKey
+	}
+	return r.AddKey(key, edp), nil
+}
+
+// AddKey registers edp under key, for endpoints that learn the identity of their peer some other way.
+// An endpoint that was registered for key before is replaced and returned, but not terminated.
+// Deciding which of two connections to the same peer to keep is up to the caller.
+// edp is removed once its session ends.
+func (r *Registry) AddKey(key ed25519.PublicKey, edp muxrpc.Endpoint) (prev muxrpc.Endpoint) {
+	key = append(ed25519.PublicKey(nil), key...)
+
+	r.evMu.Lock()
+	defer r.evMu.Unlock()
+
+	r.mu.Lock()
+	prev = r.peers[string(key)]
+	r.peers[string(key)] = edp
+	r.mu.Unlock()
+
+	if prev == edp {
+		return nil
+	}
+	if prev != nil {
+		r.emit(Event{Type: Removed, Key: key, Endpoint: prev})
+	}
+	r.emit(Event{Type: Added, Key: key, Endpoint: edp})
+
+	go func() {
+		<-edp.Done()
+		r.remove(key, edp)
+	}()
+	return prev
+}
+
+// Remove unregisters edp, if it is registered. It returns false if it wasn't.
+func (r *Registry) Remove(edp muxrpc.Endpoint) bool {
+	r.mu.Lock()
+	var key string
+	for k, e := range r.peers {
+		if e == edp {
+			key = k
+			break
+		}
+	}
+	r.mu.Unlock()
+
+	if key == "" {
+		return false
+	}
+	return r.remove(ed25519.PublicKey(key), edp)
+}
+
+// remove drops the entry of key, if it still is edp
+func (r *Registry) remove(key ed25519.PublicKey, edp muxrpc.Endpoint) bool {
+	r.evMu.Lock()
+	defer r.evMu.Unlock()
+
+	r.mu.Lock()
+	if r.peers[string(key)] != edp {
+		r.mu.Unlock()
+		return false
+	}
+	delete(r.peers, string(key))
+	r.mu.Unlock()
+
+	r.emit(Event{Type: Removed, Key: key, Endpoint: edp})
+	return true
+}
+
+// Get returns the endpoint of the peer with key
+func (r *Registry) Get(key ed25519.PublicKey) (muxrpc.Endpoint, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	edp, ok := r.peers[string(key)]
+	return edp, ok
+}
+
+// Has tells whether there is an endpoint for the peer with key
+func (r *Registry) Has(key ed25519.PublicKey) bool {
+	_, ok := r.Get(key)
+	return ok
+}
+
+// Keys returns the keys of all registered peers, in a stable order
+func (r *Registry) Keys() []ed25519.PublicKey {
+	r.mu.Lock()
+	keys := make([]string, 0, len(r.peers))
+	for k := range r.peers {
+		keys = append(keys, k)
+	}
+	r.mu.Unlock()
+
+	sort.Strings(keys)
+	out := make([]ed25519.PublicKey, len(keys))
+	for i, k := range keys {
+		out[i] = ed25519.PublicKey(k)
+	}
+	return out
+}
+
+// Len returns the number of registered peers
+func (r *Registry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.peers)
+}
+
+// Each calls fn for every registered peer until it returns false.
+// It works on a snapshot, so fn may use the registry.
+func (r *Registry) Each(fn func(key ed25519.PublicKey, edp muxrpc.Endpoint) bool) {
+	for _, key := range r.Keys() {
+		edp, ok := r.Get(key)
+		if !ok {
+			continue
+		}
+		if !fn(key, edp) {
+			return
+		}
+	}
+}
+
+// Subscribe calls fn for every change from now on, until cancel is called.
+// The events are delivered one at a time and in order, so fn must not block for long and must not add or remove endpoints itself.
+// Set replay to get Added events for the endpoints that are already registered first.
+func (r *Registry) Subscribe(fn func(Event), replay bool) (cancel func()) {
+	r.evMu.Lock()
+	defer r.evMu.Unlock()
+
+	if replay {
+		r.mu.Lock()
+		current := make([]Event, 0, len(r.peers))
+		for k, edp := range r.peers {
+			current = append(current, Event{Type: Added, Key: ed25519.PublicKey(k), Endpoint: edp})
+		}
+		r.mu.Unlock()
+		for _, evt := range current {
+			fn(evt)
+		}
+	}
+
+	id := r.nextID
+	r.nextID++
+	r.subs[id] = fn
+
+	return func() {
+		r.evMu.Lock()
+		defer r.evMu.Unlock()
+		delete(r.subs, id)
+	}
+}
+
+// emit hands evt to all subscribers. The caller holds evMu.
+func (r *Registry) emit(evt Event) {
+	for _, fn := range r.subs {
+		fn(evt)
+	}
+}
+
+// Wrap returns a handler that adds each endpoint it is connected to before passing it on to h.
+// Endpoints without a key are passed on but not registered. It can be used as a muxrpc.HandlerWrapper.
+func (r *Registry) Wrap(h muxrpc.Handler) muxrpc.Handler {
+	return registeringHandler{Handler: h, reg: r}
+}
+
+type registeringHandler struct {
+	muxrpc.Handler
+	reg *Registry
+}
+
+func (h registeringHandler) HandleConnect(ctx context.Context, edp muxrpc.Endpoint) {
+	h.reg.Add(edp)
+	h.Handler.HandleConnect(ctx, edp)
+}
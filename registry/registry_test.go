@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: MIT
+
+package registry
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2"
+	"go.cryptoscope.co/muxrpc/v2/muxtest"
+)
+
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (rec *recorder) record(evt Event) {
+	rec.mu.Lock()
+	rec.events = append(rec.events, evt)
+	rec.mu.Unlock()
+}
+
+func (rec *recorder) types() []EventType {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	var ts []EventType
+	for _, evt := range rec.events {
+		ts = append(ts, evt.Type)
+	}
+	return ts
+}
+
+func peerAddr(key ed25519.PublicKey) muxrpc.HandleOption {
+	return muxrpc.WithRemoteAddr(muxrpc.SecretStreamAddr{
+		Addr:   &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8008},
+		PubKey: key,
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	r := require.New(t)
+
+	key := bytes.Repeat([]byte{1}, ed25519.PublicKeySize)
+	reg := New()
+	var rec recorder
+	cancel := reg.Subscribe(rec.record, false)
+	defer cancel()
+
+	first := muxtest.LoopbackPair(t, reg.Wrap(&muxrpc.FakeHandler{}), nil, peerAddr(key))
+	r.Eventually(func() bool { return reg.Has(key) }, time.Second, 10*time.Millisecond)
+	edp, ok := reg.Get(key)
+	r.True(ok)
+	r.Equal(first.A, edp)
+	r.Equal(1, reg.Len())
+	r.Equal([]ed25519.PublicKey{key}, reg.Keys())
+
+	// a second connection to the same peer replaces the first one, which stays up
+	second := muxtest.LoopbackPair(t, nil, nil)
+	prev := reg.AddKey(key, second.A)
+	r.Equal(first.A, prev)
+	edp, _ = reg.Get(key)
+	r.Equal(second.A, edp)
+	r.Equal([]EventType{Added, Removed, Added}, rec.types())
+
+	// the end of the replaced session doesn't remove the current one
+	r.NoError(first.A.Terminate())
+	<-first.A.Done()
+	time.Sleep(10 * time.Millisecond)
+	r.True(reg.Has(key))
+
+	r.NoError(second.A.Terminate())
+	r.Eventually(func() bool { return !reg.Has(key) }, time.Second, 10*time.Millisecond)
+	r.Equal([]EventType{Added, Removed, Added, Removed}, rec.types())
+	r.Equal(0, reg.Len())
+
+	rec.mu.Lock()
+	last := rec.events[3]
+	rec.mu.Unlock()
+	r.Equal(second.A, last.Endpoint)
+	r.Equal(ed25519.PublicKey(key), last.Key)
+}
+
+func TestRegistryRemoveAndReplay(t *testing.T) {
+	r := require.New(t)
+
+	keyA := bytes.Repeat([]byte{1}, ed25519.PublicKeySize)
+	keyB := bytes.Repeat([]byte{2}, ed25519.PublicKeySize)
+	reg := New()
+
+	a := muxtest.LoopbackPair(t, nil, nil, peerAddr(keyA))
+	b := muxtest.LoopbackPair(t, nil, nil, peerAddr(keyB))
+	for _, edp := range []muxrpc.Endpoint{a.A, b.A} {
+		prev, err := reg.Add(edp)
+		r.NoError(err)
+		r.Nil(prev)
+	}
+	r.Nil(reg.AddKey(keyA, a.A), "adding the same endpoint again changes nothing")
+
+	var rec recorder
+	cancel := reg.Subscribe(rec.record, true)
+	r.Equal([]EventType{Added, Added}, rec.types())
+
+	var seen int
+	reg.Each(func(key ed25519.PublicKey, edp muxrpc.Endpoint) bool {
+		seen++
+		return false
+	})
+	r.Equal(1, seen)
+
+	r.True(reg.Remove(a.A))
+	r.False(reg.Remove(a.A))
+	r.False(reg.Has(keyA))
+	r.Equal([]ed25519.PublicKey{keyB}, reg.Keys())
+	r.Equal([]EventType{Added, Added, Removed}, rec.types())
+
+	cancel()
+	r.True(reg.Remove(b.A))
+	r.Len(rec.types(), 3, "canceled subscribers get no more events")
+
+	plain := muxtest.LoopbackPair(t, nil, nil)
+	_, err := reg.Add(plain.A)
+	r.Equal(ErrNoKey, err)
+}
@@ -0,0 +1,43 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"net"
+)
+
+type requestContextKey struct{}
+
+// RequestFromContext returns the incoming request whose handler got ctx (or a context derived from it).
+// It lets code deep down in a handler get at the method, request ID and the caller without passing them along.
+func RequestFromContext(ctx context.Context) (*Request, bool) {
+	req, ok := ctx.Value(requestContextKey{}).(*Request)
+	return req, ok
+}
+
+// Peer is the remote side of an incoming call, see PeerFromContext.
+type Peer struct {
+	// Addr is the remote address of the connection, see AddrLayers for its parts
+	Addr net.Addr
+
+	// Endpoint can be used to make calls back to the peer
+	Endpoint Endpoint
+}
+
+// Key returns the public key of the peer if the connection runs over secretstream, see RemoteKey.
+func (p Peer) Key() (ed25519.PublicKey, bool) { return RemoteKey(p.Addr) }
+
+// Certificate returns the certificate of the peer if the connection runs over TLS, see PeerCertificate.
+func (p Peer) Certificate() (*x509.Certificate, bool) { return PeerCertificate(p.Addr) }
+
+// PeerFromContext returns the caller of the incoming request whose handler got ctx.
+func PeerFromContext(ctx context.Context) (Peer, bool) {
+	req, ok := RequestFromContext(ctx)
+	if !ok {
+		return Peer{}, false
+	}
+	return Peer{Addr: req.remoteAddr, Endpoint: req.endpoint}, true
+}
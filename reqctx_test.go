@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestFromContext(t *testing.T) {
+	r := require.New(t)
+
+	_, ok := RequestFromContext(context.Background())
+	r.False(ok)
+	_, ok = PeerFromContext(context.Background())
+	r.False(ok)
+
+	// describe only gets the context, like code deep down in an application would
+	describe := func(ctx context.Context) string {
+		req, ok := RequestFromContext(ctx)
+		if !ok {
+			return "no request"
+		}
+		peer, ok := PeerFromContext(ctx)
+		if !ok || peer.Endpoint == nil {
+			return "no peer"
+		}
+		if _, ok := peer.Key(); ok {
+			return "unexpected key"
+		}
+		return fmt.Sprintf("%s %d %s", req.Method, req.ID(), peer.Addr.Network())
+	}
+
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("whoami"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		req.Return(ctx, describe(ctx))
+	})
+
+	rpc1, rpc2 := connectedPair(t, &fh1, &fh2)
+
+	var resp string
+	r.NoError(rpc1.Async(context.Background(), &resp, TypeString, Method{"whoami"}))
+	// the request ID is negative since rpc1 started the call, the manifest call came first
+	r.Equal(fmt.Sprintf("whoami -2 %s", rpc2.Remote().Network()), resp)
+}
@@ -11,6 +11,7 @@ import (
 	"net"
 	"runtime/debug"
 	"strings"
+	"time"
 
 	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/muxrpc/v2/codec"
@@ -25,6 +26,10 @@ const (
 	TypeBinary RequestEncoding = iota
 	TypeString
 	TypeJSON
+
+	// TypeAuto is only understood by Async. It decodes the reply according to the encoding the remote sent it with,
+	// see Async for the details.
+	TypeAuto
 )
 
 // IsValid returns false if the type is not known.
@@ -106,14 +111,90 @@ type Request struct {
 
 	remoteAddr net.Addr
 	endpoint   *rpc
+
+	// encoding of the bodies, if it isn't JSON
+	enc string
+
+	// see TraceID
+	trace string
+
+	// see Headers and WithHeaders
+	headers Headers
+
+	// set for our calls if they count towards WithMaxOutstandingRequests
+	holdsSlot bool
+
+	// when the call started, see WithAuditSink and WithOutcomeHook
+	started time.Time
+
+	// what changes while the call runs
+	state *requestState
 }
 
+// requestState holds the fields of a Request that are updated while it runs, from the serve loop and the watchdogs.
+// They are kept out of the struct, so that copying a Request (like its value methods do) doesn't read them.
+type requestState struct {
+	// body bytes the remote sent on this request, see WithStreamQuota and OnCallEnd
+	received int64
+
+	// set by the first closeStream, the others leave the request alone
+	closing uint32
+
+	// whether the end of the call was reported, see WithAuditSink and WithOutcomeHook
+	audited  uint32
+	reported uint32
+
+	// for the watchdog, see WithCallTimeout and WithStreamIdleTimeout
+	replied      uint32 // async only
+	answered     uint32 // our async calls, set by their reply, see DuplicateReply
+	lastReceived int64  // unix nanoseconds
+	live         uint32 // see MarkLive
+
+	// when closeStream started to wait for the remote to confirm the end, guarded by rLock
+	unackedSince time.Time
+}
+
+const bodyEncodingCBOR = "cbor"
+
+// CBORManifestMethod is the manifest entry that tells the remote we accept CBOR bodies, see WithCBOR.
+// Its type doesn't matter, for instance {"muxrpc": {"cbor": "sync"}}.
+var CBORManifestMethod = Method{"muxrpc", "cbor"}
+
+// wireRequest adds the protocol extensions to the JSON encoding of a request.
+type wireRequest struct {
+	*Request
+
+	// Encoding is set to "cbor" for calls where both sides agreed on CBOR bodies
+	Encoding string `json:"enc,omitempty"`
+
+	// Trace is the trace ID of the call, other implementations ignore it
+	Trace string `json:"trace,omitempty"`
+
+	// Headers are the metadata of the call, other implementations ignore them too
+	Headers Headers `json:"headers,omitempty"`
+}
+
+// TraceID identifies the call in the logs of both sides.
+// Outgoing calls get a new one unless their context carries one (see WithTraceID),
+// incoming calls use the one sent by the remote or get a new one.
+func (req Request) TraceID() string { return req.trace }
+
+// ID returns the number of the request on the connection. It is negative for calls the remote started.
+func (req Request) ID() int32 { return req.id }
+
 // Endpoint returns the client instance to start new calls. Mostly usefull inside handlers.
 func (req Request) Endpoint() Endpoint { return req.endpoint }
 
 // RemoteAddr returns the netwrap'ed network adddress of the underlying connection. This is usually a pair of secretstream.Addr and TCP
+// Use AddrLayers, FindAddr or TCPAddr to get at the individual layers.
 func (req Request) RemoteAddr() net.Addr { return req.remoteAddr }
 
+// String describes the request for logs. Unlike printing the struct,
+// it doesn't read the counters the serve loop updates while the request runs.
+func (req *Request) String() string {
+	return fmt.Sprintf("%s %s (req %d, trace %s)", req.Type, req.Method, req.id, req.trace)
+}
+
 // ResponseSink returns the response writer for incoming source requests.
 func (req *Request) ResponseSink() (*ByteSink, error) {
 	if req.Type != "source" && req.Type != "duplex" {
@@ -130,24 +211,55 @@ func (req *Request) ResponseSource() (*ByteSource, error) {
 	return req.source, nil
 }
 
+// CallSource returns the reader for the reply of an async call or the data of a source or duplex call that we started with DoBatch.
+func (req *Request) CallSource() (*ByteSource, error) {
+	if req.Type == "sink" {
+		return nil, ErrWrongStreamType{req.Type}
+	}
+	return req.source, nil
+}
+
+// CallSink returns the writer for a sink or duplex call that we started with DoBatch.
+func (req *Request) CallSink() (*ByteSink, error) {
+	if req.Type != "sink" && req.Type != "duplex" {
+		return nil, ErrWrongStreamType{req.Type}
+	}
+	return req.sink, nil
+}
+
 // Args is a legacy stub to get the unmarshaled json arguments
 func (req *Request) Args() []interface{} {
-	fmt.Println("[muxrpc/deprecation] warning: please use RawArgs where ever possible")
+	fmt.Println("[muxrpc/deprecation] warning: please use RawArgs or ParseArgs where ever possible")
 	debug.PrintStack()
 	var v []interface{}
 	json.Unmarshal(req.RawArgs, &v)
 	return v
 }
 
-// Return is a helper that returns on an async call
+// Return is a helper that returns on an async call.
+// Strings are sent as strings, a RawReply as it is and everything else as JSON.
+// ReturnString, ReturnBytes and ReturnJSON pick the encoding explicitly.
 func (req *Request) Return(ctx context.Context, v interface{}) error {
 	if req.Type != "async" && req.Type != "sync" {
 		return fmt.Errorf("cannot return value on %q stream", req.Type)
 	}
 
+	if rr, ok := v.(*RawReply); ok {
+		v = *rr
+	}
+
 	var b []byte
 	switch tv := v.(type) {
 
+	case RawReply:
+		flag, err := tv.Encoding.asCodecFlag()
+		if err != nil {
+			return err
+		}
+		req.sink.setEncodingFlag(flag)
+
+		b = tv.Body
+
 	case string:
 		req.sink.SetEncoding(TypeString)
 
@@ -157,19 +269,54 @@ func (req *Request) Return(ctx context.Context, v interface{}) error {
 		req.sink.SetEncoding(TypeJSON)
 
 		var err error
-		b, err = json.Marshal(v)
+		b, err = req.sink.json.Marshal(v)
 		if err != nil {
 			return fmt.Errorf("muxrpc: error marshaling return value: %w", err)
 		}
 	}
 
+	if req.endpoint != nil && req.endpoint.callTimeout > 0 && !req.markReplied() {
+		return fmt.Errorf("muxrpc: can't return value: %w", ErrHandlerTimeout)
+	}
+
 	if _, err := req.sink.Write(b); err != nil {
+		req.endpoint.auditCall(req, err)
 		return fmt.Errorf("muxrpc: error writing return value: %w", err)
 	}
 
+	// the reply ends the call, like closing a stream does
+	if err := req.sink.w.Flush(); err != nil {
+		req.endpoint.auditCall(req, err)
+		return fmt.Errorf("muxrpc: error flushing return value: %w", err)
+	}
+
+	req.endpoint.auditCall(req, nil)
+	// nothing follows the reply, the request can be forgotten
+	if req.endpoint != nil {
+		req.endpoint.retireRequest(req)
+	}
 	return nil
 }
 
+// ReturnString replies to an async call with s, sent as a string.
+func (req *Request) ReturnString(ctx context.Context, s string) error {
+	return req.Return(ctx, RawReply{Encoding: TypeString, Body: []byte(s)})
+}
+
+// ReturnBytes replies to an async call with b, sent as binary data instead of a base64 encoded JSON string.
+func (req *Request) ReturnBytes(ctx context.Context, b []byte) error {
+	return req.Return(ctx, RawReply{Encoding: TypeBinary, Body: b})
+}
+
+// ReturnJSON replies to an async call with v encoded as JSON, even if it's a string, which Return would send as it is.
+func (req *Request) ReturnJSON(ctx context.Context, v interface{}) error {
+	body, err := req.sink.json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("muxrpc: error marshaling return value: %w", err)
+	}
+	return req.Return(ctx, RawReply{Encoding: TypeJSON, Body: body})
+}
+
 // CloseWithError is used to close an ongoing request. Ie instruct the remote to stop sending data
 // or notify it that a stream couldn't be fully filled because of an error
 func (req *Request) CloseWithError(cerr error) error {
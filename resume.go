@@ -0,0 +1,216 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mindeco.de/log"
+	"go.mindeco.de/log/level"
+)
+
+// ResumePolicy describes how a ResumableSource picks up a stream after it failed.
+type ResumePolicy struct {
+	// Args returns the arguments of the source call.
+	// last is the last frame that was read, nil for the first call and as long as nothing was read.
+	// Use it to continue after that frame, for instance with a sequence number or offset.
+	Args func(last []byte) ([]interface{}, error)
+
+	// MaxResumes limits how often the call is made again. Zero means no limit.
+	MaxResumes int
+
+	// Backoff is the pause before the first resume. It doubles with each further one that fails without data, up to MaxBackoff if that is set.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Resumable decides if the stream is picked up again after err. If it is nil, DefaultResumable is used.
+	Resumable func(err error) bool
+
+	// Clock times the backoff, SystemClock if it is nil
+	Clock Clock
+
+	Logger log.Logger
+}
+
+// DefaultResumable resumes after every error except canceled contexts and methods the remote doesn't have.
+// Unlike DefaultRetryable it includes terminated sessions, since resuming on a new connection is the point.
+func DefaultResumable(err error) bool {
+	var (
+		nsm ErrNoSuchMethod
+		mu  ErrMethodUnsupported
+	)
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.As(err, &nsm), errors.As(err, &mu):
+		return false
+	}
+	return true
+}
+
+// ResumableSource is a source call that is made again when it fails, so that it reads like one continuous stream.
+// It is not safe for concurrent use.
+type ResumableSource struct {
+	ctx     context.Context
+	connect func(context.Context) (Endpoint, error)
+	re      RequestEncoding
+	method  Method
+	policy  ResumePolicy
+
+	cur    *ByteSource
+	cancel context.CancelFunc
+
+	last    []byte
+	resumes int
+	done    bool
+	err     error
+}
+
+// NewResumableSource makes the source call method on the endpoint returned by connect, with the arguments from policy.Args.
+// If the stream fails, connect is called again, so it can return the same endpoint or a new connection to the peer.
+// The call is then made again with the arguments policy.Args returns for the last frame that was read.
+// The stream ends regularly when the remote ends it without an error.
+func NewResumableSource(ctx context.Context, connect func(context.Context) (Endpoint, error), re RequestEncoding, method Method, policy ResumePolicy) *ResumableSource {
+	if policy.Resumable == nil {
+		policy.Resumable = DefaultResumable
+	}
+	if policy.Logger == nil {
+		policy.Logger = log.NewNopLogger()
+	}
+	policy.Clock = clockOrSystem(policy.Clock)
+	return &ResumableSource{
+		ctx:     ctx,
+		connect: connect,
+		re:      re,
+		method:  method,
+		policy:  policy,
+	}
+}
+
+// Subscribe makes a source call on edp that is made again whenever its session dies, like NewResumableSource.
+// It's meant for endpoints that manage their connection, like FailoverEndpoint, which reconnects on the next call
+// after its session died, so the stream continues on the new session.
+// The consumer reads one stream across all of them, policy.Args rewrites the arguments for each new call.
+// It ends once edp itself is terminated.
+func Subscribe(ctx context.Context, edp Endpoint, re RequestEncoding, method Method, policy ResumePolicy) *ResumableSource {
+	resumable := policy.Resumable
+	if resumable == nil {
+		resumable = DefaultResumable
+	}
+	policy.Resumable = func(err error) bool {
+		return edp.Err() == nil && resumable(err)
+	}
+	connect := func(context.Context) (Endpoint, error) { return edp, nil }
+	return NewResumableSource(ctx, connect, re, method, policy)
+}
+
+// open makes the source call for the next attempt
+func (rs *ResumableSource) open() error {
+	args, err := rs.policy.Args(rs.last)
+	if err != nil {
+		return fmt.Errorf("muxrpc: failed to get arguments to resume %s: %w", rs.method, err)
+	}
+
+	edp, err := rs.connect(rs.ctx)
+	if err != nil {
+		return fmt.Errorf("muxrpc: failed to connect for %s: %w", rs.method, err)
+	}
+
+	ctx, cancel := context.WithCancel(rs.ctx)
+	src, err := edp.Source(ctx, rs.re, rs.method, args...)
+	if err != nil {
+		cancel()
+		return err
+	}
+	rs.cur, rs.cancel = src, cancel
+	return nil
+}
+
+// Next blocks until there is a new frame, resuming the stream as needed. It returns false once the stream ended or can't be resumed.
+func (rs *ResumableSource) Next(ctx context.Context) bool {
+	if rs.done {
+		return false
+	}
+
+	backoff := rs.policy.Backoff
+	for {
+		var err error
+		if rs.cur == nil {
+			err = rs.open()
+		} else if rs.cur.Next(ctx) {
+			return true
+		} else if err = rs.cur.Err(); err == nil {
+			rs.finish(nil)
+			return false
+		}
+		if err == nil {
+			continue
+		}
+
+		rs.drop()
+		if ctx.Err() != nil || !rs.policy.Resumable(err) || (rs.policy.MaxResumes > 0 && rs.resumes >= rs.policy.MaxResumes) {
+			rs.finish(err)
+			return false
+		}
+		rs.resumes++
+		level.Debug(rs.policy.Logger).Log("event", "resuming source", "method", rs.method.String(), "resumes", rs.resumes, "err", err)
+
+		wake, timer := after(rs.policy.Clock, backoff)
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			timer.Stop()
+			rs.finish(err)
+			return false
+		case <-rs.ctx.Done():
+			timer.Stop()
+			rs.finish(err)
+			return false
+		}
+		backoff *= 2
+		if rs.policy.MaxBackoff > 0 && backoff > rs.policy.MaxBackoff {
+			backoff = rs.policy.MaxBackoff
+		}
+	}
+}
+
+// Bytes returns the current frame and remembers it as the point to resume from
+func (rs *ResumableSource) Bytes() ([]byte, error) {
+	if rs.cur == nil {
+		return nil, errors.New("muxrpc: no frame to read, call Next first")
+	}
+	b, err := rs.cur.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	rs.last = b
+	return b, nil
+}
+
+// Resumes returns how often the call was made again so far
+func (rs *ResumableSource) Resumes() int { return rs.resumes }
+
+// Err returns the error that ended the stream, nil if it ended regularly
+func (rs *ResumableSource) Err() error { return rs.err }
+
+// Cancel ends the stream and the current call
+func (rs *ResumableSource) Cancel() {
+	rs.drop()
+	rs.finish(nil)
+}
+
+func (rs *ResumableSource) drop() {
+	if rs.cancel != nil {
+		rs.cancel()
+	}
+	rs.cur, rs.cancel = nil, nil
+}
+
+func (rs *ResumableSource) finish(err error) {
+	rs.drop()
+	rs.done = true
+	rs.err = err
+}
@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumableSource(t *testing.T) {
+	r := require.New(t)
+
+	// counts from gt+1 to 10. On the first connection, the first call fails after three numbers
+	// and the second one hangs after six, until the connection is gone.
+	counter := func(flaky bool) *FakeHandler {
+		var (
+			fh    FakeHandler
+			calls int
+		)
+		fh.HandledCalls(methodChecker("count"))
+		fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+			var opts struct {
+				Gt int `json:"gt"`
+			}
+			if err := req.DecodeNamedArgs(&opts); err != nil {
+				req.CloseWithError(err)
+				return
+			}
+			snk, err := req.ResponseSink()
+			if err != nil {
+				return
+			}
+			calls++
+			for i := opts.Gt + 1; i <= 10; i++ {
+				if flaky && calls == 1 && i == 4 {
+					snk.CloseWithError(errors.New("db closed"))
+					return
+				}
+				if flaky && calls == 2 && i == 7 {
+					<-req.ConsumerGone()
+					return
+				}
+				fmt.Fprint(snk, i)
+			}
+			snk.Close()
+		})
+		return &fh
+	}
+
+	// the second connection is only made when the first one is gone
+	first, firstSrv := connectedPair(t, &FakeHandler{}, counter(true))
+	var connects int
+	connect := func(ctx context.Context) (Endpoint, error) {
+		connects++
+		if connects < 3 {
+			return first, nil
+		}
+		second, _ := connectedPair(t, &FakeHandler{}, counter(false))
+		return second, nil
+	}
+
+	ctx := context.Background()
+	src := NewResumableSource(ctx, connect, TypeString, Method{"count"}, ResumePolicy{
+		Args: func(last []byte) ([]interface{}, error) {
+			if last == nil {
+				return nil, nil
+			}
+			n, err := strconv.Atoi(string(last))
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{map[string]int{"gt": n}}, nil
+		},
+		Backoff: time.Millisecond,
+	})
+
+	var got []int
+	for src.Next(ctx) {
+		b, err := src.Bytes()
+		r.NoError(err)
+		n, err := strconv.Atoi(string(b))
+		r.NoError(err)
+		got = append(got, n)
+
+		// lose the connection in the middle of the resumed call
+		if n == 6 {
+			firstSrv.Terminate()
+		}
+	}
+	r.NoError(src.Err())
+	r.Equal([]int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, got)
+	r.Equal(2, src.Resumes())
+	r.Equal(3, connects)
+}
+
+func TestResumableSourceGivesUp(t *testing.T) {
+	r := require.New(t)
+
+	var fh FakeHandler
+	fh.HandledCalls(methodChecker("broken"))
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		req.CloseWithError(errors.New("nope"))
+	})
+	edp, _ := connectedPair(t, &FakeHandler{}, &fh)
+
+	ctx := context.Background()
+	src := NewResumableSource(ctx, func(context.Context) (Endpoint, error) { return edp, nil }, TypeString, Method{"broken"}, ResumePolicy{
+		Args:       func([]byte) ([]interface{}, error) { return []interface{}{json.RawMessage(`{}`)}, nil },
+		MaxResumes: 2,
+	})
+
+	r.False(src.Next(ctx))
+	var ce *CallError
+	r.True(errors.As(src.Err(), &ce), "unexpected error: %v", src.Err())
+	r.Equal(2, src.Resumes())
+	r.Equal(3, fh.HandleCallCallCount())
+}
+
+func TestSubscribe(t *testing.T) {
+	r := require.New(t)
+
+	a, b := &testPeer{t: t, name: "a"}, &testPeer{t: t, name: "b"}
+	f, err := NewFailoverEndpoint(FailoverConfig{Peers: []FailoverPeer{a.failover(), b.failover()}})
+	r.NoError(err)
+
+	ctx := context.Background()
+	src := Subscribe(ctx, f, TypeString, Method{"seq"}, ResumePolicy{
+		Args: func(last []byte) ([]interface{}, error) {
+			if last == nil {
+				return []interface{}{0}, nil
+			}
+			n, err := strconv.Atoi(string(last))
+			return []interface{}{n}, err
+		},
+	})
+
+	var got []int
+	for len(got) < 6 && src.Next(ctx) {
+		b, err := src.Bytes()
+		r.NoError(err)
+		n, err := strconv.Atoi(string(b))
+		r.NoError(err)
+		got = append(got, n)
+
+		if n == 3 {
+			a.kill() // the stream continues on b
+		}
+	}
+	r.Equal([]int{1, 2, 3, 4, 5, 6}, got)
+	r.Equal(1, src.Resumes())
+	r.True(f.Health()[1].Connected)
+
+	// the subscription ends with the endpoint instead of resuming
+	done := make(chan bool)
+	go func() { done <- src.Next(ctx) }()
+	r.NoError(f.Terminate())
+	select {
+	case more := <-done:
+		r.False(more)
+		r.Error(src.Err())
+		r.Equal(1, src.Resumes())
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscription didn't end")
+	}
+}
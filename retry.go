@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mindeco.de/log/level"
+)
+
+// RetryPolicy describes how often and when a failed Async call is repeated, see WithRetry.
+// Only use it for idempotent methods, since the remote might have processed an attempt that failed on our side.
+type RetryPolicy struct {
+	// MaxAttempts is the number of tries, including the first one
+	MaxAttempts int
+
+	// Backoff is the pause before the second attempt. It doubles with each further attempt, up to MaxBackoff if that is set.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Retryable decides if an error is worth another attempt. If it is nil, DefaultRetryable is used.
+	Retryable func(error) bool
+}
+
+// DefaultRetryable retries every error except the ones that can't go away by trying again:
+// canceled contexts, a terminated session and methods the remote doesn't have.
+func DefaultRetryable(err error) bool {
+	var nsm ErrNoSuchMethod
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, ErrSessionTerminated):
+		return false
+	case errors.As(err, &nsm):
+		return false
+	}
+	return true
+}
+
+type retryPolicyKey struct{}
+
+// WithRetry returns a context that makes Async calls using it follow p.
+func WithRetry(ctx context.Context, p RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, p)
+}
+
+// retryAsync calls once until it succeeds or the policy in ctx gives up.
+func (r *rpc) retryAsync(ctx context.Context, method Method, once func() error) error {
+	p, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy)
+	if !ok || p.MaxAttempts <= 1 {
+		return once()
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = DefaultRetryable
+	}
+
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := once()
+		if err == nil || attempt >= p.MaxAttempts || !retryable(err) {
+			return err
+		}
+		level.Debug(r.logger).Log("event", "retrying call", "method", method.String(), "attempt", attempt, "err", err)
+
+		wake, timer := after(r.clock, backoff)
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-r.serveCtx.Done():
+			timer.Stop()
+			return err
+		}
+
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsyncRetry(t *testing.T) {
+	r := require.New(t)
+
+	var calls, failFirst int32
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("flaky"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		if n := atomic.AddInt32(&calls, 1); n <= atomic.LoadInt32(&failFirst) {
+			req.CloseWithError(errors.New("transient"))
+			return
+		}
+		req.Return(ctx, "ok")
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2)
+
+	try := func(failures int32, p RetryPolicy) (int32, error) {
+		atomic.StoreInt32(&calls, 0)
+		atomic.StoreInt32(&failFirst, failures)
+		ctx := WithRetry(context.Background(), p)
+		var resp string
+		err := rpc1.Async(ctx, &resp, TypeString, Method{"flaky"})
+		return atomic.LoadInt32(&calls), err
+	}
+
+	n, err := try(2, RetryPolicy{MaxAttempts: 3, Backoff: 5 * time.Millisecond})
+	r.NoError(err)
+	r.EqualValues(3, n)
+
+	n, err = try(2, RetryPolicy{MaxAttempts: 2, Backoff: 5 * time.Millisecond})
+	r.Error(err)
+	r.EqualValues(2, n)
+
+	never := func(error) bool { return false }
+	n, err = try(2, RetryPolicy{MaxAttempts: 3, Retryable: never})
+	r.Error(err)
+	r.EqualValues(1, n)
+
+	r.False(DefaultRetryable(ErrNoSuchMethod{Method{"nope"}}))
+	r.False(DefaultRetryable(context.Canceled))
+	r.True(DefaultRetryable(&CallError{Message: "transient"}))
+}
@@ -4,7 +4,7 @@ package muxrpc
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -16,29 +16,50 @@ import (
 )
 
 // Async does an aync call on the remote.
+// Use WithRetry on ctx to repeat failed attempts.
+// A *RawReply as ret takes the reply as it is, whatever re is.
+// With TypeAuto, the method needs to be listed in the manifest of the remote as async or sync.
+// The reply is then decoded according to its encoding: JSON into any ret, strings and binary data into *string or *[]byte.
+// Replies that aren't JSON are read as they are into a *string or *[]byte with the other encodings too,
+// so methods that return raw text or binary data can be called with TypeJSON as well.
 func (r *rpc) Async(ctx context.Context, ret interface{}, re RequestEncoding, method Method, args ...interface{}) error {
-	_, ok := r.manifest.Handled(method)
-	if !ok {
-		return ErrNoSuchMethod{Method: method}
+	return r.retryAsync(ctx, method, func() error {
+		return r.async(ctx, ret, re, method, args...)
+	})
+}
+
+func (r *rpc) async(ctx context.Context, ret interface{}, re RequestEncoding, method Method, args ...interface{}) (err error) {
+	auto := re == TypeAuto
+	if auto {
+		// only infer for methods the remote told us about
+		if err := r.checkAdvertised(method, "async"); err != nil {
+			return err
+		}
+		re = TypeJSON
+	} else if err := r.checkCall(method, "async"); err != nil {
+		return err
 	}
 
-	argData, err := marshalCallArgs(args)
+	argData, err := marshalCallArgs(r.json, args)
 	if err != nil {
 		return err
 	}
 
-	ctx, cancel := context.WithCancel(ctx)
+	reqCtx, cancel := context.WithCancel(ctx)
+	bodyCodec, enc := r.bodyCodec()
 
 	req := &Request{
 		Type: "async",
 
 		abort: cancel,
 
-		source: newByteSource(ctx, r.bpool),
-		sink:   newByteSink(ctx, r.pkr.w),
+		source: newByteSource(reqCtx, r.bpool, bodyCodec),
+		sink:   newByteSink(reqCtx, r.pkr.w, bodyCodec),
 
 		Method:  method,
 		RawArgs: argData,
+
+		enc: enc,
 	}
 	req.Stream = req.source.AsStream()
 
@@ -47,11 +68,23 @@ func (r *rpc) Async(ctx context.Context, ret interface{}, re RequestEncoding, me
 		return err
 	}
 
+	defer func() { r.reportOutcome(req, err) }()
+
 	if err := r.start(ctx, req); err != nil {
 		return fmt.Errorf("muxrpc(%s): error sending request: %w", method, err)
 	}
 
-	if !req.source.Next(ctx) {
+	// an async call is done once the reply was read.
+	// if the caller canceled, abortOnCancel takes care of notifying the remote.
+	// either way nobody reads from the source anymore, so its buffers can go back to the pool.
+	defer func() {
+		if ctx.Err() == nil {
+			r.retireRequest(req)
+		}
+		req.source.buf.release()
+	}()
+
+	if !req.source.Next(reqCtx) {
 		err := req.source.Err()
 		if err == nil {
 			return fmt.Errorf("muxrpc(%s): did not receive data for request", method)
@@ -60,6 +93,19 @@ func (r *rpc) Async(ctx context.Context, ret interface{}, re RequestEncoding, me
 	}
 
 	processEntry := func(rd io.Reader) error {
+		if raw, ok := ret.(*RawReply); ok {
+			body, err := ioutil.ReadAll(rd)
+			if err != nil {
+				return fmt.Errorf("error reading reply: %w", err)
+			}
+			raw.Encoding = encodingOf(req.source.hdrFlag)
+			raw.Body = body
+			return nil
+		}
+		if auto || isRawReply(req.source.hdrFlag, ret) {
+			return decodeInferred(rd, req.source.hdrFlag, req.source.json, ret)
+		}
+
 		switch tv := ret.(type) {
 		case *[]byte:
 			if re != TypeBinary {
@@ -87,7 +133,7 @@ func (r *rpc) Async(ctx context.Context, ret interface{}, re RequestEncoding, me
 			if re != TypeJSON {
 				return fmt.Errorf("unexpected requst encoding, need TypeJSON got %v for %T", re, tv)
 			}
-			err = json.NewDecoder(rd).Decode(ret)
+			err = req.source.json.NewDecoder(rd).Decode(ret)
 			if err != nil {
 				return fmt.Errorf("error decoding json from request source: %w", err)
 			}
@@ -103,13 +149,59 @@ func (r *rpc) Async(ctx context.Context, ret interface{}, re RequestEncoding, me
 	return nil
 }
 
+// isRawReply returns true for string and binary replies that go into a *string or *[]byte, which don't need decoding
+func isRawReply(flag codec.Flag, ret interface{}) bool {
+	if flag.Get(codec.FlagJSON) {
+		return false
+	}
+	switch ret.(type) {
+	case *string, *[]byte:
+		return true
+	}
+	return false
+}
+
+// decodeInferred decodes a reply of an Async call with TypeAuto, based on the flags of the reply packet
+func decodeInferred(rd io.Reader, flag codec.Flag, jc JSONCodec, ret interface{}) error {
+	isJSON := flag.Get(codec.FlagJSON)
+	switch tv := ret.(type) {
+	case *[]byte:
+		bs, err := ioutil.ReadAll(rd)
+		if err != nil {
+			return fmt.Errorf("error reading reply: %w", err)
+		}
+		*tv = bs
+		return nil
+
+	case *string:
+		if isJSON {
+			break
+		}
+		bs, err := ioutil.ReadAll(rd)
+		if err != nil {
+			return fmt.Errorf("error reading reply: %w", err)
+		}
+		*tv = string(bs)
+		return nil
+
+	default:
+		if !isJSON {
+			return fmt.Errorf("can't decode a reply without the JSON flag into %T", ret)
+		}
+	}
+
+	if err := jc.NewDecoder(rd).Decode(ret); err != nil {
+		return fmt.Errorf("error decoding json reply: %w", err)
+	}
+	return nil
+}
+
 func (r *rpc) Source(ctx context.Context, re RequestEncoding, method Method, args ...interface{}) (*ByteSource, error) {
-	_, ok := r.manifest.Handled(method)
-	if !ok {
-		return nil, ErrNoSuchMethod{Method: method}
+	if err := r.checkCall(method, "source"); err != nil {
+		return nil, err
 	}
 
-	argData, err := marshalCallArgs(args)
+	argData, err := marshalCallArgs(r.json, args)
 	if err != nil {
 		return nil, err
 	}
@@ -119,18 +211,21 @@ func (r *rpc) Source(ctx context.Context, re RequestEncoding, method Method, arg
 		return nil, err
 	}
 
-	ctx, cancel := context.WithCancel(ctx)
+	reqCtx, cancel := context.WithCancel(ctx)
+	bodyCodec, enc := r.bodyCodec()
 
 	req := &Request{
 		Type: "source",
 
 		abort: cancel,
 
-		source: newByteSource(ctx, r.bpool),
-		sink:   newByteSink(ctx, r.pkr.w),
+		source: newByteSource(reqCtx, r.bpool, bodyCodec),
+		sink:   newByteSink(reqCtx, r.pkr.w, bodyCodec),
 
 		Method:  method,
 		RawArgs: argData,
+
+		enc: enc,
 	}
 	req.sink.pkt.Flag = req.sink.pkt.Flag.Set(encFlag)
 
@@ -145,12 +240,11 @@ func (r *rpc) Source(ctx context.Context, re RequestEncoding, method Method, arg
 
 // Sink does a sink call on the remote.
 func (r *rpc) Sink(ctx context.Context, re RequestEncoding, method Method, args ...interface{}) (*ByteSink, error) {
-	_, ok := r.manifest.Handled(method)
-	if !ok {
-		return nil, ErrNoSuchMethod{Method: method}
+	if err := r.checkCall(method, "sink"); err != nil {
+		return nil, err
 	}
 
-	argData, err := marshalCallArgs(args)
+	argData, err := marshalCallArgs(r.json, args)
 	if err != nil {
 		return nil, err
 	}
@@ -160,17 +254,20 @@ func (r *rpc) Sink(ctx context.Context, re RequestEncoding, method Method, args
 		return nil, err
 	}
 
-	ctx, cancel := context.WithCancel(ctx)
+	reqCtx, cancel := context.WithCancel(ctx)
+	bodyCodec, enc := r.bodyCodec()
 
 	req := &Request{
 		Type: "sink",
 
 		abort:  cancel,
-		sink:   newByteSink(ctx, r.pkr.w),
-		source: newByteSource(ctx, r.bpool),
+		sink:   newByteSink(reqCtx, r.pkr.w, bodyCodec),
+		source: newByteSource(reqCtx, r.bpool, bodyCodec),
 
 		Method:  method,
 		RawArgs: argData,
+
+		enc: enc,
 	}
 	req.sink.pkt.Flag = req.sink.pkt.Flag.Set(encFlag).Set(codec.FlagStream)
 	req.Stream = req.sink.AsStream()
@@ -182,14 +279,19 @@ func (r *rpc) Sink(ctx context.Context, re RequestEncoding, method Method, args
 	return req.sink, nil
 }
 
+// SinkBytes starts a sink call that sends binary data, like Sink with TypeBinary.
+// Each write goes out as one frame as it is, without the JSON quoting or base64 encoding a []byte would get.
+func (r *rpc) SinkBytes(ctx context.Context, method Method, args ...interface{}) (*ByteSink, error) {
+	return r.Sink(ctx, TypeBinary, method, args...)
+}
+
 // Duplex does a duplex call on the remote.
 func (r *rpc) Duplex(ctx context.Context, re RequestEncoding, method Method, args ...interface{}) (*ByteSource, *ByteSink, error) {
-	_, ok := r.manifest.Handled(method)
-	if !ok {
-		return nil, nil, ErrNoSuchMethod{Method: method}
+	if err := r.checkCall(method, "duplex"); err != nil {
+		return nil, nil, err
 	}
 
-	argData, err := marshalCallArgs(args)
+	argData, err := marshalCallArgs(r.json, args)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -199,10 +301,11 @@ func (r *rpc) Duplex(ctx context.Context, re RequestEncoding, method Method, arg
 		return nil, nil, err
 	}
 
-	ctx, cancel := context.WithCancel(ctx)
+	reqCtx, cancel := context.WithCancel(ctx)
+	bodyCodec, enc := r.bodyCodec()
 
-	bSrc := newByteSource(ctx, r.bpool)
-	bSink := newByteSink(ctx, r.pkr.w)
+	bSrc := newByteSource(reqCtx, r.bpool, bodyCodec)
+	bSink := newByteSink(reqCtx, r.pkr.w, bodyCodec)
 	bSink.pkt.Flag = bSink.pkt.Flag.Set(encFlag).Set(codec.FlagStream)
 
 	req := &Request{
@@ -215,6 +318,8 @@ func (r *rpc) Duplex(ctx context.Context, re RequestEncoding, method Method, arg
 
 		Method:  method,
 		RawArgs: argData,
+
+		enc: enc,
 	}
 
 	req.Stream = &streamDuplex{bSrc.AsStream(), bSink.AsStream()}
@@ -226,53 +331,171 @@ func (r *rpc) Duplex(ctx context.Context, re RequestEncoding, method Method, arg
 	return bSrc, bSink, nil
 }
 
-// start starts a new call by allocating a request id and sending the first packet
+// start starts a new call by allocating a request id and sending the first packet.
+// ctx is the context of the caller, once it is canceled the remote is told to stop working on the request.
 func (r *rpc) start(ctx context.Context, req *Request) error {
+	// don't bother the closed connection, see also the check below
+	if err := r.Err(); err != nil {
+		return err
+	}
+
 	if req.abort == nil {
 		req.abort = func() {} // noop
 	}
+	req.state = new(requestState)
 
 	if req.RawArgs == nil {
 		req.RawArgs = []byte("[]")
 	}
 
+	if err := r.acquireSlot(ctx); err != nil {
+		return err
+	}
+	req.holdsSlot = r.outstanding != nil
+	req.trace = traceFor(ctx)
+	req.headers = r.requestHeaders(ctx)
+
 	var (
 		first codec.Packet
 		err   error
 
 		dbg = log.With(level.Debug(r.logger),
 			"call", req.Type,
-			"method", req.Method.String())
+			"method", req.Method.String(),
+			"trace", req.trace)
 	)
 
 	func() { // localize locking
 		r.rLock.Lock()
 		defer r.rLock.Unlock()
 
+		// Terminate cancels before it ends the requests, under the same lock,
+		// so the request is either refused here or ended there.
+		if r.serveCtx.Err() != nil {
+			err = ErrSessionTerminated
+			return
+		}
+
 		first.Flag = first.Flag.Set(codec.FlagJSON)
 		first.Flag = first.Flag.Set(req.Type.Flags())
-		first.Body, err = json.Marshal(req)
+		first.Body, err = r.json.Marshal(wireRequest{Request: req, Encoding: req.enc, Trace: req.trace, Headers: req.headers})
+		if err != nil {
+			return
+		}
+
+		// set before the request is visible to the watchdogs
+		req.started = r.clock.Now()
+		req.sink.now = r.clock.Now
+		r.instrumentSink(req)
+		r.trackMemory(req)
+		markLiveCall(ctx, req)
 
-		r.highest++
-		first.Req = r.highest
+		first.Req, err = r.nextID()
+		if err != nil {
+			return
+		}
 		r.reqs[first.Req] = req
 
 		req.id = first.Req
 		req.sink.pkt.Req = first.Req
 	}()
 	if err != nil {
+		r.releaseSlot()
 		dbg.Log("event", "request create failed", "err", err)
 		return err
 	}
 
 	dbg = log.With(dbg, "reqID", req.id)
 
+	r.callStarted(req)
 	err = r.pkr.w.WritePacket(first)
+	if err == nil {
+		// the call doesn't go anywhere until the remote sees it
+		err = r.pkr.w.Flush()
+	}
 	if err != nil {
+		// nothing would remove the request later, abortOnCancel isn't running for it
+		r.rLock.Lock()
+		r.forgetRequest(req.id)
+		r.rLock.Unlock()
+		req.source.Cancel(err)
+		req.abort()
+		r.reportOutcome(req, err)
 		return err
 	}
 
 	dbg.Log("event", "request sent", "flag", first.Flag.String())
 
+	go r.abortOnCancel(ctx, req)
+
 	return nil
 }
+
+// acquireSlot takes one of the outstanding request slots, if they are limited
+func (r *rpc) acquireSlot(ctx context.Context) error {
+	if r.outstanding == nil {
+		return nil
+	}
+	if r.outstandingFailFast {
+		select {
+		case r.outstanding <- struct{}{}:
+			return nil
+		default:
+			return ErrTooManyRequests
+		}
+	}
+	select {
+	case r.outstanding <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-r.serveCtx.Done():
+		return r.Err()
+	}
+}
+
+// releaseSlot gives back a slot taken by acquireSlot
+func (r *rpc) releaseSlot() {
+	if r.outstanding != nil {
+		<-r.outstanding
+	}
+}
+
+// bodyCodec returns the codec for the bodies of a new call and the name of its encoding, which is empty for JSON
+func (r *rpc) bodyCodec() (JSONCodec, string) {
+	if r.cbor != nil && (r.manifest.advertises(CBORManifestMethod) || r.hasExtension(ExtensionCBOR)) {
+		return r.cbor, bodyEncodingCBOR
+	}
+	return r.json, ""
+}
+
+// abortOnCancel waits for the context of the caller to be canceled and, if the request is still active,
+// sends an EndErr packet for it so that the remote can release the resources it allocated for it.
+// Plain cancellation ends the request with true (like ssb-js does when aborting a stream), other context errors are sent as an error.
+func (r *rpc) abortOnCancel(ctx context.Context, req *Request) {
+	select {
+	case <-ctx.Done():
+	case <-req.sink.streamCtx.Done():
+	case <-r.serveCtx.Done():
+		return
+	}
+
+	if ctx.Err() == nil { // closed regularly
+		return
+	}
+
+	r.rLock.RLock()
+	_, active := r.reqs[req.id]
+	r.rLock.RUnlock()
+	if !active {
+		return
+	}
+
+	level.Debug(r.logger).Log("event", "call canceled", "reqID", req.id, "trace", req.trace, "method", req.Method.String(), "err", ctx.Err())
+
+	var endErr error
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		endErr = ctx.Err()
+	}
+	r.closeStream(req, endErr)
+}
@@ -23,13 +23,14 @@ func (r *rpc) retreiveManifest() {
 	var req = Request{
 		Type: "sync",
 
-		sink:   newByteSink(ctx, r.pkr.w),
-		source: newByteSource(ctx, r.bpool),
+		sink:   newByteSink(ctx, r.pkr.w, StdJSON),
+		source: newByteSource(ctx, r.bpool, StdJSON),
 
 		Method:  Method{"manifest"},
 		RawArgs: json.RawMessage(`[]`),
 
 		abort: func() {},
+		state: new(requestState),
 	}
 
 	var (
@@ -46,8 +47,11 @@ func (r *rpc) retreiveManifest() {
 		pkt.Flag = pkt.Flag.Set(codec.FlagJSON)
 		pkt.Body = []byte(`{"name":"manifest","args":[],"type":"async"}`)
 
-		r.highest++
-		pkt.Req = r.highest
+		pkt.Req, err = r.nextID()
+		if err != nil {
+			return
+		}
+		r.trackMemory(&req)
 		r.reqs[pkt.Req] = &req
 
 		req.id = pkt.Req
@@ -61,6 +65,9 @@ func (r *rpc) retreiveManifest() {
 	dbg = log.With(dbg, "reqID", req.id)
 
 	err = r.pkr.w.WritePacket(pkt)
+	if err == nil {
+		err = r.pkr.w.Flush()
+	}
 	if err != nil {
 		dbg.Log("event", "manifest request failed to send", "err", err)
 		return
@@ -92,6 +99,14 @@ type manifestStruct struct {
 	methods manifestMap
 }
 
+// advertises is like Handled but false if we don't have a manifest
+func (ms *manifestStruct) advertises(m Method) bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	_, has := ms.methods[m.String()]
+	return !ms.missing && has
+}
+
 func (ms *manifestStruct) Handled(m Method) (string, bool) {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
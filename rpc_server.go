@@ -4,7 +4,7 @@ package muxrpc
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/ed25519"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -12,8 +12,9 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/karrick/bufpool"
 	"github.com/pkg/errors"
 	"go.mindeco.de/log"
 	"go.mindeco.de/log/level"
@@ -39,6 +40,13 @@ func WithRemoteAddr(addr net.Addr) HandleOption {
 	}
 }
 
+// WithLocalAddr sets the local address of the endpoint, like WithRemoteAddr does for the remote.
+func WithLocalAddr(addr net.Addr) HandleOption {
+	return func(r *rpc) {
+		r.local = addr
+	}
+}
+
 // WithLogger let's you overwrite the stderr logger
 func WithLogger(l log.Logger) HandleOption {
 	return func(r *rpc) {
@@ -53,6 +61,63 @@ func WithIsServer(yes bool) HandleOption {
 	}
 }
 
+// WithTerminateGracePeriod sets how long Terminate waits for the remote to acknowledge the end of the streams we started.
+// Zero disables the cooperative part of the shutdown and closes the connection right away.
+func WithTerminateGracePeriod(d time.Duration) HandleOption {
+	return func(r *rpc) {
+		r.terminateGrace = d
+	}
+}
+
+// WithJSONCodec lets the endpoint use c instead of encoding/json, for instance a faster drop-in replacement.
+func WithJSONCodec(c JSONCodec) HandleOption {
+	return func(r *rpc) {
+		r.json = c
+	}
+}
+
+// WithCBOR lets the endpoint exchange CBOR instead of JSON bodies with remotes that support it as well.
+// c does the actual encoding, wrapping a CBOR library of your choice.
+// Our manifest needs to list CBORManifestMethod so that the remote knows it can use CBOR with us,
+// unless both sides agree on ExtensionCBOR, see WithExtensions.
+// Calls to remotes which don't list it in theirs keep using JSON.
+// Only the bodies of replies and stream data are affected, call arguments and errors are always JSON.
+func WithCBOR(c JSONCodec) HandleOption {
+	return func(r *rpc) {
+		r.cbor = c
+	}
+}
+
+// WithStreamQuota limits how many body bytes the remote may send on a single stream.
+// Streams that exceed it are closed with ErrQuotaExceeded. Zero means no limit.
+func WithStreamQuota(max int64) HandleOption {
+	return func(r *rpc) {
+		r.streamQuota = max
+	}
+}
+
+// WithSessionQuota limits how many body bytes the remote may send over the whole session.
+// If it exceeds them, the session is terminated and Serve returns ErrQuotaExceeded. Zero means no limit.
+func WithSessionQuota(max int64) HandleOption {
+	return func(r *rpc) {
+		r.sessionQuota = max
+	}
+}
+
+// WithMaxOutstandingRequests limits how many calls we can have open on the remote at the same time.
+// Once the limit is reached, new calls wait until a slot frees up or their context is canceled.
+// With failFast they return ErrTooManyRequests instead of waiting. Zero means no limit.
+func WithMaxOutstandingRequests(n int, failFast bool) HandleOption {
+	return func(r *rpc) {
+		if n <= 0 {
+			r.outstanding = nil
+			return
+		}
+		r.outstanding = make(chan struct{}, n)
+		r.outstandingFailFast = failFast
+	}
+}
+
 // IsServer tells you if the passed endpoint is in the server-role or not.
 // i.e.: Did I call the remote: yes.
 // Was I called by the remote: no.
@@ -71,8 +136,15 @@ func Handle(pkr *Packer, handler Handler, opts ...HandleOption) Endpoint {
 	r := &rpc{
 		pkr:        pkr,
 		reqs:       make(map[int32]*Request),
-		reqsClosed: make(map[int32]struct{}),
+		reqsClosed: make(map[int32]closedState),
 		root:       handler,
+
+		reqsUnacked: make(map[int32]*Request),
+
+		terminateGrace: defaultTerminateGrace,
+		handlerGrace:   defaultHandlerGrace,
+		json:           StdJSON,
+		clock:          SystemClock,
 	}
 
 	// apply options
@@ -87,26 +159,51 @@ func Handle(pkr *Packer, handler Handler, opts ...HandleOption) Endpoint {
 		r.logger = log.With(logger, "ts", log.DefaultTimestampUTC, "unit", "muxrpc")
 	}
 
+	r.remoteKey = pkr.remoteKey
 	if r.remote == nil {
 		if ra, ok := pkr.c.(interface{ RemoteAddr() net.Addr }); ok {
 			r.remote = ra.RemoteAddr()
 		}
 	}
 
+	if r.local == nil {
+		if la, ok := pkr.c.(interface{ LocalAddr() net.Addr }); ok {
+			r.local = la.LocalAddr()
+		}
+	}
+
 	if r.remote != nil {
 		// TODO: retract remote address
 		r.logger = log.With(r.logger, "remote", r.remote.String())
 	}
 
+	if r.peerClasses != nil {
+		r.selectPeerHandler()
+	}
+
+	if r.clientOnly {
+		var connect ConnectHandler
+		if r.root != nil {
+			connect = r.root
+		}
+		r.root = clientOnlyHandler{connect: connect}
+	}
+	if r.health {
+		r.root = healthHandler{Handler: r.root, check: r.healthCheck}
+	}
+	r.root = extensionsHandler{Handler: r.root, r: r}
+
 	if r.serveCtx == nil {
 		r.serveCtx = context.Background()
 	}
 
-	bp, err := bufpool.NewChanPool()
-	if err != nil {
-		panic(err)
+	if r.bpool == nil {
+		r.bpool = defaultBufferPool
+	}
+
+	if r.ids == nil {
+		r.ids = SequentialIDs()
 	}
-	r.bpool = bp
 
 	// we need to be able to cancel in any case
 	r.serveCtx, r.cancel = context.WithCancel(r.serveCtx)
@@ -117,30 +214,40 @@ func Handle(pkr *Packer, handler Handler, opts ...HandleOption) Endpoint {
 	manifestDone := make(chan struct{})
 	go func() {
 		r.retreiveManifest()
+		r.negotiateExtensions()
 		close(manifestDone)
 	}()
 
+	if r.requestTTL > 0 {
+		go r.sweepRequests()
+	}
+
 	// start serving
 	r.serveErrc = make(chan error)
+	r.serveDone = make(chan struct{})
 	go func() {
 		r.serveErrc <- r.serve()
 	}()
 
 	<-manifestDone
 
-	go handler.HandleConnect(r.serveCtx, r)
+	if r.sessionStart != nil && !r.startSession() {
+		return r
+	}
+
+	r.spawn(nil, func() { r.root.HandleConnect(r.serveCtx, r) })
 
 	return r
 }
 
 // no args should be handled as empty array not args: null
-func marshalCallArgs(args []interface{}) ([]byte, error) {
+func marshalCallArgs(jc JSONCodec, args []interface{}) ([]byte, error) {
 	var argData []byte
 	if len(args) == 0 {
 		argData = []byte("[]")
 	} else {
 		var err error
-		argData, err = json.Marshal(args)
+		argData, err = jc.Marshal(args)
 		if err != nil {
 			return nil, fmt.Errorf("error marshaling request arguments: %w", err)
 		}
@@ -158,31 +265,107 @@ type rpc struct {
 	logger log.Logger
 
 	remote net.Addr
+	local  net.Addr
+
+	// set by NewSecretStreamPacker, see RemoteKey
+	remoteKey ed25519.PublicKey
+
+	// per-connection values, see SetMeta
+	meta metadata
 
 	isServer bool // is this rpc endpoint in the server role?
 
 	// pkr (un)marshales codec.Packets
 	pkr *Packer
 
-	bpool bufpool.FreeList
+	bpool BufferPool
 
 	// reqs is the map we keep, tracking all requests
 	reqs map[int32]*Request
 	// reqs we didnt accept still might send data
 	// like duplex or sink, the remote might send early data before we even get a chance to send an EndErr
-	reqsClosed map[int32]struct{}
-	rLock      sync.RWMutex
+	reqsClosed map[int32]closedState
+	// streams we closed locally but the remote didn't confirm yet, see ByteSink.AwaitRemoteClose
+	reqsUnacked map[int32]*Request
+	rLock       sync.RWMutex
+
+	// hands out the ids of the requests we start, see WithIDAllocator
+	ids IDAllocator
 
-	// highest is the highest request id we already allocated
-	highest int32
+	root       Handler
+	clientOnly bool // root only answers the manifest, see WithClientOnly
 
-	root Handler
+	peerClasses *peerClasses // picks root by the identity of the remote, see WithPeerHandlers
+	peerClass   string
+
+	manifestGating bool // see WithManifestGating
 
 	// terminated indicates that the rpc session is being terminated
 	terminated bool
+	termErr    error // what the remaining calls are closed with, see TerminateWithError
 	tLock      sync.Mutex
 
+	aborted int                // calls that were still running when Terminate was called
+	ended   *SessionEndedError // set once the serve loop stopped, see SessionEnd
+
+	json JSONCodec
+	cbor JSONCodec // nil unless WithCBOR is used
+
+	// how long Terminate waits for the remote to end our streams
+	terminateGrace time.Duration
+
+	// the goroutines running our handler, Serve waits for them up to handlerGrace
+	handlers     sync.WaitGroup
+	handlerGrace time.Duration
+
+	clock Clock
+
+	leaks *leakTracker // nil unless WithLeakCheck is used
+
+	audit   AuditSink   // nil unless WithAuditSink is used
+	outcome OutcomeHook // nil unless WithOutcomeHook is used
+	hooks   callHooks   // see OnCallStart and OnCallEnd
+
+	// watchdog limits for incoming calls
+	callTimeout       time.Duration
+	streamIdleTimeout time.Duration
+
+	requestTTL time.Duration // see WithRequestTTL
+
+	propagateDeadlines bool // see WithDeadlinePropagation
+
+	extensions extensionSet // see WithExtensions
+
+	lenientIDs bool   // see WithLenientRequestIDs
+	flippedIDs uint32 // how many ids of the remote had the wrong sign
+
+	// holds a token for each call we started, if their number is limited (see WithMaxOutstandingRequests)
+	outstanding         chan struct{}
+	outstandingFailFast bool
+
+	// limits for the bytes the remote sends us, see WithStreamQuota and WithSessionQuota
+	streamQuota  int64
+	sessionQuota int64
+	received     int64 // only used by the serve loop
+
+	budgets  []*MemoryBudget // see WithMemoryBudget
+	buffered int64           // bytes of incoming frames that weren't read yet, see BufferedBytes
+
+	stallThreshold time.Duration // see WithWriteStallHook
+	stallHook      WriteStallHook
+
+	strictPackets bool      // see WithStrictPackets
+	anomalies     [3]uint64 // by PacketAnomaly, see Anomalies
+
+	compat Compat // see WithCompat
+
+	sessionStart SessionStartHook // see WithSessionStartHook
+
+	health      bool // answer HealthMethod, see WithHealthCheck
+	healthCheck HealthCheck
+
 	serveErrc chan error
+	serveDone chan struct{} // closed once the serve loop stopped reading
 	serveCtx  context.Context
 	cancel    context.CancelFunc
 
@@ -194,16 +377,25 @@ var errSkip = errors.New("mxurpc: skip packet")
 // we might receive data for requests we chose to not handle
 func (r *rpc) maybeDiscardPacket(hdr codec.Header) error {
 	r.rLock.RLock()
-	defer r.rLock.RUnlock()
-	if _, ignore := r.reqsClosed[hdr.Req]; ignore {
-		rd := r.pkr.r.NextBodyReader(hdr.Len)
-		_, err := io.Copy(ioutil.Discard, rd)
-		if err != nil {
+	state, ignore := r.reqsClosed[hdr.Req]
+	r.rLock.RUnlock()
+	if !ignore {
+		return nil
+	}
+
+	if a := state.anomaly(); a != 0 && !hdr.Flag.Get(codec.FlagEndErr) {
+		if err := r.dropAnomaly(hdr, a); err != nil {
 			return err
 		}
 		return errSkip
 	}
-	return nil
+
+	rd := r.pkr.r.NextBodyReader(hdr.Len)
+	_, err := io.Copy(ioutil.Discard, rd)
+	if err != nil {
+		return err
+	}
+	return errSkip
 }
 
 // fetchRequest returns the request from the reqs map or, if it's not there yet, builds a new one.
@@ -223,13 +415,26 @@ func (r *rpc) fetchRequest(ctx context.Context, hdr *codec.Header) (*Request, bo
 	r.rLock.Lock()
 	defer r.rLock.Unlock()
 
+	// positive ids are replies to our calls, new calls of the remote have negative ones
+	if hdr.Req > 0 {
+		return nil, true, r.dropAnomaly(*hdr, ReplyBeforeRequest)
+	}
+
 	ctx, req, err = r.parseNewRequest(hdr, ctx)
 	if err != nil {
-		return nil, false, err
+		var me malformedError
+		if !errors.As(err, &me) {
+			return nil, false, err
+		}
+		return nil, true, r.refuseMalformed(hdr, me)
 	}
 
+	req.started = r.clock.Now()
+	r.callStarted(req)
+
 	// check if we handle the method and if not, mark the request as closed for potentially incoming data for that request
 	if !r.root.Handled(req.Method) {
+		r.auditCall(req, ErrNoSuchMethod{req.Method})
 		errPkt, err := newEndErrPacket(hdr.Req, hdr.Flag.Get(codec.FlagStream), ErrNoSuchMethod{req.Method})
 		if err != nil {
 			return nil, false, err
@@ -238,7 +443,7 @@ func (r *rpc) fetchRequest(ctx context.Context, hdr *codec.Header) (*Request, bo
 		if err != nil {
 			return nil, false, err
 		}
-		r.reqsClosed[hdr.Req] = struct{}{}
+		r.reqsClosed[hdr.Req] = closedLocally
 		// it is a new call in that there is nothing else to do
 		return nil, true, nil
 	}
@@ -250,34 +455,73 @@ func (r *rpc) fetchRequest(ctx context.Context, hdr *codec.Header) (*Request, bo
 	// buffer new requests to not mindlessly spawn goroutines
 	// and prioritize exisitng requests to unblock the connection time
 	// maybe use two maps
-	go func() {
+	r.watch(req)
+	ctx = r.applyRemoteDeadline(ctx, req)
+	r.spawn(req, func() {
 		r.root.HandleCall(ctx, req)
-		level.Debug(r.logger).Log("call", "returned", "method", req.Method, "reqID", req.id)
-	}()
+		level.Debug(r.logger).Log("call", "returned", "method", req.Method, "reqID", req.id, "trace", req.trace)
+	})
 
 	return req, true, nil
 }
 
-// parseNewRequest parses the first packet of a stream and parses the contained request
-func (r *rpc) parseNewRequest(pkt *codec.Header, sessionCtx context.Context) (context.Context, *Request, error) {
+// parseNewRequest parses the first packet of a stream and parses the contained request.
+// If the body doesn't describe a valid call, the rest of it is skipped and the error is a malformedError,
+// which only concerns this request. Other errors end the session.
+func (r *rpc) parseNewRequest(pkt *codec.Header, sessionCtx context.Context) (_ context.Context, _ *Request, err error) {
 	if pkt.Req >= 0 {
 		// request numbers should have been inverted by now
 		return nil, nil, fmt.Errorf("new request %d: expected negative request id", pkt.Req)
 	}
 
+	rd := r.pkr.r.NextBodyReader(pkt.Len)
+	defer func() {
+		if err != nil {
+			malformed, fatal := skipMalformed(pkt.Req, rd, err)
+			if fatal != nil {
+				err = fatal
+			} else {
+				err = malformed
+			}
+		}
+	}()
+
 	// the description of a call (what methods and args) is always JSON
 	if !pkt.Flag.Get(codec.FlagJSON) {
 		return nil, nil, fmt.Errorf("new request %d: expected JSON flag for new call, got %s", pkt.Req, pkt.Flag)
 	}
 
 	// decode the json body of the new request
-	rd := r.pkr.r.NextBodyReader(pkt.Len)
-
 	var req Request
-	err := json.NewDecoder(rd).Decode(&req)
+	wr := wireRequest{Request: &req}
+	err = r.json.NewDecoder(rd).Decode(&wr)
 	if err != nil {
 		return nil, nil, fmt.Errorf("new request %d: error decoding packet: %w", pkt.Req, err)
 	}
+	req.state = new(requestState)
+	req.enc = wr.Encoding
+	req.trace = wr.Trace
+	req.headers = normalizeHeaders(wr.Headers)
+	if req.trace == "" {
+		req.trace = newTraceID()
+	}
+
+	// the decoder might stop before trailing whitespace, which would otherwise be read as the next header
+	if _, err := io.Copy(ioutil.Discard, rd); err != nil {
+		return nil, nil, fmt.Errorf("new request %d: error reading rest of packet: %w", pkt.Req, err)
+	}
+
+	bodyCodec := r.json
+	switch req.enc {
+	case "":
+	case bodyEncodingCBOR:
+		if r.cbor == nil {
+			return nil, nil, fmt.Errorf("new request %d: remote wants CBOR bodies but we don't support them", pkt.Req)
+		}
+		bodyCodec = r.cbor
+	default:
+		return nil, nil, fmt.Errorf("new request %d: unsupported body encoding %q", pkt.Req, req.enc)
+	}
 
 	// initialize the other fields of the request
 	req.remoteAddr = r.remote
@@ -288,15 +532,28 @@ func (r *rpc) parseNewRequest(pkt *codec.Header, sessionCtx context.Context) (co
 	// prepare for shutting it down
 	reqCtx, reqCancel := context.WithCancel(sessionCtx)
 	req.abort = reqCancel
+	reqCtx = context.WithValue(reqCtx, requestContextKey{}, &req)
 
 	// initialize sending and receiving sides of the stream
-	req.sink = newByteSink(reqCtx, r.pkr.w)
+	req.sink = newByteSink(reqCtx, r.pkr.w, bodyCodec)
 	req.sink.pkt.Req = req.id
+	req.sink.now = r.clock.Now
+	r.instrumentSink(&req)
 
-	req.source = newByteSource(reqCtx, r.bpool)
+	req.source = newByteSource(reqCtx, r.bpool, bodyCodec)
+	r.trackMemory(&req)
+
+	isStream := pkt.Flag.Get(codec.FlagStream)
+	if req.Type == "" && r.compat.EmptyTypeIsAsync {
+		req.Type = "async"
+	}
+	if r.compat.LooseStreamFlag && isStream != req.Type.Flags().Get(codec.FlagStream) {
+		level.Debug(r.logger).Log("event", "stream flag doesn't fit the call type", "reqID", req.id, "type", req.Type, "flags", pkt.Flag)
+		isStream = !isStream
+	}
 
 	// legacy streams (TODO: remove these)
-	if pkt.Flag.Get(codec.FlagStream) {
+	if isStream {
 		req.sink.pkt.Flag = req.sink.pkt.Flag.Set(codec.FlagStream)
 		switch req.Type {
 		case "duplex":
@@ -309,16 +566,13 @@ func (r *rpc) parseNewRequest(pkt *codec.Header, sessionCtx context.Context) (co
 			return nil, nil, fmt.Errorf("new request %d: unhandled request type: %q", req.id, req.Type)
 		}
 	} else {
-		if req.Type == "" {
-			req.Type = "async"
-		}
 		if req.Type != "async" {
 			return nil, nil, fmt.Errorf("new request %d: unhandled request type: %q", req.id, req.Type)
 		}
 		req.Stream = req.sink.AsStream()
 	}
 
-	level.Debug(r.logger).Log("event", "got request", "reqID", req.id, "method", req.Method, "type", req.Type)
+	level.Debug(r.logger).Log("event", "got request", "reqID", req.id, "trace", req.trace, "method", req.Method, "type", req.Type)
 
 	return reqCtx, &req, nil
 }
@@ -329,24 +583,47 @@ type Server interface {
 	Serve() error
 }
 
-// Serve drains the incoming packets and handles the RPC session
+// Serve drains the incoming packets and handles the RPC session.
+// Once the session ended, it waits for the handlers to return, see WithHandlerGracePeriod.
 func (r *rpc) Serve() error {
-	return <-r.serveErrc
+	err := <-r.serveErrc
+	r.waitHandlers()
+	return err
 }
 
+// serve runs the session until it ends. It returns a *SessionEndedError unless the session ended cleanly.
 func (r *rpc) serve() (err error) {
 	level.Debug(r.logger).Log("event", "serving")
+
+	// where the session was and why it ended, if it ended cleanly and err is nil
+	phase, cause := PhaseHandle, error(nil)
 	defer func() {
 		if isAlreadyClosed(err) {
-			err = nil
+			cause, err = err, nil
 		}
-		cerr := r.Terminate()
+		close(r.serveDone)
+		cerr := r.TerminateWithError(context.Background(), err)
 		if err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
 			level.Error(r.logger).Log(
 				"event", "closed",
 				"handleErr", err,
 				"closeErr", cerr)
 		}
+
+		end := &SessionEndedError{Phase: phase, Cause: err}
+		if err == nil {
+			end.Cause = cause
+		}
+		r.tLock.Lock()
+		end.Aborted = r.aborted
+		r.ended = end
+		r.tLock.Unlock()
+		if r.leaks != nil {
+			go r.reportLeaks()
+		}
+		if err != nil {
+			err = end
+		}
 	}()
 
 	for {
@@ -355,29 +632,38 @@ func (r *rpc) serve() (err error) {
 		// read next packet from connection
 		doRet := func() bool {
 			err = r.pkr.NextHeader(r.serveCtx, &hdr)
-			if isAlreadyClosed(err) {
+			if err == nil {
+				return false
+			}
+
+			r.tLock.Lock()
+			defer r.tLock.Unlock()
+			if r.terminated {
+				phase, cause = PhaseTerminate, r.termErr
 				err = nil
 				return true
 			}
 
-			if err != nil {
-				r.tLock.Lock()
-				defer r.tLock.Unlock()
-
-				if r.terminated {
-					err = nil
-					return true
-				}
-				err = fmt.Errorf("muxrpc: serve failed to read from packer: %w", err)
+			phase = PhaseRead
+			if isAlreadyClosed(err) {
+				cause = io.EOF
+				err = nil
 				return true
 			}
-
-			return false
+			err = fmt.Errorf("muxrpc: serve failed to read from packer: %w", err)
+			return true
 		}()
 		if doRet {
 			return
 		}
 
+		r.received += int64(hdr.Len)
+		if r.sessionQuota > 0 && r.received > r.sessionQuota {
+			return fmt.Errorf("muxrpc: remote sent more than %d bytes: %w", r.sessionQuota, ErrQuotaExceeded)
+		}
+
+		r.normalizeID(&hdr)
+
 		// error/endstream handling and cleanup
 		if hdr.Flag.Get(codec.FlagEndErr) {
 			getReq := func(req int32) (*Request, bool) {
@@ -391,6 +677,7 @@ func (r *rpc) serve() (err error) {
 			// get the request for this new packet
 			req, ok := getReq(hdr.Req)
 			if !ok {
+				r.ackLocalClose(hdr.Req)
 				err = r.maybeDiscardPacket(hdr)
 				if err != nil {
 					if err == errSkip {
@@ -398,28 +685,30 @@ func (r *rpc) serve() (err error) {
 					}
 					return err
 				}
+				if hdr.Req > 0 {
+					if err := r.dropAnomaly(hdr, ReplyBeforeRequest); err != nil {
+						return err
+					}
+					continue
+				}
 				level.Warn(r.logger).Log("event", "unhandled packet", "reqID", hdr.Req, "len", hdr.Len, "flags", hdr.Flag)
+				if _, err := io.Copy(ioutil.Discard, r.pkr.r.NextBodyReader(hdr.Len)); err != nil {
+					return fmt.Errorf("muxrpc: failed to discard body of req: %d (len:%d): %w", hdr.Req, hdr.Len, err)
+				}
 				continue
 			}
 
-			buf := r.bpool.Get()
-
-			err = r.pkr.r.ReadBodyInto(buf, hdr.Len)
+			var streamErr error
+			streamErr, err = r.readEndErr(hdr)
 			if err != nil {
 				return fmt.Errorf("muxrpc: failed to get error body for closing of req: %d (len:%d): %w", hdr.Req, hdr.Len, err)
 			}
 
-			body := buf.Bytes()
-			r.bpool.Put(buf)
-
-			var streamErr error
-			if !isTrue(body) {
-				streamErr, err = parseError(body)
-				if err != nil {
-					return fmt.Errorf("error parsing error packet: %w", err)
-				}
+			if errors.Is(streamErr, ErrMalformedPacket) {
+				level.Warn(r.logger).Log("event", "malformed end packet", "req", hdr.Req, "trace", req.trace, "method", req.Method.String(), "err", streamErr)
 			}
 
+			req.sink.remoteEnded(nil)
 			r.closeStream(req, streamErr)
 			continue
 		}
@@ -447,11 +736,45 @@ func (r *rpc) serve() (err error) {
 			continue
 		}
 
-		err = req.source.consume(hdr.Len, hdr.Flag, r.pkr.r.NextBodyReader(hdr.Len))
+		// our async calls get a single reply
+		if req.id > 0 && !req.Type.Flags().Get(codec.FlagStream) && !atomic.CompareAndSwapUint32(&req.state.answered, 0, 1) {
+			if err := r.dropAnomaly(hdr, DuplicateReply); err != nil {
+				return err
+			}
+			continue
+		}
+
+		atomic.StoreInt64(&req.state.lastReceived, r.clock.Now().UnixNano())
+		received := atomic.AddInt64(&req.state.received, int64(hdr.Len))
+		r.streamData(req, true, int(hdr.Len))
+		if r.streamQuota > 0 && received > r.streamQuota {
+			_, err = io.Copy(ioutil.Discard, r.pkr.r.NextBodyReader(hdr.Len))
+			if err != nil {
+				return fmt.Errorf("muxrpc: failed to discard body of req: %d (len:%d): %w", hdr.Req, hdr.Len, err)
+			}
+			level.Warn(r.logger).Log("event", "stream quota exceeded", "req", hdr.Req, "trace", req.trace, "method", req.Method.String())
+			r.closeStream(req, fmt.Errorf("muxrpc: stream %d exceeded %d bytes: %w", hdr.Req, r.streamQuota, ErrQuotaExceeded))
+			continue
+		}
+
+		if err := r.reserveMemory(hdr.Len); err != nil {
+			return fmt.Errorf("muxrpc: waiting for memory for req: %d (len:%d): %w", hdr.Req, hdr.Len, err)
+		}
+
+		// the body is read into a buffer from the pool, which is then owned by the source
+		body := r.bpool.Get(int(hdr.Len))
+		err = r.pkr.r.ReadBodyInto(body, hdr.Len)
+		if err != nil {
+			r.bpool.Put(body)
+			return fmt.Errorf("muxrpc: failed to read body of req: %d (len:%d): %w", hdr.Req, hdr.Len, err)
+		}
+
+		err = req.source.consumeBuffer(hdr.Flag, body)
 		if err != nil {
 			level.Warn(r.logger).Log(
 				"event", "consume failed",
 				"req", hdr.Req,
+				"trace", req.trace,
 				"method", req.Method.String(),
 				"err", err)
 			r.closeStream(req, err)
@@ -460,45 +783,310 @@ func (r *rpc) serve() (err error) {
 	}
 }
 
-func isTrue(data []byte) bool {
-	return len(data) == 4 &&
-		data[0] == 't' &&
-		data[1] == 'r' &&
-		data[2] == 'u' &&
-		data[3] == 'e'
-}
-
+// closeStream ends req on both sides with streamErr and forgets it.
+// It can be called from several places at once, like the handler and the serve loop, but only the first call closes the request.
+// Its end is sent exactly once, the sink doesn't send anything if it was closed before.
 func (r *rpc) closeStream(req *Request, streamErr error) {
+	r.rLock.Lock()
+	// the remote might have confirmed the end while it was being closed
+	if req.sink.hasRemoteEnded() && r.reqsUnacked[req.id] == req {
+		delete(r.reqsUnacked, req.id)
+	}
+	if !atomic.CompareAndSwapUint32(&req.state.closing, 0, 1) {
+		r.rLock.Unlock()
+		return
+	}
+	r.forgetRequest(req.id)
+	// the remote might still send data, unless it ended the stream or the request was its async call
+	r.reqsClosed[req.id] = closedLocally
+	if req.sink.hasRemoteEnded() || (req.id < 0 && !req.Type.Flags().Get(codec.FlagStream)) {
+		r.reqsClosed[req.id] = closedBoth
+	}
+	// async calls are not confirmed by the remote
+	if req.Type.Flags().Get(codec.FlagStream) && !req.sink.hasRemoteEnded() {
+		r.awaitAck(req)
+	}
+	r.rLock.Unlock()
+
 	req.source.Cancel(streamErr)
 	req.sink.CloseWithError(streamErr)
 	req.abort()
+	r.auditCall(req, streamErr)
+	if req.Type.Flags().Get(codec.FlagStream) {
+		r.reportOutcome(req, streamErr)
+	}
+}
+
+// maxUnacked bounds how many of the streams we closed wait for the remote to confirm their end.
+// A peer that never confirms would make them pile up for the whole session, instead the oldest one is given up on.
+const maxUnacked = 1024
+
+// awaitAck keeps req until the remote confirmed its end, see ackLocalClose. The caller needs to hold rLock.
+func (r *rpc) awaitAck(req *Request) {
+	if len(r.reqsUnacked) >= maxUnacked {
+		var oldest *Request
+		for _, u := range r.reqsUnacked {
+			if oldest == nil || u.state.unackedSince.Before(oldest.state.unackedSince) {
+				oldest = u
+			}
+		}
+		r.giveUpAck(oldest)
+	}
+	req.state.unackedSince = r.clock.Now()
+	r.reqsUnacked[req.id] = req
+}
+
+// giveUpAck stops waiting for the remote to confirm the end of req. The caller needs to hold rLock.
+func (r *rpc) giveUpAck(req *Request) {
+	delete(r.reqsUnacked, req.id)
+	req.sink.remoteEnded(ErrCloseUnacknowledged)
+	level.Debug(r.logger).Log("event", "end of stream not acknowledged", "req", req.id, "trace", req.trace, "method", req.Method.String())
+}
+
+// forgetRequest removes an active request and frees its slot if we started it (see WithMaxOutstandingRequests).
+// The caller needs to hold rLock.
+func (r *rpc) forgetRequest(id int32) {
+	req, ok := r.reqs[id]
+	if !ok {
+		return
+	}
+	delete(r.reqs, id)
+	if req.holdsSlot {
+		r.releaseSlot()
+	}
+}
 
+// ackLocalClose handles the EndErr of the remote for a stream we already closed on our side
+func (r *rpc) ackLocalClose(id int32) {
 	r.rLock.Lock()
-	defer r.rLock.Unlock()
-	delete(r.reqs, req.id)
-	r.reqsClosed[req.id] = struct{}{}
-	return
+	req, ok := r.reqsUnacked[id]
+	delete(r.reqsUnacked, id)
+	if state, closed := r.reqsClosed[id]; closed && state == closedLocally {
+		r.reqsClosed[id] = closedBoth
+	}
+	r.rLock.Unlock()
+
+	if ok {
+		req.sink.remoteEnded(nil)
+	}
 }
 
-// Terminate ends the RPC session
+// retireRequest removes a finished request without sending anything to the remote.
+// Used for async calls, where the reply itself concludes the request.
+func (r *rpc) retireRequest(req *Request) {
+	r.rLock.Lock()
+	if _, active := r.reqs[req.id]; active {
+		r.forgetRequest(req.id)
+		// the reply was the only packet of our call, the remote doesn't send more after the request of its call
+		r.reqsClosed[req.id] = closedBoth
+		if req.id > 0 {
+			r.reqsClosed[req.id] = closedReplied
+		}
+	}
+	r.rLock.Unlock()
+
+	req.abort()
+}
+
+// defaultTerminateGrace is how long Terminate waits for the remote to end our streams, unless WithTerminateGracePeriod is used.
+const defaultTerminateGrace = time.Second
+
+// Terminate ends the RPC session.
+// Before closing the connection, it ends all the streams we started and gives the remote a moment to confirm that,
+// so that it can flush what it still has in flight instead of running into a closed connection.
+// It cancels the contexts of the running handlers but doesn't wait for them, since handlers may call it themselves.
+// Serve waits for them, see WithHandlerGracePeriod.
 func (r *rpc) Terminate() error {
+	return r.TerminateWithError(context.Background(), nil)
+}
+
+// TerminateWithError ends the RPC session like Terminate, but closes the remaining calls with a *TerminatedError for reason.
+// ctx can cut the grace period for ending our streams short. A nil reason is the same as Terminate.
+func (r *rpc) TerminateWithError(ctx context.Context, reason error) error {
+	r.tLock.Lock()
+	graceful := !r.terminated
+	if r.termErr == nil {
+		r.termErr = ErrSessionTerminated
+		if reason != nil {
+			r.termErr = &TerminatedError{Reason: reason}
+		}
+	}
+	termErr := r.termErr
+	r.tLock.Unlock()
+	if graceful && !r.endLocalStreams(ctx, termErr) {
+		// some EndErr is stuck in a write, closing the connection unblocks it
+		// and keeps the closes below from waiting on it.
+		// The serve loop has to know that this is part of the termination and not a broken connection.
+		r.tLock.Lock()
+		r.terminated = true
+		r.tLock.Unlock()
+		r.pkr.Close()
+	}
+
 	r.cancel()
 	r.tLock.Lock()
 	defer r.tLock.Unlock()
 	r.terminated = true
 
 	// close active requests
+	var ended []*Request
+	defer func() { // once the lock is released
+		r.leaks.ended(ended)
+		for _, req := range ended {
+			r.auditCall(req, termErr)
+			if req.Type.Flags().Get(codec.FlagStream) {
+				r.reportOutcome(req, termErr)
+			}
+		}
+	}()
 	r.rLock.Lock()
 	defer r.rLock.Unlock()
 	for _, req := range r.reqs {
-		req.source.Cancel(ErrSessionTerminated)
-		req.sink.CloseWithError(ErrSessionTerminated)
-		delete(r.reqs, req.id)
-		r.reqsClosed[req.id] = struct{}{}
+		ended = append(ended, req)
+		req.source.Cancel(termErr)
+		req.sink.CloseWithError(termErr)
+		req.sink.remoteEnded(termErr)
+		r.forgetRequest(req.id)
+		r.reqsClosed[req.id] = closedLocally
 	}
+	for id, req := range r.reqsUnacked {
+		req.sink.remoteEnded(termErr)
+		delete(r.reqsUnacked, id)
+	}
+	r.aborted += len(ended)
 	return r.pkr.Close()
 }
 
+// Done is closed once the session is terminated
+func (r *rpc) Done() <-chan struct{} {
+	return r.serveCtx.Done()
+}
+
+// Err returns why the session was terminated, or nil while it is running
+func (r *rpc) Err() error {
+	select {
+	case <-r.serveCtx.Done():
+	default:
+		return nil
+	}
+	r.tLock.Lock()
+	defer r.tLock.Unlock()
+	if r.termErr == nil {
+		// the context passed with WithContext was canceled
+		return ErrSessionTerminated
+	}
+	return r.termErr
+}
+
+// endLocalStreams sends an EndErr for each stream we initiated and waits for the remote to reply with its own,
+// for at most the configured grace period or until the serve loop stops.
+// Async calls are not included since the remote doesn't confirm their end, they are aborted by Terminate.
+// They are closed with termErr. ctx can end the grace period early.
+// It returns false if sending the EndErrs didn't finish within the grace period.
+func (r *rpc) endLocalStreams(ctx context.Context, termErr error) bool {
+	if r.terminateGrace <= 0 {
+		return true
+	}
+
+	select {
+	case <-r.serveDone:
+		return true // nothing would read the replies
+	default:
+	}
+
+	var pending []*Request
+	r.rLock.RLock()
+	for id, req := range r.reqs {
+		if id > 0 && req.Type.Flags().Get(codec.FlagStream) {
+			pending = append(pending, req)
+		}
+	}
+	r.rLock.RUnlock()
+
+	if len(pending) == 0 {
+		return true
+	}
+
+	level.Debug(r.logger).Log("event", "ending local streams", "count", len(pending))
+
+	ctx, cancel := context.WithTimeout(ctx, r.terminateGrace)
+	defer cancel()
+	go func() {
+		select {
+		case <-r.serveDone:
+			cancel()
+		case <-r.serveCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// a write can block on a stalled connection, don't let it hold up the whole grace period per stream
+	var sent sync.WaitGroup
+	sent.Add(len(pending))
+	for _, req := range pending {
+		go func(req *Request) {
+			req.sink.CloseWithError(termErr)
+			sent.Done()
+		}(req)
+	}
+	allSent := make(chan struct{})
+	go func() {
+		sent.Wait()
+		close(allSent)
+	}()
+
+	select {
+	case <-allSent:
+	case <-ctx.Done():
+		level.Debug(r.logger).Log("event", "could not send all stream ends in time", "err", ctx.Err())
+		return false
+	}
+
+	for _, req := range pending {
+		if err := req.sink.AwaitRemoteClose(ctx); err != nil {
+			level.Debug(r.logger).Log("event", "remote did not end all streams in time", "err", err)
+			break
+		}
+	}
+	return true
+}
+
+// Flush blocks until the packets written so far are passed to the connection.
+// This matters with WithWriteCoalescing or if the connection buffers writes itself (it has a Flush() error method).
+// Streams are flushed automatically when they are closed.
+func (r *rpc) Flush(ctx context.Context) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- r.pkr.w.Flush()
+	}()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			return fmt.Errorf("muxrpc: flush failed: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (r *rpc) Remote() net.Addr {
 	return r.remote
 }
+
+// RemoteKey returns the key recorded by NewSecretStreamPacker,
+// or the one in the remote address for connections that were wrapped some other way.
+func (r *rpc) RemoteKey() (ed25519.PublicKey, bool) {
+	if r.remoteKey != nil {
+		return r.remoteKey, true
+	}
+	return RemoteKey(r.remote)
+}
+
+func (r *rpc) Local() net.Addr {
+	return r.local
+}
+
+func (r *rpc) metadata() *metadata { return &r.meta }
@@ -3,15 +3,20 @@
 package muxrpc
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -1214,3 +1219,1297 @@ func TestDuplexHandlerJSON(t *testing.T) {
 
 	r.Equal(0, fh1.HandleCallCallCount(), "peer h1 did call unexpectedly")
 }
+
+func TestSourceCancelNotifiesRemote(t *testing.T) {
+	r := require.New(t)
+
+	handlerDone := make(chan struct{})
+
+	var fh1 FakeHandler
+
+	var fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("endless"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		defer close(handlerDone)
+		snk, err := req.ResponseSink()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for ctx.Err() == nil {
+			snk.Write([]byte("tick"))
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	src, err := rpc1.Source(ctx, TypeBinary, Method{"endless"})
+	r.NoError(err)
+	r.True(src.Next(ctx), "expected at least one tick")
+
+	cancel()
+
+	select {
+	case <-handlerDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("remote handler was not canceled")
+	}
+}
+
+func TestSinkCloseWithError(t *testing.T) {
+	r := require.New(t)
+
+	gotErr := make(chan error, 1)
+
+	var fh1 FakeHandler
+
+	var fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("upload"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		src, err := req.ResponseSource()
+		if err != nil {
+			gotErr <- err
+			return
+		}
+		for src.Next(ctx) {
+			if _, err := src.Bytes(); err != nil {
+				gotErr <- err
+				return
+			}
+		}
+		gotErr <- src.Err()
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2)
+
+	ctx := context.Background()
+	snk, err := rpc1.Sink(ctx, TypeBinary, Method{"upload"})
+	r.NoError(err)
+
+	_, err = snk.Write([]byte("some data"))
+	r.NoError(err)
+
+	reason := errors.New("upload aborted")
+	r.NoError(snk.CloseWithError(reason))
+
+	_, err = snk.Write([]byte("more"))
+	r.True(errors.Is(err, reason), "expected write after close to fail: %v", err)
+
+	select {
+	case err := <-gotErr:
+		var ce *CallError
+		r.True(errors.As(err, &ce), "expected CallError, got %v", err)
+		r.Equal("upload aborted", ce.Message)
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not see the end of the stream")
+	}
+}
+
+func TestConcurrentClose(t *testing.T) {
+	r := require.New(t)
+
+	closed := make(chan struct{})
+	var fh FakeHandler
+	fh.HandledCalls(func(m Method) bool { return m.String() == "echo" || m.String() == "hello" })
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		if req.Method.String() == "hello" {
+			req.Return(ctx, "world")
+			return
+		}
+		snk, err := req.ResponseSink()
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		// write and close all at once, while the remote ends the call as well
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if i%2 == 0 {
+					snk.Write([]byte("data"))
+				} else {
+					req.CloseWithError(fmt.Errorf("closer %d", i))
+				}
+			}(i)
+		}
+		wg.Wait()
+		closed <- struct{}{}
+	})
+	edp, rd, w := rawPeer(t, &fh)
+
+	for id := int32(1); id <= 20; id++ {
+		r.NoError(w.WritePacket(codec.Packet{
+			Req:  id,
+			Flag: codec.FlagJSON | codec.FlagStream,
+			Body: []byte(`{"name":["echo"],"args":[],"type":"duplex"}`),
+		}))
+		r.NoError(w.WritePacket(codec.Packet{
+			Req:  id,
+			Flag: codec.FlagJSON | codec.FlagStream | codec.FlagEndErr,
+			Body: []byte("true"),
+		}))
+		<-closed
+
+		// everything of the stream was sent once the next call is answered
+		r.NoError(w.WritePacket(codec.Packet{
+			Req:  100 + id,
+			Flag: codec.FlagJSON,
+			Body: []byte(`{"name":["hello"],"args":[],"type":"async"}`),
+		}))
+		var ends int
+		for {
+			pkt, err := rd.ReadPacket()
+			r.NoError(err)
+			if pkt.Req == -(100 + id) {
+				r.Equal("world", string(pkt.Body))
+				break
+			}
+			r.Equal(-id, pkt.Req)
+			if pkt.Flag.Get(codec.FlagEndErr) {
+				ends++
+			} else {
+				r.Equal(0, ends, "data after the end of stream %d", id)
+			}
+		}
+		r.Equal(1, ends, "stream %d", id)
+	}
+
+	// nothing waits for the remote anymore
+	rpc := edp.(*rpc)
+	rpc.rLock.RLock()
+	var open int
+	for _, req := range rpc.reqs {
+		if req.Method.String() == "echo" {
+			open++
+		}
+	}
+	unacked := len(rpc.reqsUnacked)
+	rpc.rLock.RUnlock()
+	r.Equal(0, open)
+	r.Equal(0, unacked)
+}
+
+// TestCloseRaceStress ends streams from every side at once: the handler pours and closes concurrently,
+// while the caller writes, ends its side (which sends the EndErr) and cancels. It's meant to be run with -race.
+func TestCloseRaceStress(t *testing.T) {
+	r := require.New(t)
+
+	var fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("stress"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		var wg sync.WaitGroup
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				switch i % 3 {
+				case 0:
+					for j := 0; j < 5; j++ {
+						if req.Stream.Pour(ctx, fmt.Sprintf("frame %d", j)) != nil {
+							return
+						}
+					}
+				case 1:
+					req.Stream.Close()
+				case 2:
+					req.CloseWithError(fmt.Errorf("closer %d", i))
+				}
+			}(i)
+		}
+		wg.Wait()
+	})
+
+	rpc1, rpc2 := connectedPair(t, &FakeHandler{}, &fh2)
+
+	const streams = 50
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			callCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			src, snk, err := rpc1.Duplex(callCtx, TypeString, Method{"stress"})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			go func() {
+				snk.Write([]byte("hello"))
+				snk.Close()
+				if i%4 == 0 {
+					cancel()
+				}
+			}()
+			for src.Next(callCtx) {
+				src.Bytes()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// both sides forget the streams once the ends crossed
+	for _, edp := range []Endpoint{rpc1, rpc2} {
+		rpc := edp.(*rpc)
+		r.Eventually(func() bool {
+			rpc.rLock.RLock()
+			defer rpc.rLock.RUnlock()
+			for _, req := range rpc.reqs {
+				if req.Method.String() == "stress" {
+					return false
+				}
+			}
+			return len(rpc.reqsUnacked) == 0
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
+
+// the serve loop can pick up the end of the remote while the stream is being closed on our side
+func TestCloseStreamTwice(t *testing.T) {
+	r := require.New(t)
+
+	edp, rd, _ := rawPeer(t, &FakeHandler{})
+	rpc := edp.(*rpc)
+
+	ctx := context.Background()
+	src, err := edp.Source(ctx, TypeJSON, Method{"things"})
+	r.NoError(err)
+	call, err := rd.ReadPacket()
+	r.NoError(err)
+
+	rpc.rLock.RLock()
+	req := rpc.reqs[call.Req]
+	rpc.rLock.RUnlock()
+	r.NotNil(req)
+
+	rpc.closeStream(req, nil)
+	end, err := rd.ReadPacket()
+	r.NoError(err)
+	r.Equal(call.Req, end.Req)
+	r.True(end.Flag.Get(codec.FlagEndErr))
+
+	req.sink.remoteEnded(nil)
+	rpc.closeStream(req, errors.New("too late"))
+	r.False(src.Next(ctx))
+	r.NoError(src.Err())
+
+	rpc.rLock.RLock()
+	_, unacked := rpc.reqsUnacked[call.Req]
+	rpc.rLock.RUnlock()
+	r.False(unacked)
+}
+
+func TestSinkCloseAndWait(t *testing.T) {
+	r := require.New(t)
+
+	var fh1 FakeHandler
+
+	var fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("upload"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		src, err := req.ResponseSource()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for src.Next(ctx) {
+			src.Bytes()
+		}
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2)
+
+	ctx := context.Background()
+	snk, err := rpc1.Sink(ctx, TypeBinary, Method{"upload"})
+	r.NoError(err)
+
+	_, err = snk.Write([]byte("some data"))
+	r.NoError(err)
+
+	waitCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	r.NoError(snk.CloseWithErrorAndWait(waitCtx, nil))
+}
+
+func TestTerminateEndsLocalStreams(t *testing.T) {
+	r := require.New(t)
+
+	var fh1 FakeHandler
+
+	var fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("upload"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		src, err := req.ResponseSource()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for src.Next(ctx) {
+			src.Bytes()
+		}
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2)
+
+	ctx := context.Background()
+	snk, err := rpc1.Sink(ctx, TypeBinary, Method{"upload"})
+	r.NoError(err)
+
+	_, err = snk.Write([]byte("some data"))
+	r.NoError(err)
+
+	start := time.Now()
+	r.NoError(rpc1.Terminate())
+	r.True(time.Since(start) < defaultTerminateGrace, "took the whole grace period")
+
+	// the remote confirmed the end of the stream before the connection was closed
+	r.NoError(snk.AwaitRemoteClose(ctx))
+}
+
+// stallingConn blocks all writes once stall is closed, until the connection is closed
+type stallingConn struct {
+	net.Conn
+	stall  chan struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *stallingConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.stall:
+		<-c.closed
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *stallingConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+func TestTerminateStalledConnection(t *testing.T) {
+	r := require.New(t)
+
+	c1, c2 := loPipe(t)
+	conn := &stallingConn{Conn: c1, stall: make(chan struct{}), closed: make(chan struct{})}
+
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("upload"))
+
+	var rpc1 Endpoint
+	handled := make(chan struct{})
+	go func() {
+		rpc1 = Handle(NewPacker(conn), &fh1, WithTerminateGracePeriod(100*time.Millisecond))
+		close(handled)
+	}()
+	rpc2 := Handle(NewPacker(c2), &fh2)
+	<-handled
+	go rpc1.(Server).Serve()
+	go rpc2.(Server).Serve()
+	defer rpc2.Terminate()
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, err := rpc1.Sink(ctx, TypeBinary, Method{"upload"})
+		r.NoError(err)
+	}
+	// from now on, sending our stream ends gets stuck
+	close(conn.stall)
+
+	terminated := make(chan error, 1)
+	go func() { terminated <- rpc1.Terminate() }()
+
+	select {
+	case <-terminated:
+	case <-time.After(3 * time.Second):
+		t.Fatal("terminate blocked on the stalled connection")
+	}
+}
+
+func TestAsyncCancelNotifiesRemote(t *testing.T) {
+	r := require.New(t)
+
+	handlerCalled := make(chan struct{})
+	handlerDone := make(chan struct{})
+
+	var fh1 FakeHandler
+
+	var fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("slow"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		close(handlerCalled)
+		<-ctx.Done()
+		close(handlerDone)
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	asyncErr := make(chan error, 1)
+	go func() {
+		var v string
+		asyncErr <- rpc1.Async(ctx, &v, TypeString, Method{"slow"})
+	}()
+
+	select {
+	case <-handlerCalled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler was not called")
+	}
+	cancel()
+
+	select {
+	case err := <-asyncErr:
+		r.Error(err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("async call did not return")
+	}
+
+	select {
+	case <-handlerDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("remote handler was not canceled")
+	}
+
+	edp := rpc1.(*rpc)
+	r.Eventually(func() bool {
+		edp.rLock.RLock()
+		defer edp.rLock.RUnlock()
+		for _, req := range edp.reqs {
+			if req.Method.String() == "slow" {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond, "request was not removed")
+}
+
+func TestSinkAwaitAfterCancel(t *testing.T) {
+	r := require.New(t)
+
+	var fh1 FakeHandler
+
+	var fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("upload"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		<-ctx.Done()
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	snk, err := rpc1.Sink(ctx, TypeBinary, Method{"upload"})
+	r.NoError(err)
+	cancel()
+
+	// the remote still acknowledges the stream that was ended by the cancellation
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer waitCancel()
+	r.NoError(snk.AwaitRemoteClose(waitCtx))
+}
+
+func TestSinkAwaitAfterTerminate(t *testing.T) {
+	r := require.New(t)
+
+	var fh1 FakeHandler
+
+	var fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("upload"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		<-ctx.Done()
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	snk, err := rpc1.Sink(ctx, TypeBinary, Method{"upload"})
+	r.NoError(err)
+	cancel()
+	r.NoError(rpc1.Terminate())
+
+	// either the ack made it or the teardown released the waiter
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer waitCancel()
+	err = snk.AwaitRemoteClose(waitCtx)
+	r.True(err == nil || errors.Is(err, ErrSessionTerminated), "unexpected error: %v", err)
+}
+
+func TestTrailingWhitespaceInBodies(t *testing.T) {
+	r := require.New(t)
+
+	c1, c2 := loPipe(t)
+	raw := NewPacker(c2)
+	defer raw.Close()
+
+	called := make(chan string, 2)
+	var fh FakeHandler
+	fh.HandledReturns(true)
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		called <- req.Method.String()
+	})
+
+	var rpc1 Endpoint
+	handled := make(chan struct{})
+	go func() {
+		rpc1 = Handle(NewPacker(c1), &fh)
+		close(handled)
+	}()
+
+	// answer the manifest call
+	ctx := context.Background()
+	var hdr codec.Header
+	r.NoError(raw.NextHeader(ctx, &hdr))
+	r.NoError(raw.r.ReadBodyInto(ioutil.Discard, hdr.Len))
+	r.NoError(raw.w.WritePacket(codec.Packet{Req: hdr.Req, Flag: codec.FlagJSON, Body: []byte(`{}`)}))
+	<-handled
+	defer rpc1.Terminate()
+
+	// JSON allows whitespace after the value, the next packet still needs to be read correctly
+	r.NoError(raw.w.WritePacket(codec.Packet{
+		Req:  1,
+		Flag: codec.FlagJSON,
+		Body: []byte(`{"name":["first"],"args":[],"type":"async"}` + " \n\t "),
+	}))
+	r.NoError(raw.w.WritePacket(codec.Packet{
+		Req:  1,
+		Flag: codec.FlagJSON | codec.FlagEndErr,
+		Body: []byte(`{"name":"Error","message":"changed my mind"}` + "\n\n"),
+	}))
+	r.NoError(raw.w.WritePacket(codec.Packet{
+		Req:  2,
+		Flag: codec.FlagJSON,
+		Body: []byte(`{"name":["second"],"args":[],"type":"async"}`),
+	}))
+
+	// handlers run concurrently, so the order is not fixed
+	var got []string
+	for len(got) < 2 {
+		select {
+		case m := <-called:
+			got = append(got, m)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only got calls %v", got)
+		}
+	}
+	r.ElementsMatch([]string{"first", "second"}, got)
+}
+
+// countingJSON wraps StdJSON and counts how often it was used
+type countingJSON struct {
+	mu                  sync.Mutex
+	marshaled, decoders int
+}
+
+func (c *countingJSON) Marshal(v interface{}) ([]byte, error) {
+	c.mu.Lock()
+	c.marshaled++
+	c.mu.Unlock()
+	return StdJSON.Marshal(v)
+}
+
+func (c *countingJSON) NewDecoder(rd io.Reader) JSONDecoder {
+	c.mu.Lock()
+	c.decoders++
+	c.mu.Unlock()
+	return StdJSON.NewDecoder(rd)
+}
+
+func TestCustomJSONCodec(t *testing.T) {
+	r := require.New(t)
+
+	var fh1 FakeHandler
+
+	var fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("hello"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		req.Return(ctx, map[string]string{"hello": "world"})
+	})
+
+	var jc countingJSON
+	rpc1, _ := connectedPair(t, &fh1, &fh2, WithJSONCodec(&jc))
+
+	var ret map[string]string
+	err := rpc1.Async(context.Background(), &ret, TypeJSON, Method{"hello"}, "arg")
+	r.NoError(err)
+	r.Equal("world", ret["hello"])
+
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+	r.NotZero(jc.marshaled, "codec not used for encoding")
+	r.NotZero(jc.decoders, "codec not used for decoding")
+}
+
+// markedJSON stands in for a CBOR library, it prefixes JSON bodies with a marker byte
+type markedJSON struct{ countingJSON }
+
+func (c *markedJSON) Marshal(v interface{}) ([]byte, error) {
+	body, err := c.countingJSON.Marshal(v)
+	return append([]byte{'C'}, body...), err
+}
+
+func (c *markedJSON) NewDecoder(rd io.Reader) JSONDecoder {
+	return markedDecoder{rd: rd, dec: c.countingJSON.NewDecoder(rd)}
+}
+
+type markedDecoder struct {
+	rd  io.Reader
+	dec JSONDecoder
+}
+
+func (md markedDecoder) Decode(v interface{}) error {
+	var marker [1]byte
+	if _, err := io.ReadFull(md.rd, marker[:]); err != nil {
+		return err
+	}
+	if marker[0] != 'C' {
+		return fmt.Errorf("not a marked body: %q", marker[0])
+	}
+	return md.dec.Decode(v)
+}
+
+func TestCBORNegotiation(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		manifest string
+		useCBOR  bool
+	}{
+		{"advertised", `{"hello":"async","muxrpc":{"cbor":"sync"}}`, true},
+		{"not advertised", `{"hello":"async"}`, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+
+			var fh1 FakeHandler
+
+			var fh2 FakeHandler
+			fh2.HandledReturns(true)
+			fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+				switch req.Method.String() {
+				case "manifest":
+					req.Return(ctx, json.RawMessage(tc.manifest))
+				case "hello":
+					req.Return(ctx, map[string]string{"hello": "world"})
+				}
+			})
+
+			var cbor1, cbor2 markedJSON
+			var rpc1 Endpoint
+			c1, c2 := loPipe(t)
+			handled := make(chan struct{})
+			go func() {
+				rpc1 = Handle(NewPacker(c1), &fh1, WithCBOR(&cbor1))
+				close(handled)
+			}()
+			rpc2 := Handle(NewPacker(c2), &fh2, WithCBOR(&cbor2))
+			<-handled
+			defer rpc1.Terminate()
+			defer rpc2.Terminate()
+
+			var ret map[string]string
+			err := rpc1.Async(context.Background(), &ret, TypeJSON, Method{"hello"})
+			r.NoError(err)
+			r.Equal("world", ret["hello"])
+
+			cbor1.mu.Lock()
+			defer cbor1.mu.Unlock()
+			cbor2.mu.Lock()
+			defer cbor2.mu.Unlock()
+			if tc.useCBOR {
+				r.Equal(1, cbor1.decoders, "reply not decoded as CBOR")
+				r.Equal(1, cbor2.marshaled, "reply not encoded as CBOR")
+			} else {
+				r.Zero(cbor1.decoders)
+				r.Zero(cbor2.marshaled)
+			}
+		})
+	}
+}
+
+func TestLocalAddr(t *testing.T) {
+	r := require.New(t)
+
+	var fh1, fh2 FakeHandler
+	rpc1, rpc2 := connectedPair(t, &fh1, &fh2)
+
+	r.NotNil(rpc1.Local())
+	r.Equal(rpc1.Local().String(), rpc2.Remote().String())
+	r.Equal(rpc2.Local().String(), rpc1.Remote().String())
+
+	local := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 8008}
+	rpc3, _ := connectedPair(t, &fh1, &fh2, WithLocalAddr(local))
+	r.Equal(local, rpc3.Local())
+}
+
+func TestStreamQuota(t *testing.T) {
+	r := require.New(t)
+
+	gotErr := make(chan error, 1)
+
+	var fh1 FakeHandler
+	var fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("upload"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		src, err := req.ResponseSource()
+		if err != nil {
+			gotErr <- err
+			return
+		}
+		for src.Next(ctx) {
+			if _, err := src.Bytes(); err != nil {
+				gotErr <- err
+				return
+			}
+		}
+		gotErr <- src.Err()
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2, WithStreamQuota(100))
+
+	ctx := context.Background()
+	snk, err := rpc1.Sink(ctx, TypeBinary, Method{"upload"})
+	r.NoError(err)
+
+	chunk := bytes.Repeat([]byte("x"), 30)
+	for i := 0; i < 4; i++ {
+		_, err = snk.Write(chunk)
+		r.NoError(err)
+	}
+
+	select {
+	case err := <-gotErr:
+		r.True(errors.Is(err, ErrQuotaExceeded), "expected quota error, got %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not see the end of the stream")
+	}
+
+	// the uploader learns about it, too
+	r.NoError(snk.AwaitRemoteClose(ctx))
+	_, err = snk.Write(chunk)
+	var ce *CallError
+	r.True(errors.As(err, &ce), "expected CallError, got %v", err)
+	r.Contains(ce.Message, "quota exceeded")
+}
+
+func TestHandlerCanceledSource(t *testing.T) {
+	r := require.New(t)
+
+	canceled := make(chan struct{})
+
+	var fh1 FakeHandler
+	var fh2 FakeHandler
+	fh2.HandledCalls(func(m Method) bool { return m.String() == "upload" || m.String() == "hello" })
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		if req.Method.String() == "hello" {
+			req.Return(ctx, "world")
+			return
+		}
+		src, err := req.ResponseSource()
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		// take one frame and stop listening, without closing the call
+		src.Next(ctx)
+		src.Cancel(errors.New("had enough"))
+		close(canceled)
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2)
+
+	ctx := context.Background()
+	snk, err := rpc1.Sink(ctx, TypeBinary, Method{"upload"})
+	r.NoError(err)
+
+	_, err = snk.Write([]byte("frame"))
+	r.NoError(err)
+	<-canceled
+	_, err = snk.Write([]byte("frame"))
+	r.NoError(err)
+
+	// only the upload is ended
+	r.NoError(snk.AwaitRemoteClose(ctx))
+	_, err = snk.Write([]byte("frame"))
+	var ce *CallError
+	r.True(errors.As(err, &ce), "expected CallError, got %v", err)
+	r.Contains(ce.Message, "had enough")
+
+	var resp string
+	r.NoError(rpc1.Async(ctx, &resp, TypeString, Method{"hello"}))
+	r.Equal("world", resp)
+	_, ended := SessionEnd(rpc1)
+	r.False(ended)
+}
+
+func TestSessionQuota(t *testing.T) {
+	r := require.New(t)
+
+	c1, c2 := loPipe(t)
+
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("upload"))
+
+	var rpc2 Endpoint
+	started := make(chan struct{})
+	go func() {
+		rpc2 = Handle(NewPacker(c2), &fh2, WithSessionQuota(1024))
+		close(started)
+	}()
+	rpc1 := Handle(NewPacker(c1), &fh1)
+	<-started
+	defer rpc1.Terminate()
+
+	big := strings.Repeat("x", 2048)
+	go rpc1.Async(context.Background(), new(string), TypeString, Method{"upload"}, big)
+
+	errc := make(chan error)
+	go func() { errc <- rpc2.(Server).Serve() }()
+	select {
+	case err := <-errc:
+		r.True(errors.Is(err, ErrQuotaExceeded), "expected quota error, got %v", err)
+		var se *SessionEndedError
+		r.True(errors.As(err, &se))
+		r.Equal(PhaseHandle, se.Phase)
+		r.False(se.Clean())
+	case <-time.After(2 * time.Second):
+		t.Fatal("session was not terminated")
+	}
+}
+
+func TestMaxOutstandingRequests(t *testing.T) {
+	r := require.New(t)
+
+	release := make(chan struct{})
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("slow"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		<-release
+		req.Return(ctx, "done")
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2, WithMaxOutstandingRequests(2, false))
+
+	ctx := context.Background()
+	results := make(chan error, 3)
+	call := func() {
+		var resp string
+		results <- rpc1.Async(ctx, &resp, TypeString, Method{"slow"})
+	}
+	go call()
+	go call()
+	time.Sleep(50 * time.Millisecond)
+
+	// the third call has to wait for a free slot
+	shortCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	var resp string
+	err := rpc1.Async(shortCtx, &resp, TypeString, Method{"slow"})
+	r.True(errors.Is(err, context.DeadlineExceeded), "expected to wait for a slot: %v", err)
+
+	go call()
+	close(release)
+	for i := 0; i < 3; i++ {
+		r.NoError(<-results)
+	}
+}
+
+func TestMaxOutstandingRequestsFailFast(t *testing.T) {
+	r := require.New(t)
+
+	release := make(chan struct{})
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("slow"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		<-release
+		if req.Type == "source" {
+			snk, _ := req.ResponseSink()
+			snk.Write([]byte("done"))
+			snk.Close()
+			return
+		}
+		req.Return(ctx, "done")
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2, WithMaxOutstandingRequests(1, true))
+
+	ctx := context.Background()
+	src, err := rpc1.Source(ctx, TypeString, Method{"slow"})
+	r.NoError(err)
+
+	var resp string
+	err = rpc1.Async(ctx, &resp, TypeString, Method{"slow"})
+	r.True(errors.Is(err, ErrTooManyRequests), "expected fail fast: %v", err)
+
+	close(release)
+	r.True(src.Next(ctx))
+	data, err := src.Bytes()
+	r.NoError(err)
+	r.Equal("done", string(data))
+	r.False(src.Next(ctx))
+
+	// the slot is free again once the source ended
+	r.NoError(rpc1.Async(ctx, &resp, TypeString, Method{"slow"}))
+	r.Equal("done", resp)
+}
+
+// breakableConn fails all writes once it is broken
+type breakableConn struct {
+	net.Conn
+
+	broken uint32
+}
+
+var errBrokenConn = errors.New("broken connection")
+
+func (c *breakableConn) Write(b []byte) (int, error) {
+	if atomic.LoadUint32(&c.broken) == 1 {
+		return 0, errBrokenConn
+	}
+	return c.Conn.Write(b)
+}
+
+// breakablePair connects rpc1 over a breakableConn, once both sides are set up
+func breakablePair(t *testing.T, h2 Handler, pkrOpts []PackerOption, opts ...HandleOption) (*rpc, *breakableConn) {
+	c1, c2 := loPipe(t)
+	bc := &breakableConn{Conn: c1}
+
+	var rpc2 Endpoint
+	handled := make(chan struct{})
+	go func() {
+		rpc2 = Handle(NewPacker(c2), h2)
+		close(handled)
+	}()
+	rpc1 := Handle(NewPacker(bc, pkrOpts...), &FakeHandler{}, opts...)
+	<-handled
+	go rpc1.(Server).Serve()
+	go rpc2.(Server).Serve()
+	t.Cleanup(func() {
+		rpc1.Terminate()
+		rpc2.Terminate()
+	})
+	return rpc1.(*rpc), bc
+}
+
+// assertSendFailureCleanedUp checks that calls which couldn't be sent don't stay registered or hold their slot
+func assertSendFailureCleanedUp(t *testing.T, edp *rpc, bc *breakableConn) {
+	r := require.New(t)
+	edp.rLock.RLock()
+	before := len(edp.reqs)
+	edp.rLock.RUnlock()
+	atomic.StoreUint32(&bc.broken, 1)
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		var resp string
+		err := edp.Async(ctx, &resp, TypeString, Method{"hello"})
+		r.True(errors.Is(err, errBrokenConn), "call %d: unexpected error: %v", i, err)
+	}
+
+	edp.rLock.RLock()
+	after := len(edp.reqs)
+	edp.rLock.RUnlock()
+	r.Equal(before, after, "failed calls stayed registered")
+	r.Equal(0, len(edp.outstanding))
+}
+
+func TestMaxOutstandingRequestsWriteFails(t *testing.T) {
+	rpc1, bc := breakablePair(t, anomalyHandler(), nil, WithMaxOutstandingRequests(1, true))
+	assertSendFailureCleanedUp(t, rpc1, bc)
+}
+
+func TestMaxOutstandingRequestsFlushFails(t *testing.T) {
+	// coalesced packets only fail once the call flushes them
+	coalesce := []PackerOption{WithWriteCoalescing(64*1024, time.Hour)}
+	rpc1, bc := breakablePair(t, anomalyHandler(), coalesce, WithMaxOutstandingRequests(1, true))
+	assertSendFailureCleanedUp(t, rpc1, bc)
+}
+
+// bufferedConn holds back writes until it is flushed
+type bufferedConn struct {
+	net.Conn
+
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func newBufferedConn(c net.Conn) *bufferedConn {
+	return &bufferedConn{Conn: c, w: bufio.NewWriterSize(c, 64*1024)}
+}
+
+func (c *bufferedConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.w.Write(b)
+}
+
+func (c *bufferedConn) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.w.Flush()
+}
+
+func TestFlush(t *testing.T) {
+	r := require.New(t)
+
+	c1, c2 := loPipe(t)
+
+	flush, end := make(chan struct{}), make(chan struct{})
+	var fh1, fh2 FakeHandler
+	fh1.HandledCalls(methodChecker("numbers"))
+	fh1.HandleCallCalls(func(ctx context.Context, req *Request) {
+		snk, err := req.ResponseSink()
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		snk.SetEncoding(TypeString)
+		for i := 0; i < 3; i++ {
+			fmt.Fprint(snk, i)
+		}
+		<-flush
+		if err := req.Endpoint().Flush(ctx); err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		<-end
+		snk.Close()
+	})
+
+	var rpc2 Endpoint
+	started := make(chan struct{})
+	go func() {
+		rpc2 = Handle(NewPacker(c2), &fh2)
+		close(started)
+	}()
+	rpc1 := Handle(NewPacker(newBufferedConn(c1)), &fh1)
+	<-started
+	go rpc1.(Server).Serve()
+	go rpc2.(Server).Serve()
+	defer rpc1.Terminate()
+	defer rpc2.Terminate()
+
+	ctx := context.Background()
+	src, err := rpc2.Source(ctx, TypeString, Method{"numbers"})
+	r.NoError(err)
+
+	got := make(chan string)
+	go func() {
+		defer close(got)
+		for src.Next(ctx) {
+			b, err := src.Bytes()
+			if err != nil {
+				return
+			}
+			got <- string(b)
+		}
+	}()
+
+	next := func() (string, bool) {
+		select {
+		case v, ok := <-got:
+			return v, ok
+		case <-time.After(100 * time.Millisecond):
+			return "", false
+		}
+	}
+
+	// the stream data is stuck in the buffer
+	_, ok := next()
+	r.False(ok)
+
+	close(flush)
+	for i := 0; i < 3; i++ {
+		v, ok := next()
+		r.True(ok, "value %d not flushed", i)
+		r.Equal(fmt.Sprint(i), v)
+	}
+
+	// closing the stream flushes its end
+	close(end)
+	select {
+	case _, ok := <-got:
+		r.False(ok)
+	case <-time.After(time.Second):
+		t.Fatal("end of stream not flushed")
+	}
+}
+
+func TestAsyncRawReplies(t *testing.T) {
+	r := require.New(t)
+
+	var fh FakeHandler
+	fh.HandledCalls(func(m Method) bool {
+		switch m.String() {
+		case "text", "blob", "obj":
+			return true
+		}
+		return false
+	})
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		switch req.Method.String() {
+		case "text":
+			req.Return(ctx, "hello")
+		case "blob":
+			req.Return(ctx, RawReply{Encoding: TypeBinary, Body: []byte{0, 1, 2}})
+		case "obj":
+			req.Return(ctx, map[string]int{"a": 1})
+		}
+	})
+
+	rpc1, _ := connectedPair(t, &FakeHandler{}, &fh)
+	ctx := context.Background()
+
+	// string and binary replies aren't forced through the JSON decoder
+	var s string
+	r.NoError(rpc1.Async(ctx, &s, TypeJSON, Method{"text"}))
+	r.Equal("hello", s)
+
+	var b []byte
+	r.NoError(rpc1.Async(ctx, &b, TypeJSON, Method{"blob"}))
+	r.Equal([]byte{0, 1, 2}, b)
+	r.NoError(rpc1.Async(ctx, &b, TypeString, Method{"text"}))
+	r.Equal([]byte("hello"), b)
+	r.NoError(rpc1.Async(ctx, &s, TypeBinary, Method{"text"}))
+	r.Equal("hello", s)
+
+	// JSON replies still need it
+	var m map[string]int
+	r.Error(rpc1.Async(ctx, &m, TypeJSON, Method{"text"}))
+	r.NoError(rpc1.Async(ctx, &m, TypeJSON, Method{"obj"}))
+	r.Equal(1, m["a"])
+	r.NoError(rpc1.Async(ctx, &s, TypeString, Method{"obj"}))
+	r.JSONEq(`{"a":1}`, s)
+}
+
+func TestSinkBytes(t *testing.T) {
+	r := require.New(t)
+
+	type frame struct {
+		flag codec.Flag
+		body []byte
+	}
+	got := make(chan []frame, 1)
+
+	var fh FakeHandler
+	fh.HandledCalls(methodChecker("blobs.add"))
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		src, err := req.ResponseSource()
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		var frames []frame
+		for src.Next(ctx) {
+			body, err := src.Bytes()
+			if err != nil {
+				break
+			}
+			src.mu.Lock()
+			frames = append(frames, frame{src.hdrFlag, body})
+			src.mu.Unlock()
+		}
+		got <- frames
+		req.Close()
+	})
+
+	rpc1, _ := connectedPair(t, &FakeHandler{}, &fh)
+
+	chunks := [][]byte{{0xff, 0x00, '"'}, []byte(`{"not":"json"}`)}
+	snk, err := rpc1.SinkBytes(context.Background(), Method{"blobs", "add"})
+	r.NoError(err)
+	for _, c := range chunks {
+		n, err := snk.Write(c)
+		r.NoError(err)
+		r.Equal(len(c), n)
+	}
+	r.NoError(snk.Close())
+
+	frames := <-got
+	r.Len(frames, len(chunks))
+	for i, f := range frames {
+		r.False(f.flag.Get(codec.FlagJSON), "frame %d", i)
+		r.False(f.flag.Get(codec.FlagString), "frame %d", i)
+		r.Equal(chunks[i], f.body)
+	}
+}
+
+func TestReturnEncodings(t *testing.T) {
+	r := require.New(t)
+
+	// the source only keeps the flag of the last packet, so the frames of the mixed stream are sent one by one
+	step := make(chan struct{})
+
+	var fh FakeHandler
+	fh.HandledCalls(func(m Method) bool {
+		switch m.String() {
+		case "str", "bin", "json", "mixed":
+			return true
+		}
+		return false
+	})
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		switch req.Method.String() {
+		case "str":
+			req.ReturnString(ctx, "text")
+		case "bin":
+			req.ReturnBytes(ctx, []byte{1, 2})
+		case "json":
+			req.ReturnJSON(ctx, "text")
+		case "mixed":
+			snk, err := req.ResponseSink()
+			if err != nil {
+				return
+			}
+			snk.SetEncoding(TypeJSON)
+			for _, write := range []func() (int, error){
+				func() (int, error) { return snk.Write([]byte(`1`)) },
+				func() (int, error) { return snk.WriteAs(TypeString, []byte("two")) },
+				func() (int, error) { return snk.WriteAs(TypeBinary, []byte{3}) },
+				func() (int, error) { return snk.Write([]byte(`4`)) },
+			} {
+				if _, err := write(); err != nil {
+					return
+				}
+				<-step
+			}
+			snk.Close()
+		}
+	})
+
+	rpc1, _ := connectedPair(t, &FakeHandler{}, &fh)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		method string
+		want   RawReply
+	}{
+		{"str", RawReply{Encoding: TypeString, Body: []byte("text")}},
+		{"bin", RawReply{Encoding: TypeBinary, Body: []byte{1, 2}}},
+		{"json", RawReply{Encoding: TypeJSON, Body: []byte(`"text"`)}},
+	} {
+		var got RawReply
+		r.NoError(rpc1.Async(ctx, &got, TypeJSON, Method{tc.method}))
+		r.Equal(tc.want, got, tc.method)
+	}
+
+	src, err := rpc1.Source(ctx, TypeJSON, Method{"mixed"})
+	r.NoError(err)
+	var encs []RequestEncoding
+	for src.Next(ctx) {
+		src.mu.Lock()
+		encs = append(encs, encodingOf(src.hdrFlag))
+		src.mu.Unlock()
+		_, err := src.Bytes()
+		r.NoError(err)
+		step <- struct{}{}
+	}
+	r.NoError(src.Err())
+	r.Equal([]RequestEncoding{TypeJSON, TypeString, TypeBinary, TypeJSON}, encs)
+}
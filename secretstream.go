@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+)
+
+// secretStreamNetwork is what secretstream.Addr returns from Network()
+const secretStreamNetwork = "shs-bs"
+
+// SecretStreamAddr is the remote address of an endpoint that runs over a secret-handshake connection (go.cryptoscope.co/secretstream).
+// Next to the network address it holds the long-term public key the peer authenticated with.
+type SecretStreamAddr struct {
+	net.Addr
+
+	PubKey ed25519.PublicKey
+}
+
+func (a SecretStreamAddr) String() string {
+	return fmt.Sprintf("%s|@%s.ed25519", a.Addr.String(), base64.StdEncoding.EncodeToString(a.PubKey))
+}
+
+// secretStreamConn reports a SecretStreamAddr as its remote address, so that Handle picks it up for Remote() and Request.RemoteAddr()
+type secretStreamConn struct {
+	net.Conn
+	remote SecretStreamAddr
+}
+
+func (c secretStreamConn) RemoteAddr() net.Addr { return c.remote }
+
+// NetConn returns the connection of the handshake, see RawConn
+func (c secretStreamConn) NetConn() net.Conn { return c.Conn }
+
+// NewSecretStreamPacker returns a packer for a connection that completed the secret-handshake,
+// like the ones returned by secretstream's client and server.
+// Endpoints created from it return the key of the peer from Endpoint.RemoteKey
+// and report a SecretStreamAddr as their remote address, see RemoteKey.
+func NewSecretStreamPacker(conn net.Conn, opts ...PackerOption) (*Packer, error) {
+	key, err := secretStreamKey(conn.RemoteAddr())
+	if err != nil {
+		return nil, fmt.Errorf("muxrpc: not a secretstream connection: %w", err)
+	}
+
+	ssc := secretStreamConn{
+		Conn: conn,
+		remote: SecretStreamAddr{
+			Addr:   conn.RemoteAddr(),
+			PubKey: key,
+		},
+	}
+	pkr := NewPacker(ssc, opts...)
+	pkr.remoteKey = key
+	return pkr, nil
+}
+
+// RemoteKey returns the public key the peer authenticated with,
+// if addr is the remote address of an endpoint or request that runs over secretstream.
+// Next to a SecretStreamAddr (see NewSecretStreamPacker) it also understands netwrap'ed addresses that contain a secretstream.Addr.
+func RemoteKey(addr net.Addr) (ed25519.PublicKey, bool) {
+	if ssa, ok := addr.(SecretStreamAddr); ok {
+		return ssa.PubKey, true
+	}
+	key, err := secretStreamKey(addr)
+	return key, err == nil
+}
+
+// secretStreamKey finds the secretstream layer of addr and copies the key out of it.
+// secretstream.Addr keeps the key in its PubKey field. It is read by reflection, since muxrpc doesn't import secretstream.
+func secretStreamKey(addr net.Addr) (ed25519.PublicKey, error) {
+	ssa := FindAddr(addr, secretStreamNetwork)
+	if ssa == nil {
+		return nil, errors.New("no secretstream address")
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(ssa))
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("unexpected secretstream address type: %T", ssa)
+	}
+	f := v.FieldByName("PubKey")
+	if !f.IsValid() || f.Kind() != reflect.Slice || f.Type().Elem().Kind() != reflect.Uint8 {
+		return nil, fmt.Errorf("secretstream address %T has no PubKey", ssa)
+	}
+	if f.Len() != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid secretstream key length: %d", f.Len())
+	}
+	return append(ed25519.PublicKey(nil), f.Bytes()...), nil
+}
@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// shsAddr and netwrapAddr mimic secretstream.Addr and the layered addresses of netwrap.
+// The key is only taken from PubKey, String is deliberately of no use for it.
+type shsAddr struct{ PubKey []byte }
+
+func (a shsAddr) Network() string { return "shs-bs" }
+func (a shsAddr) String() string  { return "shs peer" }
+
+type netwrapAddr struct{ head, inner net.Addr }
+
+func (a netwrapAddr) Network() string { return a.head.Network() + "|" + a.inner.Network() }
+func (a netwrapAddr) String() string  { return a.head.String() + "|" + a.inner.String() }
+func (a netwrapAddr) Head() net.Addr  { return a.head }
+func (a netwrapAddr) Inner() net.Addr { return a.inner }
+
+type shsConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c shsConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestSecretStreamRemoteKey(t *testing.T) {
+	r := require.New(t)
+
+	srvKey, _, err := ed25519.GenerateKey(nil)
+	r.NoError(err)
+	cliKey, _, err := ed25519.GenerateKey(nil)
+	r.NoError(err)
+
+	c1, c2 := loPipe(t)
+	srvConn := shsConn{c1, netwrapAddr{shsAddr{cliKey}, c1.RemoteAddr()}}
+	cliConn := shsConn{c2, netwrapAddr{shsAddr{srvKey}, c2.RemoteAddr()}}
+
+	srvPkr, err := NewSecretStreamPacker(srvConn)
+	r.NoError(err)
+	cliPkr, err := NewSecretStreamPacker(cliConn)
+	r.NoError(err)
+
+	var fhSrv FakeHandler
+	fhSrv.HandledCalls(methodChecker("whoami"))
+	fhSrv.HandleCallCalls(func(ctx context.Context, req *Request) {
+		key, ok := RemoteKey(req.RemoteAddr())
+		if !ok {
+			req.CloseWithError(ErrNoSuchMethod{Method: req.Method})
+			return
+		}
+		req.Return(ctx, base64.StdEncoding.EncodeToString(key))
+	})
+	var fhCli FakeHandler
+
+	var srvEdp Endpoint
+	handled := make(chan struct{})
+	go func() {
+		srvEdp = Handle(srvPkr, &fhSrv)
+		close(handled)
+	}()
+	cliEdp := Handle(cliPkr, &fhCli)
+	<-handled
+	defer srvEdp.Terminate()
+	defer cliEdp.Terminate()
+
+	key, ok := cliEdp.RemoteKey()
+	r.True(ok)
+	r.True(bytes.Equal(srvKey, key))
+	key, ok = srvEdp.RemoteKey()
+	r.True(ok)
+	r.True(bytes.Equal(cliKey, key))
+
+	key, ok = RemoteKey(cliEdp.Remote())
+	r.True(ok)
+	r.True(bytes.Equal(srvKey, key))
+
+	var name string
+	err = cliEdp.Async(context.Background(), &name, TypeString, Method{"whoami"})
+	r.NoError(err)
+	r.Equal(base64.StdEncoding.EncodeToString(cliKey), name)
+
+	// also works on plain netwrap'ed addresses
+	key, ok = RemoteKey(srvConn.RemoteAddr())
+	r.True(ok)
+	r.True(bytes.Equal(cliKey, key))
+
+	_, ok = RemoteKey(c1.RemoteAddr())
+	r.False(ok, "plain connections don't have a key")
+
+	_, ok = RemoteKey(netwrapAddr{shsAddr{[]byte("short")}, c1.RemoteAddr()})
+	r.False(ok, "keys have to be ed25519 keys")
+
+	_, err = NewSecretStreamPacker(c1)
+	r.Error(err)
+}
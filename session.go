@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// SessionPhase says where a session was when it ended, see SessionEndedError.
+type SessionPhase string
+
+// The phases of a session
+const (
+	// PhaseRead is reading the next packet from the connection.
+	// Sessions the remote closed end here.
+	PhaseRead SessionPhase = "read"
+
+	// PhaseHandle is processing a packet, like decoding a new call or checking quotas.
+	// Errors here mean the remote broke the protocol or one of our limits.
+	PhaseHandle SessionPhase = "handler"
+
+	// PhaseTerminate is used for sessions that ended because Terminate was called.
+	PhaseTerminate SessionPhase = "terminate"
+)
+
+// SessionEndedError describes why a session ended.
+// Serve returns it if the session failed, SessionEnd returns it for every ended session.
+type SessionEndedError struct {
+	Phase SessionPhase
+
+	// Cause is what ended the session. It is io.EOF if the remote closed the connection
+	// and ErrSessionTerminated if Terminate was called.
+	Cause error
+
+	// Aborted is how many calls were still running when the session ended
+	Aborted int
+}
+
+func (e *SessionEndedError) Error() string {
+	return fmt.Sprintf("muxrpc: session ended during %s (%d calls aborted): %v", e.Phase, e.Aborted, e.Cause)
+}
+
+func (e *SessionEndedError) Unwrap() error { return e.Cause }
+
+// Clean returns true if the remote closed the connection or Terminate was called,
+// as opposed to a failed connection or a protocol error.
+func (e *SessionEndedError) Clean() bool {
+	switch e.Phase {
+	case PhaseTerminate:
+		return true
+	case PhaseRead:
+		return errors.Is(e.Cause, io.EOF)
+	default:
+		return false
+	}
+}
+
+// sessionEnder is implemented by the endpoints returned from Handle
+type sessionEnder interface {
+	sessionEnd() *SessionEndedError
+}
+
+// SessionEnd returns why the session of edp ended, or false if it is still running.
+// It's also set for sessions that ended cleanly, for which Serve returns nil.
+func SessionEnd(edp Endpoint) (*SessionEndedError, bool) {
+	se, ok := edp.(sessionEnder)
+	if !ok {
+		return nil, false
+	}
+	end := se.sessionEnd()
+	return end, end != nil
+}
+
+func (r *rpc) sessionEnd() *SessionEndedError {
+	r.tLock.Lock()
+	defer r.tLock.Unlock()
+	return r.ended
+}
+
+// requestCounter is implemented by the endpoints returned from Handle
+type requestCounter interface {
+	openRequests() int
+}
+
+// OpenRequests returns how many calls are open on edp, in either direction.
+// Once all calls ended it drops back to zero, anything else after a quiet period hints at leaked streams.
+// ok is false if edp doesn't keep track, which is only the case for endpoints not created by Handle.
+func OpenRequests(edp Endpoint) (n int, ok bool) {
+	rc, ok := edp.(requestCounter)
+	if !ok {
+		return 0, false
+	}
+	return rc.openRequests(), true
+}
+
+func (r *rpc) openRequests() int {
+	r.rLock.RLock()
+	defer r.rLock.RUnlock()
+	return len(r.reqs)
+}
@@ -0,0 +1,197 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionEnd(t *testing.T) {
+	r := require.New(t)
+
+	c1, c2 := loPipe(t)
+
+	block := make(chan struct{})
+	defer close(block)
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("wait"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		select {
+		case <-block:
+		case <-ctx.Done():
+		}
+	})
+
+	var rpc2 Endpoint
+	started := make(chan struct{})
+	go func() {
+		rpc2 = Handle(NewPacker(c2), &fh2)
+		close(started)
+	}()
+	rpc1 := Handle(NewPacker(c1), &fh1, WithTerminateGracePeriod(0))
+	<-started
+
+	errc1, errc2 := make(chan error, 1), make(chan error, 1)
+	go func() { errc1 <- rpc1.(Server).Serve() }()
+	go func() { errc2 <- rpc2.(Server).Serve() }()
+
+	_, ended := SessionEnd(rpc1)
+	r.False(ended)
+
+	_, err := rpc1.Source(context.Background(), TypeString, Method{"wait"})
+	r.NoError(err)
+	time.Sleep(50 * time.Millisecond)
+
+	r.NoError(rpc1.Terminate())
+	for _, errc := range []chan error{errc1, errc2} {
+		select {
+		case err := <-errc:
+			r.NoError(err, "clean ends are not errors")
+		case <-time.After(2 * time.Second):
+			t.Fatal("serve did not return")
+		}
+	}
+
+	end, ended := SessionEnd(rpc1)
+	r.True(ended)
+	r.Equal(PhaseTerminate, end.Phase)
+	r.True(errors.Is(end, ErrSessionTerminated))
+	r.Equal(1, end.Aborted)
+	r.True(end.Clean())
+
+	end, ended = SessionEnd(rpc2)
+	r.True(ended)
+	r.Equal(PhaseRead, end.Phase)
+	r.True(errors.Is(end.Cause, io.EOF))
+	r.True(end.Clean())
+
+	_, ended = SessionEnd(&FakeEndpoint{})
+	r.False(ended)
+}
+
+func TestOpenRequests(t *testing.T) {
+	r := require.New(t)
+
+	var server FakeHandler
+	server.HandledCalls(methodChecker("whoami"))
+	server.HandleCallCalls(func(ctx context.Context, req *Request) {
+		req.Return(ctx, "you")
+	})
+	client, srv := connectedPair(t, &FakeHandler{}, &server)
+
+	var who string
+	r.NoError(client.Async(context.TODO(), &who, TypeString, Method{"whoami"}))
+
+	// the handler side forgets the call once it replied
+	r.Eventually(func() bool {
+		n, ok := OpenRequests(srv)
+		return ok && n == 0
+	}, time.Second, 10*time.Millisecond)
+	n, ok := OpenRequests(client)
+	r.True(ok)
+	r.Equal(0, n)
+
+	_, ok = OpenRequests(&FakeEndpoint{})
+	r.False(ok)
+}
+
+func TestTerminateWithError(t *testing.T) {
+	r := require.New(t)
+
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("wait"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		<-ctx.Done()
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2, WithTerminateGracePeriod(10*time.Second))
+
+	r.NoError(rpc1.Err())
+	select {
+	case <-rpc1.Done():
+		t.Fatal("done before termination")
+	default:
+	}
+
+	ctx := context.Background()
+	_, err := rpc1.Source(ctx, TypeString, Method{"wait"})
+	r.NoError(err)
+
+	asyncErr := make(chan error, 1)
+	go func() {
+		var res string
+		asyncErr <- rpc1.Async(ctx, &res, TypeString, Method{"wait"})
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	reason := errors.New("shutting down for maintenance")
+	canceled, cancel := context.WithCancel(ctx)
+	cancel() // no time for a graceful end
+
+	start := time.Now()
+	r.NoError(rpc1.TerminateWithError(canceled, reason))
+	r.True(time.Since(start) < 5*time.Second, "the canceled context should cut the grace period short")
+
+	select {
+	case <-rpc1.Done():
+	case <-time.After(time.Second):
+		t.Fatal("not done after termination")
+	}
+	var te *TerminatedError
+	r.True(errors.As(rpc1.Err(), &te), "unexpected error: %v", rpc1.Err())
+	r.Equal(reason, te.Reason)
+	r.True(errors.Is(rpc1.Err(), ErrSessionTerminated))
+
+	select {
+	case err := <-asyncErr:
+		r.True(errors.Is(err, reason), "unexpected error: %v", err)
+		r.True(errors.Is(err, ErrSessionTerminated))
+	case <-time.After(time.Second):
+		t.Fatal("async call wasn't aborted")
+	}
+
+	r.Eventually(func() bool { _, ended := SessionEnd(rpc1); return ended }, time.Second, 10*time.Millisecond)
+	end, _ := SessionEnd(rpc1)
+	r.True(errors.Is(end, reason))
+}
+
+func TestCallAfterTerminate(t *testing.T) {
+	r := require.New(t)
+
+	rpc1, rpc2 := connectedPair(t, &FakeHandler{}, &FakeHandler{})
+	time.Sleep(50 * time.Millisecond) // let the manifest exchange finish
+
+	ctx := context.Background()
+	reason := errors.New("going away")
+	r.NoError(rpc2.TerminateWithError(ctx, reason))
+	_, err := rpc2.Sink(ctx, TypeString, Method{"upload"})
+	var te *TerminatedError
+	r.True(errors.As(err, &te), "unexpected error: %v", err)
+	r.Equal(reason, te.Reason)
+
+	// rpc1 is terminated because the remote went away
+	select {
+	case <-rpc1.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("rpc1 wasn't terminated")
+	}
+
+	var res string
+	err = rpc1.Async(ctx, &res, TypeString, Method{"whoami"})
+	r.True(errors.Is(err, ErrSessionTerminated), "unexpected error: %v", err)
+
+	_, err = rpc1.Source(ctx, TypeString, Method{"feed"})
+	r.True(errors.Is(err, ErrSessionTerminated), "unexpected error: %v", err)
+
+	_, _, err = rpc1.Duplex(ctx, TypeString, Method{"chat"})
+	r.True(errors.Is(err, ErrSessionTerminated), "unexpected error: %v", err)
+
+	err = rpc1.DoBatch(ctx, &Request{Type: "async", Method: Method{"whoami"}})
+	r.True(errors.Is(err, ErrSessionTerminated), "unexpected error: %v", err)
+}
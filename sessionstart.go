@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mindeco.de/log/level"
+)
+
+// ErrIncompatiblePeer can be returned by a SessionStartHook for peers that don't speak the protocol of the application.
+var ErrIncompatiblePeer = errors.New("muxrpc: incompatible peer")
+
+// SessionStartHook runs once per session, before the HandleConnect of the handler, see WithSessionStartHook.
+// An error ends the session.
+type SessionStartHook func(ctx context.Context, edp Endpoint) error
+
+// WithSessionStartHook runs hook before HandleConnect, as the standard place to exchange an application-defined hello or version call
+// and to reject peers that are incompatible. The session is already served by then, so the hook can make calls
+// and the handler answers the ones of the remote, like its hello. Handle waits for the hook, like it waits for the manifest of the remote.
+//
+// If the hook fails, the session is terminated with its error (see TerminatedError, which wraps it) and HandleConnect isn't called.
+// ctx is canceled once the session ends, the hook should bound its calls with a timeout of its own.
+func WithSessionStartHook(hook SessionStartHook) HandleOption {
+	return func(r *rpc) {
+		r.sessionStart = hook
+	}
+}
+
+// startSession runs the SessionStartHook and terminates the session if it fails
+func (r *rpc) startSession() bool {
+	err := r.sessionStart(r.serveCtx, r)
+	if err == nil {
+		return true
+	}
+
+	level.Warn(r.logger).Log("event", "session start failed", "err", err)
+	r.TerminateWithError(context.Background(), fmt.Errorf("muxrpc: session start: %w", err))
+	return false
+}
@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// versionHandler answers the version call
+func versionHandler(version string) *FakeHandler {
+	var fh FakeHandler
+	fh.HandledCalls(methodChecker("version"))
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		req.Return(ctx, version)
+	})
+	return &fh
+}
+
+// helloHook asks the remote for its version and rejects other ones than want
+func helloHook(want string, started chan<- struct{}) SessionStartHook {
+	return func(ctx context.Context, edp Endpoint) error {
+		defer close(started)
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		var got string
+		if err := edp.Async(ctx, &got, TypeString, Method{"version"}); err != nil {
+			return err
+		}
+		if got != want {
+			return fmt.Errorf("remote has version %q: %w", got, ErrIncompatiblePeer)
+		}
+		return nil
+	}
+}
+
+func TestSessionStartHook(t *testing.T) {
+	r := require.New(t)
+
+	fh1, fh2 := versionHandler("v2"), versionHandler("v2")
+	started1, started2 := make(chan struct{}), make(chan struct{})
+	fh1.HandleConnectCalls(func(context.Context, Endpoint) {
+		select {
+		case <-started1:
+		default:
+			t.Error("HandleConnect before the hook returned")
+		}
+	})
+
+	rpc1, rpc2 := handledPair(t, fh1, fh2,
+		[]HandleOption{WithSessionStartHook(helloHook("v2", started1))},
+		[]HandleOption{WithSessionStartHook(helloHook("v2", started2))},
+	)
+	<-started1
+	<-started2
+
+	r.Eventually(func() bool {
+		return fh1.HandleConnectCallCount() == 1 && fh2.HandleConnectCallCount() == 1
+	}, time.Second, 5*time.Millisecond)
+	_, ended := SessionEnd(rpc1)
+	r.False(ended)
+	_, ended = SessionEnd(rpc2)
+	r.False(ended)
+}
+
+func TestSessionStartHookRejects(t *testing.T) {
+	r := require.New(t)
+
+	fh1, fh2 := versionHandler("v2"), versionHandler("v3")
+	started := make(chan struct{})
+	rpc1, rpc2 := handledPair(t, fh1, fh2,
+		[]HandleOption{WithSessionStartHook(helloHook("v2", started))},
+		nil,
+	)
+	<-started
+
+	r.Eventually(func() bool {
+		_, ended := SessionEnd(rpc1)
+		return ended
+	}, time.Second, 5*time.Millisecond)
+	end, _ := SessionEnd(rpc1)
+	r.True(errors.Is(end, ErrIncompatiblePeer), "unexpected end: %v", end)
+	r.True(errors.Is(end, ErrSessionTerminated))
+	r.Equal(0, fh1.HandleConnectCallCount())
+
+	// the remote sees the connection go away
+	r.Eventually(func() bool {
+		_, ended := SessionEnd(rpc2)
+		return ended
+	}, 2*time.Second, 10*time.Millisecond)
+}
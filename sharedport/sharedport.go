@@ -0,0 +1,178 @@
+// SPDX-License-Identifier: MIT
+
+// Package sharedport serves muxrpc and HTTP on the same port, like pubs do on :8008.
+//
+// It looks at the first bytes of every accepted connection. Connections that start like an HTTP request
+// go to the HTTP listener, everything else (secret-handshake hellos or plain muxrpc packets) to the muxrpc listener.
+// The sniffed bytes are handed on, the connections read as if nothing was taken from them.
+//
+//	m := sharedport.New(lis, sharedport.Options{})
+//	go http.Serve(m.HTTP(), handler)
+//	go acceptMuxrpc(m.Muxrpc())
+//	err := m.Serve()
+package sharedport
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by Accept of the split listeners once they or the Mux are closed
+var ErrClosed = errors.New("muxrpc/sharedport: listener closed")
+
+// DefaultSniffTimeout is how long a connection may take to send the bytes that decide where it goes, if Options don't say otherwise
+const DefaultSniffTimeout = 10 * time.Second
+
+// sniffLen is the length of the longest HTTP method with its space, enough to recognize all of them
+const sniffLen = 8
+
+var httpMethods = [][]byte{
+	[]byte("GET "), []byte("HEAD "), []byte("POST "), []byte("PUT "), []byte("DELETE "),
+	[]byte("CONNECT "), []byte("OPTIONS "), []byte("TRACE "), []byte("PATCH "),
+	[]byte("PRI * HT"), // preface of HTTP/2 without TLS
+}
+
+// IsHTTP returns true if prefix, the first bytes of a connection, start like an HTTP request
+func IsHTTP(prefix []byte) bool {
+	for _, m := range httpMethods {
+		if bytes.HasPrefix(prefix, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// Options configure a Mux
+type Options struct {
+	// SniffTimeout limits how long a new connection may take to send its first bytes, defaults to DefaultSniffTimeout.
+	// Connections that are too slow are closed.
+	SniffTimeout time.Duration
+}
+
+// Mux splits the connections of a listener by protocol
+type Mux struct {
+	root    net.Listener
+	timeout time.Duration
+
+	muxrpc, http *listener
+
+	wg sync.WaitGroup
+}
+
+// New returns a Mux that splits the connections of l. Call Serve to start accepting them.
+func New(l net.Listener, opts Options) *Mux {
+	if opts.SniffTimeout <= 0 {
+		opts.SniffTimeout = DefaultSniffTimeout
+	}
+	return &Mux{
+		root:    l,
+		timeout: opts.SniffTimeout,
+		muxrpc:  newListener(l.Addr()),
+		http:    newListener(l.Addr()),
+	}
+}
+
+// Muxrpc returns the listener for secret-handshake and muxrpc connections
+func (m *Mux) Muxrpc() net.Listener { return m.muxrpc }
+
+// HTTP returns the listener for HTTP connections, pass it to http.Serve
+func (m *Mux) HTTP() net.Listener { return m.http }
+
+// Serve accepts connections until the underlying listener fails or is closed, and returns that error.
+// The split listeners are closed when it returns, connections that weren't accepted from them yet are closed as well.
+func (m *Mux) Serve() error {
+	defer func() {
+		m.muxrpc.Close()
+		m.http.Close()
+		m.wg.Wait()
+	}()
+
+	for {
+		conn, err := m.root.Accept()
+		if err != nil {
+			return err
+		}
+		m.wg.Add(1)
+		go m.route(conn)
+	}
+}
+
+// Close closes the underlying listener, which ends Serve
+func (m *Mux) Close() error {
+	return m.root.Close()
+}
+
+// route sniffs the protocol of conn and hands it to the matching listener
+func (m *Mux) route(conn net.Conn) {
+	defer m.wg.Done()
+
+	br := bufio.NewReaderSize(conn, 64)
+	conn.SetReadDeadline(time.Now().Add(m.timeout))
+	prefix, err := br.Peek(sniffLen)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil && len(prefix) == 0 {
+		conn.Close()
+		return
+	}
+
+	target := m.muxrpc
+	if IsHTTP(prefix) {
+		target = m.http
+	}
+	target.deliver(&sniffedConn{Conn: conn, r: br})
+}
+
+// sniffedConn reads the sniffed bytes first and then the rest of the connection
+type sniffedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *sniffedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+// listener is one side of a Mux
+type listener struct {
+	addr  net.Addr
+	conns chan net.Conn
+
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newListener(addr net.Addr) *listener {
+	return &listener{
+		addr:   addr,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// deliver waits until conn is accepted, or closes it if the listener is closed
+func (l *listener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, ErrClosed
+	}
+}
+
+// Close stops handing out connections. Connections for this side that arrive later are closed.
+func (l *listener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *listener) Addr() net.Addr { return l.addr }
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: MIT
+
+package sharedport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2"
+)
+
+func TestIsHTTP(t *testing.T) {
+	r := require.New(t)
+	r.True(IsHTTP([]byte("GET / HT")))
+	r.True(IsHTTP([]byte("OPTIONS ")))
+	r.True(IsHTTP([]byte("PRI * HTTP/2.0")))
+	r.False(IsHTTP([]byte("GETX")))
+	r.False(IsHTTP([]byte{0x0a, 0, 0, 0, 0x2d, 0, 0, 0, 1})) // muxrpc header
+	r.False(IsHTTP(nil))
+}
+
+func TestSharedPort(t *testing.T) {
+	r := require.New(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+
+	m := New(lis, Options{})
+	served := make(chan error, 1)
+	go func() { served <- m.Serve() }()
+
+	go http.Serve(m.HTTP(), http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello http"))
+	}))
+
+	var server muxrpc.FakeHandler
+	server.HandledReturns(true)
+	server.HandleCallCalls(func(ctx context.Context, req *muxrpc.Request) {
+		switch req.Method.String() {
+		case "manifest":
+			req.Return(ctx, json.RawMessage(`{"manifest":"sync","whoami":"async"}`))
+		case "whoami":
+			req.Return(ctx, "muxrpc")
+		}
+	})
+	go func() {
+		for {
+			conn, err := m.Muxrpc().Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				edp := muxrpc.Handle(muxrpc.NewPacker(conn), &server)
+				edp.(muxrpc.Server).Serve()
+			}()
+		}
+	}()
+
+	// muxrpc
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	r.NoError(err)
+	edp := muxrpc.Handle(muxrpc.NewPacker(conn), &muxrpc.FakeHandler{})
+	go edp.(muxrpc.Server).Serve()
+	defer edp.Terminate()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var who string
+	r.NoError(edp.Async(ctx, &who, muxrpc.TypeString, muxrpc.Method{"whoami"}))
+	r.Equal("muxrpc", who)
+
+	// http
+	resp, err := http.Get("http://" + lis.Addr().String() + "/")
+	r.NoError(err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	r.NoError(err)
+	r.Equal("hello http", string(body))
+
+	r.NoError(m.Close())
+	r.Error(<-served)
+	_, err = m.HTTP().Accept()
+	r.True(errors.Is(err, ErrClosed), "unexpected error: %v", err)
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"sync/atomic"
+	"time"
+
+	"go.mindeco.de/log/level"
+)
+
+// WriteStall describes a frame that waited longer than the threshold of WithWriteStallHook to be written.
+type WriteStall struct {
+	// Req is the id of the request on this endpoint, negative for calls the remote started
+	Req     int32
+	Method  Method
+	TraceID string
+
+	// Size is the length of the body of the frame
+	Size int
+
+	// Waited is how long the write waited so far. It goes on waiting after the hook was called.
+	Waited time.Duration
+}
+
+// WriteStallHook is called with the writes that stalled, see WithWriteStallHook. It must not block.
+type WriteStallHook func(WriteStall)
+
+// WithWriteStallHook reports writes of stream frames that don't complete within threshold,
+// because the connection or the turns of other streams hold them up,
+// so that operators can tell which request and method wedges a session.
+// Each stall is logged as a warning and passed to hook, which may be nil.
+// Unlike WithWriteTimeout nothing is aborted, the write keeps waiting. Zero disables it.
+func WithWriteStallHook(threshold time.Duration, hook WriteStallHook) HandleOption {
+	return func(r *rpc) {
+		r.stallThreshold = threshold
+		r.stallHook = hook
+	}
+}
+
+// watchWrite reports the write of a frame of size bytes on req if it takes longer than the threshold.
+// The returned function has to be called once the write returned.
+func (r *rpc) watchWrite(req *Request, size int) (done func()) {
+	start := r.clock.Now()
+	var stalled uint32
+	t := r.clock.AfterFunc(r.stallThreshold, func() {
+		atomic.StoreUint32(&stalled, 1)
+		ws := WriteStall{
+			Req:     req.id,
+			Method:  req.Method,
+			TraceID: req.trace,
+			Size:    size,
+			Waited:  r.clock.Now().Sub(start),
+		}
+		level.Warn(r.logger).Log("event", "write stalled", "req", ws.Req, "trace", ws.TraceID, "method", ws.Method.String(), "size", size, "waited", ws.Waited)
+		if r.stallHook != nil {
+			r.stallHook(ws)
+		}
+	})
+
+	return func() {
+		if !t.Stop() && atomic.LoadUint32(&stalled) == 1 {
+			level.Info(r.logger).Log("event", "write resumed", "req", req.id, "trace", req.trace, "method", req.Method.String(), "waited", r.clock.Now().Sub(start))
+		}
+	}
+}
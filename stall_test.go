@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// gatedConn holds up writes between hold and release
+type gatedConn struct {
+	net.Conn
+
+	mu      sync.Mutex
+	stalled chan struct{}
+}
+
+func (c *gatedConn) hold() {
+	c.mu.Lock()
+	c.stalled = make(chan struct{})
+	c.mu.Unlock()
+}
+
+func (c *gatedConn) release() {
+	c.mu.Lock()
+	close(c.stalled)
+	c.stalled = nil
+	c.mu.Unlock()
+}
+
+func (c *gatedConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	wait := c.stalled
+	c.mu.Unlock()
+	if wait != nil {
+		<-wait
+	}
+	return c.Conn.Write(b)
+}
+
+func TestWriteStallHook(t *testing.T) {
+	r := require.New(t)
+
+	var fh FakeHandler
+	fh.HandledCalls(methodChecker("upload"))
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		src, err := req.ResponseSource()
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		for src.Next(ctx) {
+			src.Bytes()
+		}
+		req.CloseWithError(src.Err())
+	})
+
+	c1, c2 := loPipe(t)
+	sc := &gatedConn{Conn: c1}
+
+	stalls := make(chan WriteStall, 1)
+
+	var client Endpoint
+	handled := make(chan struct{})
+	go func() {
+		client = Handle(NewPacker(sc), &FakeHandler{}, WithWriteStallHook(20*time.Millisecond, func(ws WriteStall) { stalls <- ws }))
+		close(handled)
+	}()
+	server := Handle(NewPacker(c2), &fh)
+	<-handled
+	go client.(Server).Serve()
+	go server.(Server).Serve()
+	defer client.Terminate()
+	defer server.Terminate()
+
+	ctx := context.Background()
+	snk, err := client.Sink(ctx, TypeBinary, Method{"upload"})
+	r.NoError(err)
+
+	// quick writes aren't reported
+	_, err = snk.Write([]byte("quick"))
+	r.NoError(err)
+
+	sc.hold()
+	written := make(chan error, 1)
+	go func() {
+		_, err := snk.Write([]byte("slow"))
+		written <- err
+	}()
+
+	select {
+	case ws := <-stalls:
+		r.Equal("upload", ws.Method.String())
+		r.True(ws.Req > 0)
+		r.Equal(4, ws.Size)
+		r.NotEmpty(ws.TraceID)
+		r.True(ws.Waited >= 20*time.Millisecond, "waited: %s", ws.Waited)
+	case <-time.After(2 * time.Second):
+		t.Fatal("stall not reported")
+	}
+
+	// the write goes on once the connection moves again
+	sc.release()
+	r.NoError(<-written)
+	r.NoError(snk.Close())
+
+	select {
+	case ws := <-stalls:
+		t.Fatalf("unexpected stall: %+v", ws)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
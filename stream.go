@@ -3,6 +3,7 @@
 package muxrpc
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 
@@ -100,10 +101,20 @@ func newEndOkayPacket(req int32, stream bool) codec.Packet {
 }
 
 func newEndErrPacket(req int32, stream bool, err error) (codec.Packet, error) {
-	body, err := json.Marshal(CallError{
+	ce := CallError{
 		Message: err.Error(),
 		Name:    "Error",
-	})
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		ce.Name = DeadlineExceededErrorName
+	}
+	// keep the details if we are passing on an error from a remote
+	var remoteErr *CallError
+	if errors.As(err, &remoteErr) {
+		ce = *remoteErr
+	}
+
+	body, err := json.Marshal(ce)
 	if err != nil {
 		return codec.Packet{}, fmt.Errorf("error marshaling value: %w", err)
 	}
@@ -61,7 +61,7 @@ func (stream *streamSource) Next(ctx context.Context) (interface{}, error) {
 		}
 
 		err := stream.source.Reader(func(rd io.Reader) error {
-			err := json.NewDecoder(rd).Decode(&dst)
+			err := stream.source.json.NewDecoder(rd).Decode(&dst)
 			if err != nil {
 				return fmt.Errorf("muxrpc: failed to decode json from source: %w", err)
 			}
@@ -126,7 +126,15 @@ func (stream *streamSink) Next(ctx context.Context) (interface{}, error) {
 func (stream *streamSink) Pour(ctx context.Context, v interface{}) error {
 	// fmt.Println("[muxrpc/deprecation] warning: please use ByteSink where ever possible")
 	// debug.PrintStack()
-	var err error
+	flag, body, err := stream.sink.encodeValue(v)
+	if err == nil {
+		_, err = stream.sink.writeEncoded(flag, body)
+		return err
+	}
+	if err != ErrNotEncoded {
+		return fmt.Errorf("muxrpc/legacy: failed to encode %T: %w", v, err)
+	}
+
 	switch tv := v.(type) {
 	case []byte:
 		_, err = stream.sink.Write(tv)
@@ -139,10 +147,12 @@ func (stream *streamSink) Pour(ctx context.Context, v interface{}) error {
 	default:
 		// fmt.Printf("[legacy stream sink] defaulted on %T\n", v)
 		stream.sink.SetEncoding(TypeJSON)
-		err = json.NewEncoder(stream.sink).Encode(v)
+		var body []byte
+		body, err = stream.sink.json.Marshal(v)
 		if err != nil {
 			return fmt.Errorf("muxrpc/legacy: failed pouring to new sink: %w", err)
 		}
+		_, err = stream.sink.Write(body)
 	}
 	return err
 }
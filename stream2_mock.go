@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"time"
 
 	"go.cryptoscope.co/muxrpc/v2/codec"
 )
@@ -16,14 +17,15 @@ func NewTestSink(w io.Writer) *ByteSink {
 		Req: 666,
 	}
 	bs.w = codec.NewWriter(w)
+	bs.json = StdJSON
+	bs.remoteEnd = make(chan struct{})
+	bs.now = time.Now
 
 	return &bs
 }
 
 func NewTestSource(bodies ...[]byte) *ByteSource {
-	fb := &frameBuffer{
-		store: &bytes.Buffer{},
-	}
+	fb := newFrameBuffer(nil)
 
 	for _, b := range bodies {
 		err := fb.copyBody(uint32(len(b)), bytes.NewReader(b))
@@ -36,6 +38,7 @@ func NewTestSource(bodies ...[]byte) *ByteSource {
 		bpool:  nil,
 		buf:    fb,
 		closed: make(chan struct{}),
+		json:   StdJSON,
 	}
 	bs.streamCtx, bs.cancel = context.WithCancel(context.TODO())
 
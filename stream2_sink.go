@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.cryptoscope.co/muxrpc/v2/codec"
@@ -33,18 +34,44 @@ type ByteSink struct {
 	streamCtx context.Context
 
 	pkt codec.Packet
+
+	// used by the legacy stream adapter
+	json JSONCodec
+
+	valueEnc ValueEncoder // see SetValueEncoder
+
+	// unix nanoseconds of the last successful write, see WithStreamIdleTimeout
+	lastWrite int64
+	now       func() time.Time // the clock of the endpoint, see WithClock
+
+	// body bytes written so far, see OnCallEnd
+	written int64
+	sent    func(n int) // called with the size of every frame written, see OnStreamData
+
+	// called before a frame of n bytes is written, the returned function once it was, see WithWriteStallHook
+	watchWrite func(n int) (done func())
+
+	// closed once the remote sent its EndErr for this stream
+	remoteEnd     chan struct{}
+	remoteEndErr  error
+	remoteEndOnce sync.Once
 }
 
-func newByteSink(ctx context.Context, w *codec.Writer) *ByteSink {
+func newByteSink(ctx context.Context, w *codec.Writer, jc JSONCodec) *ByteSink {
 	return &ByteSink{
 		streamCtx: ctx,
 
-		w: w,
+		w:    w,
+		json: jc,
 
 		pkt: codec.Packet{},
+		now: time.Now,
+
+		remoteEnd: make(chan struct{}),
 	}
 }
 
+// SetEncoding sets the encoding of the following writes, replacing the previous one.
 func (bs *ByteSink) SetEncoding(re RequestEncoding) {
 	bs.closedMu.Lock()
 	defer bs.closedMu.Unlock()
@@ -52,12 +79,41 @@ func (bs *ByteSink) SetEncoding(re RequestEncoding) {
 	if err != nil {
 		panic(err)
 	}
-	bs.pkt.Flag = bs.pkt.Flag.Set(encFlag)
+	bs.pkt.Flag = withEncoding(bs.pkt.Flag, encFlag)
+}
+
+// withEncoding replaces the encoding bits of flag with the ones of enc
+func withEncoding(flag, enc codec.Flag) codec.Flag {
+	enc &= codec.FlagJSON | codec.FlagString
+	return flag.Clear(codec.FlagJSON).Clear(codec.FlagString).Set(enc)
 }
 
 func (bs *ByteSink) Write(b []byte) (int, error) {
 	bs.closedMu.Lock()
 	defer bs.closedMu.Unlock()
+	return bs.write(bs.pkt.Flag, b, false)
+}
+
+// WriteAs sends b as one frame with the encoding re, like a string or binary data on a stream of JSON values.
+// Unlike SetEncoding, it doesn't change the encoding of later writes.
+func (bs *ByteSink) WriteAs(re RequestEncoding, b []byte) (int, error) {
+	encFlag, err := re.asCodecFlag()
+	if err != nil {
+		return 0, err
+	}
+	return bs.writeEncoded(encFlag, b)
+}
+
+// writeEncoded sends b as one frame with the encoding bits of enc
+func (bs *ByteSink) writeEncoded(enc codec.Flag, b []byte) (int, error) {
+	bs.closedMu.Lock()
+	defer bs.closedMu.Unlock()
+	return bs.write(withEncoding(bs.pkt.Flag, enc), b, false)
+}
+
+// write sends b with flag. The caller needs to hold closedMu.
+// With try it returns ErrWouldBlock instead of waiting for the connection, see TryWrite.
+func (bs *ByteSink) write(flag codec.Flag, b []byte, try bool) (int, error) {
 	if bs.closed != nil {
 		return 0, bs.closed
 	}
@@ -75,20 +131,50 @@ func (bs *ByteSink) Write(b []byte) (int, error) {
 		return -1, fmt.Errorf("req ID not set (Flag: %s)", bs.pkt.Flag)
 	}
 
-	bs.pkt.Body = b
-	err := bs.w.WritePacket(bs.pkt)
+	pkt := bs.pkt
+	pkt.Flag = flag
+	pkt.Body = b
+	var err error
+	if try {
+		err = bs.w.TryWritePacket(pkt)
+		if err == codec.ErrWouldBlock {
+			return 0, ErrWouldBlock
+		}
+	} else {
+		var done func()
+		if bs.watchWrite != nil {
+			done = bs.watchWrite(len(b))
+		}
+		err = bs.w.WritePacket(pkt)
+		if done != nil {
+			done()
+		}
+	}
 	if err != nil {
 		bs.closed = err
 		return -1, err
 	}
+	atomic.StoreInt64(&bs.lastWrite, bs.now().UnixNano())
+	atomic.AddInt64(&bs.written, int64(len(b)))
+	if bs.sent != nil {
+		bs.sent(len(b))
+	}
 	return len(b), nil
 }
 
+// CloseWithError ends the stream. A nil error or io.EOF sends a regular end (true) to the remote,
+// everything else is sent as a JSON error packet, so that the other side knows why the stream was aborted.
+// Writes after closing return the passed error.
+// Closing an already closed sink again doesn't send anything. It returns nil if the sink was ended regularly
+// and otherwise the error it was closed with (which might be the error of a failed write).
 func (bs *ByteSink) CloseWithError(err error) error {
 	bs.closedMu.Lock()
 	defer bs.closedMu.Unlock()
 
 	if bs.closed != nil {
+		if errors.Is(bs.closed, io.EOF) {
+			return nil
+		}
 		return bs.closed
 	}
 
@@ -96,27 +182,32 @@ func (bs *ByteSink) CloseWithError(err error) error {
 	var isStream = bs.pkt.Flag.Get(codec.FlagStream)
 	if err == io.EOF || err == nil {
 		closePkt = newEndOkayPacket(bs.pkt.Req, isStream)
+		err = io.EOF
 	} else {
 		var epkt error
 		closePkt, epkt = newEndErrPacket(bs.pkt.Req, isStream, err)
 		if epkt != nil {
 			return fmt.Errorf("close bytesink: error building error packet for %s: %w", err, epkt)
 		}
-		bs.closed = err
 	}
 
 	// tollerate timeout in writing closed packets
-	var errc = make(chan error)
+	var errc = make(chan error, 1)
 	go func() {
-		errc <- bs.w.WritePacket(closePkt)
+		werr := bs.w.WritePacket(closePkt)
+		if werr == nil {
+			// make sure the end reaches the remote, nothing else might be written for a while
+			werr = bs.w.Flush()
+		}
+		errc <- werr
 	}()
 
 	select {
 	case werr := <-errc:
 		if werr != nil {
 			bs.closed = werr
+			return werr
 		}
-		return werr
 	case <-time.After(10 * time.Second):
 		bs.closed = errors.New("muxrpc: close timeout exceeded")
 		return bs.closed
@@ -129,3 +220,46 @@ func (bs *ByteSink) CloseWithError(err error) error {
 func (bs *ByteSink) Close() error {
 	return bs.CloseWithError(io.EOF)
 }
+
+// AwaitRemoteClose blocks until the remote sent its EndErr packet for this stream, confirming that it saw the end of it.
+// It returns ErrSessionTerminated if the session ended before that, ErrCloseUnacknowledged if the endpoint gave up waiting,
+// or the error of ctx if it is canceled first.
+func (bs *ByteSink) AwaitRemoteClose(ctx context.Context) error {
+	select {
+	case <-bs.remoteEnd:
+		return bs.remoteEndErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CloseWithErrorAndWait closes the sink like CloseWithError and then waits for the remote to acknowledge it (see AwaitRemoteClose).
+// It returns nil once the remote confirmed the end of the stream, even if the sink was already closed before.
+func (bs *ByteSink) CloseWithErrorAndWait(ctx context.Context, err error) error {
+	cerr := bs.CloseWithError(err)
+	if werr := bs.AwaitRemoteClose(ctx); werr != nil {
+		if cerr != nil {
+			return cerr
+		}
+		return werr
+	}
+	return nil
+}
+
+func (bs *ByteSink) hasRemoteEnded() bool {
+	select {
+	case <-bs.remoteEnd:
+		return true
+	default:
+		return false
+	}
+}
+
+// remoteEnded marks the end of the stream from the side of the remote.
+// err is nil if the remote sent an EndErr packet.
+func (bs *ByteSink) remoteEnded(err error) {
+	bs.remoteEndOnce.Do(func() {
+		bs.remoteEndErr = err
+		close(bs.remoteEnd)
+	})
+}
@@ -5,7 +5,6 @@ package muxrpc
 import (
 	"bytes"
 	"context"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -13,7 +12,6 @@ import (
 	"sync"
 	"sync/atomic"
 
-	"github.com/karrick/bufpool"
 	"go.cryptoscope.co/muxrpc/v2/codec"
 )
 
@@ -33,7 +31,7 @@ var _ ByteSourcer = (*ByteSource)(nil)
 
 // ByteSource is inspired by sql.Rows but without the Scan(), it just reads plain []bytes, one per muxrpc packet.
 type ByteSource struct {
-	bpool bufpool.FreeList
+	bpool BufferPool
 	buf   *frameBuffer
 
 	mu     sync.Mutex
@@ -42,16 +40,18 @@ type ByteSource struct {
 
 	hdrFlag codec.Flag
 
+	// used by the legacy stream adapter
+	json JSONCodec
+
 	streamCtx context.Context
 	cancel    context.CancelFunc
 }
 
-func newByteSource(ctx context.Context, pool bufpool.FreeList) *ByteSource {
+func newByteSource(ctx context.Context, pool BufferPool, jc JSONCodec) *ByteSource {
 	bs := &ByteSource{
-		bpool: pool,
-		buf: &frameBuffer{
-			store: pool.Get(),
-		},
+		bpool:  pool,
+		json:   jc,
+		buf:    newFrameBuffer(pool),
 		closed: make(chan struct{}),
 	}
 	bs.streamCtx, bs.cancel = context.WithCancel(ctx)
@@ -77,6 +77,19 @@ func (bs *ByteSource) Cancel(err error) {
 	close(bs.closed)
 }
 
+// drop cancels the source with err and hands back the frames that weren't read yet.
+// Unlike Cancel it also replaces a regular end, since the consumer misses frames.
+func (bs *ByteSource) drop(err error) {
+	bs.mu.Lock()
+	if bs.failed == nil {
+		close(bs.closed)
+	}
+	bs.failed = err
+	bs.mu.Unlock()
+
+	bs.buf.dropQueued()
+}
+
 // Err returns nill or an error when processing fails or the context was canceled
 func (bs *ByteSource) Err() error {
 	bs.mu.Lock()
@@ -89,45 +102,59 @@ func (bs *ByteSource) Err() error {
 	return bs.failed
 }
 
-// Next blocks until there are new muxrpc frames for this stream
+// Next blocks until there are new muxrpc frames for this stream.
+// A source has a single consumer, Next and reading the frames must not be called from several goroutines at once.
 func (bs *ByteSource) Next(ctx context.Context) bool {
-	bs.mu.Lock()
-	if bs.failed != nil && bs.buf.frames == 0 {
-		// don't return buffer before stream is empty
-		// TODO: what if a stream isn't fully drained?!
-		bs.bpool.Put(bs.buf.store)
-		bs.mu.Unlock()
-		return false
-	}
-	if bs.buf.frames > 0 {
-		bs.mu.Unlock()
-		return true
-	}
-	bs.mu.Unlock()
-
-	select {
-	case <-bs.streamCtx.Done():
+	for {
 		bs.mu.Lock()
-		defer bs.mu.Unlock()
-		if bs.failed == nil {
-			bs.failed = bs.streamCtx.Err()
+		if bs.failed != nil && bs.buf.Frames() == 0 {
+			// don't return buffer before stream is empty
+			// TODO: what if a stream isn't fully drained?!
+			bs.buf.release()
+			bs.mu.Unlock()
+			return false
 		}
-		return bs.buf.Frames() > 0
-
-	case <-ctx.Done():
-		bs.mu.Lock()
-		defer bs.mu.Unlock()
-		if bs.failed == nil {
-			bs.failed = ctx.Err()
+		if bs.buf.Frames() > 0 {
+			bs.mu.Unlock()
+			return true
 		}
-		return false
+		bs.mu.Unlock()
 
-	case <-bs.closed:
-		return bs.buf.Frames() > 0
+		select {
+		case <-bs.streamCtx.Done():
+			bs.mu.Lock()
+			defer bs.mu.Unlock()
+			if bs.failed == nil {
+				bs.failed = bs.streamCtx.Err()
+			}
+			return bs.more()
+
+		case <-ctx.Done():
+			bs.mu.Lock()
+			defer bs.mu.Unlock()
+			if bs.failed == nil {
+				bs.failed = ctx.Err()
+			}
+			bs.buf.release()
+			return false
+
+		case <-bs.closed:
+			return bs.more()
+
+		case <-bs.buf.waitForMore():
+			// the token might be left from a frame that was already read, check again
+		}
+	}
+}
 
-	case <-bs.buf.waitForMore():
+// more returns true if there are frames left after the stream ended.
+// Otherwise the last frame is handed back to the pool right away, instead of whenever the source is garbage collected.
+func (bs *ByteSource) more() bool {
+	if bs.buf.Frames() > 0 {
 		return true
 	}
+	bs.buf.release()
+	return false
 }
 
 // Reader passes a (limited) reader for the next segment to the passed .
@@ -137,11 +164,7 @@ func (bs *ByteSource) Reader(fn ReadFn) error {
 	if err != nil {
 		return err
 	}
-
-	bs.buf.mu.Lock()
-	err = fn(rd)
-	bs.buf.mu.Unlock()
-	return err
+	return fn(rd)
 }
 
 // Bytes returns the full slice of bytes from the next frame.
@@ -150,141 +173,253 @@ func (bs *ByteSource) Bytes() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	bs.buf.mu.Lock()
-	b, err := ioutil.ReadAll(rd)
-	bs.buf.mu.Unlock()
-	return b, err
+	return ioutil.ReadAll(rd)
+}
+
+// Skip discards what wasn't read yet of the frame that Reader or Bytes returned last,
+// and hands its buffer back right away instead of on the next read.
+func (bs *ByteSource) Skip() {
+	bs.buf.skipCurrent()
+}
+
+// SkipN drops the next n frames without reading them, for consumers that only sample a stream.
+// It waits for the frames like Next does and returns how many it dropped,
+// which is less than n if the stream ended first or ctx was canceled. The error is the one of Err then.
+func (bs *ByteSource) SkipN(ctx context.Context, n int) (int, error) {
+	bs.Skip()
+	for i := 0; i < n; i++ {
+		if !bs.Next(ctx) {
+			return i, bs.Err()
+		}
+		bs.buf.dropNext()
+	}
+	return n, nil
 }
 
+// consume reads the body of a packet from r and adds it as the next frame
 func (bs *ByteSource) consume(pktLen uint32, flag codec.Flag, r io.Reader) error {
+	body, err := bs.buf.readBody(pktLen, r)
+	if err != nil {
+		return err
+	}
+	return bs.consumeBuffer(flag, body)
+}
+
+// consumeBuffer takes ownership of body, which should be from the pool of the source, and adds it as the next frame.
+// If the source was canceled the buffer is handed back to the pool right away.
+func (bs *ByteSource) consumeBuffer(flag codec.Flag, body *bytes.Buffer) error {
 	bs.mu.Lock()
 	defer bs.mu.Unlock()
 
 	if bs.failed != nil {
+		bs.buf.putBuffer(body)
 		return fmt.Errorf("muxrpc: byte source canceled: %w", bs.failed)
 	}
 
 	bs.hdrFlag = flag
 
-	err := bs.buf.copyBody(pktLen, r)
-	if err != nil {
-		return err
-	}
-
+	bs.buf.addFrame(body)
 	return nil
 }
 
 // utils
 
-// frame buffer: a buffer frames and a frame is length+body.
-// it stores muxrpc body packets with their length as one contiguous stream in a bytes.Buffer
+// frame buffer: a queue of frames, one pooled buffer per muxrpc body packet.
+// The buffers are filled straight from the connection and handed back to the pool once the frame was read.
+//
+// It has a single consumer: the frames are read by one goroutine at a time, like the one that calls ByteSource.Next.
 type frameBuffer struct {
-	mu    sync.Mutex
-	store *bytes.Buffer
+	mu   sync.Mutex
+	pool BufferPool // might be nil, then buffers are just allocated
 
-	// TODO[weird-chans]: why exactly do you need a list of channels here
-	waiting []chan<- struct{}
+	queue   []*bytes.Buffer
+	current *bytes.Buffer // the frame that is being read
+	curSize int           // the size current had, reading it empties the buffer
 
-	// how much of the current frame has been read
-	// to advance/skip store correctly
-	currentFrameTotal uint32
-	currentFrameRead  uint32
+	mem *memCharge // counts the buffered bytes, see WithMemoryBudget
+
+	// holds a token once a frame was added after the consumer last looked, see waitForMore
+	added chan struct{}
+	// holds a token once the consumer took a frame, for producers that wait for room (see Tee)
+	taken chan struct{}
 
 	frames uint32
+}
 
-	lenBuf [4]byte
+func newFrameBuffer(pool BufferPool) *frameBuffer {
+	return &frameBuffer{
+		pool:  pool,
+		added: make(chan struct{}, 1),
+		taken: make(chan struct{}, 1),
+	}
 }
 
 func (fb *frameBuffer) Frames() uint32 {
 	return atomic.LoadUint32(&fb.frames)
 }
 
-func (fb *frameBuffer) copyBody(pktLen uint32, rd io.Reader) error {
+func (fb *frameBuffer) getBuffer(size int) *bytes.Buffer {
+	if fb.pool != nil {
+		return fb.pool.Get(size)
+	}
+	return bytes.NewBuffer(make([]byte, 0, size))
+}
+
+func (fb *frameBuffer) putBuffer(b *bytes.Buffer) {
+	if fb.pool != nil {
+		fb.pool.Put(b)
+	}
+}
+
+// track counts the buffered bytes with mc from now on
+func (fb *frameBuffer) track(mc *memCharge) {
 	fb.mu.Lock()
 	defer fb.mu.Unlock()
+	fb.mem = mc
+}
 
-	binary.LittleEndian.PutUint32(fb.lenBuf[:], uint32(pktLen))
-	fb.store.Write(fb.lenBuf[:])
+// recycle hands back a frame of size bytes. The caller needs to hold mu.
+func (fb *frameBuffer) recycle(b *bytes.Buffer, size int) {
+	fb.putBuffer(b)
+	if fb.mem != nil && size > 0 {
+		fb.mem.uncharge(size)
+	}
+}
+
+// readBody reads exactly pktLen bytes from rd into a buffer from the pool
+func (fb *frameBuffer) readBody(pktLen uint32, rd io.Reader) (*bytes.Buffer, error) {
+	body := fb.getBuffer(int(pktLen))
 
-	copied, err := io.Copy(fb.store, rd)
+	copied, err := io.Copy(body, io.LimitReader(rd, int64(pktLen)))
 	if err != nil {
-		return err
+		fb.putBuffer(body)
+		return nil, err
 	}
 
 	if uint32(copied) != pktLen {
-		return errors.New("frameBuffer: failed to consume whole body")
+		fb.putBuffer(body)
+		return nil, errors.New("frameBuffer: failed to consume whole body")
 	}
+	return body, nil
+}
 
-	atomic.AddUint32(&fb.frames, 1)
-
-	// TODO[weird-chans]: why exactly do you need a list of channels here
-	if n := len(fb.waiting); n > 0 {
-		for _, ch := range fb.waiting {
-			close(ch)
-		}
-		fb.waiting = make([]chan<- struct{}, 0)
+// copyBody reads the next frame from rd
+func (fb *frameBuffer) copyBody(pktLen uint32, rd io.Reader) error {
+	body, err := fb.readBody(pktLen, rd)
+	if err != nil {
+		return err
 	}
+	fb.addFrame(body)
 	return nil
 }
 
-func (fb *frameBuffer) waitForMore() <-chan struct{} {
+func (fb *frameBuffer) addFrame(body *bytes.Buffer) {
 	fb.mu.Lock()
 	defer fb.mu.Unlock()
 
-	// TODO: maybe retrn nil to signal this instead of allocating channels that are immediatly closed?
-	ch := make(chan struct{})
-	if fb.frames > 0 {
-		close(ch)
-		return ch
+	fb.queue = append(fb.queue, body)
+	atomic.AddUint32(&fb.frames, 1)
+	if fb.mem != nil {
+		fb.mem.charge(body.Len())
 	}
 
-	// TODO[weird-chans]: why exactly do you need a list of channels here
-	fb.waiting = append(fb.waiting, ch)
-	return ch
+	select {
+	case fb.added <- struct{}{}:
+	default: // the consumer wasn't told about the previous frame yet, that's enough
+	}
 }
 
-func (fb *frameBuffer) getNextFrameReader() (uint32, io.Reader, error) {
+func (fb *frameBuffer) signalTaken() {
+	select {
+	case fb.taken <- struct{}{}:
+	default:
+	}
+}
+
+// waitForMore returns a channel that yields once frames were added since it last did.
+// The token can be stale, if the consumer already read the frame that left it, so check Frames after receiving it.
+// It's the same channel every time, waiting doesn't allocate anything.
+func (fb *frameBuffer) waitForMore() <-chan struct{} {
+	return fb.added
+}
+
+// release hands all buffers back to the pool. It's safe to call it multiple times.
+func (fb *frameBuffer) release() {
 	fb.mu.Lock()
 	defer fb.mu.Unlock()
 
-	if fb.currentFrameTotal != 0 {
-		// if the last frame hasn't been fully read
-		diff := int64(fb.currentFrameTotal - fb.currentFrameRead)
-		if diff > 0 {
-			// seek it into /dev/null
-			io.Copy(ioutil.Discard, io.LimitReader(fb.store, diff))
-		}
+	if fb.current != nil {
+		fb.recycle(fb.current, fb.curSize)
+		fb.current = nil
 	}
+	fb.recycleQueue()
+}
 
-	_, err := fb.store.Read(fb.lenBuf[:])
-	if err != nil {
-		return 0, nil, fmt.Errorf("muxrpc: didnt get length of next body (frames:%d): %w", fb.frames, err)
+// dropQueued hands back the frames that weren't read yet, but not the one that is being read
+func (fb *frameBuffer) dropQueued() {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	fb.recycleQueue()
+}
+
+// recycleQueue hands back all queued frames. The caller needs to hold mu.
+func (fb *frameBuffer) recycleQueue() {
+	for _, b := range fb.queue {
+		fb.recycle(b, b.Len())
 	}
-	pktLen := binary.LittleEndian.Uint32(fb.lenBuf[:])
+	fb.queue = nil
+	atomic.StoreUint32(&fb.frames, 0)
+	fb.signalTaken()
+}
 
-	fb.currentFrameRead = 0
-	fb.currentFrameTotal = pktLen
+// skipCurrent hands back the frame that is being read
+func (fb *frameBuffer) skipCurrent() {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
 
-	rd := &countingReader{
-		rd:   io.LimitReader(fb.store, int64(pktLen)),
-		read: &fb.currentFrameRead,
+	if fb.current != nil {
+		fb.recycle(fb.current, fb.curSize)
+		fb.current = nil
 	}
+}
 
-	// fb.frames--
+// dropNext hands back the next frame without reading it
+func (fb *frameBuffer) dropNext() {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	if len(fb.queue) == 0 {
+		return
+	}
+	fb.recycle(fb.queue[0], fb.queue[0].Len())
+	fb.queue[0] = nil
+	fb.queue = fb.queue[1:]
 	atomic.AddUint32(&fb.frames, ^uint32(0))
-	return pktLen, rd, nil
+	fb.signalTaken()
 }
 
-type countingReader struct {
-	rd io.Reader
+// getNextFrameReader hands back the previous frame (read or not) and returns a reader for the next one.
+// The reader is valid until the next call.
+func (fb *frameBuffer) getNextFrameReader() (uint32, io.Reader, error) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
 
-	read *uint32
-}
+	if fb.current != nil {
+		fb.recycle(fb.current, fb.curSize)
+		fb.current = nil
+	}
 
-func (cr *countingReader) Read(b []byte) (int, error) {
-	n, err := cr.rd.Read(b)
-	if err == nil && n > 0 {
-		*cr.read += uint32(n)
+	if len(fb.queue) == 0 {
+		return 0, nil, fmt.Errorf("muxrpc: didnt get length of next body (frames:%d): %w", fb.frames, io.EOF)
 	}
-	return n, err
+
+	fb.current = fb.queue[0]
+	fb.curSize = fb.current.Len()
+	fb.queue[0] = nil
+	fb.queue = fb.queue[1:]
+
+	// fb.frames--
+	atomic.AddUint32(&fb.frames, ^uint32(0))
+	fb.signalTaken()
+	return uint32(fb.current.Len()), fb.current, nil
 }
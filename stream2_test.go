@@ -12,10 +12,10 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
-	"github.com/karrick/bufpool"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -28,9 +28,9 @@ func TestSourceBytesFill(t *testing.T) {
 
 	ctx := context.Background()
 
-	bpool, err := bufpool.NewLockPool()
+	bpool, err := NewSizeClassPool(DefaultBufferClasses...)
 	r.NoError(err)
-	var bs = newByteSource(ctx, bpool)
+	var bs = newByteSource(ctx, bpool, StdJSON)
 
 	var exp = [][]byte{
 		[]byte("fii"),
@@ -67,9 +67,9 @@ func TestSourceBytesOneByOne(t *testing.T) {
 
 	ctx := context.Background()
 
-	bpool, err := bufpool.NewLockPool()
+	bpool, err := NewSizeClassPool(DefaultBufferClasses...)
 	r.NoError(err)
-	var bs = newByteSource(ctx, bpool)
+	var bs = newByteSource(ctx, bpool, StdJSON)
 
 	var exp = [][]byte{
 		[]byte("fii"),
@@ -103,9 +103,9 @@ func TestSourceBytesDontReadAll(t *testing.T) {
 
 	ctx := context.Background()
 
-	bpool, err := bufpool.NewLockPool()
+	bpool, err := NewSizeClassPool(DefaultBufferClasses...)
 	r.NoError(err)
-	var bs = newByteSource(ctx, bpool)
+	var bs = newByteSource(ctx, bpool, StdJSON)
 
 	var exp = [][]byte{
 		[]byte("1fii"),
@@ -197,14 +197,17 @@ func setupSource(t testing.TB, expRx []map[string]interface{}) Endpoint {
 	ctx := context.Background()
 
 	var rpc2 Endpoint
+	rpc2started := make(chan struct{})
 	go func() {
 		rpc2 = Handle(NewPacker(c2), &fh2)
+		close(rpc2started)
 		serve(ctx, rpc2.(Server), errc, serve2)
 	}()
 
 	rpc1 := Handle(dbgpacker, &fh1)
 
 	go serve(ctx, rpc1.(Server), errc, serve1)
+	<-rpc2started
 
 	select {
 	case <-conn1:
@@ -350,3 +353,162 @@ func BenchmarkSourceByte(b *testing.B) {
 		// r.Equal(expIdx, count, "expected more items")
 	}
 }
+
+// countingPool hands out fresh buffers and counts how often they came back
+type countingPool struct {
+	mu   sync.Mutex
+	gets int
+	puts map[*bytes.Buffer]int
+}
+
+func (cp *countingPool) Get(size int) *bytes.Buffer {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.gets++
+	return new(bytes.Buffer)
+}
+
+func (cp *countingPool) Put(b *bytes.Buffer) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if cp.puts == nil {
+		cp.puts = make(map[*bytes.Buffer]int)
+	}
+	cp.puts[b]++
+}
+
+func TestSourceBytesReturnsBufferOnce(t *testing.T) {
+	r := require.New(t)
+
+	ctx := context.Background()
+
+	var pool countingPool
+	bs := newByteSource(ctx, &pool, StdJSON)
+	r.Equal(0, pool.gets, "buffer should only be taken once data arrives")
+
+	r.NoError(bs.consume(3, codec.FlagStream, strings.NewReader("fii")))
+	r.Equal(1, pool.gets)
+
+	bs.Cancel(nil)
+
+	r.True(bs.Next(ctx))
+	_, err := bs.Bytes()
+	r.NoError(err)
+
+	r.False(bs.Next(ctx))
+	r.False(bs.Next(ctx))
+
+	r.Len(pool.puts, 1)
+	for _, n := range pool.puts {
+		r.Equal(1, n, "buffer was put back more than once")
+	}
+}
+
+func TestSourceBytesReleaseAfterRead(t *testing.T) {
+	r := require.New(t)
+
+	ctx := context.Background()
+
+	var pool countingPool
+	bs := newByteSource(ctx, &pool, StdJSON)
+
+	r.NoError(bs.consume(5, codec.FlagJSON, strings.NewReader(`"hi"`+" ")))
+	r.True(bs.Next(ctx))
+	_, err := bs.Bytes()
+	r.NoError(err)
+
+	// the frame that was read is still held until the next one is requested
+	r.Len(pool.puts, 0)
+	bs.buf.release()
+	r.Len(pool.puts, 1)
+}
+
+func TestSourceBytesReleaseOnEnd(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		end  func(*ByteSource, context.CancelFunc)
+	}{
+		{"closed", func(bs *ByteSource, _ context.CancelFunc) { bs.Cancel(nil) }},
+		{"canceled", func(_ *ByteSource, cancel context.CancelFunc) { cancel() }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var pool countingPool
+			bs := newByteSource(context.Background(), &pool, StdJSON)
+
+			r.NoError(bs.consume(5, codec.FlagJSON, strings.NewReader(`"hi"`+" ")))
+			r.True(bs.Next(ctx))
+			_, err := bs.Bytes()
+			r.NoError(err)
+
+			// end the stream while Next waits for more
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				tc.end(bs, cancel)
+			}()
+			r.False(bs.Next(ctx))
+
+			pool.mu.Lock()
+			defer pool.mu.Unlock()
+			r.Len(pool.puts, 1, "the last frame should be handed back once the stream ended")
+		})
+	}
+}
+
+func TestSourceBytesStaleWakeup(t *testing.T) {
+	r := require.New(t)
+
+	ctx := context.Background()
+	bs := NewTestSource([]byte("first"))
+
+	// the frame is there before anyone waits, so its wakeup is left over
+	r.True(bs.Next(ctx))
+	b, err := bs.Bytes()
+	r.NoError(err)
+	r.Equal("first", string(b))
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		bs.consume(6, codec.FlagString, strings.NewReader("second"))
+	}()
+	r.True(bs.Next(ctx))
+	b, err = bs.Bytes()
+	r.NoError(err, "Next returned for the stale wakeup")
+	r.Equal("second", string(b))
+}
+
+func TestSourceBytesSkip(t *testing.T) {
+	r := require.New(t)
+
+	ctx := context.Background()
+	bs := NewTestSource([]byte("one"), []byte("two"), []byte("three"), []byte("four"), []byte("five"))
+
+	r.True(bs.Next(ctx))
+	err := bs.Reader(func(rd io.Reader) error {
+		var b [1]byte
+		_, err := rd.Read(b[:])
+		return err
+	})
+	r.NoError(err)
+	bs.Skip()
+	r.Nil(bs.buf.current, "the skipped frame should be handed back")
+
+	n, err := bs.SkipN(ctx, 2)
+	r.NoError(err)
+	r.Equal(2, n)
+
+	r.True(bs.Next(ctx))
+	b, err := bs.Bytes()
+	r.NoError(err)
+	r.Equal("four", string(b))
+
+	bs.Cancel(nil)
+	n, err = bs.SkipN(ctx, 3)
+	r.NoError(err)
+	r.Equal(1, n, "only one frame was left")
+	r.False(bs.Next(ctx))
+}
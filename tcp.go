@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// TCPOptions tunes the sockets of the connections made by DialTCP and ListenTCP.
+// The zero value keeps the defaults of Go and the operating system.
+type TCPOptions struct {
+	// Delay enables Nagle's algorithm, which Go disables by default (TCP_NODELAY).
+	// It trades latency for fewer packets, which can help on slow links with lots of tiny writes.
+	Delay bool
+
+	// KeepAlive is the interval of the TCP keep-alive probes (SO_KEEPALIVE).
+	// Zero keeps the default of Go, negative disables them.
+	KeepAlive time.Duration
+
+	// ReadBuffer and WriteBuffer set the size of the socket buffers in bytes (SO_RCVBUF and SO_SNDBUF).
+	// Zero keeps the default of the operating system.
+	ReadBuffer, WriteBuffer int
+}
+
+// Apply sets the options on conn, which has to be a TCP connection or wrap one (see RawConn).
+// DialTCP and ListenTCP already do this, it's for connections that are made some other way.
+func (o TCPOptions) Apply(conn net.Conn) error {
+	tc, ok := unwrapConn(conn).(*net.TCPConn)
+	if !ok {
+		return fmt.Errorf("muxrpc: not a tcp connection: %T", conn)
+	}
+
+	if err := tc.SetNoDelay(!o.Delay); err != nil {
+		return fmt.Errorf("muxrpc: failed to set TCP_NODELAY: %w", err)
+	}
+	if o.KeepAlive != 0 {
+		if err := tc.SetKeepAlive(o.KeepAlive > 0); err != nil {
+			return fmt.Errorf("muxrpc: failed to set SO_KEEPALIVE: %w", err)
+		}
+		if o.KeepAlive > 0 {
+			if err := tc.SetKeepAlivePeriod(o.KeepAlive); err != nil {
+				return fmt.Errorf("muxrpc: failed to set keep-alive period: %w", err)
+			}
+		}
+	}
+	if o.ReadBuffer > 0 {
+		if err := tc.SetReadBuffer(o.ReadBuffer); err != nil {
+			return fmt.Errorf("muxrpc: failed to set SO_RCVBUF: %w", err)
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := tc.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return fmt.Errorf("muxrpc: failed to set SO_SNDBUF: %w", err)
+		}
+	}
+	return nil
+}
+
+// DialTCP connects to addr and tunes the connection with o. Hand it to NewPacker, or to a handshake first.
+func DialTCP(ctx context.Context, addr string, o TCPOptions) (net.Conn, error) {
+	d := net.Dialer{KeepAlive: o.KeepAlive}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("muxrpc: failed to dial %s: %w", addr, err)
+	}
+	if err := o.Apply(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// ListenTCP listens on addr and tunes each accepted connection with o.
+func ListenTCP(addr string, o TCPOptions) (net.Listener, error) {
+	lc := net.ListenConfig{KeepAlive: o.KeepAlive}
+	l, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("muxrpc: failed to listen on %s: %w", addr, err)
+	}
+	return tcpListener{Listener: l, opts: o}, nil
+}
+
+type tcpListener struct {
+	net.Listener
+	opts TCPOptions
+}
+
+func (l tcpListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if err := l.opts.Apply(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// RawConn returns the network connection below an endpoint, for tuning that TCPOptions doesn't cover.
+// Wrappers like the ones of NewTLSPacker and NewSecretStreamPacker are removed, as far as they tell what they wrap.
+// Only use it for socket options and the like, reading or writing on it breaks the session.
+// It returns false if the endpoint doesn't run over a net.Conn.
+func RawConn(edp Endpoint) (net.Conn, bool) {
+	rc, ok := edp.(rawConner)
+	if !ok {
+		return nil, false
+	}
+	conn, ok := rc.rawConn().(net.Conn)
+	if !ok {
+		return nil, false
+	}
+	return unwrapConn(conn), true
+}
+
+type rawConner interface {
+	rawConn() interface{}
+}
+
+func (r *rpc) rawConn() interface{} { return r.pkr.c }
+
+// unwrapConn peels off connection wrappers that tell what they wrap, like *tls.Conn does
+func unwrapConn(conn net.Conn) net.Conn {
+	for {
+		w, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return conn
+		}
+		inner := w.NetConn()
+		if inner == nil {
+			return conn
+		}
+		conn = inner
+	}
+}
@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialListenTCP(t *testing.T) {
+	r := require.New(t)
+
+	opts := TCPOptions{
+		KeepAlive:   10 * time.Second,
+		ReadBuffer:  64 * 1024,
+		WriteBuffer: 64 * 1024,
+	}
+	l, err := ListenTCP("localhost:0", opts)
+	r.NoError(err)
+	defer l.Close()
+
+	var fh FakeHandler
+	fh.HandledCalls(methodChecker("whoami"))
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		req.Return(ctx, "server")
+	})
+
+	accepted := make(chan Endpoint, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			close(accepted)
+			return
+		}
+		edp := Handle(NewPacker(conn), &fh)
+		go edp.(Server).Serve()
+		accepted <- edp
+	}()
+
+	conn, err := DialTCP(context.Background(), l.Addr().String(), opts)
+	r.NoError(err)
+	client := Handle(NewPacker(conn), &FakeHandler{})
+	go client.(Server).Serve()
+	defer client.Terminate()
+
+	server := <-accepted
+	r.NotNil(server)
+	defer server.Terminate()
+
+	var who string
+	r.NoError(client.Async(context.Background(), &who, TypeString, Method{"whoami"}))
+	r.Equal("server", who)
+
+	raw, ok := RawConn(client)
+	r.True(ok)
+	r.Equal(conn, raw)
+	raw, ok = RawConn(server)
+	r.True(ok)
+	_, isTCP := raw.(*net.TCPConn)
+	r.True(isTCP, "unexpected conn: %T", raw)
+}
+
+func TestRawConnUnwraps(t *testing.T) {
+	r := require.New(t)
+
+	c1, c2 := loPipe(t)
+
+	remote := make(chan Endpoint, 1)
+	go func() {
+		remote <- Handle(NewPacker(c2), &FakeHandler{})
+	}()
+	ssc := secretStreamConn{Conn: c1, remote: SecretStreamAddr{Addr: c1.RemoteAddr()}}
+	edp := Handle(NewPacker(ssc), &FakeHandler{})
+	defer edp.Terminate()
+	defer (<-remote).Terminate()
+
+	raw, ok := RawConn(edp)
+	r.True(ok)
+	r.Equal(c1, raw)
+
+	r.NoError(TCPOptions{Delay: true}.Apply(tls.Client(c1, &tls.Config{})))
+
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+	r.Error(TCPOptions{}.Apply(p1))
+}
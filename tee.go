@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// defaultTeeBuffer is how many frames each consumer of a Tee may fall behind, unless Tee says otherwise.
+const defaultTeeBuffer = 16
+
+// errTeeAbandoned cancels the source of a Tee once all its consumers canceled theirs
+var errTeeAbandoned = errors.New("muxrpc: all consumers of the tee are gone")
+
+// Tee splits src into n sources that each get all of its frames, so that independent consumers can read the same stream,
+// like a blob that is hashed and written to disk at the same time.
+// Each of them buffers up to maxBuffered frames that weren't read yet (zero or less means 16).
+// Once one is full the others wait as well, so the slowest consumer sets the pace.
+//
+// The sources end like src, with the same error. Canceling one of them only drops that consumer,
+// src is canceled once all of them are. Don't read from src directly after handing it to Tee.
+func Tee(src *ByteSource, n, maxBuffered int) []*ByteSource {
+	if maxBuffered <= 0 {
+		maxBuffered = defaultTeeBuffer
+	}
+
+	t := &tee{
+		src:  src,
+		max:  uint32(maxBuffered),
+		done: make(chan struct{}),
+		outs: make([]*ByteSource, n),
+	}
+	for i := range t.outs {
+		t.outs[i] = newByteSource(context.Background(), src.bpool, src.json)
+	}
+
+	go t.pump()
+	go t.watch()
+	return append([]*ByteSource(nil), t.outs...)
+}
+
+type tee struct {
+	src *ByteSource
+	max uint32
+
+	done chan struct{} // closed once the pump stopped
+
+	outs []*ByteSource
+}
+
+// pump copies the frames of the source to the consumers that are still there
+func (t *tee) pump() {
+	defer close(t.done)
+
+	live := t.outs
+	for len(live) > 0 && t.src.Next(context.Background()) {
+		var body []byte
+		err := t.src.Reader(func(rd io.Reader) error {
+			var err error
+			body, err = ioutil.ReadAll(rd)
+			return err
+		})
+		if err != nil {
+			t.src.Cancel(err)
+			break
+		}
+
+		t.src.mu.Lock()
+		flag := t.src.hdrFlag
+		t.src.mu.Unlock()
+
+		var still []*ByteSource
+		for _, out := range live {
+			if !t.waitForRoom(out) {
+				continue
+			}
+			if err := out.consume(uint32(len(body)), flag, bytes.NewReader(body)); err != nil {
+				continue // canceled by its consumer
+			}
+			still = append(still, out)
+		}
+		live = still
+	}
+
+	if len(live) == 0 {
+		t.src.Cancel(errTeeAbandoned)
+		return
+	}
+	err := t.src.Err()
+	for _, out := range live {
+		out.Cancel(err)
+	}
+}
+
+// waitForRoom blocks while out has as many unread frames as allowed. It returns false if out was canceled.
+func (t *tee) waitForRoom(out *ByteSource) bool {
+	for out.buf.Frames() >= t.max {
+		select {
+		case <-out.buf.taken:
+		case <-out.closed:
+			return false
+		}
+	}
+	return true
+}
+
+// watch cancels the source once all consumers canceled theirs, even if the pump waits for the next frame
+func (t *tee) watch() {
+	for _, out := range t.outs {
+		select {
+		case <-out.closed:
+		case <-t.done:
+			return
+		}
+	}
+	t.src.Cancel(errTeeAbandoned)
+}
@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// readAll concatenates the frames of src
+func readAll(ctx context.Context, src *ByteSource) ([]byte, error) {
+	var all []byte
+	for src.Next(ctx) {
+		b, err := src.Bytes()
+		if err != nil {
+			return all, err
+		}
+		all = append(all, b...)
+	}
+	return all, src.Err()
+}
+
+func TestTee(t *testing.T) {
+	r := require.New(t)
+
+	var frames [][]byte
+	var want []byte
+	for i := 0; i < 50; i++ {
+		f := []byte(fmt.Sprintf("chunk %d;", i))
+		frames = append(frames, f)
+		want = append(want, f...)
+	}
+	src := NewTestSource(frames...)
+	src.Cancel(nil)
+
+	outs := Tee(src, 2, 4)
+	r.Len(outs, 2)
+
+	ctx := context.Background()
+	hashed := make(chan [32]byte)
+	go func() {
+		h := sha256.New()
+		for outs[0].Next(ctx) {
+			outs[0].Reader(func(rd io.Reader) error {
+				_, err := io.Copy(h, rd)
+				return err
+			})
+		}
+		var sum [32]byte
+		copy(sum[:], h.Sum(nil))
+		hashed <- sum
+	}()
+
+	// the slow one
+	var written []byte
+	for outs[1].Next(ctx) {
+		r.True(outs[1].buf.Frames() <= 4, "buffered more than allowed")
+		b, err := outs[1].Bytes()
+		r.NoError(err)
+		written = append(written, b...)
+		time.Sleep(time.Millisecond)
+	}
+	r.NoError(outs[1].Err())
+	r.Equal(want, written)
+	r.Equal(sha256.Sum256(want), <-hashed)
+}
+
+func TestTeeError(t *testing.T) {
+	r := require.New(t)
+
+	failure := errors.New("remote failed")
+	src := NewTestSource([]byte("a"), []byte("b"))
+	src.Cancel(failure)
+
+	for _, out := range Tee(src, 3, 0) {
+		got, err := readAll(context.Background(), out)
+		r.Equal("ab", string(got))
+		r.Equal(failure, err)
+	}
+}
+
+func TestTeeCancelConsumer(t *testing.T) {
+	r := require.New(t)
+
+	ctx := context.Background()
+	src := NewTestSource([]byte("a"), []byte("b"), []byte("c"))
+	outs := Tee(src, 2, 1)
+
+	// dropping one consumer doesn't hold up the other
+	outs[0].Cancel(nil)
+	for _, want := range []string{"a", "b", "c"} {
+		r.True(outs[1].Next(ctx))
+		b, err := outs[1].Bytes()
+		r.NoError(err)
+		r.Equal(want, string(b))
+	}
+
+	// once all are gone, so is the source
+	outs[1].Cancel(nil)
+	select {
+	case <-src.closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("source wasn't canceled")
+	}
+	r.True(errors.Is(src.Err(), errTeeAbandoned), "unexpected error: %v", src.Err())
+}
@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+)
+
+// TLSAddr is the remote address of an endpoint that runs over TLS.
+// Next to the network address it holds the certificate chain the peer presented, which identifies it.
+type TLSAddr struct {
+	net.Addr
+
+	PeerCertificates []*x509.Certificate
+}
+
+func (a TLSAddr) String() string {
+	if len(a.PeerCertificates) == 0 {
+		return a.Addr.String()
+	}
+	return fmt.Sprintf("%s|%s", a.Addr.String(), a.PeerCertificates[0].Subject.CommonName)
+}
+
+// tlsConn reports a TLSAddr as its remote address, so that Handle picks it up for Remote() and Request.RemoteAddr()
+type tlsConn struct {
+	*tls.Conn
+	remote TLSAddr
+}
+
+func (c tlsConn) RemoteAddr() net.Addr { return c.remote }
+
+// NewTLSPacker completes the TLS handshake on conn and returns a packer for it.
+// Endpoints created from it report a TLSAddr as their remote address, see PeerCertificate.
+// Set a deadline on conn to limit how long the handshake may take.
+func NewTLSPacker(conn *tls.Conn, opts ...PackerOption) (*Packer, error) {
+	if err := conn.Handshake(); err != nil {
+		return nil, fmt.Errorf("muxrpc: tls handshake failed: %w", err)
+	}
+
+	tc := tlsConn{
+		Conn: conn,
+		remote: TLSAddr{
+			Addr:             conn.RemoteAddr(),
+			PeerCertificates: conn.ConnectionState().PeerCertificates,
+		},
+	}
+	return NewPacker(tc, opts...), nil
+}
+
+// PeerCertificate returns the certificate the peer identified itself with,
+// if addr is the remote address of an endpoint or request that runs over TLS (see NewTLSPacker).
+// It returns false if there is no TLS connection or the peer didn't present a certificate.
+func PeerCertificate(addr net.Addr) (*x509.Certificate, bool) {
+	ta, ok := addr.(TLSAddr)
+	if !ok || len(ta.PeerCertificates) == 0 {
+		return nil, false
+	}
+	return ta.PeerCertificates[0], true
+}
@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func selfSignedCert(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTLSPeerCertificate(t *testing.T) {
+	r := require.New(t)
+
+	c1, c2 := loPipe(t)
+
+	srvConn := tls.Server(c1, &tls.Config{
+		Certificates: []tls.Certificate{selfSignedCert(t, "server")},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+	cliConn := tls.Client(c2, &tls.Config{
+		Certificates:       []tls.Certificate{selfSignedCert(t, "client")},
+		InsecureSkipVerify: true,
+	})
+
+	type result struct {
+		pkr *Packer
+		err error
+	}
+	srvPkr := make(chan result)
+	go func() {
+		pkr, err := NewTLSPacker(srvConn)
+		srvPkr <- result{pkr, err}
+	}()
+	cliPkr, err := NewTLSPacker(cliConn)
+	r.NoError(err)
+	srv := <-srvPkr
+	r.NoError(srv.err)
+
+	callerName := make(chan string, 1)
+	var fhSrv FakeHandler
+	fhSrv.HandledReturns(true)
+	fhSrv.HandleCallCalls(func(ctx context.Context, req *Request) {
+		if req.Method.String() != "whoami" {
+			req.CloseWithError(ErrNoSuchMethod{Method: req.Method})
+			return
+		}
+		cert, ok := PeerCertificate(req.RemoteAddr())
+		if !ok {
+			req.CloseWithError(errors.New("no certificate"))
+			return
+		}
+		callerName <- cert.Subject.CommonName
+		req.Return(ctx, cert.Subject.CommonName)
+	})
+	var fhCli FakeHandler
+
+	var srvEdp Endpoint
+	handled := make(chan struct{})
+	go func() {
+		srvEdp = Handle(srv.pkr, &fhSrv)
+		close(handled)
+	}()
+	cliEdp := Handle(cliPkr, &fhCli)
+	<-handled
+	defer srvEdp.Terminate()
+	defer cliEdp.Terminate()
+
+	cert, ok := PeerCertificate(cliEdp.Remote())
+	r.True(ok)
+	r.Equal("server", cert.Subject.CommonName)
+
+	var name string
+	err = cliEdp.Async(context.Background(), &name, TypeString, Method{"whoami"})
+	r.NoError(err)
+	r.Equal("client", name)
+	r.Equal("client", <-callerName)
+
+	_, ok = PeerCertificate(c1.RemoteAddr())
+	r.False(ok, "plain connections don't have a certificate")
+}
@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+type traceContextKey struct{}
+
+// WithTraceID makes the calls started with ctx use id as their trace ID, instead of a new one.
+// Calls made with the context of a handler already reuse the trace ID of the incoming request.
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, id)
+}
+
+// TraceIDFromContext returns the trace ID that calls started with ctx would use, see WithTraceID.
+func TraceIDFromContext(ctx context.Context) (string, bool) {
+	if id, ok := ctx.Value(traceContextKey{}).(string); ok && id != "" {
+		return id, true
+	}
+	if req, ok := RequestFromContext(ctx); ok && req.trace != "" {
+		return req.trace, true
+	}
+	return "", false
+}
+
+// traceFor returns the trace ID for a new call with ctx
+func traceFor(ctx context.Context) string {
+	if id, ok := TraceIDFromContext(ctx); ok {
+		return id
+	}
+	return newTraceID()
+}
+
+func newTraceID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceID(t *testing.T) {
+	r := require.New(t)
+
+	var fh1, fh2 FakeHandler
+	fh1.HandledCalls(methodChecker("back"))
+	fh1.HandleCallCalls(func(ctx context.Context, req *Request) {
+		req.Return(ctx, req.TraceID())
+	})
+	fh2.HandledCalls(methodChecker("hop"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		// calls made from a handler continue its trace
+		var back string
+		if err := req.Endpoint().Async(ctx, &back, TypeString, Method{"back"}); err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		req.Return(ctx, req.TraceID()+" "+back)
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2)
+
+	ctx := context.Background()
+	var resp string
+	r.NoError(rpc1.Async(WithTraceID(ctx, "t1"), &resp, TypeString, Method{"hop"}))
+	r.Equal("t1 t1", resp)
+
+	r.NoError(rpc1.Async(ctx, &resp, TypeString, Method{"hop"}))
+	r.Len(resp, 2*16+1)
+	r.Equal(resp[:16], resp[17:])
+
+	id, ok := TraceIDFromContext(WithTraceID(ctx, "t2"))
+	r.True(ok)
+	r.Equal("t2", id)
+	_, ok = TraceIDFromContext(ctx)
+	r.False(ok)
+}
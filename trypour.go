@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrWouldBlock is returned by the non-blocking writes, like TryWrite and TryPour, if the connection is busy.
+// Nothing was sent then and the stream stays open, so producers can pause at their source and try again later.
+var ErrWouldBlock = errors.New("muxrpc: write would block")
+
+// TryPourer is implemented by the legacy streams of calls that can be written to, like Request.Stream of a source call.
+type TryPourer interface {
+	// TryPour is like Pour but returns ErrWouldBlock instead of waiting for the connection, see ByteSink.TryWrite.
+	TryPour(ctx context.Context, v interface{}) error
+}
+
+var (
+	_ TryPourer = (*streamSink)(nil)
+	_ TryPourer = (*streamDuplex)(nil)
+)
+
+// TryWrite is like Write but returns ErrWouldBlock instead of waiting,
+// if another frame of this or another stream of the session is being written or waits for its turn,
+// or if the write buffer is full (see WithWriteCoalescing).
+// Writing to the connection itself can still block if there is no write buffer, it can't tell if it would.
+func (bs *ByteSink) TryWrite(b []byte) (int, error) {
+	if !bs.closedMu.TryLock() {
+		return 0, ErrWouldBlock
+	}
+	defer bs.closedMu.Unlock()
+	return bs.write(bs.pkt.Flag, b, true)
+}
+
+// TryWriteValue is like WriteValue but doesn't wait for the connection, see TryWrite.
+func (bs *ByteSink) TryWriteValue(v interface{}) error {
+	if !bs.closedMu.TryLock() {
+		return ErrWouldBlock
+	}
+	defer bs.closedMu.Unlock()
+
+	flag, body, err := bs.valueFrame(bs.valueEnc, v)
+	if err != nil {
+		return err
+	}
+	_, err = bs.write(withEncoding(bs.pkt.Flag, flag), body, true)
+	return err
+}
+
+// TryPour writes v like TryWriteValue. Unlike Pour, it doesn't change the encoding of later frames.
+func (stream *streamSink) TryPour(ctx context.Context, v interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return stream.sink.TryWriteValue(v)
+}
+
+func (stream *streamDuplex) TryPour(ctx context.Context, v interface{}) error {
+	return stream.snk.TryPour(ctx, v)
+}
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+)
+
+// gateWriter blocks its first write until release is closed
+type gateWriter struct {
+	once    sync.Once
+	entered chan struct{}
+	release chan struct{}
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (gw *gateWriter) Write(b []byte) (int, error) {
+	gw.once.Do(func() {
+		close(gw.entered)
+		<-gw.release
+	})
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	return gw.buf.Write(b)
+}
+
+func TestTryPour(t *testing.T) {
+	r := require.New(t)
+
+	gw := &gateWriter{
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	w := codec.NewWriter(gw)
+	ctx := context.Background()
+
+	newSink := func(id int32) *ByteSink {
+		snk := newByteSink(ctx, w, StdJSON)
+		snk.pkt.Req = id
+		snk.pkt.Flag = codec.FlagStream
+		return snk
+	}
+	busy, other := newSink(1), newSink(2)
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := busy.Write([]byte("first"))
+		written <- err
+	}()
+	<-gw.entered
+
+	// the connection is taken by the other stream
+	n, err := other.TryWrite([]byte("skipped"))
+	r.Equal(ErrWouldBlock, err)
+	r.Equal(0, n)
+	var tp TryPourer = other.AsStream()
+	r.Equal(ErrWouldBlock, tp.TryPour(ctx, "skipped"))
+
+	// and this one is still writing
+	r.Equal(ErrWouldBlock, busy.TryWriteValue("skipped"))
+
+	close(gw.release)
+	r.NoError(<-written)
+
+	// nothing was closed by the failed tries
+	n, err = other.TryWrite([]byte("second"))
+	r.NoError(err)
+	r.Equal(6, n)
+	r.NoError(tp.TryPour(ctx, map[string]int{"n": 3}))
+	r.NoError(busy.TryWriteValue("fourth"))
+
+	rd := codec.NewReader(&gw.buf)
+	for _, want := range []struct {
+		req  int32
+		body string
+		flag codec.Flag
+	}{
+		{1, "first", codec.FlagStream},
+		{2, "second", codec.FlagStream},
+		{2, `{"n":3}`, codec.FlagStream | codec.FlagJSON},
+		{1, "fourth", codec.FlagStream | codec.FlagString},
+	} {
+		pkt, err := rd.ReadPacket()
+		r.NoError(err)
+		r.Equal(want.req, pkt.Req)
+		r.Equal(want.flag, pkt.Flag)
+		r.Equal(want.body, string(bytes.TrimSpace(pkt.Body)))
+	}
+}
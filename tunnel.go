@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// tunnelNetwork is what TunnelAddr returns from Network()
+const tunnelNetwork = "muxrpc-tunnel"
+
+// TunnelAddr is the address of a connection that runs over a duplex call, see DialTunnel and AcceptTunnel.
+type TunnelAddr struct {
+	// Via is the address of the endpoint that carries the tunnel
+	Via net.Addr
+
+	// Method is the duplex method of the tunnel
+	Method Method
+}
+
+// Network returns "muxrpc-tunnel"
+func (a TunnelAddr) Network() string { return tunnelNetwork }
+
+func (a TunnelAddr) String() string {
+	if a.Via == nil {
+		return a.Method.String()
+	}
+	return fmt.Sprintf("%s|%s", a.Method, a.Via)
+}
+
+// DialTunnel makes the duplex call method on edp and returns it as a connection,
+// for instance to run another muxrpc session over it (like tunnel.connect of SSB rooms):
+//
+//	conn, err := muxrpc.DialTunnel(ctx, room, muxrpc.Method{"tunnel", "connect"}, args)
+//	inner := muxrpc.Handle(muxrpc.NewPacker(conn), h)
+//
+// The tunnel lasts until either side closes it, or until ctx is canceled.
+// Deadlines are accepted but not enforced, so don't use WithReadTimeout or WithWriteTimeout on its packer.
+func DialTunnel(ctx context.Context, edp Endpoint, method Method, args ...interface{}) (net.Conn, error) {
+	src, snk, err := edp.Duplex(ctx, TypeBinary, method, args...)
+	if err != nil {
+		return nil, fmt.Errorf("muxrpc: failed to open tunnel: %w", err)
+	}
+	return NewStreamConn(src, snk, edp.Local(), TunnelAddr{Via: edp.Remote(), Method: method}), nil
+}
+
+// AcceptTunnel returns an incoming duplex call as a connection, the counterpart of DialTunnel.
+// The handler can return right away, the call stays open until the connection is closed.
+func AcceptTunnel(req *Request) (net.Conn, error) {
+	if req.Type != "duplex" {
+		return nil, ErrWrongStreamType{req.Type}
+	}
+	var local net.Addr
+	if req.endpoint != nil {
+		local = req.endpoint.Local()
+	}
+	return NewStreamConn(req.source, req.sink, local, TunnelAddr{Via: req.remoteAddr, Method: req.Method}), nil
+}
+
+// NewStreamConn combines the two directions of a duplex call into a net.Conn.
+// Writes are split into frames of at most ChunkSize bytes. Closing it ends the call.
+func NewStreamConn(src *ByteSource, snk *ByteSink, local, remote net.Addr) net.Conn {
+	return &streamConn{
+		srcReader:  srcReader{src: src},
+		sinkWriter: sinkWriter{snk},
+		local:      local,
+		remote:     remote,
+	}
+}
+
+type streamConn struct {
+	srcReader
+	sinkWriter
+
+	local, remote net.Addr
+}
+
+func (c *streamConn) Close() error {
+	err := c.sinkWriter.Close()
+	c.src.Cancel(nil)
+	return err
+}
+
+func (c *streamConn) LocalAddr() net.Addr  { return c.local }
+func (c *streamConn) RemoteAddr() net.Addr { return c.remote }
+
+// deadlines are accepted but not enforced, a timed out read would end the stream
+
+func (c *streamConn) SetDeadline(t time.Time) error      { return nil }
+func (c *streamConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *streamConn) SetWriteDeadline(t time.Time) error { return nil }
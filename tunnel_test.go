@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTunnel(t *testing.T) {
+	r := require.New(t)
+
+	var inner FakeHandler
+	inner.HandledCalls(methodChecker("whoami"))
+	inner.HandleCallCalls(func(ctx context.Context, req *Request) {
+		req.Return(ctx, "inner")
+	})
+
+	innerDone := make(chan error, 1)
+	var outer FakeHandler
+	outer.HandledCalls(func(m Method) bool { return m.String() == "tunnel.connect" })
+	outer.HandleCallCalls(func(ctx context.Context, req *Request) {
+		conn, err := AcceptTunnel(req)
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		edp := Handle(NewPacker(conn), &inner)
+		go func() { innerDone <- edp.(Server).Serve() }()
+	})
+
+	client, _ := connectedPair(t, &FakeHandler{}, &outer)
+
+	ctx := context.Background()
+	conn, err := DialTunnel(ctx, client, Method{"tunnel", "connect"}, map[string]string{"target": "@inner.ed25519"})
+	r.NoError(err)
+
+	ta, ok := FindAddr(conn.RemoteAddr(), tunnelNetwork).(TunnelAddr)
+	r.True(ok, "unexpected address: %v", conn.RemoteAddr())
+	r.Equal("tunnel.connect", ta.Method.String())
+	r.Equal(client.Remote(), ta.Via)
+
+	edp := Handle(NewPacker(conn), &FakeHandler{})
+	go edp.(Server).Serve()
+
+	var who string
+	r.NoError(edp.Async(ctx, &who, TypeString, Method{"whoami"}))
+	r.Equal("inner", who)
+
+	// ending the inner session closes the tunnel, which ends the other side too
+	r.NoError(edp.Terminate())
+	select {
+	case <-innerDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("inner session on the other side did not end")
+	}
+
+	// the outer session is still fine
+	err = client.Async(ctx, &who, TypeString, Method{"tunnel", "connect"})
+	r.Error(err, "not an async method")
+}
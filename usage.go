@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	// HelpMethod is answered by a HandlerMux after ServeHelp. It's a sync call which returns a Help,
+	// like the help methods of JS sbot plugins. An optional string argument limits it to the methods under that prefix.
+	HelpMethod = Method{"help"}
+
+	// UsageMethod is like HelpMethod but returns the text of Help.Usage, like the usage method of JS sbot.
+	UsageMethod = Method{"usage"}
+)
+
+// Help describes the methods of a server, in the format of the help methods of JS sbot plugins.
+type Help struct {
+	Description string               `json:"description,omitempty"`
+	Commands    map[string]MethodDoc `json:"commands"`
+}
+
+// MethodDoc describes a method, see HandlerMux.Describe.
+type MethodDoc struct {
+	Type        string            `json:"type"` // async, sync, source, sink or duplex
+	Description string            `json:"description"`
+	Args        map[string]ArgDoc `json:"args,omitempty"`
+}
+
+// ArgDoc describes an argument of a method, or a field of its options object.
+type ArgDoc struct {
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	Optional    bool   `json:"optional,omitempty"`
+}
+
+// Describe attaches doc to m, for HelpMethod and UsageMethod. It's meant to be called next to Register.
+func (hm *HandlerMux) Describe(m Method, doc MethodDoc) {
+	if hm.docs == nil {
+		hm.docs = make(map[string]MethodDoc)
+	}
+
+	hm.docs[m.String()] = doc
+}
+
+// ServeHelp registers handlers for HelpMethod and UsageMethod, which list the methods passed to Describe.
+// description says what the server is about. The methods have to be added to the manifest by the application, as sync calls.
+func (hm *HandlerMux) ServeHelp(description string) {
+	h := helpHandler{mux: hm, description: description}
+	hm.Register(HelpMethod, h)
+	hm.Register(UsageMethod, h)
+}
+
+// help collects the described methods under prefix, all of them if it's empty
+func (hm *HandlerMux) help(description, prefix string) Help {
+	h := Help{
+		Description: description,
+		Commands:    make(map[string]MethodDoc),
+	}
+	for name, doc := range hm.docs {
+		if prefix == "" || name == prefix || strings.HasPrefix(name, prefix+".") {
+			h.Commands[name] = doc
+		}
+	}
+	return h
+}
+
+// Usage returns h as text for humans, the methods and their arguments sorted by name.
+func (h Help) Usage() string {
+	var b strings.Builder
+	if h.Description != "" {
+		fmt.Fprintf(&b, "%s\n\n", h.Description)
+	}
+
+	names := make([]string, 0, len(h.Commands))
+	for name := range h.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		doc := h.Commands[name]
+		fmt.Fprintf(&b, "%s (%s)", name, doc.Type)
+		if doc.Description != "" {
+			fmt.Fprintf(&b, ": %s", doc.Description)
+		}
+		b.WriteString("\n")
+
+		argNames := make([]string, 0, len(doc.Args))
+		for arg := range doc.Args {
+			argNames = append(argNames, arg)
+		}
+		sort.Strings(argNames)
+		for _, arg := range argNames {
+			a := doc.Args[arg]
+			fmt.Fprintf(&b, "    %s %s", arg, a.Type)
+			if a.Optional {
+				b.WriteString(" (optional)")
+			}
+			if a.Description != "" {
+				fmt.Fprintf(&b, ": %s", a.Description)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// helpHandler answers HelpMethod and UsageMethod from the docs of its mux
+type helpHandler struct {
+	mux         *HandlerMux
+	description string
+}
+
+func (h helpHandler) Handled(m Method) bool {
+	return m.String() == HelpMethod.String() || m.String() == UsageMethod.String()
+}
+
+func (h helpHandler) HandleConnect(ctx context.Context, edp Endpoint) {}
+
+func (h helpHandler) HandleCall(ctx context.Context, req *Request) {
+	var args []string
+	if len(req.RawArgs) > 0 {
+		if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+			req.CloseWithError(fmt.Errorf("muxrpc: expected an optional method prefix: %w", err))
+			return
+		}
+	}
+	var prefix string
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+
+	help := h.mux.help(h.description, prefix)
+	if req.Method.String() == UsageMethod.String() {
+		req.Return(ctx, help.Usage())
+		return
+	}
+	req.Return(ctx, help)
+}
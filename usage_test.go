@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerMuxHelp(t *testing.T) {
+	r := require.New(t)
+
+	var mux HandlerMux
+	mux.Register(Method{"whoami"}, &FakeHandler{})
+	mux.Describe(Method{"whoami"}, MethodDoc{
+		Type:        "async",
+		Description: "the id of the server",
+	})
+	mux.Register(Method{"friends"}, &FakeHandler{})
+	mux.Describe(Method{"friends", "isFollowing"}, MethodDoc{
+		Type:        "async",
+		Description: "check if one feed follows another",
+		Args: map[string]ArgDoc{
+			"source": {Type: "FeedId", Description: "the follower"},
+			"dest":   {Type: "FeedId", Description: "the followed"},
+		},
+	})
+	mux.Describe(Method{"friends", "hops"}, MethodDoc{
+		Type: "async",
+		Args: map[string]ArgDoc{"max": {Type: "number", Optional: true}},
+	})
+	mux.ServeHelp("a test server")
+	r.True(mux.Handled(HelpMethod))
+	r.True(mux.Handled(UsageMethod))
+
+	rpc1, _ := handledPair(t, &FakeHandler{}, &mux, nil, nil)
+	ctx := context.Background()
+
+	var help Help
+	r.NoError(rpc1.Async(ctx, &help, TypeJSON, HelpMethod))
+	r.Equal("a test server", help.Description)
+	r.Len(help.Commands, 3)
+	r.Equal("the id of the server", help.Commands["whoami"].Description)
+	r.Equal("FeedId", help.Commands["friends.isFollowing"].Args["dest"].Type)
+
+	// limited to a prefix
+	help = Help{}
+	r.NoError(rpc1.Async(ctx, &help, TypeJSON, HelpMethod, "friends"))
+	r.Len(help.Commands, 2)
+	r.Contains(help.Commands, "friends.hops")
+
+	var usage string
+	r.NoError(rpc1.Async(ctx, &usage, TypeString, UsageMethod, "friends"))
+	r.Equal(`a test server
+
+friends.hops (async)
+    max number (optional)
+friends.isFollowing (async): check if one feed follows another
+    dest FeedId: the followed
+    source FeedId: the follower
+`, usage)
+
+	// prefixes end at dots
+	help = Help{}
+	r.NoError(rpc1.Async(ctx, &help, TypeJSON, HelpMethod, "friend"))
+	r.Len(help.Commands, 0)
+}
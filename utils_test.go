@@ -69,3 +69,37 @@ func loPipe(t testing.TB) (net.Conn, net.Conn) {
 
 	return c1, c2
 }
+
+// connectedPair wires up two endpoints over loPipe and tears them down once the test is done.
+func connectedPair(t testing.TB, h1, h2 Handler, opts ...HandleOption) (Endpoint, Endpoint) {
+	c1, c2 := loPipe(t)
+
+	errc := make(chan error, 2)
+	serve1 := make(chan struct{})
+	serve2 := make(chan struct{})
+
+	var rpc2 Endpoint
+	rpc2started := make(chan struct{})
+	go func() {
+		rpc2 = Handle(NewPacker(c2), h2, opts...)
+		close(rpc2started)
+		serve(context.TODO(), rpc2.(Server), errc, serve2)
+	}()
+
+	rpc1 := Handle(NewPacker(c1), h1, opts...)
+	go serve(context.TODO(), rpc1.(Server), errc, serve1)
+	<-rpc2started
+
+	t.Cleanup(func() {
+		rpc1.Terminate()
+		rpc2.Terminate()
+		<-serve1
+		<-serve2
+		close(errc)
+		for err := range errc {
+			t.Error(err)
+		}
+	})
+
+	return rpc1, rpc2
+}
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// BadRequestErrorName is the name of the CallError the remote gets when its arguments were refused by an ArgsValidator.
+const BadRequestErrorName = "BadRequestError"
+
+// ArgsValidator checks the arguments of an incoming call before it is handled, see HandlerMux.Validate.
+// args is the JSON array of arguments as sent by the remote.
+type ArgsValidator func(args json.RawMessage) error
+
+// Validate registers v to check the arguments of calls to m, before they are passed to the handler.
+// If v returns an error, the call is closed with a CallError named BadRequestErrorName which carries the message of the error.
+// Validators for longer methods take precedence, like handlers do.
+func (hm *HandlerMux) Validate(m Method, v ArgsValidator) {
+	if hm.validators == nil {
+		hm.validators = make(map[string]ArgsValidator)
+	}
+
+	hm.validators[m.String()] = v
+}
+
+// validate runs the validator for the method of req, if there is one
+func (hm *HandlerMux) validate(req *Request) error {
+	for i := len(req.Method); i > 0; i-- {
+		v, ok := hm.validators[req.Method[:i].String()]
+		if !ok {
+			continue
+		}
+		if err := v(req.RawArgs); err != nil {
+			return &CallError{
+				Name:    BadRequestErrorName,
+				Message: fmt.Sprintf("invalid arguments for %s: %s", req.Method, err),
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// IsBadRequest returns true if err is the reply to a call whose arguments were refused, see HandlerMux.Validate.
+func IsBadRequest(err error) bool {
+	var ce *CallError
+	return errors.As(err, &ce) && ce.Name == BadRequestErrorName
+}
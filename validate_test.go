@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerMuxValidate(t *testing.T) {
+	r := require.New(t)
+
+	var echo FakeHandler
+	echo.HandledReturns(true)
+	echo.HandleCallCalls(func(ctx context.Context, req *Request) {
+		var args []string
+		json.Unmarshal(req.RawArgs, &args)
+		req.Return(ctx, args[0])
+	})
+
+	var mux HandlerMux
+	mux.Register(Method{"echo"}, &echo)
+	mux.Validate(Method{"echo"}, func(args json.RawMessage) error {
+		var v []string
+		if err := json.Unmarshal(args, &v); err != nil {
+			return err
+		}
+		if len(v) != 1 {
+			return errors.New("need exactly one string")
+		}
+		return nil
+	})
+
+	c1, c2 := loPipe(t)
+	var rpc1 Endpoint
+	handled := make(chan struct{})
+	go func() {
+		rpc1 = Handle(NewPacker(c1), &FakeHandler{})
+		close(handled)
+	}()
+	rpc2 := Handle(NewPacker(c2), &mux)
+	<-handled
+	go rpc1.(Server).Serve()
+	go rpc2.(Server).Serve()
+	defer rpc1.Terminate()
+	defer rpc2.Terminate()
+
+	ctx := context.Background()
+
+	var resp string
+	r.NoError(rpc1.Async(ctx, &resp, TypeString, Method{"echo"}, "hi"))
+	r.Equal("hi", resp)
+
+	for _, args := range [][]interface{}{
+		{},
+		{"a", "b"},
+		{23},
+	} {
+		err := rpc1.Async(ctx, &resp, TypeString, Method{"echo"}, args...)
+		r.True(IsBadRequest(err), "unexpected error for %v: %v", args, err)
+	}
+	r.Equal(1, echo.HandleCallCallCount(), "refused calls reached the handler")
+
+	r.False(IsBadRequest(errors.New("nope")))
+}
@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+)
+
+// ValueEncoder turns a value that is written to a stream into the flag and body of its packet, see ByteSink.SetValueEncoder.
+// Only the encoding bits of the flag are used, codec.FlagJSON or codec.FlagString, neither of them means binary data.
+type ValueEncoder func(v interface{}) (codec.Flag, []byte, error)
+
+// ErrNotEncoded is returned by a ValueEncoder for values it leaves to the default encoding.
+var ErrNotEncoded = errors.New("muxrpc: value not encoded")
+
+// SetValueEncoder makes the values written to the stream of req go through enc, see ByteSink.SetValueEncoder.
+func (req *Request) SetValueEncoder(enc ValueEncoder) {
+	req.sink.SetValueEncoder(enc)
+}
+
+// SetValueEncoder makes WriteValue and the Pour of the legacy stream pass values through enc before the default encoding.
+// Values that are stored encoded already, like the messages of a log, can then be sent as they are instead of being marshaled again.
+func (bs *ByteSink) SetValueEncoder(enc ValueEncoder) {
+	bs.closedMu.Lock()
+	defer bs.closedMu.Unlock()
+	bs.valueEnc = enc
+}
+
+// WriteValue sends v as one frame. If there is a ValueEncoder, it decides how, unless it returns ErrNotEncoded.
+// Otherwise []byte is sent as binary data, strings as strings, json.RawMessage as it is and everything else encoded as JSON.
+// The encoding of the following writes stays as it was.
+func (bs *ByteSink) WriteValue(v interface{}) error {
+	bs.closedMu.Lock()
+	enc := bs.valueEnc
+	bs.closedMu.Unlock()
+
+	flag, body, err := bs.valueFrame(enc, v)
+	if err != nil {
+		return err
+	}
+	_, err = bs.writeEncoded(flag, body)
+	return err
+}
+
+// valueFrame encodes v like WriteValue does with enc
+func (bs *ByteSink) valueFrame(enc ValueEncoder, v interface{}) (codec.Flag, []byte, error) {
+	err := ErrNotEncoded
+	var (
+		flag codec.Flag
+		body []byte
+	)
+	if enc != nil {
+		flag, body, err = enc(v)
+	}
+	if err == ErrNotEncoded {
+		switch tv := v.(type) {
+		case []byte:
+			flag, body, err = 0, tv, nil
+		case string:
+			flag, body, err = codec.FlagString, []byte(tv), nil
+		case json.RawMessage:
+			flag, body, err = codec.FlagJSON, tv, nil
+		default:
+			flag = codec.FlagJSON
+			body, err = bs.json.Marshal(v)
+		}
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("muxrpc: failed to encode %T: %w", v, err)
+	}
+	return flag, body, nil
+}
+
+// encodeValue passes v to the ValueEncoder. It returns ErrNotEncoded if there is none.
+func (bs *ByteSink) encodeValue(v interface{}) (codec.Flag, []byte, error) {
+	bs.closedMu.Lock()
+	enc := bs.valueEnc
+	bs.closedMu.Unlock()
+	if enc == nil {
+		return 0, nil, ErrNotEncoded
+	}
+	return enc(v)
+}
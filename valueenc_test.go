@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+)
+
+// storedMsg is a message that was encoded before, like one read from a log
+type storedMsg struct{ raw []byte }
+
+func TestValueEncoder(t *testing.T) {
+	r := require.New(t)
+
+	errBroken := errors.New("broken")
+	writeErr := make(chan error, 1)
+
+	var fh FakeHandler
+	fh.HandledCalls(methodChecker("log"))
+	fh.HandleCallCalls(func(ctx context.Context, req *Request) {
+		req.SetValueEncoder(func(v interface{}) (codec.Flag, []byte, error) {
+			switch tv := v.(type) {
+			case storedMsg:
+				return codec.FlagJSON, tv.raw, nil
+			case bool:
+				return 0, nil, errBroken
+			}
+			return 0, nil, ErrNotEncoded
+		})
+
+		snk, err := req.ResponseSink()
+		if err != nil {
+			return
+		}
+		if err := snk.WriteValue(storedMsg{[]byte(`{"seq": 1}`)}); err != nil {
+			snk.CloseWithError(err)
+			return
+		}
+		if err := req.Stream.Pour(ctx, storedMsg{[]byte(`{"seq": 2}`)}); err != nil {
+			snk.CloseWithError(err)
+			return
+		}
+		snk.WriteValue("plain")
+		snk.WriteValue(map[string]int{"seq": 3})
+		writeErr <- snk.WriteValue(true)
+		snk.Close()
+	})
+
+	rpc1, _ := connectedPair(t, &FakeHandler{}, &fh)
+
+	ctx := context.Background()
+	src, err := rpc1.Source(ctx, TypeJSON, Method{"log"})
+	r.NoError(err)
+
+	var frames []string
+	for src.Next(ctx) {
+		body, err := src.Bytes()
+		r.NoError(err)
+		frames = append(frames, string(body))
+	}
+	r.NoError(src.Err())
+
+	// stored messages are sent byte for byte
+	r.Equal([]string{`{"seq": 1}`, `{"seq": 2}`, "plain", `{"seq":3}`}, frames)
+	r.True(errors.Is(<-writeErr, errBroken))
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.cryptoscope.co/muxrpc/v2/codec"
+)
+
+// ErrHandlerTimeout ends incoming calls whose handler took too long, see WithCallTimeout and WithStreamIdleTimeout.
+var ErrHandlerTimeout = errors.New("muxrpc: handler timed out")
+
+// WithCallTimeout limits how long handlers may take to reply to incoming async calls.
+// Once it expires, the context of the handler is canceled and the caller gets ErrHandlerTimeout. Zero means no limit.
+func WithCallTimeout(d time.Duration) HandleOption {
+	return func(r *rpc) {
+		r.callTimeout = d
+	}
+}
+
+// WithStreamIdleTimeout ends incoming streams on which no data was sent or received for d, unless they were marked with MarkLive.
+// The context of the handler is canceled and the stream is closed with ErrHandlerTimeout. Zero means no limit.
+func WithStreamIdleTimeout(d time.Duration) HandleOption {
+	return func(r *rpc) {
+		r.streamIdleTimeout = d
+	}
+}
+
+// watch starts the watchdog timer for a new incoming call, if one is configured.
+func (r *rpc) watch(req *Request) {
+	if req.Type.Flags().Get(codec.FlagStream) {
+		if r.streamIdleTimeout > 0 {
+			r.clock.AfterFunc(r.streamIdleTimeout, func() { r.checkIdle(req) })
+		}
+		return
+	}
+
+	if r.callTimeout > 0 {
+		r.clock.AfterFunc(r.callTimeout, func() {
+			if !req.markReplied() {
+				return // too late
+			}
+			r.closeStream(req, fmt.Errorf("muxrpc: %s did not reply within %s: %w", req.Method, r.callTimeout, ErrHandlerTimeout))
+		})
+	}
+}
+
+// checkIdle closes the stream if it was idle for too long or checks again once it could be.
+// Live streams are left alone, see MarkLive.
+func (r *rpc) checkIdle(req *Request) {
+	r.rLock.RLock()
+	_, active := r.reqs[req.id]
+	r.rLock.RUnlock()
+	if !active || req.IsLive() {
+		return
+	}
+
+	idle := r.clock.Now().Sub(req.lastActivity())
+	if idle < r.streamIdleTimeout {
+		r.clock.AfterFunc(r.streamIdleTimeout-idle, func() { r.checkIdle(req) })
+		return
+	}
+	r.closeStream(req, fmt.Errorf("muxrpc: %s was idle for %s: %w", req.Method, r.streamIdleTimeout, ErrHandlerTimeout))
+}
+
+// markReplied returns true the first time it is called for an async request.
+// Return and the watchdog use it to decide who ends the call.
+func (req *Request) markReplied() bool {
+	return atomic.CompareAndSwapUint32(&req.state.replied, 0, 1)
+}
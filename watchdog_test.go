@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: MIT
+
+package muxrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallTimeout(t *testing.T) {
+	r := require.New(t)
+
+	canceled := make(chan struct{})
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("hang"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		<-ctx.Done()
+		close(canceled)
+		err := req.Return(context.Background(), "too late")
+		if !errors.Is(err, ErrHandlerTimeout) {
+			t.Errorf("expected late return to fail: %v", err)
+		}
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2, WithCallTimeout(50*time.Millisecond))
+
+	var resp string
+	err := rpc1.Async(context.Background(), &resp, TypeString, Method{"hang"})
+	var ce *CallError
+	r.True(errors.As(err, &ce), "expected CallError, got %v", err)
+	r.Contains(ce.Message, "handler timed out")
+
+	select {
+	case <-canceled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler context was not canceled")
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	r := require.New(t)
+
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("ticks"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		snk, err := req.ResponseSink()
+		if err != nil {
+			return
+		}
+		// keep the stream busy for a while, then go quiet
+		for i := 0; i < 4; i++ {
+			snk.Write([]byte("tick"))
+			time.Sleep(30 * time.Millisecond)
+		}
+		<-ctx.Done()
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2, WithStreamIdleTimeout(100*time.Millisecond))
+
+	ctx := context.Background()
+	start := time.Now()
+	src, err := rpc1.Source(ctx, TypeString, Method{"ticks"})
+	r.NoError(err)
+
+	var n int
+	for src.Next(ctx) {
+		_, err := src.Bytes()
+		r.NoError(err)
+		n++
+	}
+	r.Equal(4, n, "the busy part should not time out")
+	r.True(time.Since(start) > 150*time.Millisecond, "timed out while data was flowing")
+
+	var ce *CallError
+	r.True(errors.As(src.Err(), &ce), "expected CallError, got %v", src.Err())
+	r.Contains(ce.Message, "idle")
+}
+
+func TestLiveStream(t *testing.T) {
+	r := require.New(t)
+
+	gone := make(chan struct{})
+	var fh1, fh2 FakeHandler
+	fh2.HandledCalls(methodChecker("live"))
+	fh2.HandleCallCalls(func(ctx context.Context, req *Request) {
+		req.MarkLive()
+		snk, err := req.ResponseSink()
+		if err != nil {
+			return
+		}
+		// quiet for longer than the idle timeout in between
+		for i := 0; i < 2; i++ {
+			snk.Write([]byte("tick"))
+			select {
+			case <-req.ConsumerGone():
+			case <-time.After(250 * time.Millisecond):
+			}
+		}
+		<-req.ConsumerGone()
+		close(gone)
+	})
+
+	rpc1, _ := connectedPair(t, &fh1, &fh2, WithStreamIdleTimeout(100*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	src, err := rpc1.Source(ctx, TypeString, Method{"live"})
+	r.NoError(err)
+
+	for i := 0; i < 2; i++ {
+		r.True(src.Next(ctx), "live stream ended early: %v", src.Err())
+		_, err := src.Bytes()
+		r.NoError(err)
+	}
+
+	// going away tells the handler
+	cancel()
+	select {
+	case <-gone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not notice that the consumer went away")
+	}
+}